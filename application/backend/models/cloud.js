@@ -64,6 +64,26 @@ module.exports = function(sequelize) {
       type: DataTypes.STRING(255),
       allowNull: true
     },
+    RoleARN: {
+      type: DataTypes.STRING(255),
+      allowNull: true
+    },
+    ExternalID: {
+      type: DataTypes.STRING(255),
+      allowNull: true
+    },
+    Endpoint: {
+      type: DataTypes.STRING(255),
+      allowNull: true
+    },
+    PathStyle: {
+      type: DataTypes.BOOLEAN,
+      allowNull: true
+    },
+    AuthMode: {
+      type: DataTypes.STRING(32),
+      allowNull: true
+    },
     KeyFile: {
       type: DataTypes.BLOB,
       allowNull: true
@@ -33,6 +33,11 @@ module.exports = gql`
     ClientEmail: String
     PrivateKey: String
     ProjectID: String
+    RoleARN: String
+    ExternalID: String
+    Endpoint: String
+    PathStyle: Boolean
+    AuthMode: String
     KeyFile: Upload
 
   }
@@ -89,6 +94,11 @@ module.exports = gql`
     ClientEmail: String
     PrivateKey: String
     ProjectID: String
+    RoleARN: String
+    ExternalID: String
+    Endpoint: String
+    PathStyle: Boolean
+    AuthMode: String
     KeyFile: Upload
   }
 
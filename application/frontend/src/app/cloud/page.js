@@ -60,19 +60,22 @@ const CloudConnectionPage = () => {
             const requestOptions = {
                 method: 'POST',
                 headers: {
-                    'Content-Type': 'application/json'
+                    'Content-Type': 'application/json',
+                    Authorization: `Bearer ${localStorage.getItem('token')}`
                 },
+                // The server binds the login to this browser with a cookie
+                credentials: 'include',
                 body: JSON.stringify(requestBody)
             };
     
             const loginResponse = await fetch('http://localhost:8080/auth/google/login', requestOptions);
-            console.log("Jay responce: ",loginResponse);
             if (!loginResponse.ok) {
                 throw new Error('Failed to initiate login');
             }
     
-            // Redirect the user to Google for authentication
-            window.location.href = loginResponse.url;
+            // Send the user to Google for authentication
+            const { url } = await loginResponse.json();
+            window.location.href = url;
         } catch (error) {
             console.error('Error initiating Google login:', error);
         }
@@ -87,19 +90,22 @@ const CloudConnectionPage = () => {
             const requestOptions = {
                 method: 'POST',
                 headers: {
-                    'Content-Type': 'application/json'
+                    'Content-Type': 'application/json',
+                    Authorization: `Bearer ${localStorage.getItem('token')}`
                 },
+                // The server binds the login to this browser with a cookie
+                credentials: 'include',
                 body: JSON.stringify(requestBody)
             };
     
             const loginResponse = await fetch('http://localhost:8080/auth/azure/login', requestOptions);
-            console.log("Jay responce: ",loginResponse);
             if (!loginResponse.ok) {
                 throw new Error('Failed to initiate login');
             }
     
-            // Redirect the user to Google for authentication
-            window.location.href = loginResponse.url;
+            // Send the user to Azure for authentication
+            const { url } = await loginResponse.json();
+            window.location.href = url;
         } catch (error) {
             console.error('Error initiating Google login:', error);
         }
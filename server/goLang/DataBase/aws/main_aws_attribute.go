@@ -0,0 +1,229 @@
+package aws_dynamodb
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// Items are exchanged as plain JSON objects: strings, numbers, booleans, null, arrays and
+// objects map onto S, N, BOOL, NULL, L and M. JSON has no sets or binary data, so an object
+// with exactly one of these keys stands for that DynamoDB type instead of a map:
+//
+//	{"$ss": ["a", "b"]}        string set (SS)
+//	{"$ns": [1, "2.5"]}        number set (NS)
+//	{"$b": "aGVsbG8="}         binary (B), base64 encoded
+//	{"$bs": ["aGk=", "eW8="]}  binary set (BS)
+//
+// Items are returned in the same form, so an item that was read can be written back as it is.
+// Numbers keep all their digits in both directions.
+const (
+	stringSetKey = "$ss"
+	numberSetKey = "$ns"
+	binaryKey    = "$b"
+	binarySetKey = "$bs"
+)
+
+// encoder stores empty strings, binaries and collections as they are, DynamoDB has accepted
+// them in non-key attributes since 2020
+var encoder = dynamodbattribute.NewEncoder(func(e *dynamodbattribute.Encoder) {
+	e.NullEmptyString = false
+	e.NullEmptyByteSlice = false
+	e.EnableEmptyCollections = true
+})
+
+type stringSet []string
+
+func (s stringSet) MarshalDynamoDBAttributeValue(av *dynamodb.AttributeValue) error {
+	av.SS = aws.StringSlice(s)
+	return nil
+}
+
+type numberSet []string
+
+func (s numberSet) MarshalDynamoDBAttributeValue(av *dynamodb.AttributeValue) error {
+	av.NS = aws.StringSlice(s)
+	return nil
+}
+
+// decodeRequest decodes a JSON request body and keeps its numbers as json.Number, so they reach
+// DynamoDB with the digits they were sent with instead of going through float64
+func decodeRequest(r *http.Request, req interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	return decoder.Decode(req)
+}
+
+// marshalItem converts a JSON object into a DynamoDB item
+func marshalItem(item map[string]interface{}) (map[string]*dynamodb.AttributeValue, error) {
+	out := make(map[string]*dynamodb.AttributeValue, len(item))
+	for name, value := range item {
+		av, err := marshalValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("attribute %s: %v", name, err)
+		}
+		out[name] = av
+	}
+	return out, nil
+}
+
+// marshalValue converts a JSON value into an attribute value
+func marshalValue(value interface{}) (*dynamodb.AttributeValue, error) {
+	typed, err := typedValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return encoder.Encode(typed)
+}
+
+// typedValue replaces the numbers and typed objects in a JSON value with the types
+// dynamodbattribute encodes as N, SS, NS, B and BS
+func typedValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case json.Number:
+		return dynamodbattribute.Number(v), nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, elem := range v {
+			typed, err := typedValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = typed
+		}
+		return list, nil
+	case map[string]interface{}:
+		if len(v) == 1 {
+			for key, inner := range v {
+				switch key {
+				case stringSetKey, numberSetKey, binaryKey, binarySetKey:
+					return typedObject(key, inner)
+				}
+			}
+		}
+		m := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			typed, err := typedValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = typed
+		}
+		return m, nil
+	}
+	return value, nil
+}
+
+func typedObject(key string, value interface{}) (interface{}, error) {
+	if key == binaryKey {
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s must be a base64 string", binaryKey)
+		}
+		return base64.StdEncoding.DecodeString(s)
+	}
+
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be an array", key)
+	}
+	switch key {
+	case stringSetKey:
+		set := make(stringSet, len(list))
+		for i, elem := range list {
+			if set[i], ok = elem.(string); !ok {
+				return nil, fmt.Errorf("%s can only hold strings", stringSetKey)
+			}
+		}
+		return set, nil
+	case numberSetKey:
+		set := make(numberSet, len(list))
+		for i, elem := range list {
+			switch n := elem.(type) {
+			case json.Number:
+				set[i] = n.String()
+			case string:
+				set[i] = n
+			default:
+				return nil, fmt.Errorf("%s can only hold numbers", numberSetKey)
+			}
+		}
+		return set, nil
+	default:
+		set := make([][]byte, len(list))
+		for i, elem := range list {
+			s, ok := elem.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s can only hold base64 strings", binarySetKey)
+			}
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", binarySetKey, err)
+			}
+			set[i] = b
+		}
+		return set, nil
+	}
+}
+
+// unmarshalItems converts DynamoDB items into JSON objects
+func unmarshalItems(items []map[string]*dynamodb.AttributeValue) []map[string]interface{} {
+	out := make([]map[string]interface{}, len(items))
+	for i, item := range items {
+		out[i] = unmarshalItem(item)
+	}
+	return out
+}
+
+// unmarshalItem converts a DynamoDB item into a JSON object
+func unmarshalItem(item map[string]*dynamodb.AttributeValue) map[string]interface{} {
+	if item == nil {
+		return nil
+	}
+	out := make(map[string]interface{}, len(item))
+	for name, av := range item {
+		out[name] = unmarshalValue(av)
+	}
+	return out
+}
+
+// unmarshalValue converts an attribute value into a JSON value. It does not go through
+// dynamodbattribute.Unmarshal, which turns sets into plain arrays and numbers into float64.
+func unmarshalValue(av *dynamodb.AttributeValue) interface{} {
+	switch {
+	case av == nil || av.NULL != nil:
+		return nil
+	case av.S != nil:
+		return *av.S
+	case av.N != nil:
+		return json.Number(*av.N)
+	case av.BOOL != nil:
+		return *av.BOOL
+	case av.B != nil:
+		return map[string]interface{}{binaryKey: av.B}
+	case av.SS != nil:
+		return map[string]interface{}{stringSetKey: aws.StringValueSlice(av.SS)}
+	case av.NS != nil:
+		set := make([]json.Number, len(av.NS))
+		for i, n := range av.NS {
+			set[i] = json.Number(aws.StringValue(n))
+		}
+		return map[string]interface{}{numberSetKey: set}
+	case av.BS != nil:
+		return map[string]interface{}{binarySetKey: av.BS}
+	case av.L != nil:
+		list := make([]interface{}, len(av.L))
+		for i, elem := range av.L {
+			list[i] = unmarshalValue(elem)
+		}
+		return list
+	case av.M != nil:
+		return unmarshalItem(av.M)
+	}
+	return nil
+}
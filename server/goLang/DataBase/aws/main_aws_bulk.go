@@ -0,0 +1,173 @@
+package aws_dynamodb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"btep.project/DataBase/bulk"
+	"btep.project/apierror"
+	"btep.project/auth/awsauth"
+	db "btep.project/databaseConnection"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// ExportRequest represents the JSON request structure for export
+type ExportRequest struct {
+	TableName string `json:"tableName"`
+	Region    string `json:"region"`
+	AccountID int    `json:"accountID"`
+	bulk.ExportOptions
+}
+
+// ExportItemsHandler streams every item of a table as JSON Lines or CSV, in the JSON form
+// readItem returns them in
+func ExportItemsHandler(w http.ResponseWriter, r *http.Request) {
+	var req ExportRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		apierror.BadRequest(w, "Invalid request body")
+		return
+	}
+
+	exporter, err := bulk.NewExporter(w, req.ExportOptions, req.TableName)
+	if err != nil {
+		apierror.Write(w, err, "Invalid request")
+		return
+	}
+
+	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
+	if err != nil {
+		apierror.Write(w, err, "Error getting cloud account details")
+		return
+	}
+
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
+	if err != nil {
+		apierror.Write(w, err, "Error initializing AWS session")
+		return
+	}
+
+	svc := dynamodb.New(sess)
+
+	var writeErr error
+	err = svc.ScanPagesWithContext(r.Context(), &dynamodb.ScanInput{TableName: aws.String(req.TableName)},
+		func(page *dynamodb.ScanOutput, lastPage bool) bool {
+			for _, item := range page.Items {
+				if writeErr = exporter.Write(unmarshalItem(item)); writeErr != nil {
+					return false
+				}
+			}
+			return true
+		})
+	if err == nil {
+		err = writeErr
+	}
+	if err == nil {
+		err = exporter.Close()
+	}
+	if err != nil {
+		exporter.Fail(err, "Error exporting items from DynamoDB")
+	}
+}
+
+// ImportItemsHandler puts the items of a JSON Lines or CSV body into a table, 25 at a time.
+// The query string names the table with tableName, region and accountID, and can set format,
+// batchSize and rowsPerSecond. Items that DynamoDB rejects are listed in the report.
+func ImportItemsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	accountID, err := strconv.Atoi(query.Get("accountID"))
+	if err != nil {
+		apierror.BadRequest(w, "Invalid account ID")
+		return
+	}
+	tableName := query.Get("tableName")
+
+	opts, err := bulk.ParseImport(r, maxBatchWrite)
+	if err != nil {
+		apierror.Write(w, err, "Invalid request")
+		return
+	}
+	body, err := bulk.Body(r, &opts)
+	if err != nil {
+		apierror.Write(w, err, "Invalid request")
+		return
+	}
+
+	cloudAccount, err := db.GetCloudAccountDetails(accountID)
+	if err != nil {
+		apierror.Write(w, err, "Error getting cloud account details")
+		return
+	}
+
+	sess, err := awsauth.SessionFor(cloudAccount, query.Get("region"))
+	if err != nil {
+		apierror.Write(w, err, "Error initializing AWS session")
+		return
+	}
+
+	svc := dynamodb.New(sess)
+
+	report, err := bulk.Import(r.Context(), bulk.NewReader(body, opts.Format), opts, importBatch(svc, tableName))
+	bulk.WriteReport(w, report, err)
+}
+
+// importBatch writes rows with BatchWriteItem. One invalid item fails a whole batch, the items
+// of such a batch are put one by one to find it.
+func importBatch(svc *dynamodb.DynamoDB, table string) bulk.WriteBatch {
+	return func(ctx context.Context, rows []bulk.Row) ([]error, error) {
+		errs := make([]error, len(rows))
+		var writes []*dynamodb.WriteRequest
+		var written []int
+		for i, row := range rows {
+			item, err := marshalItem(row.Item)
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+			writes = append(writes, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: item}})
+			written = append(written, i)
+		}
+		if len(writes) == 0 {
+			return errs, nil
+		}
+
+		unprocessed, err := batchWrite(ctx, svc, table, writes)
+		if isValidation(err) {
+			for j, write := range writes {
+				_, err := svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{TableName: aws.String(table), Item: write.PutRequest.Item})
+				if isValidation(err) {
+					errs[written[j]] = errors.New(apierror.From(err).Message)
+				} else if err != nil {
+					return nil, err
+				}
+			}
+			return errs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// DynamoDB returns copies of the writes it did not process, they are found by their item
+		for _, left := range unprocessed {
+			for j, write := range writes {
+				if errs[written[j]] == nil && reflect.DeepEqual(write.PutRequest.Item, left.PutRequest.Item) {
+					errs[written[j]] = errors.New("not written, the table kept throttling the import")
+					break
+				}
+			}
+		}
+		return errs, nil
+	}
+}
+
+func isValidation(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == "ValidationException"
+}
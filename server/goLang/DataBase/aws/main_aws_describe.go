@@ -0,0 +1,225 @@
+package aws_dynamodb
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"btep.project/apierror"
+	"btep.project/auth/awsauth"
+	db "btep.project/databaseConnection"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+type DescribeTableRequest struct {
+	TableName string `json:"tableName"`
+	Region    string `json:"region"`
+	AccountID int    `json:"accountID"`
+}
+
+// TableDetails represents the JSON response structure for describeTable. DynamoDB refreshes
+// ItemCount and the sizes about every six hours.
+type TableDetails struct {
+	TableName        string              `json:"tableName"`
+	TableArn         string              `json:"tableArn"`
+	TableStatus      string              `json:"tableStatus"`
+	CreationDateTime *time.Time          `json:"creationDateTime,omitempty"`
+	BillingMode      string              `json:"billingMode"`
+	ItemCount        int64               `json:"itemCount"`
+	TableSizeBytes   int64               `json:"tableSizeBytes"`
+	KeySchema        []map[string]string `json:"keySchema"`
+	// ProvisionedThroughput is only set for provisioned tables
+	ProvisionedThroughput  map[string]int64    `json:"provisionedThroughput,omitempty"`
+	GlobalSecondaryIndexes []IndexDetails      `json:"globalSecondaryIndexes"`
+	LocalSecondaryIndexes  []IndexDetails      `json:"localSecondaryIndexes"`
+	Stream                 StreamDetails       `json:"stream"`
+	TimeToLive             TimeToLiveDetails   `json:"timeToLive"`
+	PointInTimeRecovery    PointInTimeRecovery `json:"pointInTimeRecovery"`
+}
+
+// IndexDetails describes a secondary index. A global index being created is CREATING and
+// Backfilling while it reads the existing items, local indexes share the status of the table.
+type IndexDetails struct {
+	IndexName             string              `json:"indexName"`
+	IndexStatus           string              `json:"indexStatus,omitempty"`
+	Backfilling           bool                `json:"backfilling"`
+	ItemCount             int64               `json:"itemCount"`
+	IndexSizeBytes        int64               `json:"indexSizeBytes"`
+	KeySchema             []map[string]string `json:"keySchema"`
+	ProjectionType        string              `json:"projectionType"`
+	NonKeyAttributes      []string            `json:"nonKeyAttributes,omitempty"`
+	ProvisionedThroughput map[string]int64    `json:"provisionedThroughput,omitempty"`
+}
+
+type StreamDetails struct {
+	Enabled   bool   `json:"enabled"`
+	ViewType  string `json:"viewType,omitempty"`
+	StreamArn string `json:"streamArn,omitempty"`
+}
+
+type TimeToLiveDetails struct {
+	// Status is ENABLED, DISABLED, ENABLING or DISABLING
+	Status        string `json:"status"`
+	AttributeName string `json:"attributeName,omitempty"`
+}
+
+type PointInTimeRecovery struct {
+	// Status is ENABLED or DISABLED
+	Status                     string     `json:"status"`
+	EarliestRestorableDateTime *time.Time `json:"earliestRestorableDateTime,omitempty"`
+	LatestRestorableDateTime   *time.Time `json:"latestRestorableDateTime,omitempty"`
+}
+
+// DescribeTableHandler reports the status, size, indexes, stream, time to live and point in
+// time recovery of a table
+func DescribeTableHandler(w http.ResponseWriter, r *http.Request) {
+	var req DescribeTableRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		apierror.BadRequest(w, "Invalid request body")
+		return
+	}
+
+	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
+	if err != nil {
+		apierror.Write(w, err, "Error getting cloud account details")
+		return
+	}
+
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
+	if err != nil {
+		apierror.Write(w, err, "Error initializing AWS session")
+		return
+	}
+
+	svc := dynamodb.New(sess)
+	ctx := r.Context()
+	tableName := aws.String(req.TableName)
+
+	table, err := svc.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{TableName: tableName})
+	if err != nil {
+		apierror.Write(w, err, "Error describing table in DynamoDB")
+		return
+	}
+	ttl, err := svc.DescribeTimeToLiveWithContext(ctx, &dynamodb.DescribeTimeToLiveInput{TableName: tableName})
+	if err != nil {
+		apierror.Write(w, err, "Error describing time to live of DynamoDB table")
+		return
+	}
+	backups, err := svc.DescribeContinuousBackupsWithContext(ctx, &dynamodb.DescribeContinuousBackupsInput{TableName: tableName})
+	if err != nil {
+		apierror.Write(w, err, "Error describing backups of DynamoDB table")
+		return
+	}
+
+	resp := tableDetails(table.Table)
+	if description := ttl.TimeToLiveDescription; description != nil {
+		resp.TimeToLive.Status = aws.StringValue(description.TimeToLiveStatus)
+		resp.TimeToLive.AttributeName = aws.StringValue(description.AttributeName)
+	}
+	if description := backups.ContinuousBackupsDescription; description != nil && description.PointInTimeRecoveryDescription != nil {
+		recovery := description.PointInTimeRecoveryDescription
+		resp.PointInTimeRecovery = PointInTimeRecovery{
+			Status:                     aws.StringValue(recovery.PointInTimeRecoveryStatus),
+			EarliestRestorableDateTime: recovery.EarliestRestorableDateTime,
+			LatestRestorableDateTime:   recovery.LatestRestorableDateTime,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func tableDetails(table *dynamodb.TableDescription) *TableDetails {
+	details := &TableDetails{
+		TableName:              aws.StringValue(table.TableName),
+		TableArn:               aws.StringValue(table.TableArn),
+		TableStatus:            aws.StringValue(table.TableStatus),
+		CreationDateTime:       table.CreationDateTime,
+		BillingMode:            dynamodb.BillingModeProvisioned,
+		ItemCount:              aws.Int64Value(table.ItemCount),
+		TableSizeBytes:         aws.Int64Value(table.TableSizeBytes),
+		KeySchema:              keySchemaDetails(table.KeySchema),
+		GlobalSecondaryIndexes: []IndexDetails{},
+		LocalSecondaryIndexes:  []IndexDetails{},
+		TimeToLive:             TimeToLiveDetails{Status: dynamodb.TimeToLiveStatusDisabled},
+		PointInTimeRecovery:    PointInTimeRecovery{Status: dynamodb.PointInTimeRecoveryStatusDisabled},
+	}
+	// Tables that were always provisioned have no billing mode summary
+	if table.BillingModeSummary != nil && table.BillingModeSummary.BillingMode != nil {
+		details.BillingMode = *table.BillingModeSummary.BillingMode
+	}
+	if details.BillingMode == dynamodb.BillingModeProvisioned {
+		details.ProvisionedThroughput = throughputDetails(table.ProvisionedThroughput)
+	}
+	for _, index := range table.GlobalSecondaryIndexes {
+		details.GlobalSecondaryIndexes = append(details.GlobalSecondaryIndexes, IndexDetails{
+			IndexName:             aws.StringValue(index.IndexName),
+			IndexStatus:           aws.StringValue(index.IndexStatus),
+			Backfilling:           aws.BoolValue(index.Backfilling),
+			ItemCount:             aws.Int64Value(index.ItemCount),
+			IndexSizeBytes:        aws.Int64Value(index.IndexSizeBytes),
+			KeySchema:             keySchemaDetails(index.KeySchema),
+			ProjectionType:        projectionType(index.Projection),
+			NonKeyAttributes:      nonKeyAttributes(index.Projection),
+			ProvisionedThroughput: throughputDetails(index.ProvisionedThroughput),
+		})
+	}
+	for _, index := range table.LocalSecondaryIndexes {
+		details.LocalSecondaryIndexes = append(details.LocalSecondaryIndexes, IndexDetails{
+			IndexName:        aws.StringValue(index.IndexName),
+			ItemCount:        aws.Int64Value(index.ItemCount),
+			IndexSizeBytes:   aws.Int64Value(index.IndexSizeBytes),
+			KeySchema:        keySchemaDetails(index.KeySchema),
+			ProjectionType:   projectionType(index.Projection),
+			NonKeyAttributes: nonKeyAttributes(index.Projection),
+		})
+	}
+	if stream := table.StreamSpecification; stream != nil && aws.BoolValue(stream.StreamEnabled) {
+		details.Stream = StreamDetails{
+			Enabled:   true,
+			ViewType:  aws.StringValue(stream.StreamViewType),
+			StreamArn: aws.StringValue(table.LatestStreamArn),
+		}
+	}
+	return details
+}
+
+// keySchemaDetails returns the key schema in the shape createTable takes it
+func keySchemaDetails(elements []*dynamodb.KeySchemaElement) []map[string]string {
+	keySchema := []map[string]string{}
+	for _, element := range elements {
+		keySchema = append(keySchema, map[string]string{
+			"attributeName": aws.StringValue(element.AttributeName),
+			"keyType":       aws.StringValue(element.KeyType),
+		})
+	}
+	return keySchema
+}
+
+// throughputDetails returns nil for on demand tables and indexes, which report zero units
+func throughputDetails(throughput *dynamodb.ProvisionedThroughputDescription) map[string]int64 {
+	if throughput == nil || aws.Int64Value(throughput.ReadCapacityUnits)+aws.Int64Value(throughput.WriteCapacityUnits) == 0 {
+		return nil
+	}
+	return map[string]int64{
+		"readCapacityUnits":  aws.Int64Value(throughput.ReadCapacityUnits),
+		"writeCapacityUnits": aws.Int64Value(throughput.WriteCapacityUnits),
+	}
+}
+
+func projectionType(projection *dynamodb.Projection) string {
+	if projection == nil {
+		return ""
+	}
+	return aws.StringValue(projection.ProjectionType)
+}
+
+func nonKeyAttributes(projection *dynamodb.Projection) []string {
+	if projection == nil {
+		return nil
+	}
+	return aws.StringValueSlice(projection.NonKeyAttributes)
+}
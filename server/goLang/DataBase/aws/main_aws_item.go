@@ -4,22 +4,25 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strings"
 
+	"btep.project/apierror"
+	"btep.project/auth/awsauth"
 	db "btep.project/databaseConnection"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
 )
 
 // ItemRequest represents the JSON request structure for DynamoDB item operations
 type ItemRequest struct {
-	TableName string                 `json:"tableName"`
-	Key       map[string]interface{} `json:"key"`
-	Region    string                 `json:"region"`
-	AccountID int                    `json:"accountID"`
+	TableName string `json:"tableName"`
+	// Key is the whole item for createItem and its primary key for deleteItem, as plain JSON
+	Key map[string]interface{} `json:"key"`
+	// Condition is checked against the stored item, the write fails with 409 when it does not hold
+	Condition *ItemCondition `json:"condition"`
+	Region    string         `json:"region"`
+	AccountID int            `json:"accountID"`
 }
 
 // ItemResponse represents the JSON response structure for DynamoDB item operations
@@ -29,62 +32,51 @@ type ItemResponse struct {
 
 func CreateItemHandler(w http.ResponseWriter, r *http.Request) {
 	var req ItemRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err := decodeRequest(r, &req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(req.Region),
-		Credentials: credentials.NewStaticCredentials(cloudAccount.AccessKey.String, cloudAccount.SecretKey.String, ""),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session")
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 
 	svc := dynamodb.New(sess)
 
-	item := make(map[string]*dynamodb.AttributeValue)
-	for k, v := range req.Key {
-		// Check if the value is a number
-		var attrValue *dynamodb.AttributeValue
-		switch v := v.(type) {
-		case int, int8, int16, int32, int64:
-			attrValue = &dynamodb.AttributeValue{
-				N: aws.String(fmt.Sprintf("%v", v)),
-			}
-		case float32, float64:
-			attrValue = &dynamodb.AttributeValue{
-				N: aws.String(fmt.Sprintf("%f", v)),
-			}
-		default:
-			attrValue = &dynamodb.AttributeValue{
-				S: aws.String(fmt.Sprintf("%v", v)),
-			}
-		}
-		item[k] = attrValue
+	item, err := marshalItem(req.Key)
+	if err != nil {
+		apierror.BadRequest(w, "Invalid item: "+err.Error())
+		return
+	}
+
+	condition, err := conditionExpression(req.Condition)
+	if err != nil {
+		apierror.Write(w, err, "Invalid condition")
+		return
 	}
 
 	input := &dynamodb.PutItemInput{
 		TableName: aws.String(req.TableName),
 		Item:      item,
 	}
+	if condition != nil {
+		input.ConditionExpression = condition.Condition()
+		input.ExpressionAttributeNames = condition.Names()
+		input.ExpressionAttributeValues = condition.Values()
+	}
 
-	_, err = svc.PutItem(input)
+	_, err = svc.PutItemWithContext(r.Context(), input)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating item in DynamoDB: %v", err)
+		apierror.Write(w, conditionError(err), "Error creating item in DynamoDB")
 		return
 	}
 
@@ -93,242 +85,232 @@ func CreateItemHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+// ItemQuery represents the JSON request structure for readItem. The items are read with GetItem
+// when queryKey is the whole primary key, with Query when it holds the partition key of the
+// table or an index, and with a Scan otherwise. Attributes of queryKey that are not used as key
+// conditions filter the items.
 type ItemQuery struct {
 	TableName string                 `json:"tableName"`
 	QueryKey  map[string]interface{} `json:"queryKey"`
-	Region    string                 `json:"region"`
-	AccountID int                    `json:"accountID"`
+	// IndexName queries or scans a secondary index instead of the table
+	IndexName string            `json:"indexName"`
+	SortKey   *SortKeyCondition `json:"sortKey"`
+	// Limit is the most items read in one page, DynamoDB's own limit of 1 MB applies too
+	Limit int64 `json:"limit"`
+	// ExclusiveStartKey is the lastEvaluatedKey of the previous page
+	ExclusiveStartKey map[string]interface{} `json:"exclusiveStartKey"`
+	ConsistentRead    bool                   `json:"consistentRead"`
+	Region            string                 `json:"region"`
+	AccountID         int                    `json:"accountID"`
 }
 
 func ReadItemHandler(w http.ResponseWriter, r *http.Request) {
 	var req ItemQuery
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err := decodeRequest(r, &req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(req.Region),
-		Credentials: credentials.NewStaticCredentials(cloudAccount.AccessKey.String, cloudAccount.SecretKey.String, ""),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session")
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 
 	svc := dynamodb.New(sess)
 
-	// Build the filter expression and expression attribute values dynamically
-	var conditions []string
-	expressionAttributeValues := make(map[string]*dynamodb.AttributeValue)
-	for key, value := range req.QueryKey {
-		conditions = append(conditions, fmt.Sprintf("%s = :%s", key, key))
-
-		var attrValue *dynamodb.AttributeValue
-
-		switch v := value.(type) {
-		case int, int8, int16, int32, int64:
-			attrValue = &dynamodb.AttributeValue{
-				N: aws.String(fmt.Sprintf("%v", v)),
-			}
-		case float32, float64:
-			attrValue = &dynamodb.AttributeValue{
-				N: aws.String(fmt.Sprintf("%f", v)),
-			}
-		default:
-			attrValue = &dynamodb.AttributeValue{
-				S: aws.String(fmt.Sprintf("%v", v)),
-			}
-		}
-
-		expressionAttributeValues[":"+key] = attrValue
-	}
-
-	filterExpression := strings.Join(conditions, " AND ")
-
-	input := &dynamodb.ScanInput{
-		TableName:                 aws.String(req.TableName),
-		FilterExpression:          aws.String(filterExpression),
-		ExpressionAttributeValues: expressionAttributeValues,
-	}
-	fmt.Println("input: ", input)
-
-	result, err := svc.Scan(input)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error querying items from DynamoDB: %v", err)
-		return
-	}
-	fmt.Println("result: ", result)
-	// Convert the result to JSON and send it in the response
-	resultJSON, err := json.Marshal(result.Items)
+	resp, err := readItems(r.Context(), svc, req)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error encoding JSON response: %v", err)
+		apierror.Write(w, err, "Error reading items from DynamoDB")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(resultJSON)
+	json.NewEncoder(w).Encode(resp)
 }
 
 type updateRequest struct {
 	TableName string                 `json:"tableName"`
 	QueryKey  map[string]interface{} `json:"queryKey"`
 	UpdateMap map[string]interface{} `json:"updateMap"`
-	Region    string                 `json:"region"`
-	AccountID int                    `json:"accountID"`
+	// Condition must hold for every item that is updated
+	Condition *ItemCondition `json:"condition"`
+	Region    string         `json:"region"`
+	AccountID int            `json:"accountID"`
 }
 
+// UpdateItemHandler sets the attributes of updateMap on every item whose attributes equal those
+// of queryKey. The key attributes of an item cannot be changed. When the condition of the
+// request fails for an item, the items before it stay updated.
 func UpdateItemHandler(w http.ResponseWriter, r *http.Request) {
 	var req updateRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err := decodeRequest(r, &req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(req.Region),
-		Credentials: credentials.NewStaticCredentials(cloudAccount.AccessKey.String, cloudAccount.SecretKey.String, ""),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session")
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 
 	svc := dynamodb.New(sess)
+	ctx := r.Context()
+
+	table, _, err := describeKeys(ctx, svc, req.TableName)
+	if err != nil {
+		apierror.Write(w, err, "Error describing DynamoDB table")
+		return
+	}
+	update, err := buildUpdate(table, req.UpdateMap, req.Condition)
+	if err != nil {
+		apierror.Write(w, err, "Invalid update")
+		return
+	}
+	values, err := marshalItem(req.QueryKey)
+	if err != nil {
+		apierror.BadRequest(w, "Invalid queryKey: "+err.Error())
+		return
+	}
 
-	// Step 1: Scan for items based on the query key
+	// Step 1: Scan for the keys of the matching items, every page of them
 	scanInput := &dynamodb.ScanInput{
 		TableName: aws.String(req.TableName),
 	}
-	scanInput.FilterExpression, scanInput.ExpressionAttributeValues = buildFilterExpression(req.QueryKey)
-
-	scanResult, err := svc.Scan(scanInput)
+	if filter, ok := equalsFilter(values); ok {
+		expr, err := expression.NewBuilder().WithFilter(filter).Build()
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+		scanInput.FilterExpression = expr.Filter()
+		scanInput.ExpressionAttributeNames = expr.Names()
+		scanInput.ExpressionAttributeValues = expr.Values()
+	}
+	var keys []map[string]*dynamodb.AttributeValue
+	err = svc.ScanPagesWithContext(ctx, scanInput, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			keys = append(keys, table.keyOf(item))
+		}
+		return true
+	})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error scanning items from DynamoDB: %v", err)
+		apierror.Write(w, err, "Error scanning items from DynamoDB")
 		return
 	}
 
 	// Step 2: Update the matching items with the update map
-	for _, item := range scanResult.Items {
+	for i, key := range keys {
 		updateInput := &dynamodb.UpdateItemInput{
 			TableName:                 aws.String(req.TableName),
-			Key:                       item, // Use the item as the key for the update
-			UpdateExpression:          aws.String(buildUpdateExpression(req.UpdateMap)),
-			ExpressionAttributeValues: buildExpressionAttributeValues(req.UpdateMap),
+			Key:                       key,
+			UpdateExpression:          update.Update(),
+			ConditionExpression:       update.Condition(),
+			ExpressionAttributeNames:  update.Names(),
+			ExpressionAttributeValues: update.Values(),
 		}
 
-		_, err = svc.UpdateItem(updateInput)
+		_, err = svc.UpdateItemWithContext(ctx, updateInput)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "Error updating item in DynamoDB: %v", err)
+			apierror.Write(w, conditionError(err), fmt.Sprintf("Error updating item in DynamoDB after %d of %d items", i, len(keys)))
 			return
 		}
 	}
 
-	resp := ItemResponse{Message: "Items updated successfully"}
+	resp := ItemResponse{Message: fmt.Sprintf("%d items updated successfully", len(keys))}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
-// Helper function to build the filter expression and expression attribute values based on the query key
-// Helper function to build the filter expression and expression attribute values based on the query key
-func buildFilterExpression(queryKey map[string]interface{}) (*string, map[string]*dynamodb.AttributeValue) {
-	var expressions []string
-	expressionAttributeValues := make(map[string]*dynamodb.AttributeValue)
-
-	for key, value := range queryKey {
-		expressions = append(expressions, fmt.Sprintf("%s = :%s", key, key))
-		expressionAttributeValues[":"+key] = &dynamodb.AttributeValue{
-			S: aws.String(fmt.Sprintf("%v", value)),
+// buildUpdate returns the expression that sets the attributes of updateMap when condition
+// holds, it must not change the key of the table
+func buildUpdate(table keySchema, updateMap map[string]interface{}, condition *ItemCondition) (expression.Expression, error) {
+	for name := range updateMap {
+		if name == table.partition || name == table.sort {
+			return expression.Expression{}, invalid("%s is a key attribute and cannot be updated", name)
 		}
 	}
-
-	filterExpression := strings.Join(expressions, " AND ")
-	return aws.String(filterExpression), expressionAttributeValues
-}
-
-// Helper function to build the update expression based on the update map
-func buildUpdateExpression(updateMap map[string]interface{}) string {
-	var expressions []string
-	for key := range updateMap {
-		expressions = append(expressions, fmt.Sprintf("%s = :%s", key, key))
+	update, err := updateBuilder(updateMap)
+	if err != nil {
+		return expression.Expression{}, err
 	}
-	return "SET " + strings.Join(expressions, ", ")
-}
-
-// Helper function to build the expression attribute values based on the update map
-func buildExpressionAttributeValues(updateMap map[string]interface{}) map[string]*dynamodb.AttributeValue {
-	expressionAttributeValues := make(map[string]*dynamodb.AttributeValue)
-	for key, value := range updateMap {
-		expressionAttributeValues[":"+key] = &dynamodb.AttributeValue{
-			S: aws.String(fmt.Sprintf("%v", value)), // Assuming all values are strings for simplicity
-		}
+	builder := expression.NewBuilder().WithUpdate(update)
+	built, ok, err := condition.build()
+	if err != nil {
+		return expression.Expression{}, err
 	}
-	return expressionAttributeValues
+	if ok {
+		builder = builder.WithCondition(built)
+	}
+	expr, err := builder.Build()
+	if err != nil {
+		return expression.Expression{}, invalid("%v", err)
+	}
+	return expr, nil
 }
 
 // DeleteItemHandler handles POST requests to delete an item from DynamoDB
 func DeleteItemHandler(w http.ResponseWriter, r *http.Request) {
 	var req ItemRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err := decodeRequest(r, &req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(req.Region),
-		Credentials: credentials.NewStaticCredentials(cloudAccount.AccessKey.String, cloudAccount.SecretKey.String, ""),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session")
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 
 	svc := dynamodb.New(sess)
 
+	key, err := marshalItem(req.Key)
+	if err != nil {
+		apierror.BadRequest(w, "Invalid key: "+err.Error())
+		return
+	}
+	condition, err := conditionExpression(req.Condition)
+	if err != nil {
+		apierror.Write(w, err, "Invalid condition")
+		return
+	}
+
 	input := &dynamodb.DeleteItemInput{
 		TableName: aws.String(req.TableName),
-		Key:       buildKey(req.Key),
+		Key:       key,
+	}
+	if condition != nil {
+		input.ConditionExpression = condition.Condition()
+		input.ExpressionAttributeNames = condition.Names()
+		input.ExpressionAttributeValues = condition.Values()
 	}
 
-	_, err = svc.DeleteItem(input)
+	_, err = svc.DeleteItemWithContext(r.Context(), input)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error deleting item from DynamoDB: %v", err)
+		apierror.Write(w, conditionError(err), "Error deleting item from DynamoDB")
 		return
 	}
 
@@ -337,17 +319,6 @@ func DeleteItemHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
-// Helper function to build DynamoDB Key from input
-func buildKey(input map[string]interface{}) map[string]*dynamodb.AttributeValue {
-	key := make(map[string]*dynamodb.AttributeValue)
-	for k, v := range input {
-		key[k] = &dynamodb.AttributeValue{
-			S: aws.String(fmt.Sprintf("%v", v)),
-		}
-	}
-	return key
-}
-
 type ListItemsQuery struct {
 	TableName string `json:"tableName"`
 	Region    string `json:"region"`
@@ -358,22 +329,19 @@ func ListItemsHandler(w http.ResponseWriter, r *http.Request) {
 	var req ListItemsQuery
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error getting cloud account details: %v", err), http.StatusInternalServerError)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(req.Region),
-		Credentials: credentials.NewStaticCredentials(cloudAccount.AccessKey.String, cloudAccount.SecretKey.String, ""),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
 	if err != nil {
-		http.Error(w, "Error initializing AWS session", http.StatusInternalServerError)
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 
@@ -382,18 +350,16 @@ func ListItemsHandler(w http.ResponseWriter, r *http.Request) {
 	input := &dynamodb.ScanInput{
 		TableName: aws.String(req.TableName),
 	}
-	fmt.Println("input: ", input)
-
 	result, err := svc.Scan(input)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error querying items from DynamoDB: %v", err), http.StatusInternalServerError)
+		apierror.Write(w, err, "Error querying items from DynamoDB")
 		return
 	}
 
 	// Convert the result to JSON and send it in the response
-	resultJSON, err := json.Marshal(result.Items)
+	resultJSON, err := json.Marshal(unmarshalItems(result.Items))
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error encoding JSON response: %v", err), http.StatusInternalServerError)
+		apierror.Write(w, err, "Error encoding JSON response")
 		return
 	}
 
@@ -0,0 +1,359 @@
+package aws_dynamodb
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"btep.project/apierror"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// Operations a read can be served with
+const (
+	operationGetItem = "GetItem"
+	operationQuery   = "Query"
+	operationScan    = "Scan"
+)
+
+// SortKeyCondition narrows the sort key of a Query
+type SortKeyCondition struct {
+	// Operator is one of =, <, <=, >, >=, begins_with and between
+	Operator string      `json:"operator"`
+	Value    interface{} `json:"value"`
+	// UpperBound is the second value of between
+	UpperBound interface{} `json:"upperBound"`
+}
+
+// ItemsResponse represents the JSON response structure for readItem
+type ItemsResponse struct {
+	// Operation is GetItem, Query or Scan, whichever served the read
+	Operation string                   `json:"operation"`
+	IndexName string                   `json:"indexName,omitempty"`
+	Items     []map[string]interface{} `json:"items"`
+	// LastEvaluatedKey is set while there are more items, it is sent back as exclusiveStartKey
+	LastEvaluatedKey map[string]interface{} `json:"lastEvaluatedKey,omitempty"`
+}
+
+// keySchema names the key attributes of a table or one of its indexes
+type keySchema struct {
+	// index is empty for the table itself
+	index     string
+	partition string
+	// sort is empty when there is no sort key
+	sort string
+	// projectsAll is true when the index holds every attribute of the items
+	projectsAll bool
+}
+
+func newKeySchema(index string, elements []*dynamodb.KeySchemaElement, projection *dynamodb.Projection) keySchema {
+	schema := keySchema{index: index, projectsAll: projection == nil || aws.StringValue(projection.ProjectionType) == dynamodb.ProjectionTypeAll}
+	for _, element := range elements {
+		if aws.StringValue(element.KeyType) == dynamodb.KeyTypeHash {
+			schema.partition = aws.StringValue(element.AttributeName)
+		} else {
+			schema.sort = aws.StringValue(element.AttributeName)
+		}
+	}
+	return schema
+}
+
+// name describes the schema in error messages
+func (k keySchema) name(table string) string {
+	if k.index == "" {
+		return "table " + table
+	}
+	return "index " + k.index
+}
+
+// isKey reports whether values hold exactly the key attributes
+func (k keySchema) isKey(values map[string]*dynamodb.AttributeValue) bool {
+	if _, ok := values[k.partition]; !ok {
+		return false
+	}
+	if k.sort == "" {
+		return len(values) == 1
+	}
+	_, ok := values[k.sort]
+	return ok && len(values) == 2
+}
+
+// keyOf returns the key attributes of item
+func (k keySchema) keyOf(item map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	key := map[string]*dynamodb.AttributeValue{k.partition: item[k.partition]}
+	if k.sort != "" {
+		key[k.sort] = item[k.sort]
+	}
+	return key
+}
+
+// describeKeys returns the key schema of the table and of its global and local secondary indexes
+func describeKeys(ctx context.Context, svc *dynamodb.DynamoDB, table string) (keySchema, []keySchema, error) {
+	out, err := svc.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)})
+	if err != nil {
+		return keySchema{}, nil, err
+	}
+	description := out.Table
+	var indexes []keySchema
+	for _, index := range description.GlobalSecondaryIndexes {
+		indexes = append(indexes, newKeySchema(aws.StringValue(index.IndexName), index.KeySchema, index.Projection))
+	}
+	for _, index := range description.LocalSecondaryIndexes {
+		indexes = append(indexes, newKeySchema(aws.StringValue(index.IndexName), index.KeySchema, index.Projection))
+	}
+	return newKeySchema("", description.KeySchema, nil), indexes, nil
+}
+
+// readItems serves req with the cheapest operation the given attributes allow: GetItem for the
+// whole primary key, Query when the partition key of the table or an index is known, and a Scan
+// of one page otherwise
+func readItems(ctx context.Context, svc *dynamodb.DynamoDB, req ItemQuery) (*ItemsResponse, error) {
+	if req.Limit < 0 {
+		return nil, invalid("limit cannot be negative")
+	}
+	values, err := marshalItem(req.QueryKey)
+	if err != nil {
+		return nil, invalid("invalid queryKey: %v", err)
+	}
+	var startKey map[string]*dynamodb.AttributeValue
+	if req.ExclusiveStartKey != nil {
+		if startKey, err = marshalItem(req.ExclusiveStartKey); err != nil {
+			return nil, invalid("invalid exclusiveStartKey: %v", err)
+		}
+	}
+
+	table, indexes, err := describeKeys(ctx, svc, req.TableName)
+	if err != nil {
+		return nil, err
+	}
+	if req.IndexName == "" && req.SortKey == nil && table.isKey(values) {
+		return getItem(ctx, svc, req, values)
+	}
+
+	schema, err := chooseSchema(req, table, indexes, values)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := values[schema.partition]; ok {
+		return query(ctx, svc, req, schema, values, startKey)
+	}
+	if req.SortKey != nil {
+		return nil, invalid("sortKey needs the partition key %s of the %s in queryKey", schema.partition, schema.name(req.TableName))
+	}
+	return scan(ctx, svc, req, values, startKey)
+}
+
+// chooseSchema returns the requested index, or the table, or else an index that can be queried
+// with the given attributes and holds whole items
+func chooseSchema(req ItemQuery, table keySchema, indexes []keySchema, values map[string]*dynamodb.AttributeValue) (keySchema, error) {
+	if req.IndexName != "" {
+		for _, index := range indexes {
+			if index.index == req.IndexName {
+				return index, nil
+			}
+		}
+		return keySchema{}, invalid("table %s has no index %s", req.TableName, req.IndexName)
+	}
+	if _, ok := values[table.partition]; ok {
+		return table, nil
+	}
+
+	var candidate *keySchema
+	for i, index := range indexes {
+		if _, ok := values[index.partition]; !ok || !index.projectsAll {
+			continue
+		}
+		// An index whose sort key is also given narrows the query further
+		if _, ok := values[index.sort]; ok || candidate == nil {
+			candidate = &indexes[i]
+		}
+	}
+	if candidate != nil {
+		return *candidate, nil
+	}
+	return table, nil
+}
+
+func getItem(ctx context.Context, svc *dynamodb.DynamoDB, req ItemQuery, key map[string]*dynamodb.AttributeValue) (*ItemsResponse, error) {
+	out, err := svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(req.TableName),
+		Key:            key,
+		ConsistentRead: aws.Bool(req.ConsistentRead),
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp := &ItemsResponse{Operation: operationGetItem, Items: []map[string]interface{}{}}
+	if out.Item != nil {
+		resp.Items = append(resp.Items, unmarshalItem(out.Item))
+	}
+	return resp, nil
+}
+
+// query reads the items of one partition. The sort key is matched by req.SortKey or by equality
+// when it is in values, the other attributes of values filter the items.
+func query(ctx context.Context, svc *dynamodb.DynamoDB, req ItemQuery, schema keySchema, values, startKey map[string]*dynamodb.AttributeValue) (*ItemsResponse, error) {
+	rest := make(map[string]*dynamodb.AttributeValue, len(values))
+	for name, value := range values {
+		rest[name] = value
+	}
+	keyCondition := expression.Key(schema.partition).Equal(expression.Value(*rest[schema.partition]))
+	delete(rest, schema.partition)
+
+	sortValue, sortGiven := rest[schema.sort]
+	switch {
+	case req.SortKey != nil && schema.sort == "":
+		return nil, invalid("the %s has no sort key", schema.name(req.TableName))
+	case req.SortKey != nil && sortGiven:
+		return nil, invalid("give the sort key %s either in queryKey or in sortKey", schema.sort)
+	case req.SortKey != nil:
+		condition, err := sortKeyCondition(schema.sort, req.SortKey)
+		if err != nil {
+			return nil, err
+		}
+		keyCondition = keyCondition.And(condition)
+	case sortGiven && schema.sort != "":
+		keyCondition = keyCondition.And(expression.Key(schema.sort).Equal(expression.Value(*sortValue)))
+		delete(rest, schema.sort)
+	}
+
+	builder := expression.NewBuilder().WithKeyCondition(keyCondition)
+	if filter, ok := equalsFilter(rest); ok {
+		builder = builder.WithFilter(filter)
+	}
+	expr, err := builder.Build()
+	if err != nil {
+		return nil, invalid("%v", err)
+	}
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(req.TableName),
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ExclusiveStartKey:         startKey,
+		ConsistentRead:            aws.Bool(req.ConsistentRead),
+	}
+	if schema.index != "" {
+		input.IndexName = aws.String(schema.index)
+	}
+	if req.Limit > 0 {
+		input.Limit = aws.Int64(req.Limit)
+	}
+	out, err := svc.QueryWithContext(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	return &ItemsResponse{
+		Operation:        operationQuery,
+		IndexName:        schema.index,
+		Items:            unmarshalItems(out.Items),
+		LastEvaluatedKey: unmarshalItem(out.LastEvaluatedKey),
+	}, nil
+}
+
+// scan reads one page of the table or index, keeping the items that equal values
+func scan(ctx context.Context, svc *dynamodb.DynamoDB, req ItemQuery, values, startKey map[string]*dynamodb.AttributeValue) (*ItemsResponse, error) {
+	input := &dynamodb.ScanInput{
+		TableName:         aws.String(req.TableName),
+		ExclusiveStartKey: startKey,
+		ConsistentRead:    aws.Bool(req.ConsistentRead),
+	}
+	if filter, ok := equalsFilter(values); ok {
+		expr, err := expression.NewBuilder().WithFilter(filter).Build()
+		if err != nil {
+			return nil, invalid("%v", err)
+		}
+		input.FilterExpression = expr.Filter()
+		input.ExpressionAttributeNames = expr.Names()
+		input.ExpressionAttributeValues = expr.Values()
+	}
+	if req.IndexName != "" {
+		input.IndexName = aws.String(req.IndexName)
+	}
+	if req.Limit > 0 {
+		input.Limit = aws.Int64(req.Limit)
+	}
+	out, err := svc.ScanWithContext(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	return &ItemsResponse{
+		Operation:        operationScan,
+		IndexName:        req.IndexName,
+		Items:            unmarshalItems(out.Items),
+		LastEvaluatedKey: unmarshalItem(out.LastEvaluatedKey),
+	}, nil
+}
+
+func sortKeyCondition(name string, condition *SortKeyCondition) (expression.KeyConditionBuilder, error) {
+	var none expression.KeyConditionBuilder
+	if condition.Value == nil {
+		return none, invalid("sortKey needs a value")
+	}
+	value, err := marshalValue(condition.Value)
+	if err != nil {
+		return none, invalid("invalid sortKey value: %v", err)
+	}
+	key := expression.Key(name)
+	switch strings.ToLower(condition.Operator) {
+	case "", "=":
+		return key.Equal(expression.Value(*value)), nil
+	case "<":
+		return key.LessThan(expression.Value(*value)), nil
+	case "<=":
+		return key.LessThanEqual(expression.Value(*value)), nil
+	case ">":
+		return key.GreaterThan(expression.Value(*value)), nil
+	case ">=":
+		return key.GreaterThanEqual(expression.Value(*value)), nil
+	case "begins_with":
+		if value.S == nil {
+			return none, invalid("begins_with needs a string prefix")
+		}
+		return key.BeginsWith(*value.S), nil
+	case "between":
+		if condition.UpperBound == nil {
+			return none, invalid("between needs an upperBound")
+		}
+		upper, err := marshalValue(condition.UpperBound)
+		if err != nil {
+			return none, invalid("invalid sortKey upperBound: %v", err)
+		}
+		return key.Between(expression.Value(*value), expression.Value(*upper)), nil
+	}
+	return none, invalid("sortKey operator must be =, <, <=, >, >=, begins_with or between, not %q", condition.Operator)
+}
+
+// equalsFilter requires every attribute of values to equal its value. The names are set
+// through ExpressionAttributeNames, so reserved words like "name" or "status" work, and a name
+// with dots addresses a nested attribute.
+func equalsFilter(values map[string]*dynamodb.AttributeValue) (expression.ConditionBuilder, bool) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	// Sorted, so the same request always builds the same expression
+	sort.Strings(names)
+
+	conditions := make([]expression.ConditionBuilder, len(names))
+	for i, name := range names {
+		conditions[i] = expression.Name(name).Equal(expression.Value(*values[name]))
+	}
+	switch len(conditions) {
+	case 0:
+		return expression.ConditionBuilder{}, false
+	case 1:
+		return conditions[0], true
+	}
+	return expression.And(conditions[0], conditions[1], conditions[2:]...), true
+}
+
+func invalid(format string, args ...interface{}) error {
+	return apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, fmt.Sprintf(format, args...))
+}
@@ -1,14 +1,16 @@
 package aws_dynamodb
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 
+	"btep.project/apierror"
+	"btep.project/auth/awsauth"
 	db "btep.project/databaseConnection"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 )
 
@@ -19,6 +21,39 @@ type TableRequest struct {
 	AttributeDefinitions  []map[string]string `json:"attributeDefinitions"`
 	KeySchema             []map[string]string `json:"keySchema"`
 	ProvisionedThroughput map[string]int64    `json:"provisionedThroughput"`
+	// BillingMode is PROVISIONED, the default, or PAY_PER_REQUEST which ignores the throughput
+	BillingMode            string         `json:"billingMode"`
+	GlobalSecondaryIndexes []IndexRequest `json:"globalSecondaryIndexes"`
+	LocalSecondaryIndexes  []IndexRequest `json:"localSecondaryIndexes"`
+	Stream                 *StreamRequest `json:"stream"`
+	// TimeToLive and PointInTimeRecovery are set once the table is active, the request waits for it
+	TimeToLive          *TimeToLiveRequest `json:"timeToLive"`
+	PointInTimeRecovery *bool              `json:"pointInTimeRecovery"`
+}
+
+// IndexRequest describes a secondary index, the attributes of its key schema must be in the
+// attributeDefinitions of the request
+type IndexRequest struct {
+	IndexName string              `json:"indexName"`
+	KeySchema []map[string]string `json:"keySchema"`
+	// ProjectionType is ALL, the default, KEYS_ONLY or INCLUDE with NonKeyAttributes
+	ProjectionType   string   `json:"projectionType"`
+	NonKeyAttributes []string `json:"nonKeyAttributes"`
+	// ProvisionedThroughput of a global index defaults to that of the table
+	ProvisionedThroughput map[string]int64 `json:"provisionedThroughput"`
+}
+
+// StreamRequest turns the stream of a table on or off, ViewType says what a change record holds:
+// KEYS_ONLY, NEW_IMAGE, OLD_IMAGE or NEW_AND_OLD_IMAGES, the default
+type StreamRequest struct {
+	Enabled  bool   `json:"enabled"`
+	ViewType string `json:"viewType"`
+}
+
+// TimeToLiveRequest names the attribute holding the epoch second an item expires at
+type TimeToLiveRequest struct {
+	AttributeName string `json:"attributeName"`
+	Enabled       bool   `json:"enabled"`
 }
 
 type TableResponse struct {
@@ -30,48 +65,80 @@ func CreateTableHandler(w http.ResponseWriter, r *http.Request) {
 	var req TableRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
+		return
+	}
+
+	billingMode, err := parseBillingMode(req.BillingMode)
+	if err != nil {
+		apierror.Write(w, err, "Invalid request")
 		return
 	}
 
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(req.Region),
-		Credentials: credentials.NewStaticCredentials(cloudAccount.AccessKey.String, cloudAccount.SecretKey.String, ""),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session")
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 
 	svc := dynamodb.New(sess)
-	fmt.Println(req.AttributeDefinitions)
+	ctx := r.Context()
 
 	input := &dynamodb.CreateTableInput{
 		AttributeDefinitions: buildAttributeDefinitions(req.AttributeDefinitions),
 		KeySchema:            buildKeySchema(req.KeySchema),
 		TableName:            aws.String(req.TableName),
-		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
-			ReadCapacityUnits:  aws.Int64(req.ProvisionedThroughput["ReadCapacityUnits"]),
-			WriteCapacityUnits: aws.Int64(req.ProvisionedThroughput["WriteCapacityUnits"]),
-		},
+		BillingMode:          aws.String(billingMode),
+		StreamSpecification:  buildStreamSpecification(req.Stream),
+	}
+	provisioned := billingMode == dynamodb.BillingModeProvisioned
+	if provisioned {
+		input.ProvisionedThroughput = buildProvisionedThroughput(req.ProvisionedThroughput)
+	}
+	for _, index := range req.GlobalSecondaryIndexes {
+		gsi := &dynamodb.GlobalSecondaryIndex{
+			IndexName:  aws.String(index.IndexName),
+			KeySchema:  buildKeySchema(index.KeySchema),
+			Projection: buildProjection(index),
+		}
+		if provisioned {
+			gsi.ProvisionedThroughput = indexThroughput(index, input.ProvisionedThroughput)
+		}
+		input.GlobalSecondaryIndexes = append(input.GlobalSecondaryIndexes, gsi)
+	}
+	for _, index := range req.LocalSecondaryIndexes {
+		input.LocalSecondaryIndexes = append(input.LocalSecondaryIndexes, &dynamodb.LocalSecondaryIndex{
+			IndexName:  aws.String(index.IndexName),
+			KeySchema:  buildKeySchema(index.KeySchema),
+			Projection: buildProjection(index),
+		})
 	}
 
-	_, err = svc.CreateTable(input)
+	_, err = svc.CreateTableWithContext(ctx, input)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating table in DynamoDB: %v", err)
+		apierror.Write(w, err, "Error creating table in DynamoDB")
 		return
 	}
 
+	if req.TimeToLive != nil || req.PointInTimeRecovery != nil {
+		// Both can only be set on an active table
+		err = svc.WaitUntilTableExistsWithContext(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(req.TableName)})
+		if err != nil {
+			apierror.Write(w, err, "Error waiting for the DynamoDB table to become active")
+			return
+		}
+		if err := updateTableSettings(ctx, svc, req.TableName, req.TimeToLive, req.PointInTimeRecovery); err != nil {
+			apierror.Write(w, err, "Table created, but its settings could not be applied")
+			return
+		}
+	}
+
 	resp := TableResponse{Message: "Table created successfully"}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
@@ -88,25 +155,19 @@ func DeleteTableHandler(w http.ResponseWriter, r *http.Request) {
 	var req DeleteTableRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(req.Region),
-		Credentials: credentials.NewStaticCredentials(cloudAccount.AccessKey.String, cloudAccount.SecretKey.String, ""),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session")
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 
@@ -118,8 +179,7 @@ func DeleteTableHandler(w http.ResponseWriter, r *http.Request) {
 
 	_, err = svc.DeleteTable(input)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error deleting table from DynamoDB: %v", err)
+		apierror.Write(w, err, "Error deleting table from DynamoDB")
 		return
 	}
 
@@ -134,6 +194,17 @@ type UpdateTableRequest struct {
 	AccountID             int                 `json:"accountID"`
 	AttributeDefinitions  []map[string]string `json:"attributeDefinitions,omitempty"`
 	ProvisionedThroughput map[string]int64    `json:"provisionedThroughput,omitempty"`
+	BillingMode           string              `json:"billingMode,omitempty"`
+	// DynamoDB creates or deletes one global secondary index per update, UpdateIndexes only
+	// changes the throughput of existing ones
+	CreateIndex   *IndexRequest  `json:"createIndex,omitempty"`
+	DeleteIndex   string         `json:"deleteIndex,omitempty"`
+	UpdateIndexes []IndexRequest `json:"updateIndexes,omitempty"`
+	Stream        *StreamRequest `json:"stream,omitempty"`
+	// Local secondary indexes cannot be changed after the table is created
+	LocalSecondaryIndexes []IndexRequest     `json:"localSecondaryIndexes,omitempty"`
+	TimeToLive            *TimeToLiveRequest `json:"timeToLive,omitempty"`
+	PointInTimeRecovery   *bool              `json:"pointInTimeRecovery,omitempty"`
 }
 
 // UpdateTableHandler handles POST requests to update a table in DynamoDB
@@ -141,44 +212,89 @@ func UpdateTableHandler(w http.ResponseWriter, r *http.Request) {
 	var req UpdateTableRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
+		return
+	}
+	if len(req.LocalSecondaryIndexes) > 0 {
+		apierror.BadRequest(w, "local secondary indexes can only be created with the table")
 		return
 	}
+	if req.CreateIndex != nil && req.DeleteIndex != "" {
+		apierror.BadRequest(w, "an update can create or delete an index, not both")
+		return
+	}
+	billingMode := ""
+	if req.BillingMode != "" {
+		if billingMode, err = parseBillingMode(req.BillingMode); err != nil {
+			apierror.Write(w, err, "Invalid request")
+			return
+		}
+	}
 
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(req.Region),
-		Credentials: credentials.NewStaticCredentials(cloudAccount.AccessKey.String, cloudAccount.SecretKey.String, ""),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session")
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 
 	svc := dynamodb.New(sess)
+	ctx := r.Context()
+
+	// Time to live and backups are not part of UpdateTable and do not change the table status,
+	// so they go first
+	if err := updateTableSettings(ctx, svc, req.TableName, req.TimeToLive, req.PointInTimeRecovery); err != nil {
+		apierror.Write(w, err, "Error updating table settings in DynamoDB")
+		return
+	}
 
 	input := &dynamodb.UpdateTableInput{
-		TableName:            aws.String(req.TableName),
-		AttributeDefinitions: buildAttributeDefinitions(req.AttributeDefinitions),
-		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
-			ReadCapacityUnits:  aws.Int64(req.ProvisionedThroughput["readCapacityUnits"]),
-			WriteCapacityUnits: aws.Int64(req.ProvisionedThroughput["writeCapacityUnits"]),
-		},
+		TableName:             aws.String(req.TableName),
+		AttributeDefinitions:  buildAttributeDefinitions(req.AttributeDefinitions),
+		ProvisionedThroughput: buildProvisionedThroughput(req.ProvisionedThroughput),
+		StreamSpecification:   buildStreamSpecification(req.Stream),
+	}
+	if billingMode != "" {
+		input.BillingMode = aws.String(billingMode)
+	}
+	if index := req.CreateIndex; index != nil {
+		action := &dynamodb.CreateGlobalSecondaryIndexAction{
+			IndexName:  aws.String(index.IndexName),
+			KeySchema:  buildKeySchema(index.KeySchema),
+			Projection: buildProjection(*index),
+		}
+		if billingMode != dynamodb.BillingModePayPerRequest {
+			action.ProvisionedThroughput = buildProvisionedThroughput(index.ProvisionedThroughput)
+		}
+		input.GlobalSecondaryIndexUpdates = append(input.GlobalSecondaryIndexUpdates, &dynamodb.GlobalSecondaryIndexUpdate{Create: action})
+	}
+	if req.DeleteIndex != "" {
+		input.GlobalSecondaryIndexUpdates = append(input.GlobalSecondaryIndexUpdates, &dynamodb.GlobalSecondaryIndexUpdate{
+			Delete: &dynamodb.DeleteGlobalSecondaryIndexAction{IndexName: aws.String(req.DeleteIndex)},
+		})
+	}
+	for _, index := range req.UpdateIndexes {
+		input.GlobalSecondaryIndexUpdates = append(input.GlobalSecondaryIndexUpdates, &dynamodb.GlobalSecondaryIndexUpdate{
+			Update: &dynamodb.UpdateGlobalSecondaryIndexAction{
+				IndexName:             aws.String(index.IndexName),
+				ProvisionedThroughput: buildProvisionedThroughput(index.ProvisionedThroughput),
+			},
+		})
 	}
 
-	_, err = svc.UpdateTable(input)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error updating table in DynamoDB: %v", err)
-		return
+	// DynamoDB rejects an update that changes nothing
+	if input.ProvisionedThroughput != nil || input.StreamSpecification != nil || input.BillingMode != nil ||
+		len(input.GlobalSecondaryIndexUpdates) > 0 {
+		_, err = svc.UpdateTableWithContext(ctx, input)
+		if err != nil {
+			apierror.Write(w, err, "Error updating table in DynamoDB")
+			return
+		}
 	}
 
 	resp := TableResponse{Message: "Table updated successfully"}
@@ -186,6 +302,101 @@ func UpdateTableHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+// updateTableSettings sets the time to live and point in time recovery when they are given
+func updateTableSettings(ctx context.Context, svc *dynamodb.DynamoDB, table string, ttl *TimeToLiveRequest, pointInTimeRecovery *bool) error {
+	if ttl != nil {
+		if ttl.AttributeName == "" {
+			return apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "timeToLive needs an attributeName")
+		}
+		_, err := svc.UpdateTimeToLiveWithContext(ctx, &dynamodb.UpdateTimeToLiveInput{
+			TableName: aws.String(table),
+			TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
+				AttributeName: aws.String(ttl.AttributeName),
+				Enabled:       aws.Bool(ttl.Enabled),
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if pointInTimeRecovery != nil {
+		_, err := svc.UpdateContinuousBackupsWithContext(ctx, &dynamodb.UpdateContinuousBackupsInput{
+			TableName: aws.String(table),
+			PointInTimeRecoverySpecification: &dynamodb.PointInTimeRecoverySpecification{
+				PointInTimeRecoveryEnabled: pointInTimeRecovery,
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseBillingMode(mode string) (string, error) {
+	switch strings.ToUpper(mode) {
+	case "", dynamodb.BillingModeProvisioned:
+		return dynamodb.BillingModeProvisioned, nil
+	case dynamodb.BillingModePayPerRequest, "ON_DEMAND":
+		return dynamodb.BillingModePayPerRequest, nil
+	}
+	return "", apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest,
+		fmt.Sprintf("billingMode must be PROVISIONED or PAY_PER_REQUEST, not %q", mode))
+}
+
+// buildProvisionedThroughput returns nil when no capacity is given. The units are read in either
+// case, createTable has been sent ReadCapacityUnits and updateTable readCapacityUnits.
+func buildProvisionedThroughput(units map[string]int64) *dynamodb.ProvisionedThroughput {
+	read, write := units["readCapacityUnits"], units["writeCapacityUnits"]
+	if read == 0 {
+		read = units["ReadCapacityUnits"]
+	}
+	if write == 0 {
+		write = units["WriteCapacityUnits"]
+	}
+	if read == 0 && write == 0 {
+		return nil
+	}
+	return &dynamodb.ProvisionedThroughput{
+		ReadCapacityUnits:  aws.Int64(read),
+		WriteCapacityUnits: aws.Int64(write),
+	}
+}
+
+// indexThroughput returns the throughput of a global index, the table's when it has none
+func indexThroughput(index IndexRequest, table *dynamodb.ProvisionedThroughput) *dynamodb.ProvisionedThroughput {
+	if throughput := buildProvisionedThroughput(index.ProvisionedThroughput); throughput != nil {
+		return throughput
+	}
+	return table
+}
+
+func buildProjection(index IndexRequest) *dynamodb.Projection {
+	projection := &dynamodb.Projection{ProjectionType: aws.String(dynamodb.ProjectionTypeAll)}
+	if index.ProjectionType != "" {
+		projection.ProjectionType = aws.String(strings.ToUpper(index.ProjectionType))
+	}
+	if len(index.NonKeyAttributes) > 0 {
+		projection.NonKeyAttributes = aws.StringSlice(index.NonKeyAttributes)
+	}
+	return projection
+}
+
+func buildStreamSpecification(stream *StreamRequest) *dynamodb.StreamSpecification {
+	if stream == nil {
+		return nil
+	}
+	spec := &dynamodb.StreamSpecification{StreamEnabled: aws.Bool(stream.Enabled)}
+	if stream.Enabled {
+		viewType := strings.ToUpper(stream.ViewType)
+		if viewType == "" {
+			viewType = dynamodb.StreamViewTypeNewAndOldImages
+		}
+		spec.StreamViewType = aws.String(viewType)
+	}
+	return spec
+}
+
 // Helper function to build DynamoDB AttributeDefinitions from input
 func buildAttributeDefinitions(input []map[string]string) []*dynamodb.AttributeDefinition {
 	var attributeDefinitions []*dynamodb.AttributeDefinition
@@ -219,27 +430,21 @@ func ListTablesHandler(w http.ResponseWriter, r *http.Request) {
 	var req ListTableRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Get cloud account details
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Create AWS session
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(req.Region),
-		Credentials: credentials.NewStaticCredentials(cloudAccount.AccessKey.String, cloudAccount.SecretKey.String, ""),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session")
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 
@@ -252,8 +457,7 @@ func ListTablesHandler(w http.ResponseWriter, r *http.Request) {
 	// ListTables operation to get a list of table names
 	result, err := svc.ListTables(input)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error listing tables in DynamoDB: %v", err)
+		apierror.Write(w, err, "Error listing tables in DynamoDB")
 		return
 	}
 
@@ -268,8 +472,7 @@ func ListTablesHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		tableOutput, err := svc.DescribeTable(describeInput)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "Error describing table %s: %v", *tableName, err)
+			apierror.Write(w, err, fmt.Sprintf("Error describing table %s", *tableName))
 			return
 		}
 
@@ -0,0 +1,493 @@
+package aws_dynamodb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"btep.project/apierror"
+	"btep.project/auth/awsauth"
+	db "btep.project/databaseConnection"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+const (
+	// maxBatchWrite is the most writes BatchWriteItem takes at once
+	maxBatchWrite = 25
+	// maxTransactWrite is the most actions TransactWriteItems takes at once
+	maxTransactWrite = 100
+	// batchAttempts is how often unprocessed writes are sent before giving up
+	batchAttempts = 8
+	batchBackoff  = 50 * time.Millisecond
+	maxBackoff    = 5 * time.Second
+)
+
+// Types of the actions of a transaction
+const (
+	actionPut            = "put"
+	actionUpdate         = "update"
+	actionDelete         = "delete"
+	actionConditionCheck = "conditionCheck"
+)
+
+// ItemCondition makes a write depend on the item it replaces, changes or deletes, every part of
+// it must hold. A put that only creates items has the partition key in AttributeNotExists.
+type ItemCondition struct {
+	AttributeExists    []string `json:"attributeExists"`
+	AttributeNotExists []string `json:"attributeNotExists"`
+	// Equals lists the values attributes must have, e.g. the version an update was made on
+	Equals  map[string]interface{} `json:"equals"`
+	Compare []AttributeComparison  `json:"compare"`
+}
+
+// AttributeComparison compares an attribute of the item with a value
+type AttributeComparison struct {
+	Attribute string `json:"attribute"`
+	// Operator is one of =, <>, <, <=, >, >=, begins_with, contains and between
+	Operator string      `json:"operator"`
+	Value    interface{} `json:"value"`
+	// UpperBound is the second value of between
+	UpperBound interface{} `json:"upperBound"`
+}
+
+// build returns the condition, ok is false when c holds nothing to check
+func (c *ItemCondition) build() (condition expression.ConditionBuilder, ok bool, err error) {
+	if c == nil {
+		return condition, false, nil
+	}
+	var conditions []expression.ConditionBuilder
+	for _, name := range c.AttributeExists {
+		conditions = append(conditions, expression.AttributeExists(expression.Name(name)))
+	}
+	for _, name := range c.AttributeNotExists {
+		conditions = append(conditions, expression.AttributeNotExists(expression.Name(name)))
+	}
+	values, err := marshalItem(c.Equals)
+	if err != nil {
+		return condition, false, invalid("invalid condition: %v", err)
+	}
+	if equals, ok := equalsFilter(values); ok {
+		conditions = append(conditions, equals)
+	}
+	for _, comparison := range c.Compare {
+		compared, err := comparison.build()
+		if err != nil {
+			return condition, false, err
+		}
+		conditions = append(conditions, compared)
+	}
+
+	switch len(conditions) {
+	case 0:
+		return condition, false, nil
+	case 1:
+		return conditions[0], true, nil
+	}
+	return expression.And(conditions[0], conditions[1], conditions[2:]...), true, nil
+}
+
+func (c AttributeComparison) build() (expression.ConditionBuilder, error) {
+	var none expression.ConditionBuilder
+	if c.Attribute == "" {
+		return none, invalid("a comparison of the condition needs an attribute")
+	}
+	if c.Value == nil {
+		return none, invalid("the comparison of %s needs a value", c.Attribute)
+	}
+	av, err := marshalValue(c.Value)
+	if err != nil {
+		return none, invalid("invalid value of %s: %v", c.Attribute, err)
+	}
+	name, value := expression.Name(c.Attribute), expression.Value(*av)
+	switch strings.ToLower(c.Operator) {
+	case "", "=":
+		return name.Equal(value), nil
+	case "<>":
+		return name.NotEqual(value), nil
+	case "<":
+		return name.LessThan(value), nil
+	case "<=":
+		return name.LessThanEqual(value), nil
+	case ">":
+		return name.GreaterThan(value), nil
+	case ">=":
+		return name.GreaterThanEqual(value), nil
+	case "begins_with", "contains":
+		if av.S == nil {
+			return none, invalid("%s on %s needs a string", c.Operator, c.Attribute)
+		}
+		if strings.ToLower(c.Operator) == "contains" {
+			return name.Contains(*av.S), nil
+		}
+		return name.BeginsWith(*av.S), nil
+	case "between":
+		if c.UpperBound == nil {
+			return none, invalid("between on %s needs an upperBound", c.Attribute)
+		}
+		upper, err := marshalValue(c.UpperBound)
+		if err != nil {
+			return none, invalid("invalid upperBound of %s: %v", c.Attribute, err)
+		}
+		return name.Between(value, expression.Value(*upper)), nil
+	}
+	return none, invalid("the operator of %s must be =, <>, <, <=, >, >=, begins_with, contains or between, not %q", c.Attribute, c.Operator)
+}
+
+// conditionExpression builds the condition of a single write. The expression is nil when
+// there is nothing to check.
+func conditionExpression(condition *ItemCondition) (*expression.Expression, error) {
+	built, ok, err := condition.build()
+	if err != nil || !ok {
+		return nil, err
+	}
+	expr, err := expression.NewBuilder().WithCondition(built).Build()
+	if err != nil {
+		return nil, invalid("%v", err)
+	}
+	return &expr, nil
+}
+
+// updateBuilder sets the attributes of updateMap
+func updateBuilder(updateMap map[string]interface{}) (expression.UpdateBuilder, error) {
+	var update expression.UpdateBuilder
+	if len(updateMap) == 0 {
+		return update, invalid("updateMap cannot be empty")
+	}
+	// Sorted, so the same request always builds the same expression
+	names := make([]string, 0, len(updateMap))
+	for name := range updateMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		av, err := marshalValue(updateMap[name])
+		if err != nil {
+			return update, invalid("invalid value of %s: %v", name, err)
+		}
+		update = update.Set(expression.Name(name), expression.Value(*av))
+	}
+	return update, nil
+}
+
+// conditionError explains why a conditional write failed, the message of DynamoDB only says
+// that a condition did not hold. Other errors are returned as they are.
+func conditionError(err error) error {
+	var failed *dynamodb.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		e := apierror.From(err)
+		e.Message = "the item does not meet the condition of the write"
+		return e
+	}
+
+	var canceled *dynamodb.TransactionCanceledException
+	if !errors.As(err, &canceled) {
+		return err
+	}
+	e := apierror.From(err)
+	var reasons []string
+	onlyInvalid := true
+	for i, reason := range canceled.CancellationReasons {
+		code := aws.StringValue(reason.Code)
+		if code == "" || code == "None" {
+			continue
+		}
+		if code == "ConditionalCheckFailed" {
+			reasons = append(reasons, fmt.Sprintf("action %d does not meet its condition", i))
+		} else {
+			reasons = append(reasons, fmt.Sprintf("action %d failed with %s: %s", i, code, aws.StringValue(reason.Message)))
+		}
+		onlyInvalid = onlyInvalid && code == "ValidationError"
+	}
+	if len(reasons) > 0 {
+		e.Message = "the transaction was canceled, " + strings.Join(reasons, "; ")
+	}
+	// A transaction that can never succeed is the caller's mistake, not a conflict
+	if len(reasons) > 0 && onlyInvalid {
+		e.Status, e.Code, e.Retryable = http.StatusBadRequest, apierror.CodeInvalidRequest, false
+	}
+	return e
+}
+
+// BatchWriteRequest represents the JSON request structure for batchWrite
+type BatchWriteRequest struct {
+	TableName string `json:"tableName"`
+	// Items are put and Keys deleted, both as plain JSON, in batches of 25 writes
+	Items     []map[string]interface{} `json:"items"`
+	Keys      []map[string]interface{} `json:"keys"`
+	Region    string                   `json:"region"`
+	AccountID int                      `json:"accountID"`
+}
+
+// BatchWriteResponse represents the JSON response structure for batchWrite
+type BatchWriteResponse struct {
+	Written int `json:"written"`
+	// UnprocessedItems and UnprocessedKeys are the writes DynamoDB still refused after the retries
+	UnprocessedItems []map[string]interface{} `json:"unprocessedItems"`
+	UnprocessedKeys  []map[string]interface{} `json:"unprocessedKeys"`
+}
+
+// BatchWriteItemsHandler puts and deletes many items without conditions. Writes DynamoDB does
+// not process, because the table is throttled, are sent again with a growing delay.
+func BatchWriteItemsHandler(w http.ResponseWriter, r *http.Request) {
+	var req BatchWriteRequest
+	err := decodeRequest(r, &req)
+	if err != nil {
+		apierror.BadRequest(w, "Invalid request body")
+		return
+	}
+	if len(req.Items)+len(req.Keys) == 0 {
+		apierror.BadRequest(w, "items or keys are required")
+		return
+	}
+
+	var writes []*dynamodb.WriteRequest
+	for i, item := range req.Items {
+		av, err := marshalItem(item)
+		if err != nil {
+			apierror.BadRequest(w, fmt.Sprintf("Invalid item %d: %v", i, err))
+			return
+		}
+		writes = append(writes, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: av}})
+	}
+	for i, key := range req.Keys {
+		av, err := marshalItem(key)
+		if err != nil {
+			apierror.BadRequest(w, fmt.Sprintf("Invalid key %d: %v", i, err))
+			return
+		}
+		writes = append(writes, &dynamodb.WriteRequest{DeleteRequest: &dynamodb.DeleteRequest{Key: av}})
+	}
+
+	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
+	if err != nil {
+		apierror.Write(w, err, "Error getting cloud account details")
+		return
+	}
+
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
+	if err != nil {
+		apierror.Write(w, err, "Error initializing AWS session")
+		return
+	}
+
+	svc := dynamodb.New(sess)
+
+	resp := BatchWriteResponse{UnprocessedItems: []map[string]interface{}{}, UnprocessedKeys: []map[string]interface{}{}}
+	for start := 0; start < len(writes); start += maxBatchWrite {
+		end := start + maxBatchWrite
+		if end > len(writes) {
+			end = len(writes)
+		}
+		unprocessed, err := batchWrite(r.Context(), svc, req.TableName, writes[start:end])
+		if err != nil {
+			apierror.Write(w, err, fmt.Sprintf("Error writing items to DynamoDB after %d writes", resp.Written))
+			return
+		}
+		resp.Written += end - start - len(unprocessed)
+		for _, write := range unprocessed {
+			if write.PutRequest != nil {
+				resp.UnprocessedItems = append(resp.UnprocessedItems, unmarshalItem(write.PutRequest.Item))
+			} else {
+				resp.UnprocessedKeys = append(resp.UnprocessedKeys, unmarshalItem(write.DeleteRequest.Key))
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// batchWrite sends up to 25 writes and retries the unprocessed ones, it returns those that
+// were still unprocessed after the last attempt
+func batchWrite(ctx context.Context, svc *dynamodb.DynamoDB, table string, writes []*dynamodb.WriteRequest) ([]*dynamodb.WriteRequest, error) {
+	backoff := batchBackoff
+	for attempt := 1; ; attempt++ {
+		out, err := svc.BatchWriteItemWithContext(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]*dynamodb.WriteRequest{table: writes},
+		})
+		if err != nil {
+			return nil, err
+		}
+		writes = out.UnprocessedItems[table]
+		if len(writes) == 0 || attempt == batchAttempts {
+			return writes, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// TransactWriteRequest represents the JSON request structure for transactWrite
+type TransactWriteRequest struct {
+	Actions []TransactAction `json:"actions"`
+	// ClientRequestToken makes the transaction idempotent, a retry with the same token within
+	// ten minutes is not applied again
+	ClientRequestToken string `json:"clientRequestToken"`
+	Region             string `json:"region"`
+	AccountID          int    `json:"accountID"`
+}
+
+// TransactAction is one write of a transaction, the actions of a transaction all happen or
+// none does
+type TransactAction struct {
+	// Type is put, update, delete or conditionCheck
+	Type      string `json:"type"`
+	TableName string `json:"tableName"`
+	// Item is the item of a put
+	Item map[string]interface{} `json:"item"`
+	// Key is the primary key of the item the other types act on
+	Key map[string]interface{} `json:"key"`
+	// UpdateMap holds the attributes an update sets
+	UpdateMap map[string]interface{} `json:"updateMap"`
+	// Condition is required for a conditionCheck and optional for the others
+	Condition *ItemCondition `json:"condition"`
+}
+
+// TransactWriteItemsHandler applies up to 100 puts, updates, deletes and condition checks,
+// possibly on different tables, as one transaction
+func TransactWriteItemsHandler(w http.ResponseWriter, r *http.Request) {
+	var req TransactWriteRequest
+	err := decodeRequest(r, &req)
+	if err != nil {
+		apierror.BadRequest(w, "Invalid request body")
+		return
+	}
+	if len(req.Actions) == 0 || len(req.Actions) > maxTransactWrite {
+		apierror.BadRequest(w, fmt.Sprintf("a transaction needs 1 to %d actions", maxTransactWrite))
+		return
+	}
+
+	input := &dynamodb.TransactWriteItemsInput{}
+	if req.ClientRequestToken != "" {
+		input.ClientRequestToken = aws.String(req.ClientRequestToken)
+	}
+	for i, action := range req.Actions {
+		item, err := action.build()
+		if err != nil {
+			apierror.Write(w, err, fmt.Sprintf("Invalid action %d", i))
+			return
+		}
+		input.TransactItems = append(input.TransactItems, item)
+	}
+
+	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
+	if err != nil {
+		apierror.Write(w, err, "Error getting cloud account details")
+		return
+	}
+
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
+	if err != nil {
+		apierror.Write(w, err, "Error initializing AWS session")
+		return
+	}
+
+	svc := dynamodb.New(sess)
+
+	_, err = svc.TransactWriteItemsWithContext(r.Context(), input)
+	if err != nil {
+		apierror.Write(w, conditionError(err), "Error writing transaction to DynamoDB")
+		return
+	}
+
+	resp := ItemResponse{Message: fmt.Sprintf("Transaction of %d actions written successfully", len(req.Actions))}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (a TransactAction) build() (*dynamodb.TransactWriteItem, error) {
+	if a.TableName == "" {
+		return nil, invalid("tableName is required")
+	}
+	condition, hasCondition, err := a.Condition.build()
+	if err != nil {
+		return nil, err
+	}
+
+	var item map[string]*dynamodb.AttributeValue
+	if a.Type == actionPut {
+		item, err = marshalItem(a.Item)
+	} else {
+		item, err = marshalItem(a.Key)
+	}
+	if err != nil {
+		return nil, invalid("%v", err)
+	}
+	if len(item) == 0 {
+		return nil, invalid("a %s needs the item or its key", a.Type)
+	}
+
+	builder := expression.NewBuilder()
+	if hasCondition {
+		builder = builder.WithCondition(condition)
+	}
+	if a.Type == actionUpdate {
+		update, err := updateBuilder(a.UpdateMap)
+		if err != nil {
+			return nil, err
+		}
+		builder = builder.WithUpdate(update)
+	}
+	var expr expression.Expression
+	if hasCondition || a.Type == actionUpdate {
+		if expr, err = builder.Build(); err != nil {
+			return nil, invalid("%v", err)
+		}
+	}
+
+	table := aws.String(a.TableName)
+	switch a.Type {
+	case actionPut:
+		return &dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+			TableName:                 table,
+			Item:                      item,
+			ConditionExpression:       expr.Condition(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+		}}, nil
+	case actionUpdate:
+		return &dynamodb.TransactWriteItem{Update: &dynamodb.Update{
+			TableName:                 table,
+			Key:                       item,
+			UpdateExpression:          expr.Update(),
+			ConditionExpression:       expr.Condition(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+		}}, nil
+	case actionDelete:
+		return &dynamodb.TransactWriteItem{Delete: &dynamodb.Delete{
+			TableName:                 table,
+			Key:                       item,
+			ConditionExpression:       expr.Condition(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+		}}, nil
+	case actionConditionCheck:
+		if !hasCondition {
+			return nil, invalid("a conditionCheck needs a condition")
+		}
+		return &dynamodb.TransactWriteItem{ConditionCheck: &dynamodb.ConditionCheck{
+			TableName:                 table,
+			Key:                       item,
+			ConditionExpression:       expr.Condition(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+		}}, nil
+	}
+	return nil, invalid("type must be put, update, delete or conditionCheck, not %q", a.Type)
+}
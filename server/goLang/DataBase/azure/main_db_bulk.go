@@ -0,0 +1,226 @@
+package azure_cosmosdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"btep.project/DataBase/bulk"
+	"btep.project/apierror"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxImportBatch is how many documents an import writes before it reads on
+	maxImportBatch = 100
+	// importWorkers is how many documents of a batch are written at the same time
+	importWorkers = 10
+)
+
+// systemProperties are set by Cosmos DB on every document and left out of exports
+var systemProperties = []string{"_rid", "_self", "_etag", "_attachments", "_ts"}
+
+// ExportRequest represents the JSON request structure for export
+type ExportRequest struct {
+	CosmosDBContainerRequest
+	bulk.ExportOptions
+}
+
+// ExportDocumentsHandler streams every document of a container as JSON Lines or CSV, without
+// the system properties Cosmos DB adds
+func ExportDocumentsHandler(w http.ResponseWriter, r *http.Request) {
+	var req ExportRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		apierror.BadRequest(w, "Invalid request body")
+		return
+	}
+
+	exporter, err := bulk.NewExporter(w, req.ExportOptions, req.ContainerName)
+	if err != nil {
+		apierror.Write(w, err, "Invalid request")
+		return
+	}
+
+	ctx := r.Context()
+	client, err := openDocumentClient(ctx, req.SubscriptionID, req.AccountID, req.ResourceGroup, req.AccountName)
+	if err != nil {
+		apierror.Write(w, err, "Failed to initialize Cosmos DB client")
+		return
+	}
+
+	continuation := ""
+	for {
+		docs, next, err := client.readDocuments(ctx, req.DatabaseName, req.ContainerName, continuation)
+		if err != nil {
+			exporter.Fail(err, "Error exporting documents from Cosmos DB")
+			return
+		}
+		for _, doc := range docs {
+			for _, name := range systemProperties {
+				delete(doc, name)
+			}
+			if err := exporter.Write(doc); err != nil {
+				exporter.Fail(err, "Error exporting documents from Cosmos DB")
+				return
+			}
+		}
+		if next == "" {
+			break
+		}
+		continuation = next
+	}
+	if err := exporter.Close(); err != nil {
+		exporter.Fail(err, "Error exporting documents from Cosmos DB")
+	}
+}
+
+// ImportDocumentsHandler upserts the documents of a JSON Lines or CSV body into a container.
+// The query string names it with subscriptionID, accountID, resourceGroup, accountName,
+// databaseName and containerName, and can set format, batchSize and rowsPerSecond. Rows
+// without an id get a new one, a numeric id is stored as a string.
+func ImportDocumentsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	accountID, err := strconv.Atoi(query.Get("accountID"))
+	if err != nil {
+		apierror.BadRequest(w, "Invalid account ID")
+		return
+	}
+	database, container := query.Get("databaseName"), query.Get("containerName")
+
+	opts, err := bulk.ParseImport(r, maxImportBatch)
+	if err != nil {
+		apierror.Write(w, err, "Invalid request")
+		return
+	}
+	body, err := bulk.Body(r, &opts)
+	if err != nil {
+		apierror.Write(w, err, "Invalid request")
+		return
+	}
+
+	ctx := r.Context()
+	client, err := openDocumentClient(ctx, query.Get("subscriptionID"), accountID, query.Get("resourceGroup"), query.Get("accountName"))
+	if err != nil {
+		apierror.Write(w, err, "Failed to initialize Cosmos DB client")
+		return
+	}
+	paths, err := client.partitionKeyPaths(ctx, database, container)
+	if err != nil {
+		apierror.Write(w, err, "Error reading Cosmos DB container")
+		return
+	}
+
+	report, err := bulk.Import(ctx, bulk.NewReader(body, opts.Format), opts, importBatch(client, database, container, paths))
+	bulk.WriteReport(w, report, err)
+}
+
+// importBatch upserts the documents of a batch concurrently. The REST API writes one document
+// per request, a container has no batch of documents across partition keys.
+func importBatch(client *documentClient, database, container string, paths []string) bulk.WriteBatch {
+	return func(ctx context.Context, rows []bulk.Row) ([]error, error) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		errs := make([]error, len(rows))
+		var (
+			wg       sync.WaitGroup
+			mu       sync.Mutex
+			stopping error
+		)
+		workers := make(chan struct{}, importWorkers)
+		for i, row := range rows {
+			doc := row.Item
+			if err := setID(doc); err != nil {
+				errs[i] = err
+				continue
+			}
+			key := make([]interface{}, len(paths))
+			for j, path := range paths {
+				key[j] = partitionKeyValue(doc, path)
+			}
+
+			wg.Add(1)
+			workers <- struct{}{}
+			go func(i int) {
+				defer func() { <-workers; wg.Done() }()
+				err := client.upsertDocument(ctx, database, container, doc, key)
+				if isFatal(err) {
+					// Without the container or access to it no document can be written
+					mu.Lock()
+					if stopping == nil {
+						stopping = err
+					}
+					mu.Unlock()
+					cancel()
+				}
+				errs[i] = rowError(err)
+			}(i)
+		}
+		wg.Wait()
+		if stopping != nil {
+			return nil, stopping
+		}
+		return errs, nil
+	}
+}
+
+// setID makes sure doc has the string id every Cosmos DB document needs
+func setID(doc map[string]interface{}) error {
+	switch id := doc["id"].(type) {
+	case nil:
+		doc["id"] = uuid.New().String()
+	case json.Number:
+		doc["id"] = id.String()
+	case string:
+		if id == "" || strings.ContainsAny(id, `/\?#`) {
+			return errors.New("invalid document id " + strconv.Quote(id))
+		}
+	default:
+		return errors.New("id must be a string")
+	}
+	return nil
+}
+
+// partitionKeyValue reads the value at a partition key path such as /address/city. A document
+// without it has an undefined key, which the REST API takes as an empty object.
+func partitionKeyValue(doc map[string]interface{}, path string) interface{} {
+	var value interface{} = doc
+	for _, name := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return struct{}{}
+		}
+		if value, ok = m[name]; !ok {
+			return struct{}{}
+		}
+	}
+	return value
+}
+
+// rowError reports a rejected document with the code and message of Cosmos DB alone
+func rowError(err error) error {
+	var detailed autorest.DetailedError
+	if errors.As(err, &detailed) && detailed.Original != nil {
+		return detailed.Original
+	}
+	return err
+}
+
+func isFatal(err error) bool {
+	var detailed autorest.DetailedError
+	if !errors.As(err, &detailed) {
+		return errors.Is(err, context.Canceled)
+	}
+	switch detailed.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,215 @@
+package azure_cosmosdb
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+const (
+	// apiVersion is the version of the Cosmos DB REST API the documents are read and written with
+	apiVersion = "2018-12-31"
+	// maxThrottledRetries bounds how often a request is sent again after a 429
+	maxThrottledRetries = 9
+)
+
+// documentClient reads and writes the documents of a Cosmos DB account through its REST API.
+// The management SDK has no data plane, so requests are signed with the account's primary key.
+type documentClient struct {
+	endpoint string
+	key      []byte
+}
+
+// openDocumentClient looks up the endpoint and primary key of an account
+func openDocumentClient(ctx context.Context, subscriptionID string, accountID int, resourceGroup, accountName string) (*documentClient, error) {
+	client, err := initCosmosDBClient(subscriptionID, accountID)
+	if err != nil {
+		return nil, err
+	}
+	account, err := client.Get(ctx, resourceGroup, accountName)
+	if err != nil {
+		return nil, err
+	}
+	if account.DatabaseAccountGetProperties == nil || account.DocumentEndpoint == nil {
+		return nil, errors.New("the account has no document endpoint yet")
+	}
+	keys, err := client.ListKeys(ctx, resourceGroup, accountName)
+	if err != nil {
+		return nil, err
+	}
+	if keys.PrimaryMasterKey == nil {
+		return nil, errors.New("the account has no primary key")
+	}
+	key, err := base64.StdEncoding.DecodeString(*keys.PrimaryMasterKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid primary key: %v", err)
+	}
+	return &documentClient{endpoint: strings.TrimSuffix(*account.DocumentEndpoint, "/"), key: key}, nil
+}
+
+// partitionKeyPaths returns the paths of a container's partition key, e.g. /partitionKey
+func (c *documentClient) partitionKeyPaths(ctx context.Context, database, container string) ([]string, error) {
+	link := containerLink(database, container)
+	resp, err := c.do(ctx, http.MethodGet, "colls", link, link, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var collection struct {
+		PartitionKey struct {
+			Paths []string `json:"paths"`
+		} `json:"partitionKey"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&collection); err != nil {
+		return nil, err
+	}
+	return collection.PartitionKey.Paths, nil
+}
+
+// readDocuments returns a page of a container's documents and the continuation of the next
+// page, which is empty after the last one
+func (c *documentClient) readDocuments(ctx context.Context, database, container, continuation string) ([]map[string]interface{}, string, error) {
+	link := containerLink(database, container)
+	headers := map[string]string{"x-ms-max-item-count": "1000"}
+	if continuation != "" {
+		headers["x-ms-continuation"] = continuation
+	}
+	resp, err := c.do(ctx, http.MethodGet, "docs", link, link+"/docs", headers, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	var page struct {
+		Documents []map[string]interface{} `json:"Documents"`
+	}
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&page); err != nil {
+		return nil, "", err
+	}
+	return page.Documents, resp.Header.Get("x-ms-continuation"), nil
+}
+
+// upsertDocument creates doc or replaces the document with its id. partitionKey holds the
+// values of the container's partition key paths in doc.
+func (c *documentClient) upsertDocument(ctx context.Context, database, container string, doc map[string]interface{}, partitionKey []interface{}) error {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	key, err := json.Marshal(partitionKey)
+	if err != nil {
+		return err
+	}
+	link := containerLink(database, container)
+	headers := map[string]string{
+		"Content-Type":                 "application/json",
+		"x-ms-documentdb-is-upsert":    "True",
+		"x-ms-documentdb-partitionkey": string(key),
+	}
+	resp, err := c.do(ctx, http.MethodPost, "docs", link, link+"/docs", headers, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do sends a signed request and waits out throttling. A response that is not a success is
+// returned as an autorest.DetailedError, so apierror maps it like the management API's.
+func (c *documentClient) do(ctx context.Context, method, resourceType, resourceLink, path string, headers map[string]string, body []byte) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, c.endpoint+"/"+escapePath(path), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		date := time.Now().UTC().Format(http.TimeFormat)
+		req.Header.Set("x-ms-date", date)
+		req.Header.Set("x-ms-version", apiVersion)
+		req.Header.Set("Authorization", c.authorization(method, resourceType, resourceLink, date))
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < maxThrottledRetries {
+			resp.Body.Close()
+			wait := time.Second
+			if ms, err := strconv.Atoi(resp.Header.Get("x-ms-retry-after-ms")); err == nil {
+				wait = time.Duration(ms) * time.Millisecond
+			}
+			select {
+			case <-time.After(wait):
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		return nil, responseError(method, resp)
+	}
+}
+
+// authorization signs a request with the master key, as the REST API documents it
+func (c *documentClient) authorization(method, resourceType, resourceLink, date string) string {
+	text := strings.ToLower(method) + "\n" + strings.ToLower(resourceType) + "\n" + resourceLink + "\n" + strings.ToLower(date) + "\n\n"
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(text))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return url.QueryEscape("type=master&ver=1.0&sig=" + signature)
+}
+
+func responseError(method string, resp *http.Response) error {
+	defer resp.Body.Close()
+	var body struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if json.Unmarshal(data, &body) != nil || body.Message == "" {
+		body.Message = strings.TrimSpace(string(data))
+	}
+	// The message carries a diagnostic trail after its first line
+	if i := strings.IndexAny(body.Message, "\r\n"); i > 0 {
+		body.Message = body.Message[:i]
+	}
+	return autorest.DetailedError{
+		Original:    fmt.Errorf("%s: %s", body.Code, body.Message),
+		PackageType: "azure_cosmosdb.documentClient",
+		Method:      method,
+		StatusCode:  resp.StatusCode,
+		Message:     "Cosmos DB request failed",
+		Response:    resp,
+	}
+}
+
+func containerLink(database, container string) string {
+	return "dbs/" + database + "/colls/" + container
+}
+
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
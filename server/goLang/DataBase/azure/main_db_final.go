@@ -3,17 +3,17 @@ package azure_cosmosdb
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
 
+	"btep.project/apierror"
+	"btep.project/auth/azureauth"
 	"github.com/Azure/azure-sdk-for-go/profiles/latest/cosmos-db/mgmt/documentdb"
-	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/to"
 )
 
 type CosmosDBAccountRequest struct {
 	SubscriptionID string `json:"subscriptionID"`
-	Token          string `json:"token"`
+	AccountID      int    `json:"accountID"`
 	ResourceGroup  string `json:"resourceGroup"`
 	Location       string `json:"location"`
 	AccountName    string `json:"accountName"`
@@ -25,30 +25,23 @@ type CosmosDBAccountResponse struct {
 	Message string `json:"message"`
 }
 
-type tokenAuthorizer struct {
-	token string
-}
-
-func (ta tokenAuthorizer) WithAuthorization() autorest.PrepareDecorator {
-	return func(p autorest.Preparer) autorest.Preparer {
-		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
-			r.Header.Set("Authorization", "Bearer "+ta.token)
-			return r, nil
-		})
-	}
-}
-
-func initCosmosDBClient(subscriptionID string, token string) (documentdb.DatabaseAccountsClient, error) {
+func initCosmosDBClient(subscriptionID string, accountID int) (documentdb.DatabaseAccountsClient, error) {
 	client := documentdb.NewDatabaseAccountsClient(subscriptionID)
-	client.Authorizer = autorest.NullAuthorizer{} // Set to NullAuthorizer since we are manually inserting the token
-	client.RequestInspector = tokenAuthorizer{token: token}.WithAuthorization()
+	authorizer, err := azureauth.Authorizer(context.Background(), accountID)
+	if err != nil {
+		return client, err
+	}
+	client.Authorizer = authorizer
 	return client, nil
 }
 
-func initSQLClient(subscriptionID string, token string) (documentdb.SQLResourcesClient, error) {
+func initSQLClient(subscriptionID string, accountID int) (documentdb.SQLResourcesClient, error) {
 	client := documentdb.NewSQLResourcesClient(subscriptionID)
-	client.Authorizer = autorest.NullAuthorizer{} // Set to NullAuthorizer since we are manually inserting the token
-	client.RequestInspector = tokenAuthorizer{token: token}.WithAuthorization()
+	authorizer, err := azureauth.Authorizer(context.Background(), accountID)
+	if err != nil {
+		return client, err
+	}
+	client.Authorizer = authorizer
 	return client, nil
 }
 
@@ -58,18 +51,16 @@ func DeleteCosmosDBAccountHandler(w http.ResponseWriter, r *http.Request) {
 	var req CosmosDBAccountRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
-	client, err := initCosmosDBClient(req.SubscriptionID, req.Token)
+	client, err := initCosmosDBClient(req.SubscriptionID, req.AccountID)
 
 	// Delete the Cosmos DB account
 	_, err = client.Delete(context.Background(), req.ResourceGroup, req.AccountName)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error deleting Cosmos DB account: %v", err)
+		apierror.Write(w, err, "Error deleting Cosmos DB account")
 		return
 	}
 
@@ -85,26 +76,23 @@ func ListCosmosDBAccountsHandler(w http.ResponseWriter, r *http.Request) {
 	var req CosmosDBAccountRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
-	client, err := initCosmosDBClient(req.SubscriptionID, req.Token)
+	client, err := initCosmosDBClient(req.SubscriptionID, req.AccountID)
 
 	// List Cosmos DB accounts
 	accounts, err := client.ListByResourceGroup(context.Background(), req.ResourceGroup)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error listing Cosmos DB accounts: %v", err)
+		apierror.Write(w, err, "Error listing Cosmos DB accounts")
 		return
 	}
 
 	// Convert accounts to JSON and send as response
 	jsonResponse, err := json.Marshal(accounts)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error marshaling response: %v", err)
+		apierror.Write(w, err, "Error marshaling response")
 		return
 	}
 
@@ -116,12 +104,11 @@ func CreateCosmosDBAccountHandler(w http.ResponseWriter, r *http.Request) {
 	var req CosmosDBAccountRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
-	client, err := initCosmosDBClient(req.SubscriptionID, req.Token)
+	client, err := initCosmosDBClient(req.SubscriptionID, req.AccountID)
 
 	accountParameters := documentdb.DatabaseAccountCreateUpdateParameters{
 		Location: &req.Location,
@@ -139,8 +126,7 @@ func CreateCosmosDBAccountHandler(w http.ResponseWriter, r *http.Request) {
 
 	_, err = client.CreateOrUpdate(context.Background(), req.ResourceGroup, req.AccountName, accountParameters)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating Cosmos DB account: %v", err)
+		apierror.Write(w, err, "Error creating Cosmos DB account")
 		return
 	}
 
@@ -149,15 +135,9 @@ func CreateCosmosDBAccountHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
-// handleError is a utility function to handle errors
-func handleError(w http.ResponseWriter, status int, message string) {
-	w.WriteHeader(status)
-	fmt.Fprintf(w, message)
-}
-
 type CosmosDBDatabaseRequest struct {
 	SubscriptionID string `json:"subscriptionID"`
-	Token          string `json:"token"`
+	AccountID      int    `json:"accountID"`
 	ResourceGroup  string `json:"resourceGroup"`
 	Location       string `json:"location"`
 	AccountName    string `json:"accountName"`
@@ -173,19 +153,19 @@ func CreateCosmosDBDatabaseHandler(w http.ResponseWriter, r *http.Request) {
 	var req CosmosDBDatabaseRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		handleError(w, http.StatusBadRequest, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
-	client, err := initSQLClient(req.SubscriptionID, req.Token)
+	client, err := initSQLClient(req.SubscriptionID, req.AccountID)
 	if err != nil {
-		handleError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to initialize Cosmos DB client: %v", err))
+		apierror.Write(w, err, "Failed to initialize Cosmos DB client")
 		return
 	}
 
 	err = createDatabase(context.Background(), &client, req.AccountName, req.ResourceGroup, req.DatabaseName, req.Location)
 	if err != nil {
-		handleError(w, http.StatusInternalServerError, fmt.Sprintf("Error creating Cosmos DB database: %v", err))
+		apierror.Write(w, err, "Error creating Cosmos DB database")
 		return
 	}
 
@@ -218,19 +198,19 @@ func DeleteCosmosDBDatabaseHandler(w http.ResponseWriter, r *http.Request) {
 	var req CosmosDBDatabaseRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		handleError(w, http.StatusBadRequest, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
-	client, err := initSQLClient(req.SubscriptionID, req.Token)
+	client, err := initSQLClient(req.SubscriptionID, req.AccountID)
 	if err != nil {
-		handleError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to initialize Cosmos DB client: %v", err))
+		apierror.Write(w, err, "Failed to initialize Cosmos DB client")
 		return
 	}
 
 	err = deleteDatabase(context.Background(), &client, req.AccountName, req.ResourceGroup, req.DatabaseName)
 	if err != nil {
-		handleError(w, http.StatusInternalServerError, fmt.Sprintf("Error deleting Cosmos DB database: %v", err))
+		apierror.Write(w, err, "Error deleting Cosmos DB database")
 		return
 	}
 
@@ -250,7 +230,7 @@ func deleteDatabase(ctx context.Context, databaseClient *documentdb.SQLResources
 
 type CosmosDBContainerRequest struct {
 	SubscriptionID string `json:"subscriptionID"`
-	Token          string `json:"token"`
+	AccountID      int    `json:"accountID"`
 	ResourceGroup  string `json:"resourceGroup"`
 	Location       string `json:"location"`
 	AccountName    string `json:"accountName"`
@@ -266,19 +246,19 @@ func CreateCosmosDBContainerHandler(w http.ResponseWriter, r *http.Request) {
 	var req CosmosDBContainerRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		handleError(w, http.StatusBadRequest, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
-	client, err := initSQLClient(req.SubscriptionID, req.Token)
+	client, err := initSQLClient(req.SubscriptionID, req.AccountID)
 	if err != nil {
-		handleError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to initialize Cosmos DB client: %v", err))
+		apierror.Write(w, err, "Failed to initialize Cosmos DB client")
 		return
 	}
 
 	err = createContainer(context.Background(), &client, req.AccountName, req.ResourceGroup, req.DatabaseName, req.ContainerName)
 	if err != nil {
-		handleError(w, http.StatusInternalServerError, fmt.Sprintf("Error creating Cosmos DB container: %v", err))
+		apierror.Write(w, err, "Error creating Cosmos DB container")
 		return
 	}
 
@@ -313,19 +293,19 @@ func DeleteCosmosDBContainerHandler(w http.ResponseWriter, r *http.Request) {
 	var req CosmosDBContainerRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		handleError(w, http.StatusBadRequest, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
-	client, err := initSQLClient(req.SubscriptionID, req.Token)
+	client, err := initSQLClient(req.SubscriptionID, req.AccountID)
 	if err != nil {
-		handleError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to initialize Cosmos DB client: %v", err))
+		apierror.Write(w, err, "Failed to initialize Cosmos DB client")
 		return
 	}
 
 	err = deleteContainer(context.Background(), &client, req.AccountName, req.ResourceGroup, req.DatabaseName, req.ContainerName)
 	if err != nil {
-		handleError(w, http.StatusInternalServerError, fmt.Sprintf("Error deleting Cosmos DB container: %v", err))
+		apierror.Write(w, err, "Error deleting Cosmos DB container")
 		return
 	}
 
@@ -0,0 +1,93 @@
+package bulk
+
+import (
+	"fmt"
+	"net/http"
+
+	"btep.project/apierror"
+)
+
+// flushEvery is how many rows are written before they are sent on, so a large export reaches
+// the client while the table is still being read
+const flushEvery = 500
+
+// ExportOptions represents the JSON request fields every export takes
+type ExportOptions struct {
+	// Format is jsonl, the default, or csv
+	Format string `json:"format"`
+	// Columns are the columns of a CSV, those of the first row when none are given
+	Columns []string `json:"columns"`
+}
+
+// Exporter streams the rows of an export as the response. The response starts with the first
+// row, an error before it is answered with the usual error envelope.
+type Exporter struct {
+	w        http.ResponseWriter
+	format   Format
+	name     string
+	rows     RowWriter
+	started  bool
+	exported int
+}
+
+// NewExporter prepares an export of the table name, which names the downloaded file
+func NewExporter(w http.ResponseWriter, opts ExportOptions, name string) (*Exporter, error) {
+	format, err := ParseFormat(opts.Format)
+	if err != nil {
+		return nil, err
+	}
+	return &Exporter{w: w, format: format, name: name, rows: NewWriter(w, format, opts.Columns)}, nil
+}
+
+// Write adds a row to the export
+func (e *Exporter) Write(item map[string]interface{}) error {
+	e.start()
+	if err := e.rows.Write(item); err != nil {
+		return err
+	}
+	if e.exported++; e.exported%flushEvery == 0 {
+		return e.flush()
+	}
+	return nil
+}
+
+// Close ends the export, an empty table is exported as an empty file
+func (e *Exporter) Close() error {
+	e.start()
+	return e.flush()
+}
+
+// Fail ends the export with err. A response that has started cannot turn into an error any
+// more, the connection is broken off instead so the client does not take the rows it got for
+// the whole table.
+func (e *Exporter) Fail(err error, message string) {
+	if !e.started {
+		apierror.Write(e.w, err, message)
+		return
+	}
+	panic(http.ErrAbortHandler)
+}
+
+func (e *Exporter) start() {
+	if e.started {
+		return
+	}
+	e.started = true
+	extension := "jsonl"
+	if e.format == CSV {
+		extension = "csv"
+	}
+	e.w.Header().Set("Content-Type", e.format.ContentType())
+	e.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", e.name+"."+extension))
+	e.w.WriteHeader(http.StatusOK)
+}
+
+func (e *Exporter) flush() error {
+	if err := e.rows.Flush(); err != nil {
+		return err
+	}
+	if flusher, ok := e.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+// Package bulk moves table data in and out of the databases as JSON Lines or CSV, so
+// environments can be seeded and datasets moved between DynamoDB, Firestore and Cosmos DB.
+//
+// Every row is a flat or nested JSON object. JSON Lines holds one object per line. CSV has a
+// header row naming the columns, a column that does not hold strings says what it holds:
+//
+//	id,age:number,active:bool,address:json
+//	u1,42,true,"{""city"":""Oslo""}"
+//
+// An empty cell leaves the attribute out. Exports write the same header, so a CSV export can be
+// imported again without losing the types of its values.
+package bulk
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"btep.project/apierror"
+)
+
+// Format is how rows are encoded
+type Format string
+
+const (
+	JSONLines Format = "jsonl"
+	CSV       Format = "csv"
+)
+
+// Types of CSV columns, string is the default and has no suffix
+const (
+	typeString = "string"
+	typeNumber = "number"
+	typeBool   = "bool"
+	typeJSON   = "json"
+)
+
+// maxLine is the longest JSON line that is read, DynamoDB and Cosmos DB items are at most 400 KB
+// and 2 MB
+const maxLine = 4 << 20
+
+// ParseFormat reads the format of a request, JSON Lines when none is given
+func ParseFormat(format string) (Format, error) {
+	switch strings.ToLower(format) {
+	case "", "jsonl", "ndjson", "json":
+		return JSONLines, nil
+	case "csv":
+		return CSV, nil
+	}
+	return "", apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest,
+		fmt.Sprintf("format must be jsonl or csv, not %q", format))
+}
+
+// ContentType is the media type of the format
+func (f Format) ContentType() string {
+	if f == CSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
+
+// Row is a row that was read, Line is where it starts and is reported with its error
+type Row struct {
+	Line int
+	Item map[string]interface{}
+	// Err is set when the row could not be decoded, Item is nil then
+	Err error
+}
+
+// RowReader reads rows one by one
+type RowReader interface {
+	// Next returns the next row or io.EOF. A row that cannot be decoded is returned with Err
+	// set, reading goes on after it.
+	Next() (Row, error)
+}
+
+// NewReader reads rows in format from r. Numbers are kept as json.Number.
+func NewReader(r io.Reader, format Format) RowReader {
+	if format == CSV {
+		reader := csv.NewReader(r)
+		reader.ReuseRecord = true
+		return &csvReader{reader: reader}
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLine)
+	return &jsonReader{scanner: scanner}
+}
+
+type jsonReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (r *jsonReader) Next() (Row, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := strings.TrimSpace(r.scanner.Text())
+		if line == "" {
+			continue
+		}
+		row := Row{Line: r.line}
+		decoder := json.NewDecoder(strings.NewReader(line))
+		decoder.UseNumber()
+		if err := decoder.Decode(&row.Item); err != nil {
+			row.Err = fmt.Errorf("invalid JSON: %v", err)
+		} else if row.Item == nil {
+			row.Err = fmt.Errorf("a row must be a JSON object")
+		}
+		return row, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return Row{}, err
+	}
+	return Row{}, io.EOF
+}
+
+type csvReader struct {
+	reader  *csv.Reader
+	columns []csvColumn
+}
+
+type csvColumn struct {
+	name string
+	kind string
+}
+
+func (r *csvReader) Next() (Row, error) {
+	if r.columns == nil {
+		header, err := r.reader.Read()
+		if err != nil {
+			if err == io.EOF {
+				return Row{}, err
+			}
+			return Row{}, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, fmt.Sprintf("invalid CSV header: %v", err))
+		}
+		if r.columns, err = parseHeader(header); err != nil {
+			return Row{}, err
+		}
+	}
+
+	record, err := r.reader.Read()
+	if err == io.EOF {
+		return Row{}, err
+	}
+	if err != nil {
+		// A broken quote or a wrong number of fields only spoils this record. FieldPos has no
+		// positions after such an error, the line comes from the error.
+		var parseErr *csv.ParseError
+		if !errors.As(err, &parseErr) {
+			return Row{}, err
+		}
+		return Row{Line: parseErr.StartLine, Err: fmt.Errorf("invalid CSV: %v", parseErr.Err)}, nil
+	}
+	line, _ := r.reader.FieldPos(0)
+	row := Row{Line: line}
+
+	row.Item = make(map[string]interface{}, len(record))
+	for i, cell := range record {
+		if cell == "" {
+			continue
+		}
+		value, err := r.columns[i].parse(cell)
+		if err != nil {
+			return Row{Line: line, Err: err}, nil
+		}
+		row.Item[r.columns[i].name] = value
+	}
+	return row, nil
+}
+
+func parseHeader(header []string) ([]csvColumn, error) {
+	columns := make([]csvColumn, len(header))
+	seen := make(map[string]bool, len(header))
+	for i, field := range header {
+		column := csvColumn{name: field, kind: typeString}
+		if j := strings.LastIndex(field, ":"); j >= 0 {
+			switch kind := field[j+1:]; kind {
+			case typeString, typeNumber, typeBool, typeJSON:
+				column = csvColumn{name: field[:j], kind: kind}
+			}
+		}
+		if column.name == "" || seen[column.name] {
+			return nil, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest,
+				fmt.Sprintf("CSV column %d has an empty or repeated name %q", i+1, column.name))
+		}
+		seen[column.name] = true
+		columns[i] = column
+	}
+	return columns, nil
+}
+
+func (c csvColumn) parse(cell string) (interface{}, error) {
+	switch c.kind {
+	case typeNumber:
+		if _, err := strconv.ParseFloat(cell, 64); err != nil {
+			return nil, fmt.Errorf("%s: %q is not a number", c.name, cell)
+		}
+		return json.Number(cell), nil
+	case typeBool:
+		b, err := strconv.ParseBool(cell)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %q is not true or false", c.name, cell)
+		}
+		return b, nil
+	case typeJSON:
+		var value interface{}
+		decoder := json.NewDecoder(strings.NewReader(cell))
+		decoder.UseNumber()
+		if err := decoder.Decode(&value); err != nil {
+			return nil, fmt.Errorf("%s: invalid JSON: %v", c.name, err)
+		}
+		return value, nil
+	}
+	return cell, nil
+}
+
+// RowWriter writes rows one by one
+type RowWriter interface {
+	Write(item map[string]interface{}) error
+	// Flush writes what is buffered
+	Flush() error
+}
+
+// NewWriter writes rows in format to w. A CSV has the given columns, or those of the first row
+// when there are none. Attributes missing from the columns are left out of a CSV.
+func NewWriter(w io.Writer, format Format, columns []string) RowWriter {
+	if format == CSV {
+		return &csvWriter{writer: csv.NewWriter(w), names: columns}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	return &jsonWriter{encoder: encoder}
+}
+
+type jsonWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *jsonWriter) Write(item map[string]interface{}) error {
+	return w.encoder.Encode(item)
+}
+
+func (w *jsonWriter) Flush() error {
+	return nil
+}
+
+type csvWriter struct {
+	writer  *csv.Writer
+	names   []string
+	columns []csvColumn
+	record  []string
+}
+
+func (w *csvWriter) Write(item map[string]interface{}) error {
+	if w.columns == nil {
+		w.writeHeader(item)
+	}
+	for i, column := range w.columns {
+		cell, err := column.format(item[column.name])
+		if err != nil {
+			return err
+		}
+		w.record[i] = cell
+	}
+	return w.writer.Write(w.record)
+}
+
+// writeHeader types the columns by the values of the first row
+func (w *csvWriter) writeHeader(first map[string]interface{}) {
+	names := w.names
+	if len(names) == 0 {
+		for name := range first {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	w.columns = make([]csvColumn, len(names))
+	w.record = make([]string, len(names))
+	for i, name := range names {
+		w.columns[i] = csvColumn{name: name, kind: kindOf(first[name])}
+		w.record[i] = name
+		if w.columns[i].kind != typeString {
+			w.record[i] += ":" + w.columns[i].kind
+		}
+	}
+	w.writer.Write(w.record)
+}
+
+func (w *csvWriter) Flush() error {
+	// An export of no rows still has its header when the columns are known
+	if w.columns == nil && len(w.names) > 0 {
+		w.writeHeader(nil)
+	}
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+func kindOf(value interface{}) string {
+	switch value.(type) {
+	case nil, string:
+		return typeString
+	case json.Number, float64, int64, int:
+		return typeNumber
+	case bool:
+		return typeBool
+	}
+	return typeJSON
+}
+
+// format writes value as a cell of the column. Items of a table can differ, a value of another
+// type than its column is written as JSON in a json column and as text in the others, where
+// an import reports it unless the column holds strings.
+func (c csvColumn) format(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		if c.kind == typeJSON {
+			break
+		}
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
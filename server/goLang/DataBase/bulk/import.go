@@ -0,0 +1,198 @@
+package bulk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"btep.project/apierror"
+
+	"golang.org/x/time/rate"
+)
+
+// MaxReportedErrors bounds the row errors an import report lists
+const MaxReportedErrors = 1000
+
+// ImportOptions says how an import writes its rows
+type ImportOptions struct {
+	Format Format
+	// BatchSize is how many rows are written together
+	BatchSize int
+	// RowsPerSecond limits the write rate, so an import does not use up the table's capacity;
+	// 0 writes as fast as the database takes the rows
+	RowsPerSecond float64
+}
+
+// RowError is a row that was not imported
+type RowError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// ImportReport represents the JSON response structure of an import
+type ImportReport struct {
+	Imported int        `json:"imported"`
+	Failed   int        `json:"failed"`
+	Errors   []RowError `json:"errors"`
+	// ErrorsTruncated is set when more rows failed than Errors lists
+	ErrorsTruncated bool `json:"errorsTruncated"`
+}
+
+// WriteBatch writes rows and returns one error for every row, nil when it was written. An
+// error of its own stops the import, e.g. when the table does not exist.
+type WriteBatch func(ctx context.Context, rows []Row) ([]error, error)
+
+// ParseImport reads the options of an import from the query string of r. The body holds the
+// rows, so the other parameters of an import are in the query string too. maxBatch is the
+// largest batch the database writes at once.
+func ParseImport(r *http.Request, maxBatch int) (ImportOptions, error) {
+	query := r.URL.Query()
+	opts := ImportOptions{BatchSize: maxBatch}
+	format := query.Get("format")
+	if format == "" && isCSV(r) {
+		format = string(CSV)
+	}
+	var err error
+	if opts.Format, err = ParseFormat(format); err != nil {
+		return opts, err
+	}
+	if value := query.Get("batchSize"); value != "" {
+		opts.BatchSize, err = strconv.Atoi(value)
+		if err != nil || opts.BatchSize < 1 || opts.BatchSize > maxBatch {
+			return opts, invalid("batchSize must be between 1 and %d", maxBatch)
+		}
+	}
+	if value := query.Get("rowsPerSecond"); value != "" {
+		opts.RowsPerSecond, err = strconv.ParseFloat(value, 64)
+		if err != nil || opts.RowsPerSecond < 0 || math.IsInf(opts.RowsPerSecond, 0) {
+			return opts, invalid("rowsPerSecond must be a number of at least 0")
+		}
+	}
+	return opts, nil
+}
+
+// isCSV tells a CSV body by its content type, Body checks the name of an uploaded file
+func isCSV(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "text/csv"
+}
+
+// Body returns the rows of an import request: the body itself, or the "file" part of a
+// multipart form, which is read as it arrives instead of being stored first
+func Body(r *http.Request, opts *ImportOptions) (io.Reader, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return r.Body, nil
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, invalid("invalid multipart body: %v", err)
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, invalid("the form has no file part")
+		}
+		if err != nil {
+			return nil, invalid("invalid multipart body: %v", err)
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+		if r.URL.Query().Get("format") == "" && strings.EqualFold(path.Ext(part.FileName()), ".csv") {
+			opts.Format = CSV
+		}
+		return part, nil
+	}
+}
+
+// Import reads the rows and writes them in batches. Rows that cannot be read or written are
+// reported and the import goes on, an error stops it and is returned with the report so far.
+func Import(ctx context.Context, reader RowReader, opts ImportOptions, write WriteBatch) (*ImportReport, error) {
+	report := &ImportReport{Errors: []RowError{}}
+	var limiter *rate.Limiter
+	if opts.RowsPerSecond > 0 {
+		// The burst has to fit a whole batch, WaitN fails for more
+		burst := int(math.Ceil(opts.RowsPerSecond))
+		if burst < opts.BatchSize {
+			burst = opts.BatchSize
+		}
+		limiter = rate.NewLimiter(rate.Limit(opts.RowsPerSecond), burst)
+	}
+
+	batch := make([]Row, 0, opts.BatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if limiter != nil {
+			if err := limiter.WaitN(ctx, len(batch)); err != nil {
+				return err
+			}
+		}
+		errs, err := write(ctx, batch)
+		if err != nil {
+			return err
+		}
+		for i, row := range batch {
+			if errs[i] != nil {
+				report.fail(row.Line, errs[i])
+			} else {
+				report.Imported++
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		row, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, err
+		}
+		if row.Err != nil {
+			report.fail(row.Line, row.Err)
+			continue
+		}
+		batch = append(batch, row)
+		if len(batch) == opts.BatchSize {
+			if err := flush(); err != nil {
+				return report, err
+			}
+		}
+	}
+	return report, flush()
+}
+
+func (r *ImportReport) fail(line int, err error) {
+	r.Failed++
+	if len(r.Errors) == MaxReportedErrors {
+		r.ErrorsTruncated = true
+		return
+	}
+	r.Errors = append(r.Errors, RowError{Line: line, Error: err.Error()})
+}
+
+// WriteReport responds with the report, or with the error that stopped the import
+func WriteReport(w http.ResponseWriter, report *ImportReport, err error) {
+	if err != nil {
+		apierror.Write(w, err, fmt.Sprintf("Import stopped after %d rows were imported", report.Imported))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func invalid(format string, args ...interface{}) error {
+	return apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, fmt.Sprintf(format, args...))
+}
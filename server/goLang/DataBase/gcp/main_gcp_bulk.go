@@ -0,0 +1,242 @@
+package gcp_firebase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"btep.project/DataBase/bulk"
+	"btep.project/apierror"
+	db "btep.project/databaseConnection"
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/genproto/googleapis/type/latlng"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// defaultIDField holds the document ID in exported and imported rows
+	defaultIDField = "_id"
+	// maxImportBatch is the most documents written together, as in a Firestore batch
+	maxImportBatch = 500
+)
+
+// ExportRequest represents the JSON request structure for export
+type ExportRequest struct {
+	TableName string `json:"tableName"`
+	AccountID int    `json:"accountID"`
+	// IDField is the field the document ID is exported as, _id when it is empty. A document
+	// field of the same name is overwritten.
+	IDField string `json:"idField"`
+	bulk.ExportOptions
+}
+
+// ExportDocumentsHandler streams every document of a collection as JSON Lines or CSV.
+// Timestamps are exported as RFC 3339 strings and references as their path.
+func ExportDocumentsHandler(w http.ResponseWriter, r *http.Request) {
+	var req ExportRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		apierror.BadRequest(w, "Invalid request body")
+		return
+	}
+	idField := req.IDField
+	if idField == "" {
+		idField = defaultIDField
+	}
+
+	exporter, err := bulk.NewExporter(w, req.ExportOptions, req.TableName)
+	if err != nil {
+		apierror.Write(w, err, "Invalid request")
+		return
+	}
+
+	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
+	if err != nil {
+		apierror.Write(w, err, "Error getting cloud account details")
+		return
+	}
+
+	ctx := r.Context()
+	client, err := CreateFirestoreClient(ctx, req.AccountID, cloudAccount.ProjectID.String)
+	if err != nil {
+		apierror.Write(w, err, "Error creating Firestore client")
+		return
+	}
+	defer client.Close()
+
+	iter := client.Collection(req.TableName).Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err == nil {
+			row := exportValue(doc.Data()).(map[string]interface{})
+			row[idField] = doc.Ref.ID
+			err = exporter.Write(row)
+		}
+		if err != nil {
+			exporter.Fail(err, "Error exporting documents from Firestore")
+			return
+		}
+	}
+	if err := exporter.Close(); err != nil {
+		exporter.Fail(err, "Error exporting documents from Firestore")
+	}
+}
+
+// ImportDocumentsHandler sets the documents of a JSON Lines or CSV body in a collection. The
+// query string names it with tableName and accountID, and can set format, batchSize,
+// rowsPerSecond and idField. A row's idField, _id by default, is its document ID, rows
+// without one get a new ID. Numbers without a fraction are stored as integers.
+func ImportDocumentsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	accountID, err := strconv.Atoi(query.Get("accountID"))
+	if err != nil {
+		apierror.BadRequest(w, "Invalid account ID")
+		return
+	}
+	idField := query.Get("idField")
+	if idField == "" {
+		idField = defaultIDField
+	}
+
+	opts, err := bulk.ParseImport(r, maxImportBatch)
+	if err != nil {
+		apierror.Write(w, err, "Invalid request")
+		return
+	}
+	body, err := bulk.Body(r, &opts)
+	if err != nil {
+		apierror.Write(w, err, "Invalid request")
+		return
+	}
+
+	cloudAccount, err := db.GetCloudAccountDetails(accountID)
+	if err != nil {
+		apierror.Write(w, err, "Error getting cloud account details")
+		return
+	}
+
+	ctx := r.Context()
+	client, err := CreateFirestoreClient(ctx, accountID, cloudAccount.ProjectID.String)
+	if err != nil {
+		apierror.Write(w, err, "Error creating Firestore client")
+		return
+	}
+	defer client.Close()
+
+	collection := client.Collection(query.Get("tableName"))
+	report, err := bulk.Import(ctx, bulk.NewReader(body, opts.Format), opts, importBatch(client, collection, idField))
+	bulk.WriteReport(w, report, err)
+}
+
+// importBatch writes rows with a BulkWriter, which reports every document on its own
+func importBatch(client *firestore.Client, collection *firestore.CollectionRef, idField string) bulk.WriteBatch {
+	return func(ctx context.Context, rows []bulk.Row) ([]error, error) {
+		errs := make([]error, len(rows))
+		jobs := make([]*firestore.BulkWriterJob, len(rows))
+		writer := client.BulkWriter(ctx)
+		for i, row := range rows {
+			data := importValue(row.Item).(map[string]interface{})
+			doc := collection.NewDoc()
+			if id, ok := data[idField]; ok {
+				name, isString := id.(string)
+				if !isString {
+					errs[i] = errors.New(idField + " must be a string")
+					continue
+				}
+				// A slash would name a document of a subcollection, outside the collection
+				if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+					errs[i] = errors.New("invalid document ID " + strconv.Quote(name))
+					continue
+				}
+				doc = collection.Doc(name)
+				delete(data, idField)
+			}
+			jobs[i], errs[i] = writer.Set(doc, data)
+		}
+		writer.End()
+
+		for i, job := range jobs {
+			if job == nil {
+				continue
+			}
+			if _, err := job.Results(); err != nil {
+				// Without access no document can be written, so the import stops
+				switch status.Code(err) {
+				case codes.PermissionDenied, codes.Unauthenticated:
+					return nil, err
+				}
+				errs[i] = err
+			}
+		}
+		return errs, nil
+	}
+}
+
+// exportValue converts a Firestore value into JSON
+func exportValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int64:
+		return json.Number(strconv.FormatInt(v, 10))
+	case float64:
+		// JSON has no NaN or infinity
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return strconv.FormatFloat(v, 'g', -1, 64)
+		}
+		return json.Number(strconv.FormatFloat(v, 'g', -1, 64))
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case *firestore.DocumentRef:
+		return v.Path
+	case *latlng.LatLng:
+		return map[string]interface{}{"latitude": v.Latitude, "longitude": v.Longitude}
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			m[key] = exportValue(elem)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, elem := range v {
+			list[i] = exportValue(elem)
+		}
+		return list
+	}
+	return value
+}
+
+// importValue converts a JSON value into a Firestore value
+func importValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			m[key] = importValue(elem)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, elem := range v {
+			list[i] = importValue(elem)
+		}
+		return list
+	}
+	return value
+}
@@ -7,9 +7,10 @@ import (
 	"fmt"
 	"net/http"
 
+	"btep.project/apierror"
+	"btep.project/auth/gcpauth"
 	db "btep.project/databaseConnection"
 	"cloud.google.com/go/firestore"
-	"golang.org/x/oauth2"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
@@ -18,7 +19,6 @@ type TableRequest struct {
 	TableName  string              `json:"tableName"`
 	ProjectID  string              `json:"projectID"`
 	AccountID  int                 `json:"accountID"`
-	Token      string              `json:"token"`
 	Attributes []map[string]string `json:"attributes,omitempty"`
 }
 
@@ -27,11 +27,13 @@ type TableResponse struct {
 }
 
 // CreateFirestoreClient creates a Firestore client with OAuth 2.0 token authentication
-func CreateFirestoreClient(ctx context.Context, token, projectID string) (*firestore.Client, error) {
-	// Create Firestore client with OAuth 2.0 token authentication
-	client, err := firestore.NewClient(ctx, projectID, option.WithTokenSource(oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: token},
-	)))
+func CreateFirestoreClient(ctx context.Context, accountID int, projectID string) (*firestore.Client, error) {
+	// Service account accounts sign their own tokens, others use their OAuth login
+	tokenSource, err := gcpauth.TokenSource(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	client, err := firestore.NewClient(ctx, projectID, option.WithTokenSource(tokenSource))
 	if err != nil {
 		return nil, err
 	}
@@ -43,8 +45,7 @@ func CreateTableHandler(w http.ResponseWriter, r *http.Request) {
 	var req TableRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 	fmt.Println("decoded request", req)
@@ -52,18 +53,15 @@ func CreateTableHandler(w http.ResponseWriter, r *http.Request) {
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
-	fmt.Println("cloudAccount", cloudAccount)
 	// Create Firestore client with OAuth 2.0 token authentication
 	ctx := context.Background()
-	client, err := CreateFirestoreClient(ctx, req.Token, cloudAccount.ProjectID.String)
+	client, err := CreateFirestoreClient(ctx, req.AccountID, cloudAccount.ProjectID.String)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating Firestore client: %v", err)
+		apierror.Write(w, err, "Error creating Firestore client")
 		return
 	}
 	defer client.Close()
@@ -73,8 +71,7 @@ func CreateTableHandler(w http.ResponseWriter, r *http.Request) {
 	// Create the collection (table) with the given name
 	_, err = client.Collection(req.TableName).Doc("placeholder").Set(ctx, map[string]interface{}{})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating collection in Firestore: %v", err)
+		apierror.Write(w, err, "Error creating collection in Firestore")
 		return
 	}
 
@@ -90,25 +87,22 @@ func UpdateTableHandler(w http.ResponseWriter, r *http.Request) {
 	var req TableRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Create Firestore client with OAuth 2.0 token authentication
 	ctx := context.Background()
-	client, err := CreateFirestoreClient(ctx, req.Token, cloudAccount.ProjectID.String)
+	client, err := CreateFirestoreClient(ctx, req.AccountID, cloudAccount.ProjectID.String)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating Firestore client: %v", err)
+		apierror.Write(w, err, "Error creating Firestore client")
 		return
 	}
 	defer client.Close()
@@ -125,8 +119,7 @@ func UpdateTableHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		fmt.Println(doc.Data())
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "Error iterating over documents: %v", err)
+			apierror.Write(w, err, "Error iterating over documents")
 			return
 		}
 
@@ -144,25 +137,22 @@ func DeleteTableHandler(w http.ResponseWriter, r *http.Request) {
 	var req TableRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Create Firestore client with OAuth 2.0 token authentication
 	ctx := context.Background()
-	client, err := CreateFirestoreClient(ctx, req.Token, cloudAccount.ProjectID.String)
+	client, err := CreateFirestoreClient(ctx, req.AccountID, cloudAccount.ProjectID.String)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating Firestore client: %v", err)
+		apierror.Write(w, err, "Error creating Firestore client")
 		return
 	}
 	defer client.Close()
@@ -179,8 +169,7 @@ func DeleteTableHandler(w http.ResponseWriter, r *http.Request) {
 			break
 		}
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "Error iterating over documents: %v", err)
+			apierror.Write(w, err, "Error iterating over documents")
 			return
 		}
 
@@ -191,8 +180,7 @@ func DeleteTableHandler(w http.ResponseWriter, r *http.Request) {
 		if deletedCount%batchSize == 0 {
 			_, err := batch.Commit(ctx)
 			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				fmt.Fprintf(w, "Error committing batch delete: %v", err)
+				apierror.Write(w, err, "Error committing batch delete")
 				return
 			}
 			batch = client.Batch()
@@ -202,8 +190,7 @@ func DeleteTableHandler(w http.ResponseWriter, r *http.Request) {
 	// Commit any remaining documents in the batch
 	_, err = batch.Commit(ctx)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error committing batch delete: %v", err)
+		apierror.Write(w, err, "Error committing batch delete")
 		return
 	}
 
@@ -216,25 +203,22 @@ func ListTablesHandler(w http.ResponseWriter, r *http.Request) {
 	var req TableRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Create Firestore client with OAuth 2.0 token authentication
 	ctx := context.Background()
-	client, err := CreateFirestoreClient(ctx, req.Token, cloudAccount.ProjectID.String)
+	client, err := CreateFirestoreClient(ctx, req.AccountID, cloudAccount.ProjectID.String)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating Firestore client: %v", err)
+		apierror.Write(w, err, "Error creating Firestore client")
 		return
 	}
 	defer client.Close()
@@ -242,8 +226,7 @@ func ListTablesHandler(w http.ResponseWriter, r *http.Request) {
 	// Retrieve a list of all collections (tables) in the Firestore database
 	collections, err := client.Collections(ctx).GetAll()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error retrieving collections from Firestore: %v", err)
+		apierror.Write(w, err, "Error retrieving collections from Firestore")
 		return
 	}
 
@@ -278,7 +261,7 @@ func ListTablesHandler(w http.ResponseWriter, r *http.Request) {
 
 // 	// Create Firestore client with OAuth 2.0 token authentication
 // 	ctx := context.Background()
-// 	client, err := CreateFirestoreClient(ctx, req.Token, cloudAccount.ProjectID.String)
+// 	client, err := CreateFirestoreClient(ctx, req.AccountID, cloudAccount.ProjectID.String)
 // 	if err != nil {
 // 		w.WriteHeader(http.StatusInternalServerError)
 // 		fmt.Fprintf(w, "Error creating Firestore client: %v", err)
@@ -1,18 +1,19 @@
 package aws_s3
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"mime/multipart"
 	"net/http"
-	"strconv"
 
+	"btep.project/Storage/objectstore"
+	"btep.project/apierror"
+	"btep.project/auth/awsauth"
 	db "btep.project/databaseConnection"
+	"btep.project/middleware"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 )
 
@@ -40,33 +41,23 @@ func CreateBucketHandler(w http.ResponseWriter, r *http.Request) {
 	var req BucketRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
-	fmt.Println(cloudAccount)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(req.Region),
-		Credentials: credentials.NewStaticCredentials(cloudAccount.AccessKey.String, cloudAccount.SecretKey.String, ""),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session")
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 	svc := s3.New(sess)
-	_, err = svc.CreateBucket(&s3.CreateBucketInput{
-		Bucket: aws.String(req.BucketName),
-	})
+	_, err = svc.CreateBucket(createBucketInput(svc, req.BucketName))
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating bucket: %v", err)
+		apierror.Write(w, err, "Error creating bucket")
 		return
 	}
 	resp := BucketResponse{Message: "Bucket created successfully"}
@@ -78,38 +69,30 @@ func UploadObjectHandler(w http.ResponseWriter, r *http.Request) {
 	// Parse the form data
 	err := r.ParseMultipartForm(10 << 20) // Set maxMemory to 10 MB
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Error parsing form data: %v", err)
+		apierror.BadRequest(w, fmt.Sprintf("Error parsing form data: %v", err))
 		return
 	}
 
 	// Extract fields from form data
 	bucketName := r.FormValue("bucketName")
 	region := r.FormValue("region")
-	accountIDStr := r.FormValue("accountID")
-	accountID, err := strconv.Atoi(accountIDStr)
+	accountID, err := middleware.FormAccountID(r)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid account ID: %v", err)
+		apierror.Write(w, err, "")
 		return
 	}
 
 	// Get CloudAccount details
 	cloudAccount, err := db.GetCloudAccountDetails(accountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Create AWS session
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(region),
-		Credentials: credentials.NewStaticCredentials(*&cloudAccount.AccessKey.String, *&cloudAccount.SecretKey.String, ""),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, region)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session: %v", err)
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 
@@ -119,24 +102,26 @@ func UploadObjectHandler(w http.ResponseWriter, r *http.Request) {
 	// Retrieve the uploaded file
 	file, handler, err := r.FormFile("content")
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Error retrieving file from form data: %v", err)
+		apierror.BadRequest(w, fmt.Sprintf("Error retrieving file from form data: %v", err))
 		return
 	}
 	defer file.Close()
 
-	// Use the file name as the object key
-	objectKey := handler.Filename
+	// Use the key from the form, or the file name under the optional prefix
+	objectKey := r.FormValue("key")
+	if objectKey == "" {
+		objectKey = r.FormValue("prefix") + handler.Filename
+	}
 
 	// Upload the object to the S3 bucket
 	_, err = svc.PutObject(&s3.PutObjectInput{
-		Bucket: aws.String(bucketName),
-		Key:    aws.String(objectKey),
-		Body:   file,
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(objectKey),
+		Body:        file,
+		ContentType: nonEmpty(handler.Header.Get("Content-Type")),
 	})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error uploading object to S3: %v", err)
+		apierror.Write(w, err, "Error uploading object to S3")
 		return
 	}
 
@@ -146,64 +131,31 @@ func UploadObjectHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
-// GetObjectHandler handles GET requests to retrieve an object from an S3 bucket
+// GetObjectHandler handles GET requests to retrieve an object from an S3 bucket.
+// The object is streamed, Range and If-None-Match request headers are honoured.
 func GetObjectHandler(w http.ResponseWriter, r *http.Request) {
 	var req ObjectRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Get CloudAccount details
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
-	fmt.Println(cloudAccount)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
-		return
-	}
-
-	// Create AWS session
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(req.Region),
-		Credentials: credentials.NewStaticCredentials(cloudAccount.AccessKey.String, cloudAccount.SecretKey.String, ""),
-		// Credentials: credentials.NewStaticCredentials(*cloudAccount.accessKey, *cloudAccount.secretKey, ""),
-
-	})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session")
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
-	// Create S3 service client
-	svc := s3.New(sess)
-
-	// Download the object from the S3 bucket
-	objOutput, err := svc.GetObject(&s3.GetObjectInput{
-		Bucket: aws.String(req.BucketName),
-		Key:    aws.String(req.ObjectKey),
-	})
+	store, err := NewObjectStore(r.Context(), cloudAccount, objectstore.Options{AccountID: req.AccountID, Region: req.Region})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error downloading object from S3: %v", err)
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
+	defer store.Close()
 
-	// Read the object content
-	objContent, err := ioutil.ReadAll(objOutput.Body)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error reading object content: %v", err)
-		return
-	}
-
-	// Send the object content in the response
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Disposition", "attachment; filename="+req.ObjectKey)
-	w.Write(objContent)
+	objectstore.Download(w, r, store, req.BucketName, req.ObjectKey)
 }
 
 // DeleteObjectHandler handles POST requests to delete an object from an S3 bucket
@@ -211,31 +163,22 @@ func DeleteObjectHandler(w http.ResponseWriter, r *http.Request) {
 	var req ObjectRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Get CloudAccount details
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
-	fmt.Println(cloudAccount)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Create AWS session
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(req.Region),
-		Credentials: credentials.NewStaticCredentials(cloudAccount.AccessKey.String, cloudAccount.SecretKey.String, ""),
-		// Credentials: credentials.NewStaticCredentials(*cloudAccount.accessKey, *cloudAccount.secretKey, ""),
-
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session")
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 
@@ -248,8 +191,7 @@ func DeleteObjectHandler(w http.ResponseWriter, r *http.Request) {
 		Key:    aws.String(req.ObjectKey),
 	})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error deleting object from S3: %v", err)
+		apierror.Write(w, err, "Error deleting object from S3")
 		return
 	}
 
@@ -270,67 +212,129 @@ func DeleteBucketHandler(w http.ResponseWriter, r *http.Request) {
 	var req BucketRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Get CloudAccount details
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Create AWS session
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(req.Region),
-		Credentials: credentials.NewStaticCredentials(cloudAccount.AccessKey.String, cloudAccount.SecretKey.String, ""),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session")
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 
 	// Create S3 service client
 	svc := s3.New(sess)
-	resp, err := svc.ListObjectsV2(&s3.ListObjectsV2Input{
+
+	// S3 refuses to delete a bucket that still holds objects, old versions or delete markers
+	if err := emptyBucket(r.Context(), svc, req.BucketName); err != nil {
+		apierror.Write(w, err, "Error emptying the bucket")
+		return
+	}
+
+	// Delete the bucket from S3
+	_, err = svc.DeleteBucketWithContext(r.Context(), &s3.DeleteBucketInput{
 		Bucket: aws.String(req.BucketName),
 	})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error listing objects in the bucket: %v", err)
+		apierror.Write(w, err, "Error deleting bucket from S3")
 		return
 	}
 
-	for _, obj := range resp.Contents {
-		_, err := svc.DeleteObject(&s3.DeleteObjectInput{
-			Bucket: aws.String(req.BucketName),
-			Key:    obj.Key,
+	// Send success response
+	resp1 := BucketResponse{Message: "Bucket deleted successfully"}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp1)
+}
+
+// emptyBucket deletes every object version and delete marker of bucket, a page of at most 1000 keys at a time
+func emptyBucket(ctx context.Context, svc *s3.S3, bucket string) error {
+	var deleteErr error
+	err := svc.ListObjectVersionsPagesWithContext(ctx, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucket),
+	}, func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+		var objects []*s3.ObjectIdentifier
+		for _, version := range page.Versions {
+			objects = append(objects, &s3.ObjectIdentifier{Key: version.Key, VersionId: version.VersionId})
+		}
+		for _, marker := range page.DeleteMarkers {
+			objects = append(objects, &s3.ObjectIdentifier{Key: marker.Key, VersionId: marker.VersionId})
+		}
+		if len(objects) == 0 {
+			return true
+		}
+
+		out, err := svc.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &s3.Delete{Objects: objects, Quiet: aws.Bool(true)},
 		})
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "Error deleting object %s from bucket: %v", *obj.Key, err)
-			return
+			deleteErr = err
+			return false
 		}
+		// DeleteObjects succeeds as a whole and reports the keys it could not delete
+		if len(out.Errors) > 0 {
+			failed := out.Errors[0]
+			deleteErr = fmt.Errorf("could not delete %d objects, %s: %s: %s", len(out.Errors),
+				aws.StringValue(failed.Key), aws.StringValue(failed.Code), aws.StringValue(failed.Message))
+			return false
+		}
+		return true
+	})
+	if deleteErr != nil {
+		return deleteErr
 	}
+	return err
+}
 
-	// Delete the bucket from S3
-	_, err = svc.DeleteBucket(&s3.DeleteBucketInput{
-		Bucket: aws.String(req.BucketName),
-	})
+// ListObjectsRequest represents the JSON request structure for listObjects
+type ListObjectsRequest struct {
+	BucketName string `json:"bucketName"`
+	Region     string `json:"region"`
+	AccountID  int    `json:"accountID"`
+	Prefix     string `json:"prefix"`
+	Delimiter  string `json:"delimiter"`
+	PageToken  string `json:"pageToken"`
+	MaxKeys    int    `json:"maxKeys"`
+}
+
+// ListObjectsHandler handles POST requests to list one page of the objects in an S3 bucket.
+// A delimiter of "/" lists the bucket folder by folder, the folders come back as commonPrefixes.
+func ListObjectsHandler(w http.ResponseWriter, r *http.Request) {
+	var req ListObjectsRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error deleting bucket from S3: %v", err)
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
-	// Send success response
-	resp1 := BucketResponse{Message: "Bucket deleted successfully"}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp1)
+	// Get CloudAccount details
+	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
+	if err != nil {
+		apierror.Write(w, err, "Error getting cloud account details")
+		return
+	}
+
+	store, err := NewObjectStore(r.Context(), cloudAccount, objectstore.Options{AccountID: req.AccountID, Region: req.Region})
+	if err != nil {
+		apierror.Write(w, err, "Error initializing AWS session")
+		return
+	}
+	defer store.Close()
+
+	objectstore.ListObjects(w, r, store, req.BucketName, objectstore.ListOptions{
+		Prefix:    req.Prefix,
+		Delimiter: req.Delimiter,
+		PageToken: req.PageToken,
+		MaxKeys:   req.MaxKeys,
+	})
 }
 
 type ListBucketRequest struct {
@@ -350,27 +354,21 @@ func ListBucketsHandler(w http.ResponseWriter, r *http.Request) {
 	var req ListBucketRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Get CloudAccount details
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Create AWS session
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(req.Region),
-		Credentials: credentials.NewStaticCredentials(cloudAccount.AccessKey.String, cloudAccount.SecretKey.String, ""),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session")
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 
@@ -380,10 +378,8 @@ func ListBucketsHandler(w http.ResponseWriter, r *http.Request) {
 	// all buckets with its detials
 	bucketsOutput, err := svc.ListBuckets(&s3.ListBucketsInput{})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error listing buckets: %v", err)
-		//here return null responce
-
+		apierror.Write(w, err, "Error listing buckets")
+		return
 	}
 
 	var bucketDetails []*BucketDetails
@@ -0,0 +1,257 @@
+package aws_s3
+
+import (
+	"context"
+	"fmt"
+
+	"btep.project/Storage/objectstore"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// createBucketInput creates bucket in the region of svc. Only us-east-1 buckets may omit
+// the location constraint, elsewhere S3 answers IllegalLocationConstraintException.
+func createBucketInput(svc *s3.S3, bucket string) *s3.CreateBucketInput {
+	input := &s3.CreateBucketInput{Bucket: aws.String(bucket)}
+	if region := aws.StringValue(svc.Config.Region); region != "" && region != "us-east-1" {
+		input.CreateBucketConfiguration = &s3.CreateBucketConfiguration{LocationConstraint: aws.String(region)}
+	}
+	return input
+}
+
+// GetBucketConfig reads the versioning, lifecycle, CORS, encryption and public access block of bucket.
+// S3-compatible services that do not implement CORS, encryption or public access blocks leave
+// those settings out.
+func (s *ObjectStore) GetBucketConfig(ctx context.Context, bucket string) (*objectstore.BucketConfig, error) {
+	config := &objectstore.BucketConfig{Lifecycle: []objectstore.LifecycleRule{}, CORS: []objectstore.CORSRule{}}
+
+	versioning, err := s.svc.GetBucketVersioningWithContext(ctx, &s3.GetBucketVersioningInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	config.Versioning = aws.Bool(aws.StringValue(versioning.Status) == s3.BucketVersioningStatusEnabled)
+
+	lifecycle, err := s.svc.GetBucketLifecycleConfigurationWithContext(ctx, &s3.GetBucketLifecycleConfigurationInput{Bucket: aws.String(bucket)})
+	if err != nil && !isCode(err, "NoSuchLifecycleConfiguration") {
+		return nil, translateError(err)
+	}
+	if lifecycle != nil {
+		for _, rule := range lifecycle.Rules {
+			config.Lifecycle = append(config.Lifecycle, lifecycleFromS3(rule))
+		}
+	}
+
+	cors, err := s.svc.GetBucketCorsWithContext(ctx, &s3.GetBucketCorsInput{Bucket: aws.String(bucket)})
+	if isCode(err, "NotImplemented") {
+		config.CORS = nil
+	} else if err != nil && !isCode(err, "NoSuchCORSConfiguration") {
+		return nil, translateError(err)
+	}
+	if cors != nil {
+		for _, rule := range cors.CORSRules {
+			config.CORS = append(config.CORS, objectstore.CORSRule{
+				AllowedOrigins: aws.StringValueSlice(rule.AllowedOrigins),
+				AllowedMethods: aws.StringValueSlice(rule.AllowedMethods),
+				AllowedHeaders: aws.StringValueSlice(rule.AllowedHeaders),
+				ExposedHeaders: aws.StringValueSlice(rule.ExposeHeaders),
+				MaxAgeSeconds:  int(aws.Int64Value(rule.MaxAgeSeconds)),
+			})
+		}
+	}
+
+	encryption, err := s.svc.GetBucketEncryptionWithContext(ctx, &s3.GetBucketEncryptionInput{Bucket: aws.String(bucket)})
+	if err != nil && !isCode(err, "ServerSideEncryptionConfigurationNotFoundError") && !isCode(err, "NotImplemented") {
+		return nil, translateError(err)
+	}
+	if encryption != nil && encryption.ServerSideEncryptionConfiguration != nil {
+		for _, rule := range encryption.ServerSideEncryptionConfiguration.Rules {
+			if byDefault := rule.ApplyServerSideEncryptionByDefault; byDefault != nil {
+				config.Encryption = encryptionFromS3(byDefault)
+				break
+			}
+		}
+	}
+
+	block, err := s.svc.GetPublicAccessBlockWithContext(ctx, &s3.GetPublicAccessBlockInput{Bucket: aws.String(bucket)})
+	if isCode(err, "NotImplemented") {
+		return config, nil
+	}
+	if err != nil && !isCode(err, "NoSuchPublicAccessBlockConfiguration") {
+		return nil, translateError(err)
+	}
+	blocked := false
+	if block != nil && block.PublicAccessBlockConfiguration != nil {
+		c := block.PublicAccessBlockConfiguration
+		blocked = aws.BoolValue(c.BlockPublicAcls) && aws.BoolValue(c.IgnorePublicAcls) &&
+			aws.BoolValue(c.BlockPublicPolicy) && aws.BoolValue(c.RestrictPublicBuckets)
+	}
+	config.PublicAccessBlocked = aws.Bool(blocked)
+	return config, nil
+}
+
+// SetBucketConfig applies the settings of config that are not nil
+func (s *ObjectStore) SetBucketConfig(ctx context.Context, bucket string, config objectstore.BucketConfig) error {
+	if config.Versioning != nil {
+		// A versioned bucket can only be suspended, never return to unversioned
+		status := s3.BucketVersioningStatusSuspended
+		if *config.Versioning {
+			status = s3.BucketVersioningStatusEnabled
+		}
+		_, err := s.svc.PutBucketVersioningWithContext(ctx, &s3.PutBucketVersioningInput{
+			Bucket:                  aws.String(bucket),
+			VersioningConfiguration: &s3.VersioningConfiguration{Status: aws.String(status)},
+		})
+		if err != nil {
+			return fmt.Errorf("error setting versioning: %w", err)
+		}
+	}
+
+	if config.Lifecycle != nil {
+		var err error
+		if len(config.Lifecycle) == 0 {
+			_, err = s.svc.DeleteBucketLifecycleWithContext(ctx, &s3.DeleteBucketLifecycleInput{Bucket: aws.String(bucket)})
+		} else {
+			rules := make([]*s3.LifecycleRule, len(config.Lifecycle))
+			for i, rule := range config.Lifecycle {
+				rules[i] = lifecycleToS3(rule, i)
+			}
+			_, err = s.svc.PutBucketLifecycleConfigurationWithContext(ctx, &s3.PutBucketLifecycleConfigurationInput{
+				Bucket:                 aws.String(bucket),
+				LifecycleConfiguration: &s3.BucketLifecycleConfiguration{Rules: rules},
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("error setting lifecycle rules: %w", err)
+		}
+	}
+
+	if config.CORS != nil {
+		var err error
+		if len(config.CORS) == 0 {
+			_, err = s.svc.DeleteBucketCorsWithContext(ctx, &s3.DeleteBucketCorsInput{Bucket: aws.String(bucket)})
+		} else {
+			rules := make([]*s3.CORSRule, len(config.CORS))
+			for i, rule := range config.CORS {
+				rules[i] = &s3.CORSRule{
+					AllowedOrigins: aws.StringSlice(rule.AllowedOrigins),
+					AllowedMethods: aws.StringSlice(rule.AllowedMethods),
+					AllowedHeaders: aws.StringSlice(rule.AllowedHeaders),
+					ExposeHeaders:  aws.StringSlice(rule.ExposedHeaders),
+				}
+				if rule.MaxAgeSeconds > 0 {
+					rules[i].MaxAgeSeconds = aws.Int64(int64(rule.MaxAgeSeconds))
+				}
+			}
+			_, err = s.svc.PutBucketCorsWithContext(ctx, &s3.PutBucketCorsInput{
+				Bucket:            aws.String(bucket),
+				CORSConfiguration: &s3.CORSConfiguration{CORSRules: rules},
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("error setting CORS rules: %w", err)
+		}
+	}
+
+	if config.Encryption != nil {
+		// S3 encrypts every bucket, so the default can be changed but not removed
+		byDefault := &s3.ServerSideEncryptionByDefault{SSEAlgorithm: aws.String(s3.ServerSideEncryptionAes256)}
+		if config.Encryption.Algorithm == objectstore.EncryptionKMS {
+			byDefault = &s3.ServerSideEncryptionByDefault{
+				SSEAlgorithm:   aws.String(s3.ServerSideEncryptionAwsKms),
+				KMSMasterKeyID: aws.String(config.Encryption.KMSKeyID),
+			}
+		}
+		_, err := s.svc.PutBucketEncryptionWithContext(ctx, &s3.PutBucketEncryptionInput{
+			Bucket: aws.String(bucket),
+			ServerSideEncryptionConfiguration: &s3.ServerSideEncryptionConfiguration{
+				Rules: []*s3.ServerSideEncryptionRule{{ApplyServerSideEncryptionByDefault: byDefault}},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("error setting encryption: %w", err)
+		}
+	}
+
+	if config.PublicAccessBlocked != nil {
+		var err error
+		if *config.PublicAccessBlocked {
+			_, err = s.svc.PutPublicAccessBlockWithContext(ctx, &s3.PutPublicAccessBlockInput{
+				Bucket: aws.String(bucket),
+				PublicAccessBlockConfiguration: &s3.PublicAccessBlockConfiguration{
+					BlockPublicAcls:       aws.Bool(true),
+					IgnorePublicAcls:      aws.Bool(true),
+					BlockPublicPolicy:     aws.Bool(true),
+					RestrictPublicBuckets: aws.Bool(true),
+				},
+			})
+		} else {
+			_, err = s.svc.DeletePublicAccessBlockWithContext(ctx, &s3.DeletePublicAccessBlockInput{Bucket: aws.String(bucket)})
+		}
+		if err != nil {
+			return fmt.Errorf("error setting public access block: %w", err)
+		}
+	}
+	return nil
+}
+
+func lifecycleFromS3(rule *s3.LifecycleRule) objectstore.LifecycleRule {
+	converted := objectstore.LifecycleRule{
+		ID:      aws.StringValue(rule.ID),
+		Prefix:  aws.StringValue(rule.Prefix),
+		Enabled: aws.StringValue(rule.Status) == s3.ExpirationStatusEnabled,
+	}
+	if rule.Filter != nil {
+		if rule.Filter.Prefix != nil {
+			converted.Prefix = aws.StringValue(rule.Filter.Prefix)
+		} else if rule.Filter.And != nil {
+			converted.Prefix = aws.StringValue(rule.Filter.And.Prefix)
+		}
+	}
+	if rule.Expiration != nil {
+		converted.ExpireAfterDays = int(aws.Int64Value(rule.Expiration.Days))
+	}
+	if len(rule.Transitions) > 0 {
+		converted.TransitionAfterDays = int(aws.Int64Value(rule.Transitions[0].Days))
+		converted.StorageClass = aws.StringValue(rule.Transitions[0].StorageClass)
+	}
+	return converted
+}
+
+func lifecycleToS3(rule objectstore.LifecycleRule, index int) *s3.LifecycleRule {
+	converted := &s3.LifecycleRule{
+		ID:     aws.String(rule.ID),
+		Filter: &s3.LifecycleRuleFilter{Prefix: aws.String(rule.Prefix)},
+		Status: aws.String(s3.ExpirationStatusDisabled),
+	}
+	if rule.ID == "" {
+		converted.ID = aws.String(fmt.Sprintf("rule-%d", index+1))
+	}
+	if rule.Enabled {
+		converted.Status = aws.String(s3.ExpirationStatusEnabled)
+	}
+	if rule.ExpireAfterDays > 0 {
+		converted.Expiration = &s3.LifecycleExpiration{Days: aws.Int64(int64(rule.ExpireAfterDays))}
+	}
+	if rule.TransitionAfterDays > 0 {
+		converted.Transitions = []*s3.Transition{{
+			Days:         aws.Int64(int64(rule.TransitionAfterDays)),
+			StorageClass: aws.String(rule.StorageClass),
+		}}
+	}
+	return converted
+}
+
+func encryptionFromS3(byDefault *s3.ServerSideEncryptionByDefault) *objectstore.Encryption {
+	if aws.StringValue(byDefault.SSEAlgorithm) == s3.ServerSideEncryptionAes256 {
+		return &objectstore.Encryption{Algorithm: objectstore.EncryptionProviderManaged}
+	}
+	return &objectstore.Encryption{Algorithm: objectstore.EncryptionKMS, KMSKeyID: aws.StringValue(byDefault.KMSMasterKeyID)}
+}
+
+// isCode reports whether err is an AWS error with the given code
+func isCode(err error, code string) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == code
+}
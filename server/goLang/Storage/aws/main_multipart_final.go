@@ -0,0 +1,583 @@
+package aws_s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"btep.project/apierror"
+	"btep.project/auth/awsauth"
+	db "btep.project/databaseConnection"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// Streamed uploads are sent to S3 as a multipart upload, one part per partSize bytes of the
+// request body, with at most concurrency parts in flight (and in memory) at a time. The parts
+// of a failed upload are kept, so the client can send the rest of the file to resumeUpload.
+const (
+	defaultPartSize    = 16 << 20
+	maxPartSize        = 64 << 20
+	defaultConcurrency = 4
+	maxConcurrency     = 16
+	// maxUploadMemory bounds partSize × concurrency, the part buffers one upload can hold
+	maxUploadMemory = 256 << 20
+	// partBufferStep is what part buffers are rounded up to, so uploads of close part sizes
+	// share buffers
+	partBufferStep = 1 << 20
+	// metadataHeaderPrefix marks request headers that are stored as object metadata
+	metadataHeaderPrefix = "X-Amz-Meta-"
+)
+
+// UploadResponse is returned once a streamed upload is complete
+type UploadResponse struct {
+	Message    string `json:"message"`
+	BucketName string `json:"bucketName"`
+	ObjectKey  string `json:"objectKey"`
+	UploadID   string `json:"uploadId"`
+	ETag       string `json:"etag"`
+	Location   string `json:"location"`
+	Size       int64  `json:"size"`
+}
+
+// UploadRequest identifies an in-progress multipart upload
+type UploadRequest struct {
+	AccountID  int    `json:"accountID"`
+	Region     string `json:"region"`
+	BucketName string `json:"bucketName"`
+	ObjectKey  string `json:"objectKey"`
+	UploadID   string `json:"uploadId"`
+}
+
+// UploadPart is a part S3 already stored for an upload
+type UploadPart struct {
+	PartNumber int64  `json:"partNumber"`
+	Size       int64  `json:"size"`
+	ETag       string `json:"etag"`
+}
+
+// UploadStatusResponse tells the client where to resume an upload: the body sent to
+// resumeUpload has to start at byte BytesUploaded of the file
+type UploadStatusResponse struct {
+	BucketName     string       `json:"bucketName"`
+	ObjectKey      string       `json:"objectKey"`
+	UploadID       string       `json:"uploadId"`
+	Parts          []UploadPart `json:"parts"`
+	PartSize       int64        `json:"partSize"`
+	BytesUploaded  int64        `json:"bytesUploaded"`
+	NextPartNumber int64        `json:"nextPartNumber"`
+}
+
+// ListUploadsRequest lists the unfinished uploads of a bucket
+type ListUploadsRequest struct {
+	AccountID  int    `json:"accountID"`
+	Region     string `json:"region"`
+	BucketName string `json:"bucketName"`
+	Prefix     string `json:"prefix"`
+}
+
+type UploadDetails struct {
+	ObjectKey string `json:"objectKey"`
+	UploadID  string `json:"uploadId"`
+	Initiated string `json:"initiated"`
+}
+
+type ListUploadsResponse struct {
+	Uploads []UploadDetails `json:"uploads"`
+}
+
+// MultipartUploadHandler handles POST /aws/s3/multipartUpload and streams the request body to S3.
+//
+// The body is either the raw file or a multipart/form-data body whose first file field is the
+// file; it is never buffered to disk. The query carries accountID (or the X-Account-ID header),
+// region, bucketName, key or prefix (the file name is appended to prefix), and optionally
+// partSize in bytes and concurrency. Content-Type and X-Amz-Meta-* headers are stored with the object.
+func MultipartUploadHandler(w http.ResponseWriter, r *http.Request) {
+	streamUpload(w, r, false)
+}
+
+// ResumeUploadHandler handles POST /aws/s3/resumeUpload. It takes the same query as
+// multipartUpload plus uploadId and offset, and a body holding the file from offset on,
+// where offset is the bytesUploaded reported by uploadStatus.
+func ResumeUploadHandler(w http.ResponseWriter, r *http.Request) {
+	streamUpload(w, r, true)
+}
+
+func streamUpload(w http.ResponseWriter, r *http.Request, resume bool) {
+	query := r.URL.Query()
+	accountIDStr := query.Get("accountID")
+	if accountIDStr == "" {
+		accountIDStr = r.Header.Get("X-Account-ID")
+	}
+	accountID, err := strconv.Atoi(accountIDStr)
+	if err != nil {
+		apierror.BadRequest(w, fmt.Sprintf("Invalid account ID: %v", err))
+		return
+	}
+	bucketName := query.Get("bucketName")
+	if bucketName == "" {
+		apierror.BadRequest(w, "bucketName is required")
+		return
+	}
+	uploadID := query.Get("uploadId")
+	if resume && uploadID == "" {
+		apierror.BadRequest(w, "uploadId is required to resume an upload")
+		return
+	}
+	partSize, err := queryInt(query.Get("partSize"), defaultPartSize)
+	if err != nil || partSize < s3manager.MinUploadPartSize || partSize > maxPartSize {
+		apierror.BadRequest(w, fmt.Sprintf("partSize must be between %d and %d bytes", s3manager.MinUploadPartSize, int64(maxPartSize)))
+		return
+	}
+	concurrency, err := queryInt(query.Get("concurrency"), defaultConcurrency)
+	if err != nil || concurrency < 1 || concurrency > maxConcurrency {
+		apierror.BadRequest(w, fmt.Sprintf("concurrency must be between 1 and %d", maxConcurrency))
+		return
+	}
+	if partSize*concurrency > maxUploadMemory {
+		apierror.BadRequest(w, fmt.Sprintf("partSize × concurrency must not exceed %d bytes", int64(maxUploadMemory)))
+		return
+	}
+
+	body, filename, contentType, err := uploadBody(r)
+	if err != nil {
+		apierror.BadRequest(w, err.Error())
+		return
+	}
+	objectKey := query.Get("key")
+	if objectKey == "" {
+		if filename == "" {
+			apierror.BadRequest(w, "key, or a file name to append to prefix, is required")
+			return
+		}
+		objectKey = query.Get("prefix") + filename
+	}
+
+	cloudAccount, err := db.GetCloudAccountDetails(accountID)
+	if err != nil {
+		apierror.Write(w, err, "Error getting cloud account details")
+		return
+	}
+	sess, err := awsauth.SessionFor(cloudAccount, query.Get("region"))
+	if err != nil {
+		apierror.Write(w, err, "Error initializing AWS session")
+		return
+	}
+	upload := &multipartUpload{
+		svc:         s3.New(sess),
+		bucket:      bucketName,
+		key:         objectKey,
+		uploadID:    uploadID,
+		partSize:    partSize,
+		concurrency: int(concurrency),
+	}
+
+	var completed []*s3.CompletedPart
+	var offset int64
+	if resume {
+		status, err := upload.status(r.Context())
+		if err != nil {
+			apierror.Write(w, err, "Error reading upload status")
+			return
+		}
+		if v := query.Get("offset"); v != "" && v != strconv.FormatInt(status.BytesUploaded, 10) {
+			apierror.Send(w, apierror.New(http.StatusConflict, apierror.CodeConflict,
+				fmt.Sprintf("S3 holds %d bytes of upload %s, resume from that offset instead of %s", status.BytesUploaded, uploadID, v)))
+			return
+		}
+		if status.PartSize > maxPartSize {
+			apierror.Send(w, apierror.New(http.StatusConflict, apierror.CodeConflict,
+				fmt.Sprintf("Upload %s has %d-byte parts, more than the %d bytes a part can have here; abort it and upload again", uploadID, status.PartSize, int64(maxPartSize))))
+			return
+		}
+		if status.PartSize > 0 {
+			// Every part but the last has to be the same size, so resumed parts keep the original size
+			upload.partSize = status.PartSize
+			if upload.partSize*int64(upload.concurrency) > maxUploadMemory {
+				upload.concurrency = int(maxUploadMemory / upload.partSize)
+			}
+		}
+		for _, part := range status.Parts[:status.NextPartNumber-1] {
+			completed = append(completed, &s3.CompletedPart{PartNumber: aws.Int64(part.PartNumber), ETag: aws.String(part.ETag)})
+		}
+		offset = status.BytesUploaded
+	} else {
+		created, err := upload.svc.CreateMultipartUploadWithContext(r.Context(), &s3.CreateMultipartUploadInput{
+			Bucket:      aws.String(bucketName),
+			Key:         aws.String(objectKey),
+			ContentType: nonEmpty(contentType),
+			Metadata:    metadataFromHeaders(r.Header),
+		})
+		if err != nil {
+			apierror.Write(w, err, "Error starting multipart upload")
+			return
+		}
+		upload.uploadID = *created.UploadId
+	}
+	// Sent with errors as well, the client needs it to resume or abort
+	w.Header().Set("X-Upload-Id", upload.uploadID)
+
+	parts, size, err := upload.uploadParts(r.Context(), body, int64(len(completed))+1)
+	if err != nil {
+		apierror.Write(w, err, fmt.Sprintf("Error uploading parts of upload %s, it can be resumed", upload.uploadID))
+		return
+	}
+	completed = append(completed, parts...)
+
+	result, err := upload.svc.CompleteMultipartUploadWithContext(r.Context(), &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucketName),
+		Key:             aws.String(objectKey),
+		UploadId:        aws.String(upload.uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		apierror.Write(w, err, fmt.Sprintf("Error completing upload %s", upload.uploadID))
+		return
+	}
+
+	resp := UploadResponse{
+		Message:    "Object uploaded successfully",
+		BucketName: bucketName,
+		ObjectKey:  objectKey,
+		UploadID:   upload.uploadID,
+		ETag:       aws.StringValue(result.ETag),
+		Location:   aws.StringValue(result.Location),
+		Size:       offset + size,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// UploadStatusHandler handles POST /aws/s3/uploadStatus and reports the parts S3 stored so far
+func UploadStatusHandler(w http.ResponseWriter, r *http.Request) {
+	upload, ok := openUpload(w, r)
+	if !ok {
+		return
+	}
+	status, err := upload.status(r.Context())
+	if err != nil {
+		apierror.Write(w, err, "Error reading upload status")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// AbortUploadHandler handles POST /aws/s3/abortUpload and discards the stored parts
+func AbortUploadHandler(w http.ResponseWriter, r *http.Request) {
+	upload, ok := openUpload(w, r)
+	if !ok {
+		return
+	}
+	_, err := upload.svc.AbortMultipartUploadWithContext(r.Context(), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(upload.bucket),
+		Key:      aws.String(upload.key),
+		UploadId: aws.String(upload.uploadID),
+	})
+	if err != nil {
+		apierror.Write(w, err, "Error aborting upload")
+		return
+	}
+
+	resp := BucketResponse{Message: fmt.Sprintf("Upload %s aborted successfully", upload.uploadID)}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ListUploadsHandler handles POST /aws/s3/listUploads
+func ListUploadsHandler(w http.ResponseWriter, r *http.Request) {
+	var req ListUploadsRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		apierror.BadRequest(w, "Invalid request body")
+		return
+	}
+	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
+	if err != nil {
+		apierror.Write(w, err, "Error getting cloud account details")
+		return
+	}
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
+	if err != nil {
+		apierror.Write(w, err, "Error initializing AWS session")
+		return
+	}
+
+	uploads := []UploadDetails{}
+	input := &s3.ListMultipartUploadsInput{Bucket: aws.String(req.BucketName), Prefix: nonEmpty(req.Prefix)}
+	err = s3.New(sess).ListMultipartUploadsPagesWithContext(r.Context(), input, func(page *s3.ListMultipartUploadsOutput, lastPage bool) bool {
+		for _, upload := range page.Uploads {
+			uploads = append(uploads, UploadDetails{
+				ObjectKey: aws.StringValue(upload.Key),
+				UploadID:  aws.StringValue(upload.UploadId),
+				Initiated: aws.TimeValue(upload.Initiated).String(),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		apierror.Write(w, err, "Error listing uploads")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ListUploadsResponse{Uploads: uploads})
+}
+
+// openUpload decodes an UploadRequest and connects to its bucket
+func openUpload(w http.ResponseWriter, r *http.Request) (*multipartUpload, bool) {
+	var req UploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.BadRequest(w, "Invalid request body")
+		return nil, false
+	}
+	if req.BucketName == "" || req.ObjectKey == "" || req.UploadID == "" {
+		apierror.BadRequest(w, "bucketName, objectKey and uploadId are required")
+		return nil, false
+	}
+	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
+	if err != nil {
+		apierror.Write(w, err, "Error getting cloud account details")
+		return nil, false
+	}
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
+	if err != nil {
+		apierror.Write(w, err, "Error initializing AWS session")
+		return nil, false
+	}
+	return &multipartUpload{svc: s3.New(sess), bucket: req.BucketName, key: req.ObjectKey, uploadID: req.UploadID}, true
+}
+
+type multipartUpload struct {
+	svc         *s3.S3
+	bucket      string
+	key         string
+	uploadID    string
+	partSize    int64
+	concurrency int
+}
+
+// status lists the stored parts. Only the parts numbered 1..n without a gap and of the size of
+// part 1 count as uploaded, a part after a gap is sent again when the upload resumes. A short
+// part was the end of the body, more parts cannot follow it, so it is sent again as well. Part 1
+// counts only if it is at least the smallest part S3 takes, otherwise the upload starts over.
+func (u *multipartUpload) status(ctx context.Context) (*UploadStatusResponse, error) {
+	status := &UploadStatusResponse{BucketName: u.bucket, ObjectKey: u.key, UploadID: u.uploadID, Parts: []UploadPart{}}
+	input := &s3.ListPartsInput{Bucket: aws.String(u.bucket), Key: aws.String(u.key), UploadId: aws.String(u.uploadID)}
+	err := u.svc.ListPartsPagesWithContext(ctx, input, func(page *s3.ListPartsOutput, lastPage bool) bool {
+		for _, part := range page.Parts {
+			status.Parts = append(status.Parts, UploadPart{
+				PartNumber: aws.Int64Value(part.PartNumber),
+				Size:       aws.Int64Value(part.Size),
+				ETag:       aws.StringValue(part.ETag),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(status.Parts, func(i, j int) bool { return status.Parts[i].PartNumber < status.Parts[j].PartNumber })
+	if len(status.Parts) > 0 && status.Parts[0].PartNumber == 1 && status.Parts[0].Size >= s3manager.MinUploadPartSize {
+		status.PartSize = status.Parts[0].Size
+	}
+	status.NextPartNumber = 1
+	for _, part := range status.Parts {
+		if part.PartNumber != status.NextPartNumber || part.Size != status.PartSize {
+			break
+		}
+		status.BytesUploaded += part.Size
+		status.NextPartNumber++
+	}
+	return status, nil
+}
+
+type partResult struct {
+	part *s3.CompletedPart
+	err  error
+}
+
+// partBuffers holds the part buffers of all uploads by their size, so they are reused across
+// requests instead of allocated for each
+var partBuffers = struct {
+	sync.Mutex
+	pools map[int64]*sync.Pool
+}{pools: make(map[int64]*sync.Pool)}
+
+// partBufferPool returns the pool of buffers that hold a part of partSize bytes
+func partBufferPool(partSize int64) *sync.Pool {
+	size := (partSize + partBufferStep - 1) / partBufferStep * partBufferStep
+	partBuffers.Lock()
+	defer partBuffers.Unlock()
+	pool, ok := partBuffers.pools[size]
+	if !ok {
+		pool = &sync.Pool{New: func() interface{} {
+			buf := make([]byte, size)
+			return &buf
+		}}
+		partBuffers.pools[size] = pool
+	}
+	return pool
+}
+
+// uploadParts reads body in partSize chunks and uploads them as parts firstPart, firstPart+1, ...
+// At most concurrency buffers are taken, each only once a part is read into it, so a slow S3
+// connection slows down reading the body instead of growing memory.
+func (u *multipartUpload) uploadParts(ctx context.Context, body io.Reader, firstPart int64) ([]*s3.CompletedPart, int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pool := partBufferPool(u.partSize)
+	slots := make(chan struct{}, u.concurrency)
+	results := make(chan partResult, s3manager.MaxUploadParts)
+	var wg sync.WaitGroup
+	var size int64
+	var readErr error
+
+	for partNumber := firstPart; ; partNumber++ {
+		if partNumber > s3manager.MaxUploadParts {
+			readErr = fmt.Errorf("the file needs more than %d parts, use a larger partSize", s3manager.MaxUploadParts)
+			break
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		buf := pool.Get().(*[]byte)
+		n, err := io.ReadFull(body, (*buf)[:u.partSize])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			pool.Put(buf)
+			<-slots
+			readErr = fmt.Errorf("error reading request body: %v", err)
+			break
+		}
+		// An empty body still needs one (empty) part, a resumed upload does not
+		if n == 0 && (partNumber > 1 || firstPart > 1) {
+			pool.Put(buf)
+			<-slots
+			break
+		}
+		size += int64(n)
+
+		wg.Add(1)
+		go func(partNumber int64, buf *[]byte, n int) {
+			defer wg.Done()
+			out, err := u.svc.UploadPartWithContext(ctx, &s3.UploadPartInput{
+				Bucket:        aws.String(u.bucket),
+				Key:           aws.String(u.key),
+				UploadId:      aws.String(u.uploadID),
+				PartNumber:    aws.Int64(partNumber),
+				Body:          bytes.NewReader((*buf)[:n]),
+				ContentLength: aws.Int64(int64(n)),
+			})
+			pool.Put(buf)
+			<-slots
+			if err != nil {
+				cancel()
+				results <- partResult{err: err}
+				return
+			}
+			results <- partResult{part: &s3.CompletedPart{PartNumber: aws.Int64(partNumber), ETag: out.ETag}}
+		}(partNumber, buf, n)
+
+		if err != nil {
+			// io.EOF or io.ErrUnexpectedEOF: this was the last part
+			break
+		}
+	}
+	wg.Wait()
+	close(results)
+
+	var parts []*s3.CompletedPart
+	var uploadErr error
+	for result := range results {
+		if result.err != nil {
+			if uploadErr == nil {
+				uploadErr = result.err
+			}
+			continue
+		}
+		parts = append(parts, result.part)
+	}
+	if uploadErr != nil {
+		return nil, 0, uploadErr
+	}
+	if readErr != nil {
+		return nil, 0, readErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	sort.Slice(parts, func(i, j int) bool { return *parts[i].PartNumber < *parts[j].PartNumber })
+	return parts, size, nil
+}
+
+// uploadBody returns the file of the request without buffering it: the first file field of a
+// multipart/form-data body, or the raw body otherwise
+func uploadBody(r *http.Request) (io.Reader, string, string, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return r.Body, r.URL.Query().Get("filename"), r.Header.Get("Content-Type"), nil
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", "", fmt.Errorf("error reading form data: %v", err)
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, "", "", fmt.Errorf("the form data contains no file")
+		}
+		if err != nil {
+			return nil, "", "", fmt.Errorf("error reading form data: %v", err)
+		}
+		if part.FileName() != "" {
+			return part, part.FileName(), part.Header.Get("Content-Type"), nil
+		}
+		part.Close()
+	}
+}
+
+// metadataFromHeaders collects the X-Amz-Meta-* request headers as object metadata
+func metadataFromHeaders(header http.Header) map[string]*string {
+	metadata := make(map[string]*string)
+	for name, values := range header {
+		if len(values) == 0 || !strings.HasPrefix(name, metadataHeaderPrefix) {
+			continue
+		}
+		metadata[strings.ToLower(strings.TrimPrefix(name, metadataHeaderPrefix))] = aws.String(values[0])
+	}
+	if len(metadata) == 0 {
+		return nil
+	}
+	return metadata
+}
+
+func queryInt(value string, fallback int64) (int64, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+func nonEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
@@ -0,0 +1,127 @@
+package aws_s3
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"btep.project/Storage/objectstore"
+	"btep.project/apierror"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// maxCopySize is the largest object a single CopyObject call can copy
+const maxCopySize = 5 << 30
+
+// UpdateObject copies the object onto itself with the new metadata or storage class, which
+// is the only way S3 changes either. Everything the update leaves out is copied from the
+// current object, including its encryption and tags, but the object gets a new ETag and
+// LastModified. Objects in GLACIER or DEEP_ARCHIVE must be restored before they can be copied.
+func (s *ObjectStore) UpdateObject(ctx context.Context, bucket, key string, update objectstore.ObjectUpdate) error {
+	head, err := s.svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return translateError(err)
+	}
+	if aws.Int64Value(head.ContentLength) > maxCopySize {
+		return apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest,
+			fmt.Sprintf("%s is larger than 5 GiB, S3 cannot update it in place", key))
+	}
+
+	input := &s3.CopyObjectInput{
+		Bucket:             aws.String(bucket),
+		Key:                aws.String(key),
+		CopySource:         aws.String(url.PathEscape(bucket + "/" + key)),
+		MetadataDirective:  aws.String(s3.MetadataDirectiveReplace),
+		ContentType:        head.ContentType,
+		CacheControl:       head.CacheControl,
+		ContentDisposition: head.ContentDisposition,
+		ContentEncoding:    head.ContentEncoding,
+		ContentLanguage:    head.ContentLanguage,
+		Metadata:           head.Metadata,
+		StorageClass:       head.StorageClass,
+		// A copy is encrypted with the bucket default unless told otherwise
+		ServerSideEncryption: head.ServerSideEncryption,
+		SSEKMSKeyId:          head.SSEKMSKeyId,
+		BucketKeyEnabled:     head.BucketKeyEnabled,
+	}
+	if expires, err := http.ParseTime(aws.StringValue(head.Expires)); err == nil {
+		input.Expires = aws.Time(expires)
+	}
+	if update.ContentType != nil {
+		input.ContentType = nonEmpty(*update.ContentType)
+	}
+	if update.CacheControl != nil {
+		input.CacheControl = nonEmpty(*update.CacheControl)
+	}
+	if update.Metadata != nil {
+		input.Metadata = aws.StringMap(update.Metadata)
+	}
+	if update.StorageClass != "" {
+		input.StorageClass = aws.String(update.StorageClass)
+	}
+	_, err = s.svc.CopyObjectWithContext(ctx, input)
+	return translateError(err)
+}
+
+func (s *ObjectStore) GetTags(ctx context.Context, bucket, key string) (map[string]string, error) {
+	out, err := s.svc.GetObjectTaggingWithContext(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	tags := make(map[string]string, len(out.TagSet))
+	for _, tag := range out.TagSet {
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return tags, nil
+}
+
+func (s *ObjectStore) SetTags(ctx context.Context, bucket, key string, tags map[string]string) error {
+	if len(tags) == 0 {
+		_, err := s.svc.DeleteObjectTaggingWithContext(ctx, &s3.DeleteObjectTaggingInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		return translateError(err)
+	}
+	tagSet := make([]*s3.Tag, 0, len(tags))
+	for k, v := range tags {
+		tagSet = append(tagSet, &s3.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	_, err := s.svc.PutObjectTaggingWithContext(ctx, &s3.PutObjectTaggingInput{
+		Bucket:  aws.String(bucket),
+		Key:     aws.String(key),
+		Tagging: &s3.Tagging{TagSet: tagSet},
+	})
+	return translateError(err)
+}
+
+// storageClass names the class of a HeadObject response, which leaves STANDARD out
+func storageClass(class *string) string {
+	if class == nil {
+		return s3.StorageClassStandard
+	}
+	return *class
+}
+
+// metadata lower-cases the keys the SDK canonicalizes like HTTP headers, so they come back
+// as they were set
+func metadata(m map[string]*string) map[string]string {
+	if len(m) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[strings.ToLower(k)] = aws.StringValue(v)
+	}
+	return out
+}
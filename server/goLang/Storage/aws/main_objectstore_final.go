@@ -0,0 +1,233 @@
+package aws_s3
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"btep.project/Storage/objectstore"
+	"btep.project/auth/awsauth"
+	db "btep.project/databaseConnection"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// ObjectStore implements objectstore.ObjectStore on top of S3
+type ObjectStore struct {
+	svc      *s3.S3
+	uploader *s3manager.Uploader
+}
+
+// NewObjectStore opens an S3 backed objectstore.ObjectStore for the cloud account
+func NewObjectStore(ctx context.Context, cloudAccount *db.CloudAccount, opts objectstore.Options) (objectstore.ObjectStore, error) {
+	sess, err := awsauth.SessionFor(cloudAccount, opts.Region)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectStore{svc: s3.New(sess), uploader: s3manager.NewUploader(sess)}, nil
+}
+
+func (s *ObjectStore) CreateBucket(ctx context.Context, bucket string) error {
+	_, err := s.svc.CreateBucketWithContext(ctx, createBucketInput(s.svc, bucket))
+	return err
+}
+
+func (s *ObjectStore) Put(ctx context.Context, bucket, key string, body io.Reader, opts objectstore.PutOptions) error {
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   body,
+	}
+	if opts.ContentType != "" {
+		input.ContentType = aws.String(opts.ContentType)
+	}
+	if len(opts.MD5) > 0 {
+		// Checked by S3 when the object fits in a single part, multipart uploads drop it
+		input.ContentMD5 = aws.String(base64.StdEncoding.EncodeToString(opts.MD5))
+	}
+	_, err := s.uploader.UploadWithContext(ctx, input)
+	return err
+}
+
+func (s *ObjectStore) Get(ctx context.Context, bucket, key string, opts objectstore.GetOptions) (io.ReadCloser, *objectstore.ObjectInfo, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	if opts.Range != nil {
+		input.Range = aws.String(opts.Range.HeaderValue())
+	}
+	if opts.IfNoneMatch != "" {
+		input.IfNoneMatch = aws.String(opts.IfNoneMatch)
+	}
+	out, err := s.svc.GetObjectWithContext(ctx, input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidRange" {
+		// S3 does not say how large the object is, the client needs it to ask again
+		if head, statErr := s.Stat(ctx, bucket, key); statErr == nil {
+			return nil, nil, objectstore.RangeNotSatisfiable(head.Size)
+		}
+	}
+	if err != nil {
+		return nil, nil, translateError(err)
+	}
+	info := &objectstore.ObjectInfo{
+		Key:          key,
+		Size:         aws.Int64Value(out.ContentLength),
+		ETag:         aws.StringValue(out.ETag),
+		ContentType:  aws.StringValue(out.ContentType),
+		LastModified: aws.TimeValue(out.LastModified),
+		MD5:          etagMD5(out.ETag, out.ServerSideEncryption),
+	}
+	if contentRange, size, ok := objectstore.ParseContentRange(aws.StringValue(out.ContentRange)); ok {
+		info.ContentRange, info.Size = contentRange, size
+	}
+	return out.Body, info, nil
+}
+
+func (s *ObjectStore) Delete(ctx context.Context, bucket, key string) error {
+	_, err := s.svc.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	return translateError(err)
+}
+
+func (s *ObjectStore) List(ctx context.Context, bucket string, opts objectstore.ListOptions) (*objectstore.ListPage, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket:            aws.String(bucket),
+		Prefix:            nonEmpty(opts.Prefix),
+		Delimiter:         nonEmpty(opts.Delimiter),
+		ContinuationToken: nonEmpty(opts.PageToken),
+	}
+	if opts.MaxKeys > 0 {
+		input.MaxKeys = aws.Int64(int64(opts.MaxKeys))
+	}
+	out, err := s.svc.ListObjectsV2WithContext(ctx, input)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	page := &objectstore.ListPage{Objects: []objectstore.ObjectInfo{}, CommonPrefixes: []string{}}
+	for _, obj := range out.Contents {
+		page.Objects = append(page.Objects, objectstore.ObjectInfo{
+			Key:          aws.StringValue(obj.Key),
+			Size:         aws.Int64Value(obj.Size),
+			ETag:         aws.StringValue(obj.ETag),
+			LastModified: aws.TimeValue(obj.LastModified),
+			StorageClass: aws.StringValue(obj.StorageClass),
+		})
+	}
+	for _, prefix := range out.CommonPrefixes {
+		page.CommonPrefixes = append(page.CommonPrefixes, aws.StringValue(prefix.Prefix))
+	}
+	if aws.BoolValue(out.IsTruncated) {
+		page.NextPageToken = aws.StringValue(out.NextContinuationToken)
+	}
+	return page, nil
+}
+
+func (s *ObjectStore) Stat(ctx context.Context, bucket, key string) (*objectstore.ObjectInfo, error) {
+	out, err := s.svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &objectstore.ObjectInfo{
+		Key:          key,
+		Size:         aws.Int64Value(out.ContentLength),
+		ETag:         aws.StringValue(out.ETag),
+		ContentType:  aws.StringValue(out.ContentType),
+		LastModified: aws.TimeValue(out.LastModified),
+		MD5:          etagMD5(out.ETag, out.ServerSideEncryption),
+		StorageClass: storageClass(out.StorageClass),
+		CacheControl: aws.StringValue(out.CacheControl),
+		Metadata:     metadata(out.Metadata),
+	}, nil
+}
+
+// SignURL presigns a GetObject or PutObject request. The signature covers the Content-Type
+// of a PUT, so S3 rejects an upload sent with any other type.
+func (s *ObjectStore) SignURL(ctx context.Context, bucket, key string, opts objectstore.SignOptions) (*objectstore.SignedURL, error) {
+	var req *request.Request
+	if opts.Method == http.MethodPut {
+		req, _ = s.svc.PutObjectRequest(&s3.PutObjectInput{
+			Bucket:      aws.String(bucket),
+			Key:         aws.String(key),
+			ContentType: nonEmpty(opts.ContentType),
+		})
+	} else {
+		req, _ = s.svc.GetObjectRequest(&s3.GetObjectInput{
+			Bucket:              aws.String(bucket),
+			Key:                 aws.String(key),
+			ResponseContentType: nonEmpty(opts.ContentType),
+		})
+	}
+	req.SetContext(ctx)
+
+	// Presigning needs the credentials, resolving assumed role ones can fail
+	signedURL, signedHeaders, err := req.PresignRequest(opts.Expiry)
+	if err != nil {
+		return nil, err
+	}
+	signed := &objectstore.SignedURL{
+		URL:       signedURL,
+		Method:    opts.Method,
+		ExpiresAt: time.Now().Add(opts.Expiry).UTC(),
+		Headers:   map[string]string{},
+	}
+	// A URL signed with assumed role credentials stops working when they expire
+	if expiresAt, err := s.svc.Config.Credentials.ExpiresAt(); err == nil && expiresAt.Before(signed.ExpiresAt) {
+		signed.ExpiresAt = expiresAt.UTC()
+	}
+	// The signer keys the headers in lower case, the map cannot be read with Get
+	for name, values := range signedHeaders {
+		signed.Headers[http.CanonicalHeaderKey(name)] = strings.Join(values, ",")
+	}
+	return signed, nil
+}
+
+func (s *ObjectStore) Close() error {
+	return nil
+}
+
+// etagMD5 returns the MD5 an ETag carries. Only objects uploaded in a single part and not
+// encrypted with SSE-KMS have the MD5 of their content as ETag; listings do not say how an
+// object is encrypted, so List leaves MD5 empty.
+func etagMD5(etag, serverSideEncryption *string) []byte {
+	if strings.HasPrefix(aws.StringValue(serverSideEncryption), s3.ServerSideEncryptionAwsKms) {
+		return nil
+	}
+	sum, err := hex.DecodeString(strings.Trim(aws.StringValue(etag), `"`))
+	if err != nil || len(sum) != md5.Size {
+		return nil
+	}
+	return sum
+}
+
+// translateError maps S3 "not found" codes onto objectstore.ErrNotFound and the
+// 304 of a conditional GET onto objectstore.ErrNotModified
+func translateError(err error) error {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchKey, s3.ErrCodeNoSuchBucket, "NotFound":
+			return objectstore.ErrNotFound
+		case "NotModified":
+			return objectstore.ErrNotModified
+		}
+	}
+	if failure, ok := err.(awserr.RequestFailure); ok && failure.StatusCode() == http.StatusNotModified {
+		return objectstore.ErrNotModified
+	}
+	return err
+}
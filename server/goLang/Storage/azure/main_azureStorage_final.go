@@ -1,21 +1,19 @@
 package azure_storage
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"mime/multipart"
 	"net/http"
-	"net/url"
-	"os"
 
+	"btep.project/Storage/objectstore"
+	"btep.project/apierror"
+	"btep.project/auth/azureauth"
 	db "btep.project/databaseConnection"
+	"btep.project/middleware"
 
 	"github.com/Azure/azure-sdk-for-go/profiles/latest/storage/mgmt/storage"
-	"github.com/Azure/azure-storage-blob-go/azblob"
-	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/to"
 )
 
@@ -23,7 +21,7 @@ type StorageAccountRequest struct {
 	AccountName    string `json:"accountName"`
 	ResourceGroup  string `json:"resourceGroup"`
 	SubscriptionID string `json:"subscriptionID"`
-	Token          string `json:"token"`
+	AccountID      int    `json:"accountID"`
 	Location       string `json:"location"`
 	StorageType    string `json:"storageType"`
 	AccessTier     string `json:"accessTier"`
@@ -38,16 +36,14 @@ func CreateStorageAccountHandler(w http.ResponseWriter, r *http.Request) {
 	var req StorageAccountRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Create Azure Storage account client
-	client, err := initStorageClient(req.SubscriptionID, req.Token)
+	client, err := initStorageClient(req.SubscriptionID, req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Failed to initialize Azure Storage account client: %v", err)
+		apierror.Write(w, err, "Failed to initialize Azure Storage account client")
 		return
 	}
 
@@ -77,8 +73,7 @@ func CreateStorageAccountHandler(w http.ResponseWriter, r *http.Request) {
 	// Create the storage account
 	_, err = client.Create(context.Background(), req.ResourceGroup, req.AccountName, accountParameters)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating Azure Storage account: %v", err)
+		apierror.Write(w, err, "Error creating Azure Storage account")
 		return
 	}
 
@@ -94,26 +89,24 @@ func DeleteStorageAccountHandler(w http.ResponseWriter, r *http.Request) {
 	var req StorageAccountRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Create Azure Storage account client
-	client, err := initStorageClient(req.SubscriptionID, req.Token)
+	client, err := initStorageClient(req.SubscriptionID, req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Failed to initialize Azure Storage account client: %v", err)
+		apierror.Write(w, err, "Failed to initialize Azure Storage account client")
 		return
 	}
 
 	// Delete the storage account
 	_, err = client.Delete(context.Background(), req.ResourceGroup, req.AccountName)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error deleting Azure Storage account: %v", err)
+		apierror.Write(w, err, "Error deleting Azure Storage account")
 		return
 	}
+	forgetAccountStore(req.ResourceGroup, req.AccountName)
 
 	// Send success response
 	resp := StorageAccountResponse{Message: "Azure Storage account deleted successfully"}
@@ -121,49 +114,39 @@ func DeleteStorageAccountHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
-func withBearerToken(token string) autorest.PrepareDecorator {
-	return func(p autorest.Preparer) autorest.Preparer {
-		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
-			r.Header.Set("Authorization", "Bearer "+token)
-			return r, nil
-		})
-	}
-}
-
-func initStorageClient(subscriptionID string, token string) (storage.AccountsClient, error) {
+func initStorageClient(subscriptionID string, accountID int) (storage.AccountsClient, error) {
 	client := storage.NewAccountsClient(subscriptionID)
-	client.Authorizer = autorest.NullAuthorizer{}
-	client.RequestInspector = withBearerToken(token)
+	authorizer, err := azureauth.Authorizer(context.Background(), accountID)
+	if err != nil {
+		return client, err
+	}
+	client.Authorizer = authorizer
 	return client, nil
 }
 
 type ListStorageAccountRequest struct {
-	AccountID int    `json:"accountID"`
-	Token     string `json:"token"`
+	AccountID int `json:"accountID"`
 }
 
 func ListStorageAccountsHandler(w http.ResponseWriter, r *http.Request) {
 	var req ListStorageAccountRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
-	client, err := initStorageClient(cloudAccount.SubscriptionID.String, req.Token)
+	client, err := initStorageClient(cloudAccount.SubscriptionID.String, req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Failed to initialize Azure Storage account client: %v", err)
+		apierror.Write(w, err, "Failed to initialize Azure Storage account client")
 		return
 	}
 	fmt.Println("client:", client)
 
 	accounts, err := client.ListComplete(context.Background())
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error listing Azure Storage accounts: %v", err)
+		apierror.Write(w, err, "Error listing Azure Storage accounts")
 		return
 	}
 	fmt.Println("accounts:", accounts)
@@ -172,8 +155,7 @@ func ListStorageAccountsHandler(w http.ResponseWriter, r *http.Request) {
 	jsonResponse, err := json.Marshal(accounts)
 	fmt.Println("jsonResponse:", jsonResponse)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error marshaling response: %v", err)
+		apierror.Write(w, err, "Error marshaling response")
 		return
 	}
 
@@ -186,15 +168,13 @@ func UpdateStorageAccountHandler(w http.ResponseWriter, r *http.Request) {
 	var req StorageAccountRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
-	client, err := initStorageClient(req.SubscriptionID, req.Token)
+	client, err := initStorageClient(req.SubscriptionID, req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Failed to initialize Azure Storage account client: %v", err)
+		apierror.Write(w, err, "Failed to initialize Azure Storage account client")
 		return
 	}
 
@@ -209,8 +189,7 @@ func UpdateStorageAccountHandler(w http.ResponseWriter, r *http.Request) {
 
 	_, err = client.Update(context.Background(), req.ResourceGroup, req.AccountName, updateParams)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error updating Azure Storage account: %v", err)
+		apierror.Write(w, err, "Error updating Azure Storage account")
 		return
 	}
 
@@ -224,67 +203,61 @@ type UploadObjectRequest struct {
 	AccountName    string                `json:"accountName"`
 	ResourceGroup  string                `json:"resourceGroup"`
 	SubscriptionID string                `json:"subscriptionID"`
-	Token          string                `json:"token"`
+	AccountID      int                   `json:"accountID"`
 	ContainerName  string                `json:"containerName"`
 	ObjectName     string                `json:"objectName"`
 	BlobName       string                `json:"blobName"`
-	file           *multipart.FileHeader `json:"file"`
+	File           *multipart.FileHeader `json:"file"`
 }
 
 type UploadObjectResponse struct {
 	Message string `json:"message"`
 }
 
+// UploadBlobHandler streams the "file" part of a multipart form into a block blob.
+// The blob is named blobName, objectName or the file name, in that order.
 func UploadBlobHandler(w http.ResponseWriter, r *http.Request) {
 	var req UploadObjectRequest
+	accountID, err := middleware.FormAccountID(r)
+	if err != nil {
+		apierror.Write(w, err, "")
+		return
+	}
+	req.AccountID = accountID
 	req.AccountName = r.FormValue("accountName")
-	req.Token = r.FormValue("token")
 	req.ContainerName = r.FormValue("containerName")
 	req.ObjectName = r.FormValue("objectName")
 	req.BlobName = r.FormValue("blobName")
 	req.SubscriptionID = r.FormValue("subscriptionID")
 	req.ResourceGroup = r.FormValue("resourceGroup")
-	// err := json.NewDecoder(r.Body).Decode(&req)
-	// if err != nil {
-	// 	w.WriteHeader(http.StatusBadRequest)
-	// 	fmt.Fprintf(w, "Invalid request body", err)
-	// 	return
-	// }
-
-	client, err := initStorageClient(req.SubscriptionID, req.Token)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Failed to initialize Azure Storage account client: %v", err)
+	if req.ContainerName == "" {
+		apierror.BadRequest(w, "containerName is required")
 		return
 	}
 
-	containerURL := getContainerURL(client, req.ResourceGroup, req.AccountName)
 	file, fileHeader, err := r.FormFile("file")
-	blobURL := containerURL.NewBlockBlobURL(fileHeader.Filename)
-
-	fmt.Println(file)
-
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Failed to open file: %v", err)
+		apierror.BadRequest(w, fmt.Sprintf("Failed to open file: %v", err))
 		return
 	}
 	defer file.Close()
 
-	fileBytes := new(bytes.Buffer)
-	_, err = fileBytes.ReadFrom(file)
-	fileBytes1 := fileBytes.Bytes()
+	blobName := req.BlobName
+	if blobName == "" {
+		blobName = req.ObjectName
+	}
+	if blobName == "" {
+		blobName = fileHeader.Filename
+	}
 
+	store, err := openAccountStore(r.Context(), req.SubscriptionID, req.AccountID, req.ResourceGroup, req.AccountName)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Failed to read file: %v", err)
+		apierror.Write(w, err, "Failed to connect to the storage account")
 		return
 	}
-
-	_, err = azblob.UploadBufferToBlockBlob(context.Background(), fileBytes1, blobURL, azblob.UploadToBlockBlobOptions{})
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Failed to upload blob: %v", err)
+	opts := objectstore.PutOptions{ContentType: fileHeader.Header.Get("Content-Type"), Size: fileHeader.Size}
+	if err := store.Put(r.Context(), req.ContainerName, blobName, file, opts); err != nil {
+		apierror.Write(w, err, "Failed to upload blob")
 		return
 	}
 
@@ -293,25 +266,9 @@ func UploadBlobHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
-func getContainerURL(client storage.AccountsClient, resourceGroup, accountName string) azblob.ContainerURL {
-	keys, err := client.ListKeys(context.Background(), resourceGroup, accountName, "")
-	if err != nil {
-		fmt.Println("Failed to get storage account keys:", err)
-		os.Exit(1)
-	}
-
-	credential, err := azblob.NewSharedKeyCredential(accountName, *(*keys.Keys)[0].Value)
-	p := azblob.NewPipeline(credential, azblob.PipelineOptions{})
-	url, err := url.Parse("https://" + accountName + ".blob.core.windows.net")
-	serviceURL := azblob.NewServiceURL(*url, p)
-	containerURL := serviceURL.NewContainerURL("vatsaldp")
-
-	return containerURL
-}
-
 type GetObjectRequest struct {
 	AccountName    string `json:"accountName"`
-	Token          string `json:"token"`
+	AccountID      int    `json:"accountID"`
 	ContainerName  string `json:"containerName"`
 	ObjectName     string `json:"objectName"`
 	SubscriptionID string `json:"subscriptionID"`
@@ -320,46 +277,60 @@ type GetObjectRequest struct {
 
 func GetObjectHandler(w http.ResponseWriter, r *http.Request) {
 	var req GetObjectRequest
+	accountID, err := middleware.FormAccountID(r)
+	if err != nil {
+		apierror.Write(w, err, "")
+		return
+	}
+	req.AccountID = accountID
 	req.AccountName = r.FormValue("accountName")
-	req.Token = r.FormValue("token")
 	req.ContainerName = r.FormValue("containerName")
 	req.ObjectName = r.FormValue("objectName")
 	req.SubscriptionID = r.FormValue("subscriptionID")
 	req.ResourceGroup = r.FormValue("resourceGroup")
 
-	// Initialize Azure Blob Storage client
-	client, err := initStorageClient(req.SubscriptionID, req.Token)
+	store, err := openAccountStore(r.Context(), req.SubscriptionID, req.AccountID, req.ResourceGroup, req.AccountName)
 	if err != nil {
-		handleError(w, fmt.Sprintf("Failed to initialize Azure Storage account client: %v", err), http.StatusInternalServerError)
+		apierror.Write(w, err, "Failed to connect to the storage account")
 		return
 	}
 
-	// Create a container URL
-	containerURL := getContainerURL(client, req.ResourceGroup, req.AccountName)
+	// Stream the blob, honouring Range and If-None-Match
+	objectstore.Download(w, r, store, req.ContainerName, req.ObjectName)
+}
 
-	// Create a blob URL within the container
-	blobURL := containerURL.NewBlockBlobURL(req.ObjectName)
+// ListObjectsRequest represents the JSON request structure for listObjects
+type ListObjectsRequest struct {
+	AccountName    string `json:"accountName"`
+	AccountID      int    `json:"accountID"`
+	ContainerName  string `json:"containerName"`
+	SubscriptionID string `json:"subscriptionID"`
+	ResourceGroup  string `json:"resourceGroup"`
+	Prefix         string `json:"prefix"`
+	Delimiter      string `json:"delimiter"`
+	PageToken      string `json:"pageToken"`
+	MaxKeys        int    `json:"maxKeys"`
+}
 
-	// Download the blob content
-	downloadResponse, err := blobURL.Download(context.Background(), 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false, azblob.ClientProvidedKeyOptions{})
-	if err != nil {
-		handleError(w, fmt.Sprintf("Failed to download object from Azure Storage: %v", err), http.StatusInternalServerError)
+// ListObjectsHandler lists one page of the blobs in a container.
+// A delimiter of "/" lists the container folder by folder, the folders come back as commonPrefixes.
+func ListObjectsHandler(w http.ResponseWriter, r *http.Request) {
+	var req ListObjectsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
-	defer downloadResponse.Body(azblob.RetryReaderOptions{}).Close()
-
-	// Set response headers
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Disposition", "attachment; filename="+req.ObjectName)
 
-	// Write the blob content to the response writer
-	if _, err := io.Copy(w, downloadResponse.Body(azblob.RetryReaderOptions{})); err != nil {
-		handleError(w, fmt.Sprintf("Failed to write object data to response: %v", err), http.StatusInternalServerError)
+	store, err := openAccountStore(r.Context(), req.SubscriptionID, req.AccountID, req.ResourceGroup, req.AccountName)
+	if err != nil {
+		apierror.Write(w, err, "Failed to connect to the storage account")
 		return
 	}
-}
 
-func handleError(w http.ResponseWriter, errMsg string, statusCode int) {
-	w.WriteHeader(statusCode)
-	fmt.Fprintf(w, errMsg)
+	objectstore.ListObjects(w, r, store, req.ContainerName, objectstore.ListOptions{
+		Prefix:    req.Prefix,
+		Delimiter: req.Delimiter,
+		PageToken: req.PageToken,
+		MaxKeys:   req.MaxKeys,
+	})
 }
@@ -0,0 +1,332 @@
+package azure_storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"btep.project/Storage/objectstore"
+	"btep.project/apierror"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// copyWait is how long CopyBlobHandler waits for a copy to finish before answering 202
+const copyWait = 30 * time.Second
+
+// ContainerRequest represents the JSON request structure for the container routes
+type ContainerRequest struct {
+	AccountID      int    `json:"accountID"`
+	SubscriptionID string `json:"subscriptionID"`
+	ResourceGroup  string `json:"resourceGroup"`
+	AccountName    string `json:"accountName"`
+	ContainerName  string `json:"containerName"`
+	// PublicAccess of a new container: "" (private), "blob" or "container"
+	PublicAccess string `json:"publicAccess"`
+	Prefix       string `json:"prefix"`
+	PageToken    string `json:"pageToken"`
+	MaxKeys      int    `json:"maxKeys"`
+}
+
+// BlobRequest represents the JSON request structure for the blob routes
+type BlobRequest struct {
+	AccountID      int    `json:"accountID"`
+	SubscriptionID string `json:"subscriptionID"`
+	ResourceGroup  string `json:"resourceGroup"`
+	AccountName    string `json:"accountName"`
+	ContainerName  string `json:"containerName"`
+	BlobName       string `json:"blobName"`
+	// SourceContainer and SourceBlob name the blob copyBlob copies, in the same storage account
+	SourceContainer string `json:"sourceContainer"`
+	SourceBlob      string `json:"sourceBlob"`
+	// AccessTier is Hot, Cool or Archive
+	AccessTier string `json:"accessTier"`
+	// RehydratePriority is Standard or High when a blob leaves the Archive tier
+	RehydratePriority string `json:"rehydratePriority"`
+}
+
+// ContainerDetails describes a container in ListContainersResponse
+type ContainerDetails struct {
+	Name         string    `json:"name"`
+	LastModified time.Time `json:"lastModified"`
+	PublicAccess string    `json:"publicAccess"`
+}
+
+// ListContainersResponse represents the JSON response structure for listContainers
+type ListContainersResponse struct {
+	Containers    []ContainerDetails `json:"containers"`
+	NextPageToken string             `json:"nextPageToken,omitempty"`
+}
+
+// CopyBlobResponse represents the JSON response structure for copyBlob
+type CopyBlobResponse struct {
+	CopyID string `json:"copyID"`
+	// Status is pending, success, aborted or failed
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// CreateContainerHandler creates a container in a storage account
+func CreateContainerHandler(w http.ResponseWriter, r *http.Request) {
+	var req ContainerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.BadRequest(w, "Invalid request body")
+		return
+	}
+	var access azblob.PublicAccessType
+	switch strings.ToLower(req.PublicAccess) {
+	case "", "none", "private":
+		access = azblob.PublicAccessNone
+	case "blob":
+		access = azblob.PublicAccessBlob
+	case "container":
+		access = azblob.PublicAccessContainer
+	default:
+		apierror.BadRequest(w, fmt.Sprintf("publicAccess must be blob, container or empty, not %q", req.PublicAccess))
+		return
+	}
+
+	store, err := openAccountStore(r.Context(), req.SubscriptionID, req.AccountID, req.ResourceGroup, req.AccountName)
+	if err != nil {
+		apierror.Write(w, err, "Failed to connect to the storage account")
+		return
+	}
+	_, err = store.serviceURL.NewContainerURL(req.ContainerName).Create(r.Context(), azblob.Metadata{}, access)
+	if err != nil {
+		apierror.Write(w, err, "Error creating container")
+		return
+	}
+
+	resp := StorageAccountResponse{Message: "Container created successfully"}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ListContainersHandler lists one page of the containers in a storage account
+func ListContainersHandler(w http.ResponseWriter, r *http.Request) {
+	var req ContainerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.BadRequest(w, "Invalid request body")
+		return
+	}
+	if req.MaxKeys < 0 || req.MaxKeys > objectstore.MaxListKeys {
+		apierror.BadRequest(w, fmt.Sprintf("maxKeys must be between 1 and %d", objectstore.MaxListKeys))
+		return
+	}
+
+	store, err := openAccountStore(r.Context(), req.SubscriptionID, req.AccountID, req.ResourceGroup, req.AccountName)
+	if err != nil {
+		apierror.Write(w, err, "Failed to connect to the storage account")
+		return
+	}
+	marker := azblob.Marker{}
+	if req.PageToken != "" {
+		marker.Val = &req.PageToken
+	}
+	list, err := store.serviceURL.ListContainersSegment(r.Context(), marker, azblob.ListContainersSegmentOptions{
+		Prefix:     req.Prefix,
+		MaxResults: int32(req.MaxKeys),
+	})
+	if err != nil {
+		apierror.Write(w, err, "Error listing containers")
+		return
+	}
+
+	resp := ListContainersResponse{Containers: []ContainerDetails{}}
+	for _, container := range list.ContainerItems {
+		access := string(container.Properties.PublicAccess)
+		if access == "" {
+			access = "private"
+		}
+		resp.Containers = append(resp.Containers, ContainerDetails{
+			Name:         container.Name,
+			LastModified: container.Properties.LastModified,
+			PublicAccess: access,
+		})
+	}
+	if list.NextMarker.NotDone() {
+		resp.NextPageToken = *list.NextMarker.Val
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// DeleteContainerHandler deletes a container together with its blobs
+func DeleteContainerHandler(w http.ResponseWriter, r *http.Request) {
+	var req ContainerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.BadRequest(w, "Invalid request body")
+		return
+	}
+
+	store, err := openAccountStore(r.Context(), req.SubscriptionID, req.AccountID, req.ResourceGroup, req.AccountName)
+	if err != nil {
+		apierror.Write(w, err, "Failed to connect to the storage account")
+		return
+	}
+	_, err = store.serviceURL.NewContainerURL(req.ContainerName).Delete(r.Context(), azblob.ContainerAccessConditions{})
+	if err != nil {
+		apierror.Write(w, err, "Error deleting container")
+		return
+	}
+
+	resp := StorageAccountResponse{Message: "Container deleted successfully"}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// DeleteBlobHandler deletes a blob and its snapshots
+func DeleteBlobHandler(w http.ResponseWriter, r *http.Request) {
+	var req BlobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.BadRequest(w, "Invalid request body")
+		return
+	}
+
+	store, err := openAccountStore(r.Context(), req.SubscriptionID, req.AccountID, req.ResourceGroup, req.AccountName)
+	if err != nil {
+		apierror.Write(w, err, "Failed to connect to the storage account")
+		return
+	}
+	if err := store.Delete(r.Context(), req.ContainerName, req.BlobName); err != nil {
+		if err == objectstore.ErrNotFound {
+			apierror.Send(w, apierror.New(http.StatusNotFound, apierror.CodeNotFound, "Error deleting blob: blob not found"))
+			return
+		}
+		apierror.Write(w, err, "Error deleting blob")
+		return
+	}
+
+	resp := StorageAccountResponse{Message: "Blob deleted successfully"}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// CopyBlobHandler copies sourceContainer/sourceBlob to containerName/blobName inside the storage
+// account. The blob service copies in the background; the handler waits up to copyWait and
+// answers 202 with the copy still pending when it takes longer.
+func CopyBlobHandler(w http.ResponseWriter, r *http.Request) {
+	var req BlobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.BadRequest(w, "Invalid request body")
+		return
+	}
+	if req.SourceContainer == "" || req.SourceBlob == "" || req.ContainerName == "" || req.BlobName == "" {
+		apierror.BadRequest(w, "sourceContainer, sourceBlob, containerName and blobName are required")
+		return
+	}
+
+	store, err := openAccountStore(r.Context(), req.SubscriptionID, req.AccountID, req.ResourceGroup, req.AccountName)
+	if err != nil {
+		apierror.Write(w, err, "Failed to connect to the storage account")
+		return
+	}
+	// A source in the same account is authorised by the shared key of the copy request
+	source := store.serviceURL.NewContainerURL(req.SourceContainer).NewBlobURL(req.SourceBlob).URL()
+	target := store.serviceURL.NewContainerURL(req.ContainerName).NewBlobURL(req.BlobName)
+	started, err := target.StartCopyFromURL(r.Context(), source, azblob.Metadata{}, azblob.ModifiedAccessConditions{},
+		azblob.BlobAccessConditions{}, azblob.DefaultAccessTier, nil)
+	if err != nil {
+		apierror.Write(w, err, "Error copying blob")
+		return
+	}
+
+	resp := CopyBlobResponse{CopyID: started.CopyID(), Status: string(started.CopyStatus())}
+	if started.CopyStatus() == azblob.CopyStatusPending {
+		resp, err = waitForCopy(r.Context(), target, resp)
+		if err != nil {
+			apierror.Write(w, err, "Error checking the copy")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch azblob.CopyStatusType(resp.Status) {
+	case azblob.CopyStatusPending:
+		w.WriteHeader(http.StatusAccepted)
+	case azblob.CopyStatusFailed, azblob.CopyStatusAborted:
+		apierror.Send(w, apierror.New(http.StatusBadGateway, apierror.CodeProviderError,
+			fmt.Sprintf("Blob copy %s %s: %s", resp.CopyID, resp.Status, resp.Message)))
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// waitForCopy polls the copy status of target until it leaves pending or copyWait passes
+func waitForCopy(ctx context.Context, target azblob.BlobURL, resp CopyBlobResponse) (CopyBlobResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, copyWait)
+	defer cancel()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return resp, nil
+		case <-ticker.C:
+		}
+		props, err := target.GetProperties(ctx, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+		if err != nil {
+			if ctx.Err() != nil {
+				return resp, nil
+			}
+			return resp, err
+		}
+		resp.Status, resp.Message = string(props.CopyStatus()), props.CopyStatusDescription()
+		if props.CopyStatus() != azblob.CopyStatusPending {
+			return resp, nil
+		}
+	}
+}
+
+// SetAccessTierHandler moves a block blob to the Hot, Cool or Archive tier
+func SetAccessTierHandler(w http.ResponseWriter, r *http.Request) {
+	var req BlobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.BadRequest(w, "Invalid request body")
+		return
+	}
+	tier, ok := accessTier(req.AccessTier)
+	if !ok {
+		apierror.BadRequest(w, fmt.Sprintf("accessTier must be Hot, Cool or Archive, not %q", req.AccessTier))
+		return
+	}
+	priority := azblob.RehydratePriorityNone
+	switch strings.ToLower(req.RehydratePriority) {
+	case "":
+	case "standard":
+		priority = azblob.RehydratePriorityStandard
+	case "high":
+		priority = azblob.RehydratePriorityHigh
+	default:
+		apierror.BadRequest(w, fmt.Sprintf("rehydratePriority must be Standard or High, not %q", req.RehydratePriority))
+		return
+	}
+
+	store, err := openAccountStore(r.Context(), req.SubscriptionID, req.AccountID, req.ResourceGroup, req.AccountName)
+	if err != nil {
+		apierror.Write(w, err, "Failed to connect to the storage account")
+		return
+	}
+	blobURL := store.serviceURL.NewContainerURL(req.ContainerName).NewBlobURL(req.BlobName)
+	if _, err := blobURL.SetTier(r.Context(), tier, azblob.LeaseAccessConditions{}, priority); err != nil {
+		apierror.Write(w, err, "Error setting the access tier")
+		return
+	}
+
+	resp := StorageAccountResponse{Message: fmt.Sprintf("Blob moved to the %s tier", tier)}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// accessTier maps a tier name in any case to the block blob tiers
+func accessTier(name string) (azblob.AccessTierType, bool) {
+	for _, tier := range []azblob.AccessTierType{azblob.AccessTierHot, azblob.AccessTierCool, azblob.AccessTierArchive} {
+		if strings.EqualFold(name, string(tier)) {
+			return tier, true
+		}
+	}
+	return azblob.AccessTierNone, false
+}
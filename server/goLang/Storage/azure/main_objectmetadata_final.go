@@ -0,0 +1,84 @@
+package azure_storage
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"btep.project/Storage/objectstore"
+	"btep.project/apierror"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// UpdateObject changes the blob's properties and metadata in place and then moves it to the
+// new access tier. The headers are set as a whole, so the ones the update leaves out are
+// copied from the blob, including its MD5. Leaving Archive only starts a rehydration, the
+// blob reports its old tier until that is done.
+func (s *ObjectStore) UpdateObject(ctx context.Context, bucket, key string, update objectstore.ObjectUpdate) error {
+	var tier azblob.AccessTierType
+	if update.StorageClass != "" {
+		var ok bool
+		if tier, ok = accessTier(update.StorageClass); !ok {
+			return apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest,
+				fmt.Sprintf("storageClass must be Hot, Cool or Archive, not %q", update.StorageClass))
+		}
+	}
+
+	blobURL := s.serviceURL.NewContainerURL(bucket).NewBlobURL(key)
+	if update.ContentType != nil || update.CacheControl != nil {
+		props, err := blobURL.GetProperties(ctx, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+		if err != nil {
+			return translateError(err)
+		}
+		headers := azblob.BlobHTTPHeaders{
+			ContentType:        props.ContentType(),
+			ContentMD5:         props.ContentMD5(),
+			ContentEncoding:    props.ContentEncoding(),
+			ContentLanguage:    props.ContentLanguage(),
+			ContentDisposition: props.ContentDisposition(),
+			CacheControl:       props.CacheControl(),
+		}
+		if update.ContentType != nil {
+			headers.ContentType = *update.ContentType
+		}
+		if update.CacheControl != nil {
+			headers.CacheControl = *update.CacheControl
+		}
+		conditions := azblob.BlobAccessConditions{ModifiedAccessConditions: azblob.ModifiedAccessConditions{IfMatch: props.ETag()}}
+		if _, err := blobURL.SetHTTPHeaders(ctx, headers, conditions); err != nil {
+			return translateError(err)
+		}
+	}
+	if update.Metadata != nil {
+		if _, err := blobURL.SetMetadata(ctx, azblob.Metadata(update.Metadata), azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{}); err != nil {
+			return translateError(err)
+		}
+	}
+	if tier != "" {
+		if _, err := blobURL.SetTier(ctx, tier, azblob.LeaseAccessConditions{}, azblob.RehydratePriorityStandard); err != nil {
+			return translateError(err)
+		}
+	}
+	return nil
+}
+
+// GetTags returns the blob index tags, which unlike metadata can be searched across a storage account
+func (s *ObjectStore) GetTags(ctx context.Context, bucket, key string) (map[string]string, error) {
+	blobURL := s.serviceURL.NewContainerURL(bucket).NewBlobURL(key)
+	resp, err := blobURL.GetTags(ctx, nil)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	tags := make(map[string]string, len(resp.BlobTagSet))
+	for _, tag := range resp.BlobTagSet {
+		tags[tag.Key] = tag.Value
+	}
+	return tags, nil
+}
+
+func (s *ObjectStore) SetTags(ctx context.Context, bucket, key string, tags map[string]string) error {
+	blobURL := s.serviceURL.NewContainerURL(bucket).NewBlobURL(key)
+	_, err := blobURL.SetTags(ctx, nil, nil, nil, azblob.BlobTagsMap(tags))
+	return translateError(err)
+}
@@ -0,0 +1,309 @@
+package azure_storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"btep.project/Storage/objectstore"
+	db "btep.project/databaseConnection"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/storage/mgmt/storage"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// ObjectStore implements objectstore.ObjectStore on top of Azure Blob storage.
+// Buckets map to containers inside a single storage account.
+type ObjectStore struct {
+	serviceURL azblob.ServiceURL
+	credential *azblob.SharedKeyCredential
+}
+
+// NewObjectStore opens an Azure Blob backed objectstore.ObjectStore for the cloud account
+func NewObjectStore(ctx context.Context, cloudAccount *db.CloudAccount, opts objectstore.Options) (objectstore.ObjectStore, error) {
+	if opts.AccountName == "" || opts.ResourceGroup == "" {
+		return nil, fmt.Errorf("accountName and resourceGroup are required for Azure storage")
+	}
+	return openAccountStore(ctx, cloudAccount.SubscriptionID.String, cloudAccount.AccountID, opts.ResourceGroup, opts.AccountName)
+}
+
+// keyCacheTTL is how long a storage account key is reused before it is listed again,
+// so a rotated key is picked up without a restart
+const keyCacheTTL = 15 * time.Minute
+
+type storeKey struct {
+	accountID      int
+	subscriptionID string
+	resourceGroup  string
+	accountName    string
+}
+
+type cachedStore struct {
+	store   *ObjectStore
+	expires time.Time
+}
+
+var (
+	storesMu sync.Mutex
+	stores   = make(map[storeKey]cachedStore)
+)
+
+// openAccountStore returns the blob service of a storage account. Listing the account keys
+// is a management API call, so the store is cached for keyCacheTTL.
+func openAccountStore(ctx context.Context, subscriptionID string, accountID int, resourceGroup, accountName string) (*ObjectStore, error) {
+	key := storeKey{accountID: accountID, subscriptionID: subscriptionID, resourceGroup: resourceGroup, accountName: accountName}
+	storesMu.Lock()
+	cached, ok := stores[key]
+	storesMu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.store, nil
+	}
+
+	client, err := initStorageClient(subscriptionID, accountID)
+	if err != nil {
+		return nil, err
+	}
+	store, err := newAccountStore(ctx, client, resourceGroup, accountName)
+	if err != nil {
+		return nil, err
+	}
+	storesMu.Lock()
+	stores[key] = cachedStore{store: store, expires: time.Now().Add(keyCacheTTL)}
+	storesMu.Unlock()
+	return store, nil
+}
+
+// forgetAccountStore drops the cached stores of a storage account, e.g. after it was deleted
+func forgetAccountStore(resourceGroup, accountName string) {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	for key := range stores {
+		if key.resourceGroup == resourceGroup && key.accountName == accountName {
+			delete(stores, key)
+		}
+	}
+}
+
+// newAccountStore opens the blob service of a storage account, authorised with its shared key
+func newAccountStore(ctx context.Context, client storage.AccountsClient, resourceGroup, accountName string) (*ObjectStore, error) {
+	keys, err := client.ListKeys(ctx, resourceGroup, accountName, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage account keys: %v", err)
+	}
+	if keys.Keys == nil || len(*keys.Keys) == 0 {
+		return nil, fmt.Errorf("storage account %s has no access keys", accountName)
+	}
+
+	credential, err := azblob.NewSharedKeyCredential(accountName, *(*keys.Keys)[0].Value)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse("https://" + accountName + ".blob.core.windows.net")
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectStore{
+		serviceURL: azblob.NewServiceURL(*u, azblob.NewPipeline(credential, azblob.PipelineOptions{})),
+		credential: credential,
+	}, nil
+}
+
+func (s *ObjectStore) CreateBucket(ctx context.Context, bucket string) error {
+	_, err := s.serviceURL.NewContainerURL(bucket).Create(ctx, azblob.Metadata{}, azblob.PublicAccessNone)
+	return err
+}
+
+func (s *ObjectStore) Put(ctx context.Context, bucket, key string, body io.Reader, opts objectstore.PutOptions) error {
+	blobURL := s.serviceURL.NewContainerURL(bucket).NewBlockBlobURL(key)
+	_, err := azblob.UploadStreamToBlockBlob(ctx, body, blobURL, azblob.UploadStreamToBlockBlobOptions{
+		// Uploads in blocks get no MD5 from the service, the given one is stored as the blob's
+		BlobHTTPHeaders: azblob.BlobHTTPHeaders{ContentType: opts.ContentType, ContentMD5: opts.MD5},
+	})
+	return err
+}
+
+func (s *ObjectStore) Get(ctx context.Context, bucket, key string, opts objectstore.GetOptions) (io.ReadCloser, *objectstore.ObjectInfo, error) {
+	blobURL := s.serviceURL.NewContainerURL(bucket).NewBlobURL(key)
+	conditions := azblob.BlobAccessConditions{}
+	if opts.IfNoneMatch != "" {
+		conditions.ModifiedAccessConditions.IfNoneMatch = azblob.ETag(opts.IfNoneMatch)
+	}
+
+	offset, count := int64(0), int64(azblob.CountToEnd)
+	if opts.Range != nil {
+		byteRange := *opts.Range
+		if byteRange.Offset < 0 {
+			// The blob service has no suffix ranges, so the size is needed to locate the last bytes
+			props, err := blobURL.GetProperties(ctx, conditions, azblob.ClientProvidedKeyOptions{})
+			if err != nil {
+				return nil, nil, translateError(err)
+			}
+			resolved, ok := byteRange.Resolve(props.ContentLength())
+			if !ok {
+				return nil, nil, objectstore.RangeNotSatisfiable(props.ContentLength())
+			}
+			byteRange = resolved
+			// Download the version that was measured
+			conditions.ModifiedAccessConditions.IfMatch = props.ETag()
+		}
+		offset = byteRange.Offset
+		if byteRange.Length > 0 {
+			count = byteRange.Length
+		}
+	}
+
+	resp, err := blobURL.Download(ctx, offset, count, conditions, false, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		return nil, nil, translateError(err)
+	}
+	info := &objectstore.ObjectInfo{
+		Key:          key,
+		Size:         resp.ContentLength(),
+		ETag:         string(resp.ETag()),
+		ContentType:  resp.ContentType(),
+		LastModified: resp.LastModified(),
+		MD5:          resp.ContentMD5(),
+	}
+	if contentRange, size, ok := objectstore.ParseContentRange(resp.ContentRange()); ok {
+		// Content-MD5 only covers whole blob downloads, the blob's digest has its own header
+		info.ContentRange, info.Size, info.MD5 = contentRange, size, resp.BlobContentMD5()
+	}
+	return resp.Body(azblob.RetryReaderOptions{MaxRetryRequests: 3}), info, nil
+}
+
+func (s *ObjectStore) Delete(ctx context.Context, bucket, key string) error {
+	blobURL := s.serviceURL.NewContainerURL(bucket).NewBlobURL(key)
+	_, err := blobURL.Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
+	return translateError(err)
+}
+
+func (s *ObjectStore) List(ctx context.Context, bucket string, opts objectstore.ListOptions) (*objectstore.ListPage, error) {
+	containerURL := s.serviceURL.NewContainerURL(bucket)
+	marker := azblob.Marker{}
+	if opts.PageToken != "" {
+		marker.Val = &opts.PageToken
+	}
+	options := azblob.ListBlobsSegmentOptions{Prefix: opts.Prefix, MaxResults: int32(opts.MaxKeys)}
+
+	page := &objectstore.ListPage{Objects: []objectstore.ObjectInfo{}, CommonPrefixes: []string{}}
+	var next azblob.Marker
+	if opts.Delimiter != "" {
+		resp, err := containerURL.ListBlobsHierarchySegment(ctx, marker, opts.Delimiter, options)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		for _, blob := range resp.Segment.BlobItems {
+			page.Objects = append(page.Objects, blobInfo(blob))
+		}
+		for _, prefix := range resp.Segment.BlobPrefixes {
+			page.CommonPrefixes = append(page.CommonPrefixes, prefix.Name)
+		}
+		next = resp.NextMarker
+	} else {
+		resp, err := containerURL.ListBlobsFlatSegment(ctx, marker, options)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		for _, blob := range resp.Segment.BlobItems {
+			page.Objects = append(page.Objects, blobInfo(blob))
+		}
+		next = resp.NextMarker
+	}
+	if next.NotDone() {
+		page.NextPageToken = *next.Val
+	}
+	return page, nil
+}
+
+func (s *ObjectStore) Stat(ctx context.Context, bucket, key string) (*objectstore.ObjectInfo, error) {
+	blobURL := s.serviceURL.NewContainerURL(bucket).NewBlobURL(key)
+	props, err := blobURL.GetProperties(ctx, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &objectstore.ObjectInfo{
+		Key:          key,
+		Size:         props.ContentLength(),
+		ETag:         string(props.ETag()),
+		ContentType:  props.ContentType(),
+		LastModified: props.LastModified(),
+		MD5:          props.ContentMD5(),
+		StorageClass: props.AccessTier(),
+		CacheControl: props.CacheControl(),
+		Metadata:     props.NewMetadata(),
+	}, nil
+}
+
+// SignURL creates a blob SAS signed with the storage account key. Azure does not put the
+// Content-Type of an upload under the signature, the header is returned for the client to send.
+func (s *ObjectStore) SignURL(ctx context.Context, bucket, key string, opts objectstore.SignOptions) (*objectstore.SignedURL, error) {
+	expiresAt := time.Now().Add(opts.Expiry).UTC()
+	values := azblob.BlobSASSignatureValues{
+		Protocol:      azblob.SASProtocolHTTPS,
+		StartTime:     time.Now().Add(-5 * time.Minute).UTC(), // tolerate clock skew
+		ExpiryTime:    expiresAt,
+		ContainerName: bucket,
+		BlobName:      key,
+	}
+	signed := &objectstore.SignedURL{Method: opts.Method, ExpiresAt: expiresAt}
+	if opts.Method == http.MethodPut {
+		values.Permissions = azblob.BlobSASPermissions{Create: true, Write: true}.String()
+		// A PUT without x-ms-blob-type is rejected by the blob service
+		signed.Headers = map[string]string{"x-ms-blob-type": string(azblob.BlobBlockBlob)}
+		if opts.ContentType != "" {
+			signed.Headers["Content-Type"] = opts.ContentType
+		}
+	} else {
+		values.Permissions = azblob.BlobSASPermissions{Read: true}.String()
+		values.ContentType = opts.ContentType
+	}
+
+	sas, err := values.NewSASQueryParameters(s.credential)
+	if err != nil {
+		return nil, err
+	}
+	u := s.serviceURL.NewContainerURL(bucket).NewBlobURL(key).URL()
+	u.RawQuery = sas.Encode()
+	signed.URL = u.String()
+	return signed, nil
+}
+
+func (s *ObjectStore) Close() error {
+	return nil
+}
+
+func blobInfo(blob azblob.BlobItemInternal) objectstore.ObjectInfo {
+	info := objectstore.ObjectInfo{
+		Key:          blob.Name,
+		ETag:         string(blob.Properties.Etag),
+		LastModified: blob.Properties.LastModified,
+		MD5:          blob.Properties.ContentMD5,
+		StorageClass: string(blob.Properties.AccessTier),
+	}
+	if blob.Properties.ContentLength != nil {
+		info.Size = *blob.Properties.ContentLength
+	}
+	if blob.Properties.ContentType != nil {
+		info.ContentType = *blob.Properties.ContentType
+	}
+	return info
+}
+
+// translateError maps blob service "not found" codes onto objectstore.ErrNotFound and the
+// 304 of a conditional download onto objectstore.ErrNotModified
+func translateError(err error) error {
+	if serr, ok := err.(azblob.StorageError); ok {
+		switch serr.ServiceCode() {
+		case azblob.ServiceCodeBlobNotFound, azblob.ServiceCodeContainerNotFound:
+			return objectstore.ErrNotFound
+		}
+		if resp := serr.Response(); resp != nil && resp.StatusCode == http.StatusNotModified {
+			return objectstore.ErrNotModified
+		}
+	}
+	return err
+}
@@ -0,0 +1,130 @@
+package gcp_gcs
+
+import (
+	"context"
+	"time"
+
+	"btep.project/Storage/objectstore"
+
+	"cloud.google.com/go/storage"
+)
+
+// GetBucketConfig reads the versioning, lifecycle, CORS, encryption and public access prevention of bucket.
+// Each GCS lifecycle rule has a single action, so it becomes a rule that either expires or transitions.
+func (s *ObjectStore) GetBucketConfig(ctx context.Context, bucket string) (*objectstore.BucketConfig, error) {
+	attrs, err := s.client.Bucket(bucket).Attrs(ctx)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	config := &objectstore.BucketConfig{
+		Versioning:          &attrs.VersioningEnabled,
+		Lifecycle:           []objectstore.LifecycleRule{},
+		CORS:                []objectstore.CORSRule{},
+		Encryption:          &objectstore.Encryption{Algorithm: objectstore.EncryptionProviderManaged},
+		PublicAccessBlocked: new(bool),
+	}
+	*config.PublicAccessBlocked = attrs.PublicAccessPrevention == storage.PublicAccessPreventionEnforced
+
+	for _, rule := range attrs.Lifecycle.Rules {
+		converted := objectstore.LifecycleRule{Enabled: true}
+		if len(rule.Condition.MatchesPrefix) > 0 {
+			converted.Prefix = rule.Condition.MatchesPrefix[0]
+		}
+		switch rule.Action.Type {
+		case storage.DeleteAction:
+			converted.ExpireAfterDays = int(rule.Condition.AgeInDays)
+		case storage.SetStorageClassAction:
+			converted.TransitionAfterDays = int(rule.Condition.AgeInDays)
+			converted.StorageClass = rule.Action.StorageClass
+		default:
+			// Rules like AbortIncompleteMultipartUpload have no neutral form
+			continue
+		}
+		config.Lifecycle = append(config.Lifecycle, converted)
+	}
+
+	for _, rule := range attrs.CORS {
+		config.CORS = append(config.CORS, objectstore.CORSRule{
+			AllowedOrigins: rule.Origins,
+			AllowedMethods: rule.Methods,
+			ExposedHeaders: rule.ResponseHeaders,
+			MaxAgeSeconds:  int(rule.MaxAge.Seconds()),
+		})
+	}
+
+	if attrs.Encryption != nil && attrs.Encryption.DefaultKMSKeyName != "" {
+		config.Encryption = &objectstore.Encryption{Algorithm: objectstore.EncryptionKMS, KMSKeyID: attrs.Encryption.DefaultKMSKeyName}
+	}
+	return config, nil
+}
+
+// SetBucketConfig applies the settings of config that are not nil in a single bucket update.
+// Disabled lifecycle rules are left out, GCS has no way to keep a rule without applying it.
+func (s *ObjectStore) SetBucketConfig(ctx context.Context, bucket string, config objectstore.BucketConfig) error {
+	var update storage.BucketAttrsToUpdate
+	if config.Versioning != nil {
+		update.VersioningEnabled = *config.Versioning
+	}
+
+	if config.Lifecycle != nil {
+		lifecycle := &storage.Lifecycle{}
+		for _, rule := range config.Lifecycle {
+			if !rule.Enabled {
+				continue
+			}
+			condition := storage.LifecycleCondition{}
+			if rule.Prefix != "" {
+				condition.MatchesPrefix = []string{rule.Prefix}
+			}
+			if rule.ExpireAfterDays > 0 {
+				expire := condition
+				expire.AgeInDays = int64(rule.ExpireAfterDays)
+				lifecycle.Rules = append(lifecycle.Rules, storage.LifecycleRule{
+					Action:    storage.LifecycleAction{Type: storage.DeleteAction},
+					Condition: expire,
+				})
+			}
+			if rule.TransitionAfterDays > 0 {
+				transition := condition
+				transition.AgeInDays = int64(rule.TransitionAfterDays)
+				lifecycle.Rules = append(lifecycle.Rules, storage.LifecycleRule{
+					Action:    storage.LifecycleAction{Type: storage.SetStorageClassAction, StorageClass: rule.StorageClass},
+					Condition: transition,
+				})
+			}
+		}
+		update.Lifecycle = lifecycle
+	}
+
+	if config.CORS != nil {
+		// An empty, not nil, slice removes the CORS configuration
+		update.CORS = []storage.CORS{}
+		for _, rule := range config.CORS {
+			update.CORS = append(update.CORS, storage.CORS{
+				Origins:         rule.AllowedOrigins,
+				Methods:         rule.AllowedMethods,
+				ResponseHeaders: rule.ExposedHeaders,
+				MaxAge:          time.Duration(rule.MaxAgeSeconds) * time.Second,
+			})
+		}
+	}
+
+	if config.Encryption != nil {
+		// An empty key name returns the bucket to Google-managed keys
+		update.Encryption = &storage.BucketEncryption{}
+		if config.Encryption.Algorithm == objectstore.EncryptionKMS {
+			update.Encryption.DefaultKMSKeyName = config.Encryption.KMSKeyID
+		}
+	}
+
+	if config.PublicAccessBlocked != nil {
+		update.PublicAccessPrevention = storage.PublicAccessPreventionInherited
+		if *config.PublicAccessBlocked {
+			update.PublicAccessPrevention = storage.PublicAccessPreventionEnforced
+		}
+	}
+
+	_, err := s.client.Bucket(bucket).Update(ctx, update)
+	return translateError(err)
+}
@@ -5,13 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"time"
 
+	"btep.project/Storage/objectstore"
+	"btep.project/apierror"
+	"btep.project/auth/gcpauth"
 	db "btep.project/databaseConnection"
-	"golang.org/x/oauth2"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 
@@ -21,33 +22,47 @@ import (
 type BucketRequest struct {
 	BucketName string `json:"bucketName"`
 	AccountID  int    `json:"accountID"`
-	Token      string `json:"token"`
+	// Location of a new bucket, e.g. "EU" or "us-central1", GCS defaults to "US"
+	Location string `json:"location"`
 }
 type BucketResponse struct {
 	Message string `json:"message"`
 }
 
+// newClient creates a GCS client with the account's service account or OAuth login
+func newClient(ctx context.Context, accountID int) (*storage.Client, error) {
+	tokenSource, err := gcpauth.TokenSource(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewClient(ctx, option.WithTokenSource(tokenSource))
+}
+
 func CreateBucketHandler(w http.ResponseWriter, r *http.Request) {
 	var req BucketRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
+		return
+	}
+	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
+	if err != nil {
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
-	token := req.Token
 	ctx := context.Background()
-	creds := option.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))
-	client, err := storage.NewClient(ctx, creds)
+	client, err := newClient(ctx, req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating GCS client: %v", err)
+		apierror.Write(w, err, "Error creating GCS client")
 		return
 	}
 	defer client.Close()
-	if err := client.Bucket(req.BucketName).Create(ctx, "divine-treat-413716", nil); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating GCS bucket: %v", err)
+	var attrs *storage.BucketAttrs
+	if req.Location != "" {
+		attrs = &storage.BucketAttrs{Location: req.Location}
+	}
+	if err := client.Bucket(req.BucketName).Create(ctx, cloudAccount.ProjectID.String, attrs); err != nil {
+		apierror.Write(w, err, "Error creating GCS bucket")
 		return
 	}
 	resp := BucketResponse{Message: "GCS bucket created successfully"}
@@ -60,7 +75,6 @@ type ObjectRequest struct {
 	ObjectName string                `json:"objectName"`
 	File       *multipart.FileHeader `json:"file"`
 	AccountID  int                   `json:"accountID"`
-	Token      string                `json:"token"`
 }
 
 // ObjectResponse represents the JSON response structure for object operations
@@ -74,8 +88,7 @@ func UploadObjectHandler(w http.ResponseWriter, r *http.Request) {
 	// Parse the multipart form
 	err := r.ParseMultipartForm(10 << 20) // Max size 10MB, adjust as needed
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Error parsing form: %v", err)
+		apierror.BadRequest(w, fmt.Sprintf("Error parsing form: %v", err))
 		return
 	}
 
@@ -83,30 +96,22 @@ func UploadObjectHandler(w http.ResponseWriter, r *http.Request) {
 	var req ObjectRequest
 	err = json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
-	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
+	_, err = db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
-	fmt.Println(cloudAccount)
 
-	// Extract the token from the request
-	token := req.Token
-
-	// Create a GCS client with token authentication
+	// Create a GCS client authenticated as the account
 	ctx := context.Background()
-	creds := option.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))
-	client, err := storage.NewClient(ctx, creds)
+	client, err := newClient(ctx, req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating GCS client: %v", err)
+		apierror.Write(w, err, "Error creating GCS client")
 		return
 	}
 	defer client.Close()
@@ -114,21 +119,18 @@ func UploadObjectHandler(w http.ResponseWriter, r *http.Request) {
 	// Upload the object to the GCS bucket
 	file, _, err := r.FormFile("file")
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Error retrieving file from form: %v", err)
+		apierror.BadRequest(w, fmt.Sprintf("Error retrieving file from form: %v", err))
 		return
 	}
 	defer file.Close()
 
 	wc := client.Bucket(req.BucketName).Object(req.ObjectName).NewWriter(ctx)
 	if _, err := io.Copy(wc, file); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error uploading object to GCS: %v", err)
+		apierror.Write(w, err, "Error uploading object to GCS")
 		return
 	}
 	if err := wc.Close(); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error closing object writer: %v", err)
+		apierror.Write(w, err, "Error closing object writer")
 		return
 	}
 
@@ -143,7 +145,6 @@ type GetObjectRequest struct {
 	BucketName string `json:"bucketName"`
 	ObjectName string `json:"objectName"`
 	AccountID  int    `json:"accountID"`
-	Token      string `json:"token"`
 }
 
 // GetObjectHandler handles GET requests to retrieve an object from a GCS bucket
@@ -152,62 +153,33 @@ func GetObjectHandler(w http.ResponseWriter, r *http.Request) {
 	var req GetObjectRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
-		return
-	}
-	fmt.Println(cloudAccount)
-
-	// Extract the token from the request
-	token := req.Token
-
-	// Create a GCS client with token authentication
-	ctx := context.Background()
-	creds := option.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))
-	client, err := storage.NewClient(ctx, creds)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating GCS client: %v", err)
-		return
-	}
-	defer client.Close()
-
-	// Download the object from the GCS bucket
-	rc, err := client.Bucket(req.BucketName).Object(req.ObjectName).NewReader(ctx)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error downloading object from GCS: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
-	defer rc.Close()
 
-	// Read the object content
-	objectContent, err := ioutil.ReadAll(rc)
+	// Create a GCS client authenticated as the account
+	store, err := NewObjectStore(r.Context(), cloudAccount, objectstore.Options{AccountID: req.AccountID})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error reading object content: %v", err)
+		apierror.Write(w, err, "Error creating GCS client")
 		return
 	}
+	defer store.Close()
 
-	// Send success response with the object content
-	w.Header().Set("Content-Disposition", "attachment; filename="+req.ObjectName)
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Write(objectContent)
+	// Stream the object, honouring Range and If-None-Match
+	objectstore.Download(w, r, store, req.BucketName, req.ObjectName)
 }
 
 type DeleteObjectRequest struct {
 	BucketName string `json:"bucketName"`
 	ObjectName string `json:"objectName"`
 	AccountID  int    `json:"accountID"`
-	Token      string `json:"token"`
 }
 
 // DeleteObjectHandler handles DELETE requests to delete an object from a GCS bucket
@@ -216,30 +188,22 @@ func DeleteObjectHandler(w http.ResponseWriter, r *http.Request) {
 	var req DeleteObjectRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
-	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
+	_, err = db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
-	fmt.Println(cloudAccount)
 
-	// Extract the token from the request
-	token := req.Token
-
-	// Create a GCS client with token authentication
+	// Create a GCS client authenticated as the account
 	ctx := context.Background()
-	creds := option.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))
-	client, err := storage.NewClient(ctx, creds)
+	client, err := newClient(ctx, req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating GCS client: %v", err)
+		apierror.Write(w, err, "Error creating GCS client")
 		return
 	}
 	defer client.Close()
@@ -247,8 +211,7 @@ func DeleteObjectHandler(w http.ResponseWriter, r *http.Request) {
 	// Delete the object from the GCS bucket
 	err = client.Bucket(req.BucketName).Object(req.ObjectName).Delete(ctx)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error deleting object from GCS: %v", err)
+		apierror.Write(w, err, "Error deleting object from GCS")
 		return
 	}
 
@@ -263,30 +226,29 @@ func DeleteBucketHandler(w http.ResponseWriter, r *http.Request) {
 	var req BucketRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
-	token := req.Token
-
-	// Create a GCS client with token authentication
+	// Create a GCS client authenticated as the account
 	ctx := context.Background()
-	creds := option.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))
-	client, err := storage.NewClient(ctx, creds)
+	client, err := newClient(ctx, req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating GCS client: %v", err)
+		apierror.Write(w, err, "Error creating GCS client")
 		return
 	}
 	defer client.Close()
 
-	// Delete the GCS bucket
-	client.Bucket(req.BucketName).Delete(ctx)
+	// GCS refuses to delete a bucket that still holds objects or noncurrent versions
+	bucket := client.Bucket(req.BucketName)
+	if err := emptyBucket(ctx, bucket); err != nil {
+		apierror.Write(w, err, "Error emptying GCS bucket")
+		return
+	}
 
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error deleting GCS bucket: %v", err)
+	// Delete the GCS bucket
+	if err := bucket.Delete(ctx); err != nil {
+		apierror.Write(w, err, "Error deleting GCS bucket")
 		return
 	}
 
@@ -296,9 +258,69 @@ func DeleteBucketHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+// emptyBucket deletes every object of bucket, including the noncurrent generations of versioned buckets
+func emptyBucket(ctx context.Context, bucket *storage.BucketHandle) error {
+	it := bucket.Objects(ctx, &storage.Query{Versions: true})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = bucket.Object(attrs.Name).Generation(attrs.Generation).Delete(ctx)
+		if err != nil && err != storage.ErrObjectNotExist {
+			return fmt.Errorf("error deleting %s: %w", attrs.Name, err)
+		}
+	}
+}
+
+// ListObjectsRequest represents the JSON request structure for listObjects
+type ListObjectsRequest struct {
+	BucketName string `json:"bucketName"`
+	AccountID  int    `json:"accountID"`
+	Prefix     string `json:"prefix"`
+	Delimiter  string `json:"delimiter"`
+	PageToken  string `json:"pageToken"`
+	MaxKeys    int    `json:"maxKeys"`
+}
+
+// ListObjectsHandler handles POST requests to list one page of the objects in a GCS bucket.
+// A delimiter of "/" lists the bucket folder by folder, the folders come back as commonPrefixes.
+func ListObjectsHandler(w http.ResponseWriter, r *http.Request) {
+	var req ListObjectsRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		apierror.BadRequest(w, "Invalid request body")
+		return
+	}
+
+	// Fetch cloud account details from the database
+	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
+	if err != nil {
+		apierror.Write(w, err, "Error getting cloud account details")
+		return
+	}
+
+	// Create a GCS client authenticated as the account
+	store, err := NewObjectStore(r.Context(), cloudAccount, objectstore.Options{AccountID: req.AccountID})
+	if err != nil {
+		apierror.Write(w, err, "Error creating GCS client")
+		return
+	}
+	defer store.Close()
+
+	objectstore.ListObjects(w, r, store, req.BucketName, objectstore.ListOptions{
+		Prefix:    req.Prefix,
+		Delimiter: req.Delimiter,
+		PageToken: req.PageToken,
+		MaxKeys:   req.MaxKeys,
+	})
+}
+
 type ListBucketRequest struct {
-	Token     string `json:"token"`
-	AccountID int    `json:"accountID"`
+	AccountID int `json:"accountID"`
 }
 
 func ListBucketsHandler(w http.ResponseWriter, r *http.Request) {
@@ -306,22 +328,17 @@ func ListBucketsHandler(w http.ResponseWriter, r *http.Request) {
 	var req ListBucketRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
-	// Extract the token from the request
-	token := req.Token
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 
-	// Create a GCS client with token authentication
+	// Create a GCS client authenticated as the account
 	ctx := context.Background()
-	creds := option.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))
-	client, err := storage.NewClient(ctx, creds)
+	client, err := newClient(ctx, req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating GCS client: %v", err)
+		apierror.Write(w, err, "Error creating GCS client")
 		return
 	}
 	defer client.Close()
@@ -329,8 +346,7 @@ func ListBucketsHandler(w http.ResponseWriter, r *http.Request) {
 	// List all GCS buckets
 	buckets := client.Buckets(ctx, cloudAccount.ProjectID.String)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error listing GCS buckets: %v", err)
+		apierror.Write(w, err, "Error listing GCS buckets")
 		return
 	}
 
@@ -348,8 +364,7 @@ func ListBucketsHandler(w http.ResponseWriter, r *http.Request) {
 			break
 		}
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "Error listing GCS buckets: %v", err)
+			apierror.Write(w, err, "Error listing GCS buckets")
 			return
 		}
 
@@ -364,8 +379,7 @@ func ListBucketsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	// Encode bucketDetails slice as JSON and write it to the response
 	if err := json.NewEncoder(w).Encode(bucketDetails); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error encoding response: %v", err)
+		apierror.Write(w, err, "Error encoding response")
 		return
 	}
 }
@@ -0,0 +1,87 @@
+package gcp_gcs
+
+import (
+	"context"
+
+	"btep.project/Storage/objectstore"
+
+	"cloud.google.com/go/storage"
+)
+
+// UpdateObject patches the metadata in place. A new storage class needs a rewrite of the
+// object, which keeps its content type and metadata but gives it a new generation and the
+// bucket's default object ACL.
+func (s *ObjectStore) UpdateObject(ctx context.Context, bucket, key string, update objectstore.ObjectUpdate) error {
+	object := s.client.Bucket(bucket).Object(key)
+	attrs, err := object.Attrs(ctx)
+	if err != nil {
+		return translateError(err)
+	}
+	if update.StorageClass != "" && update.StorageClass != attrs.StorageClass {
+		return s.rewrite(ctx, object, attrs, update)
+	}
+
+	// A patch merges metadata keys, the keys the update drops have to be cleared first
+	object = object.If(storage.Conditions{MetagenerationMatch: attrs.Metageneration})
+	if update.Metadata != nil && removesKeys(attrs.Metadata, update.Metadata) {
+		cleared, err := object.Update(ctx, storage.ObjectAttrsToUpdate{Metadata: map[string]string{}})
+		if err != nil {
+			return translateError(err)
+		}
+		object = s.client.Bucket(bucket).Object(key).If(storage.Conditions{MetagenerationMatch: cleared.Metageneration})
+	}
+
+	var attrsToUpdate storage.ObjectAttrsToUpdate
+	if update.ContentType != nil {
+		attrsToUpdate.ContentType = *update.ContentType
+	}
+	if update.CacheControl != nil {
+		attrsToUpdate.CacheControl = *update.CacheControl
+	}
+	if len(update.Metadata) > 0 {
+		attrsToUpdate.Metadata = update.Metadata
+	}
+	if update.ContentType == nil && update.CacheControl == nil && len(update.Metadata) == 0 {
+		return nil
+	}
+	_, err = object.Update(ctx, attrsToUpdate)
+	return translateError(err)
+}
+
+// rewrite copies the object onto itself in the new storage class. The generation
+// precondition keeps a concurrent upload from being overwritten with the old content.
+func (s *ObjectStore) rewrite(ctx context.Context, object *storage.ObjectHandle, attrs *storage.ObjectAttrs, update objectstore.ObjectUpdate) error {
+	copier := object.If(storage.Conditions{GenerationMatch: attrs.Generation}).CopierFrom(object)
+	// The attributes of the copy replace those of the source, so all of them are sent again
+	copier.ObjectAttrs = storage.ObjectAttrs{
+		ContentType:        attrs.ContentType,
+		ContentEncoding:    attrs.ContentEncoding,
+		ContentLanguage:    attrs.ContentLanguage,
+		ContentDisposition: attrs.ContentDisposition,
+		CacheControl:       attrs.CacheControl,
+		Metadata:           attrs.Metadata,
+		CustomTime:         attrs.CustomTime,
+		StorageClass:       update.StorageClass,
+	}
+	if update.ContentType != nil {
+		copier.ContentType = *update.ContentType
+	}
+	if update.CacheControl != nil {
+		copier.CacheControl = *update.CacheControl
+	}
+	if update.Metadata != nil {
+		copier.Metadata = update.Metadata
+	}
+	_, err := copier.Run(ctx)
+	return translateError(err)
+}
+
+// removesKeys reports whether replacing current with next drops a key
+func removesKeys(current, next map[string]string) bool {
+	for k := range current {
+		if _, ok := next[k]; !ok {
+			return true
+		}
+	}
+	return false
+}
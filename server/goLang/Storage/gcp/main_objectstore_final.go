@@ -0,0 +1,181 @@
+package gcp_gcs
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"btep.project/Storage/objectstore"
+	"btep.project/apierror"
+	"btep.project/auth/gcpauth"
+	db "btep.project/databaseConnection"
+	"google.golang.org/api/iterator"
+
+	"cloud.google.com/go/storage"
+)
+
+// ObjectStore implements objectstore.ObjectStore on top of Google Cloud Storage
+type ObjectStore struct {
+	client  *storage.Client
+	account *db.CloudAccount
+	// location of new buckets, e.g. "EU" or "us-central1", GCS defaults to "US"
+	location string
+}
+
+// NewObjectStore opens a GCS backed objectstore.ObjectStore for the cloud account
+func NewObjectStore(ctx context.Context, cloudAccount *db.CloudAccount, opts objectstore.Options) (objectstore.ObjectStore, error) {
+	client, err := newClient(ctx, cloudAccount.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectStore{client: client, account: cloudAccount, location: opts.Region}, nil
+}
+
+func (s *ObjectStore) CreateBucket(ctx context.Context, bucket string) error {
+	var attrs *storage.BucketAttrs
+	if s.location != "" {
+		attrs = &storage.BucketAttrs{Location: s.location}
+	}
+	return s.client.Bucket(bucket).Create(ctx, s.account.ProjectID.String, attrs)
+}
+
+func (s *ObjectStore) Put(ctx context.Context, bucket, key string, body io.Reader, opts objectstore.PutOptions) error {
+	wc := s.client.Bucket(bucket).Object(key).NewWriter(ctx)
+	wc.ContentType = opts.ContentType
+	// GCS rejects the upload when the data does not match the MD5
+	wc.MD5 = opts.MD5
+	if _, err := io.Copy(wc, body); err != nil {
+		wc.Close()
+		return err
+	}
+	return wc.Close()
+}
+
+func (s *ObjectStore) Get(ctx context.Context, bucket, key string, opts objectstore.GetOptions) (io.ReadCloser, *objectstore.ObjectInfo, error) {
+	object := s.client.Bucket(bucket).Object(key)
+	attrs, err := object.Attrs(ctx)
+	if err != nil {
+		return nil, nil, translateError(err)
+	}
+	info := objectInfo(attrs)
+	if objectstore.MatchesETag(opts.IfNoneMatch, attrs.Etag) {
+		return nil, nil, objectstore.ErrNotModified
+	}
+
+	offset, length := int64(0), int64(-1)
+	if opts.Range != nil {
+		contentRange, ok := opts.Range.Resolve(attrs.Size)
+		if !ok {
+			return nil, nil, objectstore.RangeNotSatisfiable(attrs.Size)
+		}
+		info.ContentRange = &contentRange
+		offset, length = contentRange.Offset, contentRange.Length
+	}
+	// Pin the generation the attributes were read from, so a concurrent overwrite
+	// cannot mix the ETag and Size of one version with the data of another
+	rc, err := object.Generation(attrs.Generation).NewRangeReader(ctx, offset, length)
+	if err != nil {
+		return nil, nil, translateError(err)
+	}
+	return rc, &info, nil
+}
+
+func (s *ObjectStore) Delete(ctx context.Context, bucket, key string) error {
+	return translateError(s.client.Bucket(bucket).Object(key).Delete(ctx))
+}
+
+func (s *ObjectStore) List(ctx context.Context, bucket string, opts objectstore.ListOptions) (*objectstore.ListPage, error) {
+	it := s.client.Bucket(bucket).Objects(ctx, &storage.Query{Prefix: opts.Prefix, Delimiter: opts.Delimiter})
+
+	var attrs []*storage.ObjectAttrs
+	nextToken, err := iterator.NewPager(it, opts.MaxKeys, opts.PageToken).NextPage(&attrs)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	page := &objectstore.ListPage{Objects: []objectstore.ObjectInfo{}, CommonPrefixes: []string{}, NextPageToken: nextToken}
+	for _, object := range attrs {
+		// With a delimiter, the "directories" come back as entries with only a Prefix
+		if object.Prefix != "" {
+			page.CommonPrefixes = append(page.CommonPrefixes, object.Prefix)
+			continue
+		}
+		page.Objects = append(page.Objects, objectInfo(object))
+	}
+	return page, nil
+}
+
+func (s *ObjectStore) Stat(ctx context.Context, bucket, key string) (*objectstore.ObjectInfo, error) {
+	attrs, err := s.client.Bucket(bucket).Object(key).Attrs(ctx)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	info := objectInfo(attrs)
+	info.CacheControl, info.Metadata = attrs.CacheControl, attrs.Metadata
+	return &info, nil
+}
+
+// SignURL creates a V4 signed URL with the account's service account key. Accounts that
+// only hold an OAuth login have no key to sign with.
+func (s *ObjectStore) SignURL(ctx context.Context, bucket, key string, opts objectstore.SignOptions) (*objectstore.SignedURL, error) {
+	if !gcpauth.HasServiceAccount(s.account) {
+		return nil, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest,
+			"signed URLs need a service account key on the GCP account")
+	}
+	email, privateKey, err := gcpauth.SigningKey(s.account)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(opts.Expiry).UTC()
+	signOpts := &storage.SignedURLOptions{
+		GoogleAccessID: email,
+		PrivateKey:     privateKey,
+		Method:         opts.Method,
+		Expires:        expiresAt,
+		Scheme:         storage.SigningSchemeV4,
+	}
+	signed := &objectstore.SignedURL{Method: opts.Method, ExpiresAt: expiresAt}
+	if opts.Method == http.MethodPut && opts.ContentType != "" {
+		// The content type is part of the V4 signature, an upload with another type is rejected
+		signOpts.ContentType = opts.ContentType
+		signed.Headers = map[string]string{"Content-Type": opts.ContentType}
+	} else if opts.ContentType != "" {
+		signOpts.QueryParameters = url.Values{"response-content-type": {opts.ContentType}}
+	}
+	signed.URL, err = storage.SignedURL(bucket, key, signOpts)
+	if err != nil {
+		return nil, err
+	}
+	return signed, nil
+}
+
+func (s *ObjectStore) Close() error {
+	return s.client.Close()
+}
+
+func objectInfo(attrs *storage.ObjectAttrs) objectstore.ObjectInfo {
+	crc32c := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc32c, attrs.CRC32C)
+	return objectstore.ObjectInfo{
+		Key:          attrs.Name,
+		Size:         attrs.Size,
+		ETag:         attrs.Etag,
+		ContentType:  attrs.ContentType,
+		LastModified: attrs.Updated,
+		MD5:          attrs.MD5,
+		CRC32C:       crc32c,
+		StorageClass: attrs.StorageClass,
+	}
+}
+
+// translateError maps GCS "not found" errors onto objectstore.ErrNotFound
+func translateError(err error) error {
+	if err == storage.ErrObjectNotExist || err == storage.ErrBucketNotExist {
+		return objectstore.ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,341 @@
+package local_storage
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"btep.project/Storage/objectstore"
+	"btep.project/apierror"
+)
+
+const (
+	// metaDir keeps the metadata of the objects, one JSON file per object
+	metaDir = ".meta"
+	// tmpDir holds uploads until they are complete, it is on the same disk so they can be renamed
+	tmpDir = ".tmp"
+)
+
+// FileStore implements objectstore.ObjectStore on a directory: every bucket is a directory
+// below the root and every object a file at its key. Files copied into a bucket directory by
+// hand are objects too, they only lack the MD5 and metadata an upload records. Unlike on the
+// cloud stores, a key cannot be an object and the folder of other objects at the same time.
+type FileStore struct {
+	root string
+	// mu serializes the changes, so an object and its metadata file are written together
+	mu sync.Mutex
+}
+
+// metaFile is the metadata file of an object. Size and ModTime tell whether it still belongs
+// to the file, which may have been replaced by hand.
+type metaFile struct {
+	objectMeta
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// NewFileStore opens the store at root and creates the directory if it is missing
+func NewFileStore(root string) (*FileStore, error) {
+	for _, dir := range []string{root, filepath.Join(root, metaDir), filepath.Join(root, tmpDir)} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("error creating local storage directory: %v", err)
+		}
+	}
+	return &FileStore{root: root}, nil
+}
+
+func (s *FileStore) CreateBucket(ctx context.Context, bucket string) error {
+	if err := checkBucket(bucket); err != nil {
+		return err
+	}
+	err := os.Mkdir(filepath.Join(s.root, bucket), 0o755)
+	if errors.Is(err, fs.ErrExist) {
+		return bucketExists(bucket)
+	}
+	return err
+}
+
+func (s *FileStore) Put(ctx context.Context, bucket, key string, body io.Reader, opts objectstore.PutOptions) error {
+	if err := checkObject(bucket, key); err != nil {
+		return err
+	}
+	if err := s.checkBucketExists(bucket); err != nil {
+		return err
+	}
+
+	// The upload is written aside first, so readers never see half an object
+	tmp, err := os.CreateTemp(filepath.Join(s.root, tmpDir), "upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	hash := md5.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	sum := hash.Sum(nil)
+	if err := verifyMD5(opts.MD5, sum); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := s.objectPath(bucket, key)
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return conflict(key, err)
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return conflict(key, err)
+	}
+	return s.writeMeta(bucket, key, newMeta(key, opts, sum))
+}
+
+func (s *FileStore) Get(ctx context.Context, bucket, key string, opts objectstore.GetOptions) (io.ReadCloser, *objectstore.ObjectInfo, error) {
+	if err := checkObject(bucket, key); err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(s.objectPath(bucket, key))
+	if err != nil {
+		return nil, nil, translateError(err)
+	}
+	info, err := s.stat(f, bucket, key)
+	if err == nil {
+		var offset, length int64
+		if offset, length, err = readRange(info, opts); err == nil {
+			if _, err = f.Seek(offset, io.SeekStart); err == nil {
+				return readCloser{io.LimitReader(f, length), f}, info, nil
+			}
+		}
+	}
+	f.Close()
+	return nil, nil, err
+}
+
+func (s *FileStore) Delete(ctx context.Context, bucket, key string) error {
+	if err := checkObject(bucket, key); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := s.objectPath(bucket, key)
+	if info, err := os.Stat(name); err != nil || !info.Mode().IsRegular() {
+		return objectstore.ErrNotFound
+	}
+	if err := os.Remove(name); err != nil {
+		return translateError(err)
+	}
+	os.Remove(s.metaPath(bucket, key))
+
+	// Remove the directories the object was the last one in, os.Remove keeps those that are not empty
+	bucketDir := filepath.Join(s.root, bucket)
+	for dir := filepath.Dir(name); dir != bucketDir && os.Remove(dir) == nil; dir = filepath.Dir(dir) {
+	}
+	return nil
+}
+
+// List walks the directory the prefix points into and sorts the keys, which is fine for the
+// buckets of a development machine but reads the whole subtree for every page
+func (s *FileStore) List(ctx context.Context, bucket string, opts objectstore.ListOptions) (*objectstore.ListPage, error) {
+	if err := s.checkBucketExists(bucket); err != nil {
+		return nil, err
+	}
+	bucketDir := filepath.Join(s.root, bucket)
+	start := bucketDir
+	if i := strings.LastIndex(opts.Prefix, "/"); i > 0 && checkKey(opts.Prefix[:i]) == nil {
+		start = filepath.Join(bucketDir, filepath.FromSlash(opts.Prefix[:i]))
+	}
+
+	var infos []objectstore.ObjectInfo
+	err := filepath.WalkDir(start, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if name == start && translateError(err) == objectstore.ErrNotFound {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(bucketDir, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, opts.Prefix) {
+			return nil
+		}
+		fileInfo, err := entry.Info()
+		if err != nil {
+			return nil // removed while listing
+		}
+		info := s.readMeta(bucket, key, fileInfo).info(key, fileInfo.Size(), fileInfo.ModTime())
+		info.CacheControl, info.Metadata = "", nil
+		infos = append(infos, *info)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	return listPage(infos, opts), nil
+}
+
+func (s *FileStore) Stat(ctx context.Context, bucket, key string) (*objectstore.ObjectInfo, error) {
+	if err := checkObject(bucket, key); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(s.objectPath(bucket, key))
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer f.Close()
+	return s.stat(f, bucket, key)
+}
+
+// UpdateObject rewrites the metadata file, the content and LastModified stay as they are
+func (s *FileStore) UpdateObject(ctx context.Context, bucket, key string, update objectstore.ObjectUpdate) error {
+	return s.updateMeta(bucket, key, func(meta objectMeta) objectMeta {
+		return meta.apply(update)
+	})
+}
+
+func (s *FileStore) GetTags(ctx context.Context, bucket, key string) (map[string]string, error) {
+	if err := checkObject(bucket, key); err != nil {
+		return nil, err
+	}
+	fileInfo, err := os.Stat(s.objectPath(bucket, key))
+	if err != nil || !fileInfo.Mode().IsRegular() {
+		return nil, objectstore.ErrNotFound
+	}
+	return copyMap(s.readMeta(bucket, key, fileInfo).Tags), nil
+}
+
+func (s *FileStore) SetTags(ctx context.Context, bucket, key string, tags map[string]string) error {
+	return s.updateMeta(bucket, key, func(meta objectMeta) objectMeta {
+		meta.Tags = copyMap(tags)
+		return meta
+	})
+}
+
+func (s *FileStore) Close() error {
+	return nil
+}
+
+func (s *FileStore) objectPath(bucket, key string) string {
+	return filepath.Join(s.root, bucket, filepath.FromSlash(key))
+}
+
+// metaPath names metadata files by a hash of the key, so keys that are both a file and a
+// directory prefix ("a" and "a/b") cannot collide
+func (s *FileStore) metaPath(bucket, key string) string {
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(s.root, metaDir, bucket, hex.EncodeToString(sum[:])+".json")
+}
+
+func (s *FileStore) checkBucketExists(bucket string) error {
+	if err := checkBucket(bucket); err != nil {
+		return err
+	}
+	info, err := os.Stat(filepath.Join(s.root, bucket))
+	if err != nil || !info.IsDir() {
+		return objectstore.ErrNotFound
+	}
+	return nil
+}
+
+func (s *FileStore) stat(f *os.File, bucket, key string) (*objectstore.ObjectInfo, error) {
+	fileInfo, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !fileInfo.Mode().IsRegular() {
+		return nil, objectstore.ErrNotFound
+	}
+	return s.readMeta(bucket, key, fileInfo).info(key, fileInfo.Size(), fileInfo.ModTime()), nil
+}
+
+// readMeta returns the recorded metadata of the file, or what can be told from its name when
+// there is none or it was recorded for an earlier version of the file
+func (s *FileStore) readMeta(bucket, key string, fileInfo fs.FileInfo) objectMeta {
+	var meta metaFile
+	data, err := os.ReadFile(s.metaPath(bucket, key))
+	if err != nil || json.Unmarshal(data, &meta) != nil ||
+		meta.Size != fileInfo.Size() || !meta.ModTime.Equal(fileInfo.ModTime()) {
+		return newMeta(key, objectstore.PutOptions{}, nil)
+	}
+	return meta.objectMeta
+}
+
+// writeMeta records meta for the file currently at key, s.mu must be held
+func (s *FileStore) writeMeta(bucket, key string, meta objectMeta) error {
+	fileInfo, err := os.Stat(s.objectPath(bucket, key))
+	if err != nil {
+		return translateError(err)
+	}
+	data, err := json.Marshal(metaFile{objectMeta: meta, Size: fileInfo.Size(), ModTime: fileInfo.ModTime()})
+	if err != nil {
+		return err
+	}
+	name := s.metaPath(bucket, key)
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(name, data, 0o644)
+}
+
+func (s *FileStore) updateMeta(bucket, key string, change func(objectMeta) objectMeta) error {
+	if err := checkObject(bucket, key); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fileInfo, err := os.Stat(s.objectPath(bucket, key))
+	if err != nil || !fileInfo.Mode().IsRegular() {
+		return objectstore.ErrNotFound
+	}
+	return s.writeMeta(bucket, key, change(s.readMeta(bucket, key, fileInfo)))
+}
+
+// readCloser reads a part of a file and closes the file
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// translateError maps missing files and directories onto objectstore.ErrNotFound, a key
+// below a file ("a/b" when "a" is an object) is missing too
+func translateError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+		return objectstore.ErrNotFound
+	}
+	return err
+}
+
+// conflict explains why an object could not be stored at key, which happens when a key is
+// both an object and a prefix of another one ("a" and "a/b")
+func conflict(key string, err error) error {
+	if errors.Is(err, fs.ErrExist) || errors.Is(err, syscall.ENOTDIR) || errors.Is(err, syscall.EISDIR) {
+		return apierror.New(http.StatusConflict, apierror.CodeConflict,
+			fmt.Sprintf("%s cannot be stored as a file, a key is both an object and a folder of another object", key))
+	}
+	return err
+}
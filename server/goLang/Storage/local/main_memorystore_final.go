@@ -0,0 +1,192 @@
+package local_storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"btep.project/Storage/objectstore"
+)
+
+// MemoryStore implements objectstore.ObjectStore in memory. Objects are never changed in
+// place, a download keeps reading the content it started with when the object is replaced.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string]*memoryObject
+}
+
+type memoryObject struct {
+	data    []byte
+	meta    objectMeta
+	modTime time.Time
+}
+
+// NewMemoryStore returns an empty store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]map[string]*memoryObject)}
+}
+
+func (s *MemoryStore) CreateBucket(ctx context.Context, bucket string) error {
+	if err := checkBucket(bucket); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.buckets[bucket]; ok {
+		return bucketExists(bucket)
+	}
+	s.buckets[bucket] = make(map[string]*memoryObject)
+	return nil
+}
+
+func (s *MemoryStore) Put(ctx context.Context, bucket, key string, body io.Reader, opts objectstore.PutOptions) error {
+	if err := checkObject(bucket, key); err != nil {
+		return err
+	}
+	// Fail before reading the body when the bucket is missing, like the cloud stores do
+	if err := s.checkBucketExists(bucket); err != nil {
+		return err
+	}
+	hash := md5.New()
+	data, err := io.ReadAll(io.TeeReader(body, hash))
+	if err != nil {
+		return err
+	}
+	sum := hash.Sum(nil)
+	if err := verifyMD5(opts.MD5, sum); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		return objectstore.ErrNotFound
+	}
+	objects[key] = &memoryObject{data: data, meta: newMeta(key, opts, sum), modTime: time.Now()}
+	return nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, bucket, key string, opts objectstore.GetOptions) (io.ReadCloser, *objectstore.ObjectInfo, error) {
+	object, err := s.object(bucket, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	info := object.info(key)
+	offset, length, err := readRange(info, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return io.NopCloser(bytes.NewReader(object.data[offset : offset+length])), info, nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, bucket, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		return objectstore.ErrNotFound
+	}
+	if _, ok := objects[key]; !ok {
+		return objectstore.ErrNotFound
+	}
+	delete(objects, key)
+	return nil
+}
+
+func (s *MemoryStore) List(ctx context.Context, bucket string, opts objectstore.ListOptions) (*objectstore.ListPage, error) {
+	s.mu.RLock()
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		s.mu.RUnlock()
+		return nil, objectstore.ErrNotFound
+	}
+	var infos []objectstore.ObjectInfo
+	for key, object := range objects {
+		if strings.HasPrefix(key, opts.Prefix) {
+			info := object.info(key)
+			info.CacheControl, info.Metadata = "", nil
+			infos = append(infos, *info)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	return listPage(infos, opts), nil
+}
+
+func (s *MemoryStore) Stat(ctx context.Context, bucket, key string) (*objectstore.ObjectInfo, error) {
+	object, err := s.object(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return object.info(key), nil
+}
+
+// UpdateObject changes the metadata, the content and LastModified stay as they are
+func (s *MemoryStore) UpdateObject(ctx context.Context, bucket, key string, update objectstore.ObjectUpdate) error {
+	return s.updateMeta(bucket, key, func(meta objectMeta) objectMeta {
+		return meta.apply(update)
+	})
+}
+
+func (s *MemoryStore) GetTags(ctx context.Context, bucket, key string) (map[string]string, error) {
+	object, err := s.object(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return copyMap(object.meta.Tags), nil
+}
+
+func (s *MemoryStore) SetTags(ctx context.Context, bucket, key string, tags map[string]string) error {
+	return s.updateMeta(bucket, key, func(meta objectMeta) objectMeta {
+		meta.Tags = copyMap(tags)
+		return meta
+	})
+}
+
+// Close keeps the buckets, the store is shared by every request for its location
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+func (s *MemoryStore) checkBucketExists(bucket string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.buckets[bucket]; !ok {
+		return objectstore.ErrNotFound
+	}
+	return nil
+}
+
+func (s *MemoryStore) object(bucket, key string) (*memoryObject, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	object, ok := s.buckets[bucket][key]
+	if !ok {
+		return nil, objectstore.ErrNotFound
+	}
+	return object, nil
+}
+
+// updateMeta replaces the object with one that has the changed metadata, so readers holding
+// the old one are not affected
+func (s *MemoryStore) updateMeta(bucket, key string, change func(objectMeta) objectMeta) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, ok := s.buckets[bucket][key]
+	if !ok {
+		return objectstore.ErrNotFound
+	}
+	s.buckets[bucket][key] = &memoryObject{data: object.data, meta: change(object.meta), modTime: object.modTime}
+	return nil
+}
+
+func (o *memoryObject) info(key string) *objectstore.ObjectInfo {
+	return o.meta.info(key, int64(len(o.data)), o.modTime)
+}
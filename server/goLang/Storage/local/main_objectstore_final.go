@@ -0,0 +1,298 @@
+// Package local_storage keeps buckets on the local disk or in memory, so the storage routes
+// and sync jobs can be exercised without a cloud account.
+//
+// Local buckets live on the server, so the provider is only offered when LOCAL_STORAGE is set
+// on a development or CI server. A CloudAccount with CloudProvider "local" selects it, its
+// AdditionalInformation says how the buckets are kept:
+//
+//	file:///fixtures   every bucket is a directory and every object a file in it
+//	memory://          buckets live in memory until the server stops
+//
+// Every account has stores of its own. A file:// path is taken below the account's directory
+// in LOCAL_STORAGE_ROOT, file:// locations are refused without it. Accounts without
+// AdditionalInformation get a memory store.
+package local_storage
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"btep.project/Storage/objectstore"
+	"btep.project/apierror"
+	db "btep.project/databaseConnection"
+)
+
+// Provider is the CloudProvider of accounts whose buckets are kept locally
+const Provider = "local"
+
+// StorageClassStandard is the class of objects that were not given one, as on S3
+const StorageClassStandard = "STANDARD"
+
+// Config says whether accounts may keep buckets on the server and where
+type Config struct {
+	// Enabled offers the local provider, it is meant for development and CI servers only
+	Enabled bool
+	// Root is the directory file:// locations are kept in, each account in a directory of its
+	// own. file:// locations are refused when it is empty.
+	Root string
+}
+
+// ConfigFromEnv reads LOCAL_STORAGE ("true" enables the provider) and LOCAL_STORAGE_ROOT
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{Root: os.Getenv("LOCAL_STORAGE_ROOT")}
+	if enabled := os.Getenv("LOCAL_STORAGE"); enabled != "" {
+		b, err := strconv.ParseBool(enabled)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid LOCAL_STORAGE %q: %v", enabled, err)
+		}
+		cfg.Enabled = b
+	}
+	if cfg.Root != "" && !filepath.IsAbs(cfg.Root) {
+		return cfg, fmt.Errorf("LOCAL_STORAGE_ROOT %q must be an absolute path", cfg.Root)
+	}
+	return cfg, nil
+}
+
+// storeKey names the store of an account at one location
+type storeKey struct {
+	accountID int
+	location  string
+}
+
+var (
+	mu sync.Mutex
+	// root is the configured Config.Root
+	root string
+	// stores are kept for the next request of the same account, memory buckets live in them
+	stores = make(map[storeKey]objectstore.ObjectStore)
+)
+
+// Configure sets where file:// locations are kept, before the provider is registered
+func Configure(cfg Config) {
+	mu.Lock()
+	defer mu.Unlock()
+	root = cfg.Root
+}
+
+// NewObjectStore opens the local objectstore.ObjectStore the cloud account points to
+func NewObjectStore(ctx context.Context, cloudAccount *db.CloudAccount, opts objectstore.Options) (objectstore.ObjectStore, error) {
+	if !strings.EqualFold(cloudAccount.CloudProvider, Provider) {
+		return nil, invalid("account %d is a %s account, not a local one", cloudAccount.AccountID, cloudAccount.CloudProvider)
+	}
+	key := storeKey{accountID: cloudAccount.AccountID, location: cloudAccount.AdditionalInfo.String}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if store, ok := stores[key]; ok {
+		return store, nil
+	}
+	store, err := openLocation(key.accountID, key.location)
+	if err != nil {
+		return nil, err
+	}
+	stores[key] = store
+	return store, nil
+}
+
+// openLocation opens the store of an account, mu must be held
+func openLocation(accountID int, location string) (objectstore.ObjectStore, error) {
+	if location == "" {
+		return NewMemoryStore(), nil
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, invalid("local storage location %q is not a URL", location)
+	}
+	switch u.Scheme {
+	case "memory":
+		return NewMemoryStore(), nil
+	case "file":
+		if root == "" {
+			return nil, invalid("file:// locations are not enabled on this server")
+		}
+		if u.Host != "" && u.Host != "localhost" {
+			return nil, invalid("local storage location %q must be on this host", location)
+		}
+		// Cleaning the path as an absolute one drops any .. that would leave the account's directory
+		dir := filepath.Join(root, fmt.Sprintf("account-%d", accountID), filepath.FromSlash(path.Clean("/"+u.Path)))
+		return NewFileStore(dir)
+	}
+	return nil, invalid("local storage location %q must start with file:// or memory://", location)
+}
+
+// objectMeta is what the local stores keep about an object besides its content
+type objectMeta struct {
+	ContentType  string            `json:"contentType,omitempty"`
+	MD5          []byte            `json:"md5,omitempty"`
+	CacheControl string            `json:"cacheControl,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	StorageClass string            `json:"storageClass,omitempty"`
+	Tags         map[string]string `json:"tags,omitempty"`
+}
+
+func newMeta(key string, opts objectstore.PutOptions, sum []byte) objectMeta {
+	contentType := opts.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(key))
+	}
+	return objectMeta{ContentType: contentType, MD5: sum}
+}
+
+// info describes the object like Stat on a cloud store. The ETag is the hex MD5 like that
+// of a single part S3 upload, objects with no known MD5 get one made from size and time.
+func (m objectMeta) info(key string, size int64, modTime time.Time) *objectstore.ObjectInfo {
+	info := &objectstore.ObjectInfo{
+		Key:          key,
+		Size:         size,
+		ContentType:  m.ContentType,
+		LastModified: modTime.UTC(),
+		MD5:          m.MD5,
+		StorageClass: m.StorageClass,
+		CacheControl: m.CacheControl,
+		Metadata:     m.Metadata,
+	}
+	if len(m.MD5) > 0 {
+		info.ETag = `"` + hex.EncodeToString(m.MD5) + `"`
+	} else {
+		info.ETag = fmt.Sprintf(`"%x-%x"`, modTime.UnixNano(), size)
+	}
+	if info.StorageClass == "" {
+		info.StorageClass = StorageClassStandard
+	}
+	return info
+}
+
+// apply returns the metadata after update, the maps of m are not changed
+func (m objectMeta) apply(update objectstore.ObjectUpdate) objectMeta {
+	if update.ContentType != nil {
+		m.ContentType = *update.ContentType
+	}
+	if update.CacheControl != nil {
+		m.CacheControl = *update.CacheControl
+	}
+	if update.Metadata != nil {
+		m.Metadata = copyMap(update.Metadata)
+	}
+	if update.StorageClass != "" {
+		m.StorageClass = update.StorageClass
+	}
+	return m
+}
+
+func copyMap(m map[string]string) map[string]string {
+	if len(m) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+// checkBucket rejects bucket names that are not a single directory name
+func checkBucket(bucket string) error {
+	if bucket == "" || bucket == "." || bucket == ".." || strings.HasPrefix(bucket, ".") || strings.ContainsAny(bucket, `/\`) {
+		return invalid("invalid bucket name %q", bucket)
+	}
+	return nil
+}
+
+// checkKey rejects keys that cannot be stored as a file below the bucket directory, so a key
+// never leaves it. The memory store accepts the same keys, so both behave alike.
+func checkKey(key string) error {
+	if key == "" || len(key) > 1024 || strings.ContainsAny(key, "\\\x00") {
+		return invalid("invalid object key %q", key)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return invalid("object key %q cannot have empty, . or .. segments", key)
+		}
+	}
+	return nil
+}
+
+func checkObject(bucket, key string) error {
+	if err := checkBucket(bucket); err != nil {
+		return err
+	}
+	return checkKey(key)
+}
+
+// verifyMD5 compares the digest of the written content with the one the upload was sent with
+func verifyMD5(want, got []byte) error {
+	if len(want) > 0 && string(want) != string(got) {
+		return invalid("the content does not match its MD5, got %x instead of %x", got, want)
+	}
+	return nil
+}
+
+// readRange applies the conditions of a Get to the object described by info and returns the
+// part to read. A range is recorded in info.ContentRange like the cloud stores do.
+func readRange(info *objectstore.ObjectInfo, opts objectstore.GetOptions) (offset, length int64, err error) {
+	if objectstore.MatchesETag(opts.IfNoneMatch, info.ETag) {
+		return 0, 0, objectstore.ErrNotModified
+	}
+	if opts.Range == nil {
+		return 0, info.Size, nil
+	}
+	byteRange, ok := opts.Range.Resolve(info.Size)
+	if !ok {
+		return 0, 0, objectstore.RangeNotSatisfiable(info.Size)
+	}
+	info.ContentRange = &byteRange
+	return byteRange.Offset, byteRange.Length, nil
+}
+
+// listPage cuts one page out of objects, which must be sorted by key and all start with
+// opts.Prefix. The page token is the last key or common prefix of the previous page.
+func listPage(objects []objectstore.ObjectInfo, opts objectstore.ListOptions) *objectstore.ListPage {
+	maxKeys := opts.MaxKeys
+	if maxKeys <= 0 || maxKeys > objectstore.MaxListKeys {
+		maxKeys = objectstore.MaxListKeys
+	}
+	page := &objectstore.ListPage{Objects: []objectstore.ObjectInfo{}, CommonPrefixes: []string{}}
+	last := ""
+	for _, object := range objects {
+		entry, isPrefix := object.Key, false
+		if opts.Delimiter != "" {
+			if i := strings.Index(object.Key[len(opts.Prefix):], opts.Delimiter); i >= 0 {
+				entry, isPrefix = object.Key[:len(opts.Prefix)+i+len(opts.Delimiter)], true
+			}
+		}
+		// Keys under one common prefix are next to each other, so it only has to be added once
+		if entry <= opts.PageToken || entry == last {
+			continue
+		}
+		if len(page.Objects)+len(page.CommonPrefixes) == maxKeys {
+			page.NextPageToken = last
+			break
+		}
+		last = entry
+		if isPrefix {
+			page.CommonPrefixes = append(page.CommonPrefixes, entry)
+		} else {
+			page.Objects = append(page.Objects, object)
+		}
+	}
+	return page
+}
+
+func invalid(format string, args ...interface{}) error {
+	return apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, fmt.Sprintf(format, args...))
+}
+
+func bucketExists(bucket string) error {
+	return apierror.New(http.StatusConflict, apierror.CodeConflict, fmt.Sprintf("bucket %s already exists", bucket))
+}
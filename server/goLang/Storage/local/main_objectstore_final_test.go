@@ -0,0 +1,305 @@
+package local_storage
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"btep.project/Storage/objectstore"
+	"btep.project/apierror"
+	db "btep.project/databaseConnection"
+)
+
+// eachStore runs a test against both local stores
+func eachStore(t *testing.T, test func(t *testing.T, store objectstore.ObjectStore)) {
+	t.Run("file", func(t *testing.T) {
+		store, err := NewFileStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		test(t, store)
+	})
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryStore())
+	})
+}
+
+func put(t *testing.T, store objectstore.ObjectStore, bucket, key, content string) {
+	t.Helper()
+	err := store.Put(context.Background(), bucket, key, strings.NewReader(content), objectstore.PutOptions{Size: int64(len(content))})
+	if err != nil {
+		t.Fatalf("Put %s: %v", key, err)
+	}
+}
+
+func get(t *testing.T, store objectstore.ObjectStore, bucket, key string, opts objectstore.GetOptions) (string, *objectstore.ObjectInfo, error) {
+	t.Helper()
+	body, info, err := store.Get(context.Background(), bucket, key, opts)
+	if err != nil {
+		return "", nil, err
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data), info, nil
+}
+
+func status(err error) int {
+	var apiErr *apierror.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Status
+	}
+	return 0
+}
+
+func TestPutGetDelete(t *testing.T) {
+	eachStore(t, func(t *testing.T, store objectstore.ObjectStore) {
+		ctx := context.Background()
+		if err := store.CreateBucket(ctx, "b"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.CreateBucket(ctx, "b"); status(err) != http.StatusConflict {
+			t.Fatalf("second CreateBucket = %v, want a conflict", err)
+		}
+		if err := store.Put(ctx, "missing", "k", strings.NewReader("x"), objectstore.PutOptions{}); !errors.Is(err, objectstore.ErrNotFound) {
+			t.Fatalf("Put into a missing bucket = %v, want ErrNotFound", err)
+		}
+
+		put(t, store, "b", "docs/readme.txt", "hello world")
+		content, info, err := get(t, store, "b", "docs/readme.txt", objectstore.GetOptions{})
+		if err != nil || content != "hello world" {
+			t.Fatalf("Get = %q, %v", content, err)
+		}
+		sum := md5.Sum([]byte("hello world"))
+		if info.Size != 11 || !reflect.DeepEqual(info.MD5, sum[:]) || info.ContentType != "text/plain; charset=utf-8" {
+			t.Fatalf("info = %+v", info)
+		}
+
+		// A put replaces the object
+		put(t, store, "b", "docs/readme.txt", "bye")
+		if content, _, _ := get(t, store, "b", "docs/readme.txt", objectstore.GetOptions{}); content != "bye" {
+			t.Fatalf("Get after a second Put = %q", content)
+		}
+
+		// The content must match the MD5 it was sent with
+		err = store.Put(ctx, "b", "bad", strings.NewReader("abc"), objectstore.PutOptions{MD5: sum[:]})
+		if status(err) != http.StatusBadRequest {
+			t.Fatalf("Put with a wrong MD5 = %v, want a bad request", err)
+		}
+		if _, err := store.Stat(ctx, "b", "bad"); !errors.Is(err, objectstore.ErrNotFound) {
+			t.Fatalf("Stat of the rejected object = %v, want ErrNotFound", err)
+		}
+
+		if err := store.Delete(ctx, "b", "docs/readme.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := get(t, store, "b", "docs/readme.txt", objectstore.GetOptions{}); !errors.Is(err, objectstore.ErrNotFound) {
+			t.Fatalf("Get after Delete = %v, want ErrNotFound", err)
+		}
+		if err := store.Delete(ctx, "b", "docs/readme.txt"); !errors.Is(err, objectstore.ErrNotFound) {
+			t.Fatalf("second Delete = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestRangeAndIfNoneMatch(t *testing.T) {
+	eachStore(t, func(t *testing.T, store objectstore.ObjectStore) {
+		store.CreateBucket(context.Background(), "b")
+		put(t, store, "b", "k", "0123456789")
+
+		tests := []struct {
+			byteRange objectstore.ByteRange
+			want      string
+		}{
+			{objectstore.ByteRange{Offset: 2, Length: 3}, "234"},
+			{objectstore.ByteRange{Offset: 7, Length: -1}, "789"},
+			{objectstore.ByteRange{Offset: -4, Length: -1}, "6789"},
+			{objectstore.ByteRange{Offset: 8, Length: 100}, "89"},
+		}
+		for _, tt := range tests {
+			byteRange := tt.byteRange
+			content, info, err := get(t, store, "b", "k", objectstore.GetOptions{Range: &byteRange})
+			if err != nil || content != tt.want {
+				t.Fatalf("range %+v = %q, %v, want %q", tt.byteRange, content, err, tt.want)
+			}
+			if info.ContentRange == nil || info.ContentRange.Length != int64(len(tt.want)) {
+				t.Fatalf("range %+v has ContentRange %+v", tt.byteRange, info.ContentRange)
+			}
+		}
+		_, _, err := get(t, store, "b", "k", objectstore.GetOptions{Range: &objectstore.ByteRange{Offset: 10, Length: 1}})
+		if status(err) != http.StatusRequestedRangeNotSatisfiable {
+			t.Fatalf("range past the end = %v, want 416", err)
+		}
+		r := httptest.NewRequest(http.MethodGet, "/download", nil)
+		r.Header.Set("Range", "bytes=10-")
+		w := httptest.NewRecorder()
+		objectstore.Download(w, r, store, "b", "k")
+		if w.Code != http.StatusRequestedRangeNotSatisfiable || w.Header().Get("Content-Range") != "bytes */10" {
+			t.Fatalf("download past the end = %d with Content-Range %q", w.Code, w.Header().Get("Content-Range"))
+		}
+
+		info, err := store.Stat(context.Background(), "b", "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := get(t, store, "b", "k", objectstore.GetOptions{IfNoneMatch: info.ETag}); !errors.Is(err, objectstore.ErrNotModified) {
+			t.Fatalf("Get with the current ETag = %v, want ErrNotModified", err)
+		}
+		if content, _, err := get(t, store, "b", "k", objectstore.GetOptions{IfNoneMatch: `"other"`}); err != nil || content != "0123456789" {
+			t.Fatalf("Get with another ETag = %q, %v", content, err)
+		}
+	})
+}
+
+func TestList(t *testing.T) {
+	eachStore(t, func(t *testing.T, store objectstore.ObjectStore) {
+		ctx := context.Background()
+		store.CreateBucket(ctx, "b")
+		for _, key := range []string{"a.txt", "docs/1.txt", "docs/2.txt", "docs/sub/3.txt", "img/x.png", "z.txt"} {
+			put(t, store, "b", key, key)
+		}
+
+		// list pages through the bucket. A page holds its prefixes apart from its objects, they
+		// are merged so the entries of all pages come out in key order.
+		list := func(opts objectstore.ListOptions) (entries []string, pages int) {
+			for {
+				page, err := store.List(ctx, "b", opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				pages++
+				pageEntries := append([]string{}, page.CommonPrefixes...)
+				for _, object := range page.Objects {
+					pageEntries = append(pageEntries, object.Key)
+				}
+				sort.Strings(pageEntries)
+				entries = append(entries, pageEntries...)
+				if page.NextPageToken == "" {
+					return entries, pages
+				}
+				opts.PageToken = page.NextPageToken
+			}
+		}
+
+		all := []string{"a.txt", "docs/1.txt", "docs/2.txt", "docs/sub/3.txt", "img/x.png", "z.txt"}
+		tests := []struct {
+			name  string
+			opts  objectstore.ListOptions
+			want  []string
+			pages int
+		}{
+			{"all", objectstore.ListOptions{}, all, 1},
+			{"paged", objectstore.ListOptions{MaxKeys: 2}, all, 3},
+			{"prefix", objectstore.ListOptions{Prefix: "docs/"}, []string{"docs/1.txt", "docs/2.txt", "docs/sub/3.txt"}, 1},
+			{"prefix paged", objectstore.ListOptions{Prefix: "docs/", MaxKeys: 2}, []string{"docs/1.txt", "docs/2.txt", "docs/sub/3.txt"}, 2},
+			{"delimiter", objectstore.ListOptions{Delimiter: "/"}, []string{"a.txt", "docs/", "img/", "z.txt"}, 1},
+			{"prefix and delimiter", objectstore.ListOptions{Prefix: "docs/", Delimiter: "/"}, []string{"docs/1.txt", "docs/2.txt", "docs/sub/"}, 1},
+			{"delimiter paged", objectstore.ListOptions{Delimiter: "/", MaxKeys: 1}, []string{"a.txt", "docs/", "img/", "z.txt"}, 4},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				entries, pages := list(tt.opts)
+				if !reflect.DeepEqual(entries, tt.want) || pages != tt.pages {
+					t.Fatalf("List = %v in %d pages, want %v in %d", entries, pages, tt.want, tt.pages)
+				}
+			})
+		}
+
+		if _, err := store.List(ctx, "missing", objectstore.ListOptions{}); !errors.Is(err, objectstore.ErrNotFound) {
+			t.Fatalf("List of a missing bucket = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestRejectsBadNames(t *testing.T) {
+	eachStore(t, func(t *testing.T, store objectstore.ObjectStore) {
+		ctx := context.Background()
+		for _, bucket := range []string{"", ".", "..", ".hidden", "a/b", `a\b`} {
+			if err := store.CreateBucket(ctx, bucket); status(err) != http.StatusBadRequest {
+				t.Errorf("CreateBucket(%q) = %v, want a bad request", bucket, err)
+			}
+		}
+		store.CreateBucket(ctx, "b")
+		for _, key := range []string{"", "..", "../escape", "a/../../escape", "a//b", "./a", `a\b`, "a\x00b"} {
+			err := store.Put(ctx, "b", key, strings.NewReader("x"), objectstore.PutOptions{})
+			if status(err) != http.StatusBadRequest {
+				t.Errorf("Put(%q) = %v, want a bad request", key, err)
+			}
+		}
+		if err := store.Put(ctx, "..", "escape", strings.NewReader("x"), objectstore.PutOptions{}); status(err) != http.StatusBadRequest {
+			t.Errorf("Put into bucket .. = %v, want a bad request", err)
+		}
+	})
+}
+
+func TestFileStoreStaysInRoot(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(filepath.Join(dir, "root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.CreateBucket(context.Background(), "b")
+	store.Put(context.Background(), "b", "../../escape", strings.NewReader("x"), objectstore.PutOptions{})
+	if _, err := os.Stat(filepath.Join(dir, "escape")); !os.IsNotExist(err) {
+		t.Fatalf("a key with .. wrote outside the root: %v", err)
+	}
+}
+
+func TestNewObjectStore(t *testing.T) {
+	ctx := context.Background()
+	account := func(id int, location string) *db.CloudAccount {
+		return &db.CloudAccount{AccountID: id, CloudProvider: Provider, AdditionalInfo: sql.NullString{String: location, Valid: location != ""}}
+	}
+
+	Configure(Config{})
+	if _, err := NewObjectStore(ctx, account(1, "file:///data"), objectstore.Options{}); status(err) != http.StatusBadRequest {
+		t.Fatalf("file:// without LOCAL_STORAGE_ROOT = %v, want a bad request", err)
+	}
+
+	root := t.TempDir()
+	Configure(Config{Enabled: true, Root: root})
+	defer Configure(Config{})
+
+	// Every account has a directory of its own, .. cannot leave it
+	store, err := NewObjectStore(ctx, account(7, "file:///../../etc"), objectstore.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileStore, ok := store.(*FileStore)
+	if !ok || fileStore.root != filepath.Join(root, "account-7", "etc") {
+		t.Fatalf("store = %#v, want a file store in %s", store, filepath.Join(root, "account-7", "etc"))
+	}
+
+	// Accounts naming the same location do not share buckets
+	first, err := NewObjectStore(ctx, account(8, "memory://shared"), objectstore.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewObjectStore(ctx, account(9, "memory://shared"), objectstore.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Fatal("two accounts got the same memory store")
+	}
+	again, _ := NewObjectStore(ctx, account(8, "memory://shared"), objectstore.Options{})
+	if again != first {
+		t.Fatal("the memory store of an account was not kept")
+	}
+
+	if _, err := NewObjectStore(ctx, &db.CloudAccount{AccountID: 10, CloudProvider: "aws"}, objectstore.Options{}); status(err) != http.StatusBadRequest {
+		t.Fatalf("an aws account = %v, want a bad request", err)
+	}
+}
@@ -0,0 +1,175 @@
+package objectstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"btep.project/apierror"
+	"github.com/gorilla/mux"
+)
+
+// Encryption algorithms of BucketConfig
+const (
+	// EncryptionProviderManaged encrypts with keys the provider manages (SSE-S3, Google-managed keys)
+	EncryptionProviderManaged = "provider-managed"
+	// EncryptionKMS encrypts with the customer managed key in KMSKeyID
+	EncryptionKMS = "kms"
+)
+
+// BucketConfigurer is implemented by stores that can read and change the configuration of a bucket
+type BucketConfigurer interface {
+	GetBucketConfig(ctx context.Context, bucket string) (*BucketConfig, error)
+	// SetBucketConfig changes the settings that are not nil and leaves the others as they are
+	SetBucketConfig(ctx context.Context, bucket string, config BucketConfig) error
+}
+
+// BucketConfig is the provider-neutral configuration of a bucket. In a set request a nil
+// field is left unchanged, and an empty list removes the rules of that kind.
+type BucketConfig struct {
+	Versioning *bool           `json:"versioning"`
+	Lifecycle  []LifecycleRule `json:"lifecycle"`
+	CORS       []CORSRule      `json:"cors"`
+	Encryption *Encryption     `json:"encryption"`
+	// PublicAccessBlocked prevents objects from being made public through ACLs or policies
+	PublicAccessBlocked *bool `json:"publicAccessBlocked"`
+}
+
+// LifecycleRule expires or transitions the objects under Prefix
+type LifecycleRule struct {
+	// ID names the rule on S3, GCS rules have no name
+	ID      string `json:"id,omitempty"`
+	Prefix  string `json:"prefix,omitempty"`
+	Enabled bool   `json:"enabled"`
+	// ExpireAfterDays deletes objects this many days after they were created, 0 keeps them
+	ExpireAfterDays int `json:"expireAfterDays,omitempty"`
+	// TransitionAfterDays moves objects to StorageClass this many days after they were created
+	TransitionAfterDays int    `json:"transitionAfterDays,omitempty"`
+	StorageClass        string `json:"storageClass,omitempty"`
+}
+
+// CORSRule allows browsers on AllowedOrigins to call the bucket directly, e.g. with signed URLs
+type CORSRule struct {
+	AllowedOrigins []string `json:"allowedOrigins"`
+	AllowedMethods []string `json:"allowedMethods"`
+	// AllowedHeaders are the request headers a browser may send, GCS allows any
+	AllowedHeaders []string `json:"allowedHeaders,omitempty"`
+	ExposedHeaders []string `json:"exposedHeaders,omitempty"`
+	MaxAgeSeconds  int      `json:"maxAgeSeconds,omitempty"`
+}
+
+// Encryption is the default encryption of new objects
+type Encryption struct {
+	// Algorithm is EncryptionProviderManaged or EncryptionKMS
+	Algorithm string `json:"algorithm"`
+	KMSKeyID  string `json:"kmsKeyID,omitempty"`
+}
+
+// Validate checks the settings every provider needs before any of them is applied
+func (c BucketConfig) Validate() error {
+	for i, rule := range c.Lifecycle {
+		if rule.ExpireAfterDays < 0 || rule.TransitionAfterDays < 0 {
+			return fmt.Errorf("lifecycle rule %d: days cannot be negative", i)
+		}
+		if rule.ExpireAfterDays == 0 && rule.TransitionAfterDays == 0 {
+			return fmt.Errorf("lifecycle rule %d needs expireAfterDays or transitionAfterDays", i)
+		}
+		if rule.TransitionAfterDays > 0 && rule.StorageClass == "" {
+			return fmt.Errorf("lifecycle rule %d needs the storageClass to transition to", i)
+		}
+	}
+	for i, rule := range c.CORS {
+		if len(rule.AllowedOrigins) == 0 || len(rule.AllowedMethods) == 0 {
+			return fmt.Errorf("cors rule %d needs allowedOrigins and allowedMethods", i)
+		}
+	}
+	if c.Encryption != nil {
+		switch c.Encryption.Algorithm {
+		case EncryptionProviderManaged:
+		case EncryptionKMS:
+			if c.Encryption.KMSKeyID == "" {
+				return fmt.Errorf("kms encryption needs a kmsKeyID")
+			}
+		default:
+			return fmt.Errorf("encryption algorithm must be %q or %q", EncryptionProviderManaged, EncryptionKMS)
+		}
+	}
+	return nil
+}
+
+// BucketConfigRequest represents the JSON request structure for setBucketConfig
+type BucketConfigRequest struct {
+	StoreRequest
+	Config BucketConfig `json:"config"`
+}
+
+// openConfigurer opens the store of the route and checks that it manages bucket configuration
+func openConfigurer(w http.ResponseWriter, r *http.Request, req StoreRequest) (BucketConfigurer, func() error, bool) {
+	provider := mux.Vars(r)["provider"]
+	store, err := Open(r.Context(), provider, req.options())
+	if err != nil {
+		apierror.Write(w, err, "Error opening object store")
+		return nil, nil, false
+	}
+	configurer, ok := store.(BucketConfigurer)
+	if !ok {
+		store.Close()
+		apierror.BadRequest(w, fmt.Sprintf("provider %s does not support bucket configuration", provider))
+		return nil, nil, false
+	}
+	return configurer, store.Close, true
+}
+
+// GetBucketConfigHandler handles POST /storage/{provider}/getBucketConfig
+func GetBucketConfigHandler(w http.ResponseWriter, r *http.Request) {
+	var req StoreRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.BadRequest(w, "Invalid request body")
+		return
+	}
+	configurer, closeStore, ok := openConfigurer(w, r, req)
+	if !ok {
+		return
+	}
+	defer closeStore()
+
+	config, err := configurer.GetBucketConfig(r.Context(), req.BucketName)
+	if err != nil {
+		writeError(w, err, "Error reading bucket configuration")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(config)
+}
+
+// SetBucketConfigHandler handles POST /storage/{provider}/setBucketConfig and answers
+// with the configuration the bucket has afterwards
+func SetBucketConfigHandler(w http.ResponseWriter, r *http.Request) {
+	var req BucketConfigRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.BadRequest(w, "Invalid request body")
+		return
+	}
+	if err := req.Config.Validate(); err != nil {
+		apierror.BadRequest(w, err.Error())
+		return
+	}
+	configurer, closeStore, ok := openConfigurer(w, r, req.StoreRequest)
+	if !ok {
+		return
+	}
+	defer closeStore()
+
+	if err := configurer.SetBucketConfig(r.Context(), req.BucketName, req.Config); err != nil {
+		writeError(w, err, "Error updating bucket configuration")
+		return
+	}
+	config, err := configurer.GetBucketConfig(r.Context(), req.BucketName)
+	if err != nil {
+		writeError(w, err, "Error reading bucket configuration")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(config)
+}
@@ -0,0 +1,219 @@
+package objectstore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"btep.project/apierror"
+)
+
+// ErrNotModified is returned by Get when the object still has the ETag in GetOptions.IfNoneMatch
+var ErrNotModified = errors.New("object not modified")
+
+// GetOptions carries the optional conditions of a download
+type GetOptions struct {
+	// Range selects part of the object, nil reads all of it
+	Range *ByteRange
+	// IfNoneMatch skips the download when the object's ETag matches
+	IfNoneMatch string
+}
+
+// ByteRange is a range of bytes of an object. Length -1 reads to the end of the object,
+// a negative Offset selects the last -Offset bytes (the "bytes=-N" form of a Range header).
+type ByteRange struct {
+	Offset int64
+	Length int64
+}
+
+// HeaderValue formats the range as an HTTP Range header value
+func (br ByteRange) HeaderValue() string {
+	switch {
+	case br.Offset < 0:
+		return fmt.Sprintf("bytes=%d", br.Offset)
+	case br.Length < 0:
+		return fmt.Sprintf("bytes=%d-", br.Offset)
+	}
+	return fmt.Sprintf("bytes=%d-%d", br.Offset, br.Offset+br.Length-1)
+}
+
+// ParseRange parses a single range Range header. Headers with several ranges, or in a unit
+// other than bytes, return nil: RFC 9110 allows serving the whole object instead.
+func ParseRange(header string) (*ByteRange, error) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if header == "" || !ok || strings.Contains(spec, ",") {
+		return nil, nil
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid Range header %q", header)
+	}
+
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid Range header %q", header)
+		}
+		return &ByteRange{Offset: -n, Length: -1}, nil
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return nil, fmt.Errorf("invalid Range header %q", header)
+	}
+	if last == "" {
+		return &ByteRange{Offset: start, Length: -1}, nil
+	}
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start {
+		return nil, fmt.Errorf("invalid Range header %q", header)
+	}
+	return &ByteRange{Offset: start, Length: end - start + 1}, nil
+}
+
+// Resolve turns the range into absolute offsets for an object of size bytes.
+// It returns false when no byte of the object is in the range.
+func (br ByteRange) Resolve(size int64) (ByteRange, bool) {
+	if br.Offset < 0 {
+		n := -br.Offset
+		if n > size {
+			n = size
+		}
+		return ByteRange{Offset: size - n, Length: n}, n > 0
+	}
+	if br.Offset >= size {
+		return ByteRange{}, false
+	}
+	if br.Length < 0 || br.Offset+br.Length > size {
+		return ByteRange{Offset: br.Offset, Length: size - br.Offset}, true
+	}
+	return br, true
+}
+
+// ParseContentRange reads the served range and total size from a "bytes first-last/total" header
+func ParseContentRange(header string) (*ByteRange, int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return nil, 0, false
+	}
+	span, total, ok := strings.Cut(spec, "/")
+	if !ok {
+		return nil, 0, false
+	}
+	size, err := strconv.ParseInt(total, 10, 64)
+	if err != nil {
+		return nil, 0, false
+	}
+	first, last, ok := strings.Cut(span, "-")
+	if !ok {
+		return nil, 0, false
+	}
+	start, err1 := strconv.ParseInt(first, 10, 64)
+	end, err2 := strconv.ParseInt(last, 10, 64)
+	if err1 != nil || err2 != nil {
+		return nil, 0, false
+	}
+	return &ByteRange{Offset: start, Length: end - start + 1}, size, true
+}
+
+// DownloadOptions reads the Range and If-None-Match headers of a download request
+func DownloadOptions(r *http.Request) (GetOptions, error) {
+	byteRange, err := ParseRange(r.Header.Get("Range"))
+	if err != nil {
+		return GetOptions{}, err
+	}
+	return GetOptions{Range: byteRange, IfNoneMatch: r.Header.Get("If-None-Match")}, nil
+}
+
+// Download streams key from store to w. It honours the Range and If-None-Match headers of r
+// and answers 206, 304 or 416 like a static file server would, without buffering the object.
+func Download(w http.ResponseWriter, r *http.Request, store ObjectStore, bucket, key string) {
+	opts, err := DownloadOptions(r)
+	if err != nil {
+		apierror.BadRequest(w, err.Error())
+		return
+	}
+
+	body, info, err := store.Get(r.Context(), bucket, key, opts)
+	if errors.Is(err, ErrNotModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if err != nil {
+		var rangeErr *RangeError
+		if errors.As(err, &rangeErr) {
+			// RFC 9110 §15.5.17: a 416 carries the current length of the object
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", rangeErr.Size))
+		}
+		writeError(w, err, "Error downloading object")
+		return
+	}
+	defer body.Close()
+	WriteObject(w, body, info, key)
+}
+
+// WriteObject writes the headers of info and streams body, info.ContentRange makes it a 206
+func WriteObject(w http.ResponseWriter, body io.Reader, info *ObjectInfo, filename string) {
+	contentType := info.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header := w.Header()
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Disposition", "attachment; filename="+strconv.Quote(filename[strings.LastIndex(filename, "/")+1:]))
+	header.Set("Accept-Ranges", "bytes")
+	if info.ETag != "" {
+		header.Set("ETag", info.ETag)
+	}
+	if !info.LastModified.IsZero() {
+		header.Set("Last-Modified", info.LastModified.UTC().Format(http.TimeFormat))
+	}
+
+	status := http.StatusOK
+	length := info.Size
+	if info.ContentRange != nil {
+		status = http.StatusPartialContent
+		length = info.ContentRange.Length
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", info.ContentRange.Offset, info.ContentRange.Offset+info.ContentRange.Length-1, info.Size))
+	}
+	if length >= 0 {
+		header.Set("Content-Length", strconv.FormatInt(length, 10))
+	}
+	w.WriteHeader(status)
+	io.Copy(w, body)
+}
+
+// MatchesETag reports whether an If-None-Match header names etag, weak tags match too
+func MatchesETag(header, etag string) bool {
+	if header == "" || etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || strings.Trim(candidate, `"`) == strings.Trim(etag, `"`) {
+			return true
+		}
+	}
+	return false
+}
+
+// RangeError is returned by Get when no byte of the object is in the requested range. It
+// unwraps to the 416 envelope, Download also tells the client the size in Content-Range.
+type RangeError struct {
+	Size int64
+}
+
+func (e *RangeError) Error() string {
+	return fmt.Sprintf("the requested range is outside the object of %d bytes", e.Size)
+}
+
+func (e *RangeError) Unwrap() error {
+	return apierror.New(http.StatusRequestedRangeNotSatisfiable, apierror.CodeInvalidRange, e.Error())
+}
+
+// RangeNotSatisfiable returns the RangeError of an object of size bytes
+func RangeNotSatisfiable(size int64) error {
+	return &RangeError{Size: size}
+}
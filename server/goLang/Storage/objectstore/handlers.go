@@ -0,0 +1,220 @@
+package objectstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"btep.project/apierror"
+	"btep.project/middleware"
+	"github.com/gorilla/mux"
+)
+
+// StoreRequest represents the JSON request structure shared by all /storage/{provider} routes
+type StoreRequest struct {
+	AccountID     int    `json:"accountID"`
+	Region        string `json:"region"`
+	AccountName   string `json:"accountName"`
+	ResourceGroup string `json:"resourceGroup"`
+	BucketName    string `json:"bucketName"`
+	ObjectKey     string `json:"objectKey"`
+	Prefix        string `json:"prefix"`
+	Delimiter     string `json:"delimiter"`
+	PageToken     string `json:"pageToken"`
+	MaxKeys       int    `json:"maxKeys"`
+}
+
+func (req StoreRequest) options() Options {
+	return Options{
+		AccountID:     req.AccountID,
+		Region:        req.Region,
+		AccountName:   req.AccountName,
+		ResourceGroup: req.ResourceGroup,
+	}
+}
+
+// StoreResponse represents the JSON response structure for storage operations
+type StoreResponse struct {
+	Message string `json:"message"`
+}
+
+// openStore decodes the request body and opens the store for the provider in the route
+func openStore(w http.ResponseWriter, r *http.Request) (ObjectStore, *StoreRequest, bool) {
+	var req StoreRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		apierror.BadRequest(w, "Invalid request body")
+		return nil, nil, false
+	}
+
+	store, err := Open(r.Context(), mux.Vars(r)["provider"], req.options())
+	if err != nil {
+		apierror.Write(w, err, "Error opening object store")
+		return nil, nil, false
+	}
+	return store, &req, true
+}
+
+// CreateBucketHandler handles POST /storage/{provider}/createBucket
+func CreateBucketHandler(w http.ResponseWriter, r *http.Request) {
+	store, req, ok := openStore(w, r)
+	if !ok {
+		return
+	}
+	defer store.Close()
+
+	if err := store.CreateBucket(r.Context(), req.BucketName); err != nil {
+		apierror.Write(w, err, "Error creating bucket")
+		return
+	}
+
+	resp := StoreResponse{Message: "Bucket created successfully"}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// PutObjectHandler handles multipart POST /storage/{provider}/putObject.
+// The form carries the StoreRequest fields plus the object in a "file" part.
+func PutObjectHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseMultipartForm(10 << 20) // Set maxMemory to 10 MB
+	if err != nil {
+		apierror.BadRequest(w, fmt.Sprintf("Error parsing form data: %v", err))
+		return
+	}
+
+	accountID, err := middleware.FormAccountID(r)
+	if err != nil {
+		apierror.Write(w, err, "")
+		return
+	}
+	req := StoreRequest{
+		AccountID:     accountID,
+		Region:        r.FormValue("region"),
+		AccountName:   r.FormValue("accountName"),
+		ResourceGroup: r.FormValue("resourceGroup"),
+		BucketName:    r.FormValue("bucketName"),
+		ObjectKey:     r.FormValue("objectKey"),
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		apierror.BadRequest(w, fmt.Sprintf("Error retrieving file from form data: %v", err))
+		return
+	}
+	defer file.Close()
+
+	// Fall back to the uploaded file name when no key is given
+	if req.ObjectKey == "" {
+		req.ObjectKey = header.Filename
+	}
+
+	store, err := Open(r.Context(), mux.Vars(r)["provider"], req.options())
+	if err != nil {
+		apierror.Write(w, err, "Error opening object store")
+		return
+	}
+	defer store.Close()
+
+	opts := PutOptions{ContentType: header.Header.Get("Content-Type"), Size: header.Size}
+	if err := store.Put(r.Context(), req.BucketName, req.ObjectKey, file, opts); err != nil {
+		apierror.Write(w, err, "Error uploading object")
+		return
+	}
+
+	resp := StoreResponse{Message: "Object uploaded successfully"}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GetObjectHandler handles POST /storage/{provider}/getObject and streams the object back.
+// Range and If-None-Match request headers are honoured.
+func GetObjectHandler(w http.ResponseWriter, r *http.Request) {
+	store, req, ok := openStore(w, r)
+	if !ok {
+		return
+	}
+	defer store.Close()
+
+	Download(w, r, store, req.BucketName, req.ObjectKey)
+}
+
+// DeleteObjectHandler handles POST /storage/{provider}/deleteObject
+func DeleteObjectHandler(w http.ResponseWriter, r *http.Request) {
+	store, req, ok := openStore(w, r)
+	if !ok {
+		return
+	}
+	defer store.Close()
+
+	if err := store.Delete(r.Context(), req.BucketName, req.ObjectKey); err != nil {
+		writeError(w, err, "Error deleting object")
+		return
+	}
+
+	resp := StoreResponse{Message: "Object deleted successfully"}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ListObjectsHandler handles POST /storage/{provider}/listObjects
+func ListObjectsHandler(w http.ResponseWriter, r *http.Request) {
+	store, req, ok := openStore(w, r)
+	if !ok {
+		return
+	}
+	defer store.Close()
+
+	ListObjects(w, r, store, req.BucketName, ListOptions{
+		Prefix:    req.Prefix,
+		Delimiter: req.Delimiter,
+		PageToken: req.PageToken,
+		MaxKeys:   req.MaxKeys,
+	})
+}
+
+// ListObjects writes one page of the objects in bucket, it backs the listObjects route of every provider
+func ListObjects(w http.ResponseWriter, r *http.Request, store ObjectStore, bucket string, opts ListOptions) {
+	if opts.MaxKeys < 0 || opts.MaxKeys > MaxListKeys {
+		apierror.BadRequest(w, fmt.Sprintf("maxKeys must be between 1 and %d", MaxListKeys))
+		return
+	}
+	if opts.MaxKeys == 0 {
+		opts.MaxKeys = MaxListKeys
+	}
+
+	page, err := store.List(r.Context(), bucket, opts)
+	if err != nil {
+		writeError(w, err, "Error listing objects")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// StatObjectHandler handles POST /storage/{provider}/statObject and answers with the object's
+// size, storage class, metadata and, where the provider has them, tags
+func StatObjectHandler(w http.ResponseWriter, r *http.Request) {
+	store, req, ok := openStore(w, r)
+	if !ok {
+		return
+	}
+	defer store.Close()
+
+	info, err := statWithTags(r.Context(), store, req.BucketName, req.ObjectKey)
+	if err != nil {
+		writeError(w, err, "Error getting object details")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+func writeError(w http.ResponseWriter, err error, message string) {
+	if errors.Is(err, ErrNotFound) {
+		apierror.Send(w, apierror.New(http.StatusNotFound, apierror.CodeNotFound, message+": "+err.Error()))
+		return
+	}
+	apierror.Write(w, err, message)
+}
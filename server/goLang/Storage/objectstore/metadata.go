@@ -0,0 +1,233 @@
+package objectstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"btep.project/apierror"
+	"github.com/gorilla/mux"
+)
+
+// storageClassConcurrency is how many objects setStorageClass changes at once
+const storageClassConcurrency = 8
+
+// ObjectUpdater is implemented by stores that can change the metadata and storage class
+// of an existing object
+type ObjectUpdater interface {
+	UpdateObject(ctx context.Context, bucket, key string, update ObjectUpdate) error
+}
+
+// Tagger is implemented by stores whose objects carry tags next to their metadata (S3, Azure).
+// GCS objects have no tags, custom metadata serves the same purpose there.
+type Tagger interface {
+	GetTags(ctx context.Context, bucket, key string) (map[string]string, error)
+	// SetTags replaces all tags of the object, an empty map removes them
+	SetTags(ctx context.Context, bucket, key string, tags map[string]string) error
+}
+
+// ObjectUpdate lists the changes to an object, nil and empty fields are left unchanged
+type ObjectUpdate struct {
+	ContentType  *string `json:"contentType"`
+	CacheControl *string `json:"cacheControl"`
+	// Metadata replaces all custom metadata of the object, an empty map removes it
+	Metadata map[string]string `json:"metadata"`
+	// StorageClass uses the provider's names, e.g. GLACIER, COLDLINE or Archive
+	StorageClass string `json:"storageClass"`
+}
+
+// IsEmpty reports whether the update changes nothing
+func (u ObjectUpdate) IsEmpty() bool {
+	return u.ContentType == nil && u.CacheControl == nil && u.Metadata == nil && u.StorageClass == ""
+}
+
+// UpdateObjectRequest represents the JSON request structure for updateObject
+type UpdateObjectRequest struct {
+	StoreRequest
+	ObjectUpdate
+	// Tags replaces all tags of the object, an empty map removes them
+	Tags map[string]string `json:"tags"`
+}
+
+// StorageClassRequest represents the JSON request structure for setStorageClass
+type StorageClassRequest struct {
+	StoreRequest
+	StorageClass string `json:"storageClass"`
+	// DryRun counts the objects that would change without changing them
+	DryRun bool `json:"dryRun"`
+}
+
+// StorageClassResponse represents the JSON response structure for setStorageClass
+type StorageClassResponse struct {
+	Matched   int           `json:"matched"`
+	Changed   int           `json:"changed"`
+	Unchanged int           `json:"unchanged"`
+	Failed    int           `json:"failed"`
+	Errors    []ObjectError `json:"errors,omitempty"`
+}
+
+// ObjectError records why an object of a batch operation failed
+type ObjectError struct {
+	Key     string `json:"key"`
+	Message string `json:"message"`
+}
+
+// openUpdater opens the store of the route and checks that it can update objects
+func openUpdater(w http.ResponseWriter, r *http.Request, req StoreRequest) (ObjectStore, ObjectUpdater, bool) {
+	provider := mux.Vars(r)["provider"]
+	store, err := Open(r.Context(), provider, req.options())
+	if err != nil {
+		apierror.Write(w, err, "Error opening object store")
+		return nil, nil, false
+	}
+	updater, ok := store.(ObjectUpdater)
+	if !ok {
+		store.Close()
+		apierror.BadRequest(w, fmt.Sprintf("provider %s does not support updating objects", provider))
+		return nil, nil, false
+	}
+	return store, updater, true
+}
+
+// UpdateObjectHandler handles POST /storage/{provider}/updateObject and answers with the
+// object as it is afterwards
+func UpdateObjectHandler(w http.ResponseWriter, r *http.Request) {
+	var req UpdateObjectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.BadRequest(w, "Invalid request body")
+		return
+	}
+	if req.ObjectUpdate.IsEmpty() && req.Tags == nil {
+		apierror.BadRequest(w, "Nothing to update")
+		return
+	}
+	store, updater, ok := openUpdater(w, r, req.StoreRequest)
+	if !ok {
+		return
+	}
+	defer store.Close()
+
+	tagger, canTag := store.(Tagger)
+	if req.Tags != nil && !canTag {
+		apierror.BadRequest(w, fmt.Sprintf("provider %s has no object tags, use metadata instead", mux.Vars(r)["provider"]))
+		return
+	}
+	if !req.ObjectUpdate.IsEmpty() {
+		if err := updater.UpdateObject(r.Context(), req.BucketName, req.ObjectKey, req.ObjectUpdate); err != nil {
+			writeError(w, err, "Error updating object")
+			return
+		}
+	}
+	if req.Tags != nil {
+		if err := tagger.SetTags(r.Context(), req.BucketName, req.ObjectKey, req.Tags); err != nil {
+			writeError(w, err, "Error updating object tags")
+			return
+		}
+	}
+
+	info, err := statWithTags(r.Context(), store, req.BucketName, req.ObjectKey)
+	if err != nil {
+		writeError(w, err, "Error getting object details")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// SetStorageClassHandler handles POST /storage/{provider}/setStorageClass, which moves every
+// object under prefix to storageClass. Objects already in the class are left alone.
+func SetStorageClassHandler(w http.ResponseWriter, r *http.Request) {
+	var req StorageClassRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.BadRequest(w, "Invalid request body")
+		return
+	}
+	if req.StorageClass == "" {
+		apierror.BadRequest(w, "storageClass is required")
+		return
+	}
+	store, updater, ok := openUpdater(w, r, req.StoreRequest)
+	if !ok {
+		return
+	}
+	defer store.Close()
+
+	var (
+		mu   sync.Mutex
+		resp StorageClassResponse
+		wg   sync.WaitGroup
+	)
+	keys := make(chan string)
+	for i := 0; i < storageClassConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range keys {
+				err := updater.UpdateObject(r.Context(), req.BucketName, key, ObjectUpdate{StorageClass: req.StorageClass})
+				mu.Lock()
+				if err != nil {
+					resp.Failed++
+					if len(resp.Errors) < 100 {
+						resp.Errors = append(resp.Errors, ObjectError{Key: key, Message: err.Error()})
+					}
+				} else {
+					resp.Changed++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	opts := ListOptions{Prefix: req.Prefix, MaxKeys: MaxListKeys}
+	var listErr error
+	for {
+		page, err := store.List(r.Context(), req.BucketName, opts)
+		if err != nil {
+			listErr = err
+			break
+		}
+		for _, obj := range page.Objects {
+			mu.Lock()
+			resp.Matched++
+			unchanged := obj.StorageClass == req.StorageClass
+			if unchanged {
+				resp.Unchanged++
+			} else if req.DryRun {
+				resp.Changed++
+			}
+			mu.Unlock()
+			if !unchanged && !req.DryRun {
+				keys <- obj.Key
+			}
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		opts.PageToken = page.NextPageToken
+	}
+	close(keys)
+	wg.Wait()
+
+	if listErr != nil {
+		writeError(w, listErr, fmt.Sprintf("Error listing objects after changing %d", resp.Changed))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// statWithTags is Stat plus the object's tags when the store has them
+func statWithTags(ctx context.Context, store ObjectStore, bucket, key string) (*ObjectInfo, error) {
+	info, err := store.Stat(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	if tagger, ok := store.(Tagger); ok {
+		if info.Tags, err = tagger.GetTags(ctx, bucket, key); err != nil {
+			return nil, err
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,109 @@
+package objectstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"btep.project/apierror"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// DefaultURLExpiry is how long a signed URL is valid when the request does not say
+	DefaultURLExpiry = 15 * time.Minute
+	// MaxURLExpiry is the longest validity S3 and GCS V4 signatures support
+	MaxURLExpiry = 7 * 24 * time.Hour
+)
+
+// URLSigner is implemented by stores that can hand out time-limited URLs, so a browser can
+// transfer an object directly to or from the cloud instead of through the server
+type URLSigner interface {
+	SignURL(ctx context.Context, bucket, key string, opts SignOptions) (*SignedURL, error)
+}
+
+// SignOptions describes the request a signed URL allows
+type SignOptions struct {
+	// Method is http.MethodGet to download or http.MethodPut to upload
+	Method string
+	Expiry time.Duration
+	// ContentType is the only Content-Type a PUT may be sent with, a GET is served with it
+	ContentType string
+}
+
+// SignedURL is a URL the browser can call without credentials until it expires
+type SignedURL struct {
+	URL       string    `json:"url"`
+	Method    string    `json:"method"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	// Headers must be sent with the request exactly as given, they are part of the signature
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// SignedURLRequest represents the JSON request structure for signedURL
+type SignedURLRequest struct {
+	StoreRequest
+	Method      string `json:"method"`
+	ContentType string `json:"contentType"`
+	// ExpiresIn is the validity of the URL in seconds
+	ExpiresIn int `json:"expiresIn"`
+}
+
+// SignOptions validates the request and applies the defaults
+func (req SignedURLRequest) SignOptions() (SignOptions, error) {
+	opts := SignOptions{Method: req.Method, ContentType: req.ContentType, Expiry: time.Duration(req.ExpiresIn) * time.Second}
+	switch opts.Method {
+	case "":
+		opts.Method = http.MethodGet
+	case http.MethodGet, http.MethodPut:
+	default:
+		return opts, fmt.Errorf("method must be GET or PUT, not %q", req.Method)
+	}
+	if opts.Expiry == 0 {
+		opts.Expiry = DefaultURLExpiry
+	}
+	if opts.Expiry < 0 || opts.Expiry > MaxURLExpiry {
+		return opts, fmt.Errorf("expiresIn must be between 1 and %d seconds", int(MaxURLExpiry.Seconds()))
+	}
+	return opts, nil
+}
+
+// SignedURLHandler handles POST /storage/{provider}/signedURL
+func SignedURLHandler(w http.ResponseWriter, r *http.Request) {
+	var req SignedURLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.BadRequest(w, "Invalid request body")
+		return
+	}
+	opts, err := req.SignOptions()
+	if err != nil {
+		apierror.BadRequest(w, err.Error())
+		return
+	}
+	if req.BucketName == "" || req.ObjectKey == "" {
+		apierror.BadRequest(w, "bucketName and objectKey are required")
+		return
+	}
+
+	store, err := Open(r.Context(), mux.Vars(r)["provider"], req.options())
+	if err != nil {
+		apierror.Write(w, err, "Error opening object store")
+		return
+	}
+	defer store.Close()
+
+	signer, ok := store.(URLSigner)
+	if !ok {
+		apierror.BadRequest(w, fmt.Sprintf("provider %s does not support signed URLs", mux.Vars(r)["provider"]))
+		return
+	}
+	signed, err := signer.SignURL(r.Context(), req.BucketName, req.ObjectKey, opts)
+	if err != nil {
+		apierror.Write(w, err, "Error signing URL")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(signed)
+}
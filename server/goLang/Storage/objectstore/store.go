@@ -0,0 +1,123 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"btep.project/apierror"
+	db "btep.project/databaseConnection"
+)
+
+// ErrNotFound is returned by an ObjectStore when the bucket or object does not exist
+var ErrNotFound = errors.New("object not found")
+
+// ObjectStore is the provider-neutral view of a bucket/object service (S3, GCS, Azure Blob)
+type ObjectStore interface {
+	CreateBucket(ctx context.Context, bucket string) error
+	Put(ctx context.Context, bucket, key string, body io.Reader, opts PutOptions) error
+	Get(ctx context.Context, bucket, key string, opts GetOptions) (io.ReadCloser, *ObjectInfo, error)
+	Delete(ctx context.Context, bucket, key string) error
+	List(ctx context.Context, bucket string, opts ListOptions) (*ListPage, error)
+	Stat(ctx context.Context, bucket, key string) (*ObjectInfo, error)
+	Close() error
+}
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag,omitempty"`
+	ContentType  string    `json:"contentType,omitempty"`
+	LastModified time.Time `json:"lastModified"`
+	// MD5 is the digest of the content when the provider knows it, it is encoded as base64
+	MD5 []byte `json:"md5,omitempty"`
+	// CRC32C is the big-endian Castagnoli CRC32 of the content on providers that keep one (GCS),
+	// composite objects have it where they have no MD5
+	CRC32C []byte `json:"crc32c,omitempty"`
+	// StorageClass is the provider's own name, e.g. STANDARD_IA on S3, NEARLINE on GCS, Cool on Azure
+	StorageClass string `json:"storageClass,omitempty"`
+	// CacheControl and Metadata are only filled in by Stat
+	CacheControl string            `json:"cacheControl,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	// Tags are filled in by the statObject route for stores that implement Tagger
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// ContentRange is the part of the object a ranged Get returned, Size stays the full size
+	ContentRange *ByteRange `json:"-"`
+}
+
+// ListOptions selects one page of a listing
+type ListOptions struct {
+	Prefix string
+	// Delimiter groups keys below the next delimiter into CommonPrefixes, "/" browses folders
+	Delimiter string
+	// PageToken is the NextPageToken of the previous page, empty for the first page
+	PageToken string
+	// MaxKeys bounds the entries of the page, 0 means MaxListKeys
+	MaxKeys int
+}
+
+// MaxListKeys is the largest page every provider returns
+const MaxListKeys = 1000
+
+// ListPage is one page of a listing
+type ListPage struct {
+	Objects        []ObjectInfo `json:"objects"`
+	CommonPrefixes []string     `json:"commonPrefixes"`
+	// NextPageToken is empty on the last page
+	NextPageToken string `json:"nextPageToken,omitempty"`
+}
+
+// PutOptions carries optional attributes for an uploaded object
+type PutOptions struct {
+	ContentType string
+	Size        int64 // -1 when unknown
+	// MD5 of the content, when known the provider stores it and, where supported, verifies the upload
+	MD5 []byte
+}
+
+// Options identifies which cloud account (and provider specific location) a store is opened for
+type Options struct {
+	AccountID int
+	Region    string
+
+	// Azure only: the storage account and its resource group hold the containers
+	AccountName   string
+	ResourceGroup string
+}
+
+// Opener builds an ObjectStore for a cloud account
+type Opener func(ctx context.Context, account *db.CloudAccount, opts Options) (ObjectStore, error)
+
+var (
+	mu      sync.RWMutex
+	openers = make(map[string]Opener)
+)
+
+// Register makes a provider implementation available under the given name (e.g. "aws", "gcp", "azure")
+func Register(provider string, open Opener) {
+	mu.Lock()
+	defer mu.Unlock()
+	openers[provider] = open
+}
+
+// Open looks up the cloud account and returns the ObjectStore registered for provider
+func Open(ctx context.Context, provider string, opts Options) (ObjectStore, error) {
+	mu.RLock()
+	open, ok := openers[provider]
+	mu.RUnlock()
+	if !ok {
+		return nil, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, fmt.Sprintf("unknown storage provider %q", provider))
+	}
+
+	cloudAccount, err := db.GetCloudAccountDetails(opts.AccountID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting cloud account details: %w", err)
+	}
+	return open(ctx, cloudAccount, opts)
+}
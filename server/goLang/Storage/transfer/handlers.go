@@ -0,0 +1,68 @@
+package transfer
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"btep.project/apierror"
+	"btep.project/middleware"
+	"github.com/gorilla/mux"
+)
+
+// SyncRequest represents the JSON request structure for sync
+type SyncRequest struct {
+	Source      Endpoint `json:"source"`
+	Destination Endpoint `json:"destination"`
+	DryRun      bool     `json:"dryRun"`
+	Concurrency int      `json:"concurrency"`
+}
+
+// userID is the authenticated user, 0 when the route is not behind the Authenticator
+func userID(r *http.Request) int {
+	if claims, ok := middleware.ClaimsFromContext(r.Context()); ok {
+		return claims.UserID
+	}
+	return 0
+}
+
+// SyncHandler handles POST /transfer/sync. It answers 202 with the job as soon as both
+// stores are open, the progress is then polled through JobHandler.
+func SyncHandler(w http.ResponseWriter, r *http.Request) {
+	var req SyncRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.BadRequest(w, "Invalid request body")
+		return
+	}
+
+	job, err := Start(r.Context(), userID(r), req.Source, req.Destination, Options{DryRun: req.DryRun, Concurrency: req.Concurrency})
+	if err != nil {
+		apierror.Write(w, err, "Error starting sync")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job.Snapshot())
+}
+
+// JobHandler handles GET /transfer/jobs/{jobID}
+func JobHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := Lookup(mux.Vars(r)["jobID"], userID(r))
+	if !ok {
+		apierror.Send(w, apierror.New(http.StatusNotFound, apierror.CodeNotFound, "Job not found"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job.Snapshot())
+}
+
+// CancelJobHandler handles POST /transfer/jobs/{jobID}/cancel
+func CancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := Lookup(mux.Vars(r)["jobID"], userID(r))
+	if !ok {
+		apierror.Send(w, apierror.New(http.StatusNotFound, apierror.CodeNotFound, "Job not found"))
+		return
+	}
+	job.Cancel()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job.Snapshot())
+}
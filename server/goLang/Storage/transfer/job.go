@@ -0,0 +1,203 @@
+// Package transfer copies objects between buckets of any providers registered with objectstore.
+//
+// A sync job lists a source bucket/prefix and streams every object that is missing or differs
+// at the destination through the server, verifying the MD5 of what was written. An object of
+// the same size whose content cannot be compared is reported as unverifiable and left alone.
+// Jobs run in the background and are kept in memory, so their progress is lost when the server
+// restarts.
+package transfer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"btep.project/Storage/objectstore"
+)
+
+// Status of a job
+type Status string
+
+const (
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+const (
+	// DefaultConcurrency is the number of objects copied at once when the request does not say
+	DefaultConcurrency = 4
+	// MaxConcurrency caps the objects copied at once by a single job
+	MaxConcurrency = 32
+	// maxErrors is how many object errors a job keeps, later ones are only counted
+	maxErrors = 100
+	// jobRetention is how long finished jobs can still be queried
+	jobRetention = 24 * time.Hour
+)
+
+// Endpoint is a bucket and prefix on one provider
+type Endpoint struct {
+	Provider      string `json:"provider"`
+	AccountID     int    `json:"accountID"`
+	Region        string `json:"region,omitempty"`
+	AccountName   string `json:"accountName,omitempty"`
+	ResourceGroup string `json:"resourceGroup,omitempty"`
+	BucketName    string `json:"bucketName"`
+	Prefix        string `json:"prefix,omitempty"`
+}
+
+func (e Endpoint) options() objectstore.Options {
+	return objectstore.Options{
+		AccountID:     e.AccountID,
+		Region:        e.Region,
+		AccountName:   e.AccountName,
+		ResourceGroup: e.ResourceGroup,
+	}
+}
+
+// Progress counts what a job has done so far
+type Progress struct {
+	// ObjectsListed and BytesListed cover every source object seen
+	ObjectsListed int64 `json:"objectsListed"`
+	BytesListed   int64 `json:"bytesListed"`
+	// ObjectsToCopy and BytesToCopy cover the objects missing or different at the destination,
+	// for a dry run they are the whole result
+	ObjectsToCopy int64 `json:"objectsToCopy"`
+	BytesToCopy   int64 `json:"bytesToCopy"`
+	// ObjectsSkipped are identical at the destination already
+	ObjectsSkipped int64 `json:"objectsSkipped"`
+	// ObjectsUnverifiable have an object of the same size at the destination but no checksum
+	// both providers keep, e.g. an S3 multipart object synced to GCS. They are not copied.
+	ObjectsUnverifiable int64 `json:"objectsUnverifiable"`
+	ObjectsCopied       int64 `json:"objectsCopied"`
+	ObjectsFailed       int64 `json:"objectsFailed"`
+	// BytesTransferred grows while objects stream, so it also moves during large copies
+	BytesTransferred int64 `json:"bytesTransferred"`
+}
+
+// ObjectError records why an object could not be copied
+type ObjectError struct {
+	Key     string `json:"key"`
+	Message string `json:"message"`
+}
+
+// Job is a sync from Source to Destination
+type Job struct {
+	ID          string   `json:"jobID"`
+	Status      Status   `json:"status"`
+	DryRun      bool     `json:"dryRun"`
+	Concurrency int      `json:"concurrency"`
+	Source      Endpoint `json:"source"`
+	Destination Endpoint `json:"destination"`
+	Progress    Progress `json:"progress"`
+	// Error is why the job failed as a whole, e.g. the source could not be listed
+	Error  string        `json:"error,omitempty"`
+	Errors []ObjectError `json:"errors,omitempty"`
+	// Unverifiable holds the destination keys of the first unverifiable objects
+	Unverifiable []string   `json:"unverifiable,omitempty"`
+	StartedAt    time.Time  `json:"startedAt"`
+	FinishedAt   *time.Time `json:"finishedAt,omitempty"`
+
+	userID int
+	cancel context.CancelFunc
+	mu     sync.Mutex
+}
+
+// Snapshot returns a copy of the job that is safe to encode while the job runs
+func (j *Job) Snapshot() *Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return &Job{
+		ID:           j.ID,
+		Status:       j.Status,
+		DryRun:       j.DryRun,
+		Concurrency:  j.Concurrency,
+		Source:       j.Source,
+		Destination:  j.Destination,
+		Progress:     j.Progress,
+		Error:        j.Error,
+		Errors:       append([]ObjectError(nil), j.Errors...),
+		Unverifiable: append([]string(nil), j.Unverifiable...),
+		StartedAt:    j.StartedAt,
+		FinishedAt:   j.FinishedAt,
+	}
+}
+
+// Cancel stops a running job, objects already copied stay at the destination
+func (j *Job) Cancel() {
+	j.cancel()
+}
+
+func (j *Job) update(f func(p *Progress)) {
+	j.mu.Lock()
+	f(&j.Progress)
+	j.mu.Unlock()
+}
+
+func (j *Job) fail(key string, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Progress.ObjectsFailed++
+	if len(j.Errors) < maxErrors {
+		j.Errors = append(j.Errors, ObjectError{Key: key, Message: err.Error()})
+	}
+}
+
+func (j *Job) unverifiable(key string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Progress.ObjectsUnverifiable++
+	if len(j.Unverifiable) < maxErrors {
+		j.Unverifiable = append(j.Unverifiable, key)
+	}
+}
+
+func (j *Job) finish(status Status, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now().UTC()
+	j.Status, j.FinishedAt = status, &now
+	if err != nil {
+		j.Error = err.Error()
+	}
+}
+
+var (
+	mu   sync.Mutex
+	jobs = make(map[string]*Job)
+)
+
+// Lookup returns the job with the given ID if it was started by userID
+func Lookup(id string, userID int) (*Job, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	job, ok := jobs[id]
+	if !ok || job.userID != userID {
+		return nil, false
+	}
+	return job, true
+}
+
+// register adds job to the registry and forgets jobs that finished more than jobRetention ago
+func register(job *Job) {
+	mu.Lock()
+	defer mu.Unlock()
+	for id, old := range jobs {
+		old.mu.Lock()
+		expired := old.FinishedAt != nil && time.Since(*old.FinishedAt) > jobRetention
+		old.mu.Unlock()
+		if expired {
+			delete(jobs, id)
+		}
+	}
+	jobs[job.ID] = job
+}
+
+func newJobID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,251 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"btep.project/Storage/objectstore"
+	"btep.project/apierror"
+)
+
+// Options tune a sync job
+type Options struct {
+	// DryRun lists what would be copied without writing to the destination
+	DryRun bool
+	// Concurrency is the number of objects copied at once, 0 means DefaultConcurrency
+	Concurrency int
+}
+
+// Start opens the source and destination stores and syncs them in the background.
+// The returned job belongs to userID, who alone can look it up and cancel it.
+func Start(ctx context.Context, userID int, source, destination Endpoint, opts Options) (*Job, error) {
+	if source.BucketName == "" || destination.BucketName == "" {
+		return nil, invalid("source and destination bucketName are required")
+	}
+	if overlaps(source, destination) {
+		return nil, invalid("source and destination overlap, a sync would copy its own output")
+	}
+	if opts.Concurrency < 0 || opts.Concurrency > MaxConcurrency {
+		return nil, invalid(fmt.Sprintf("concurrency must be between 1 and %d", MaxConcurrency))
+	}
+	if opts.Concurrency == 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+
+	src, err := objectstore.Open(ctx, source.Provider, source.options())
+	if err != nil {
+		return nil, fmt.Errorf("error opening source: %w", err)
+	}
+	dst, err := objectstore.Open(ctx, destination.Provider, destination.options())
+	if err != nil {
+		src.Close()
+		return nil, fmt.Errorf("error opening destination: %w", err)
+	}
+
+	// The job outlives the request that started it
+	jobCtx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		ID:          newJobID(),
+		Status:      StatusRunning,
+		DryRun:      opts.DryRun,
+		Concurrency: opts.Concurrency,
+		Source:      source,
+		Destination: destination,
+		StartedAt:   time.Now().UTC(),
+		userID:      userID,
+		cancel:      cancel,
+	}
+	register(job)
+	go job.run(jobCtx, src, dst)
+	return job, nil
+}
+
+func invalid(message string) error {
+	return apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, message)
+}
+
+// overlaps reports whether one endpoint lies inside the other
+func overlaps(a, b Endpoint) bool {
+	if a.Provider != b.Provider || a.AccountID != b.AccountID || a.AccountName != b.AccountName || a.BucketName != b.BucketName {
+		return false
+	}
+	return strings.HasPrefix(a.Prefix, b.Prefix) || strings.HasPrefix(b.Prefix, a.Prefix)
+}
+
+func (j *Job) run(ctx context.Context, src, dst objectstore.ObjectStore) {
+	defer src.Close()
+	defer dst.Close()
+
+	objects := make(chan objectstore.ObjectInfo)
+	var wg sync.WaitGroup
+	for i := 0; i < j.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for obj := range objects {
+				err := j.syncObject(ctx, src, dst, obj)
+				// Objects interrupted by Cancel are not failures
+				if err != nil && ctx.Err() == nil {
+					j.fail(obj.Key, err)
+				}
+			}
+		}()
+	}
+	err := j.list(ctx, src, objects)
+	close(objects)
+	wg.Wait()
+
+	cancelled := ctx.Err() != nil
+	j.cancel()
+	failed := j.Snapshot().Progress.ObjectsFailed
+	switch {
+	case cancelled:
+		j.finish(StatusCancelled, nil)
+	case err != nil:
+		j.finish(StatusFailed, err)
+	case failed > 0:
+		j.finish(StatusFailed, fmt.Errorf("%d objects could not be copied", failed))
+	default:
+		j.finish(StatusCompleted, nil)
+	}
+}
+
+// list feeds every object under the source prefix to the workers, page by page
+func (j *Job) list(ctx context.Context, src objectstore.ObjectStore, objects chan<- objectstore.ObjectInfo) error {
+	opts := objectstore.ListOptions{Prefix: j.Source.Prefix, MaxKeys: objectstore.MaxListKeys}
+	for {
+		page, err := src.List(ctx, j.Source.BucketName, opts)
+		if err != nil {
+			return fmt.Errorf("error listing source: %w", err)
+		}
+		for _, obj := range page.Objects {
+			j.update(func(p *Progress) {
+				p.ObjectsListed++
+				p.BytesListed += obj.Size
+			})
+			select {
+			case objects <- obj:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if page.NextPageToken == "" {
+			return nil
+		}
+		opts.PageToken = page.NextPageToken
+	}
+}
+
+// syncObject copies obj unless the destination already holds an identical object
+func (j *Job) syncObject(ctx context.Context, src, dst objectstore.ObjectStore, obj objectstore.ObjectInfo) error {
+	key := j.Destination.Prefix + strings.TrimPrefix(obj.Key, j.Source.Prefix)
+	existing, err := dst.Stat(ctx, j.Destination.BucketName, key)
+	if err != nil && !errors.Is(err, objectstore.ErrNotFound) {
+		return fmt.Errorf("error checking destination: %w", err)
+	}
+	if err == nil && existing.Size == obj.Size {
+		same, known := j.compare(obj, *existing)
+		if !known {
+			// Listings do not carry every checksum on every provider, the object's metadata may
+			if info, err := src.Stat(ctx, j.Source.BucketName, obj.Key); err == nil {
+				obj.MD5, obj.CRC32C = info.MD5, info.CRC32C
+				same, known = j.compare(obj, *existing)
+			}
+		}
+		if same {
+			j.update(func(p *Progress) { p.ObjectsSkipped++ })
+			return nil
+		}
+		if !known {
+			j.unverifiable(key)
+			return nil
+		}
+	}
+
+	j.update(func(p *Progress) {
+		p.ObjectsToCopy++
+		p.BytesToCopy += obj.Size
+	})
+	if j.DryRun {
+		return nil
+	}
+	if err := j.copyObject(ctx, src, dst, obj.Key, key); err != nil {
+		return err
+	}
+	j.update(func(p *Progress) { p.ObjectsCopied++ })
+	return nil
+}
+
+// s3Providers keep S3 ETags, a multipart ETag is the same for the same content in the same parts
+var s3Providers = map[string]bool{"aws": true, "private": true}
+
+// compare tells whether src and dst, of equal size, hold the same content. It compares the
+// MD5s, else the CRC32Cs, else the multipart ETags of two S3 objects; known is false when the
+// two sides keep no checksum in common. Equal sizes alone do not prove equal content.
+func (j *Job) compare(src, dst objectstore.ObjectInfo) (same, known bool) {
+	switch {
+	case len(src.MD5) > 0 && len(dst.MD5) > 0:
+		return bytes.Equal(src.MD5, dst.MD5), true
+	case len(src.CRC32C) > 0 && len(dst.CRC32C) > 0:
+		return bytes.Equal(src.CRC32C, dst.CRC32C), true
+	case s3Providers[j.Source.Provider] && s3Providers[j.Destination.Provider] && strings.Contains(src.ETag, "-"):
+		// Parts of another size give another ETag, the object is then copied again
+		return src.ETag == dst.ETag, true
+	}
+	return false, false
+}
+
+// copyObject streams one object and verifies the copy against the MD5 of the bytes read.
+// A copy that fails verification is deleted, so it cannot be skipped as identical later.
+func (j *Job) copyObject(ctx context.Context, src, dst objectstore.ObjectStore, srcKey, dstKey string) error {
+	body, info, err := src.Get(ctx, j.Source.BucketName, srcKey, objectstore.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error reading source: %w", err)
+	}
+	defer body.Close()
+
+	hash := md5.New()
+	reader := io.TeeReader(&progressReader{r: body, job: j}, hash)
+	opts := objectstore.PutOptions{ContentType: info.ContentType, Size: info.Size, MD5: info.MD5}
+	if err := dst.Put(ctx, j.Destination.BucketName, dstKey, reader, opts); err != nil {
+		return fmt.Errorf("error writing destination: %w", err)
+	}
+	sum := hash.Sum(nil)
+
+	if len(info.MD5) > 0 && !bytes.Equal(sum, info.MD5) {
+		dst.Delete(ctx, j.Destination.BucketName, dstKey)
+		return fmt.Errorf("checksum mismatch: read MD5 %x from the source, which stores %x", sum, info.MD5)
+	}
+	written, err := dst.Stat(ctx, j.Destination.BucketName, dstKey)
+	if err != nil {
+		return fmt.Errorf("error verifying destination: %w", err)
+	}
+	if written.Size != info.Size || (len(written.MD5) > 0 && !bytes.Equal(written.MD5, sum)) {
+		dst.Delete(ctx, j.Destination.BucketName, dstKey)
+		return fmt.Errorf("checksum mismatch: the destination holds %d bytes with MD5 %x, %d bytes with MD5 %x were sent",
+			written.Size, written.MD5, info.Size, sum)
+	}
+	return nil
+}
+
+// progressReader adds the bytes read to the job's progress
+type progressReader struct {
+	r   io.Reader
+	job *Job
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.job.update(func(progress *Progress) { progress.BytesTransferred += int64(n) })
+	}
+	return n, err
+}
@@ -0,0 +1,138 @@
+// Package apierror writes the JSON error envelope every handler responds with.
+//
+// A failed request always gets a body like
+//
+//	{"code": "not_found", "message": "Error deleting bucket: NoSuchBucket: ...", "provider": "aws",
+//	 "providerRequestId": "4442587FB7D0A2F9", "retryable": false}
+//
+// Errors returned by the AWS, GCP and Azure SDKs are mapped to the HTTP status that matches
+// their meaning, so the frontend can tell a missing resource from a throttled or broken call.
+package apierror
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"btep.project/auth/vault"
+	db "btep.project/databaseConnection"
+)
+
+// Codes of the error envelope
+const (
+	CodeInvalidRequest     = "invalid_request"
+	CodeUnauthorized       = "unauthorized"
+	CodeLoginRequired      = "login_required"
+	CodeForbidden          = "forbidden"
+	CodeNotFound           = "not_found"
+	CodeConflict           = "conflict"
+	CodePreconditionFailed = "precondition_failed"
+	CodeInvalidRange       = "invalid_range"
+	CodeThrottled          = "throttled"
+	CodeTimeout            = "timeout"
+	CodeProviderError      = "provider_error"
+	CodeUnavailable        = "provider_unavailable"
+	CodeInternal           = "internal"
+)
+
+// Error is the JSON body of every failed request
+type Error struct {
+	Code              string `json:"code"`
+	Message           string `json:"message"`
+	Provider          string `json:"provider,omitempty"`
+	ProviderRequestID string `json:"providerRequestId,omitempty"`
+	Retryable         bool   `json:"retryable"`
+
+	// Status is the HTTP status the envelope is sent with
+	Status int `json:"-"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// New creates an error that is not caused by a cloud provider
+func New(status int, code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+// From maps err to an envelope. Provider errors keep the meaning of their status,
+// errors nobody recognizes are internal errors.
+func From(err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		copied := *apiErr
+		return &copied
+	}
+
+	switch {
+	case errors.Is(err, vault.ErrLoginRequired):
+		return New(http.StatusUnauthorized, CodeLoginRequired, err.Error())
+	case errors.Is(err, db.ErrAccountNotFound):
+		return New(http.StatusNotFound, CodeNotFound, err.Error())
+	}
+
+	for _, mapper := range []func(error) *Error{fromAWS, fromGoogle, fromAzure} {
+		if e := mapper(err); e != nil {
+			return e
+		}
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return &Error{Status: http.StatusGatewayTimeout, Code: CodeTimeout, Message: err.Error(), Retryable: true}
+	case errors.Is(err, context.Canceled):
+		return New(http.StatusServiceUnavailable, CodeUnavailable, err.Error())
+	}
+	return New(http.StatusInternalServerError, CodeInternal, err.Error())
+}
+
+// Send writes e as the response
+func Send(w http.ResponseWriter, e *Error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(e)
+}
+
+// Write responds with the envelope of err, message describes the operation that failed
+func Write(w http.ResponseWriter, err error, message string) {
+	e := From(err)
+	if message != "" {
+		e.Message = message + ": " + e.Message
+	}
+	Send(w, e)
+}
+
+// BadRequest responds 400 for a request the handler cannot process
+func BadRequest(w http.ResponseWriter, message string) {
+	Send(w, New(http.StatusBadRequest, CodeInvalidRequest, message))
+}
+
+// fromStatus maps the HTTP status a provider answered with to the status of the envelope
+func fromStatus(status int) (int, string, bool) {
+	switch {
+	case status == http.StatusBadRequest || status == http.StatusUnprocessableEntity:
+		return http.StatusBadRequest, CodeInvalidRequest, false
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		// The provider rejected the account's credentials, not the caller's session
+		return http.StatusForbidden, CodeForbidden, false
+	case status == http.StatusNotFound:
+		return http.StatusNotFound, CodeNotFound, false
+	case status == http.StatusConflict:
+		return http.StatusConflict, CodeConflict, false
+	case status == http.StatusPreconditionFailed:
+		return http.StatusPreconditionFailed, CodePreconditionFailed, false
+	case status == http.StatusRequestedRangeNotSatisfiable:
+		return http.StatusRequestedRangeNotSatisfiable, CodeInvalidRange, false
+	case status == http.StatusTooManyRequests:
+		return http.StatusTooManyRequests, CodeThrottled, true
+	case status == http.StatusServiceUnavailable:
+		return http.StatusServiceUnavailable, CodeUnavailable, true
+	case status >= 500:
+		return http.StatusBadGateway, CodeProviderError, true
+	case status >= 400:
+		return status, CodeInvalidRequest, false
+	}
+	return http.StatusBadGateway, CodeProviderError, false
+}
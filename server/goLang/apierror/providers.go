@@ -0,0 +1,166 @@
+package apierror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AWS answers 400 for most client errors, so the error code decides before the status does
+func awsCodeStatus(code string) (int, string, bool) {
+	switch {
+	case strings.Contains(code, "NotFound") || strings.HasPrefix(code, "NoSuch"):
+		return http.StatusNotFound, CodeNotFound, true
+	case strings.Contains(code, "AlreadyExists") || strings.Contains(code, "AlreadyOwnedByYou"),
+		code == "ResourceInUseException", code == "ResourceConflictException",
+		code == "ConditionalCheckFailedException", code == "TransactionConflictException",
+		code == "TransactionCanceledException", code == "DependencyViolation",
+		code == "OperationAborted", code == "BucketNotEmpty":
+		return http.StatusConflict, CodeConflict, true
+	case strings.HasPrefix(code, "AccessDenied"), code == "UnauthorizedOperation", code == "AuthFailure",
+		code == "InvalidClientTokenId", code == "UnrecognizedClientException",
+		code == "SignatureDoesNotMatch", code == "ExpiredToken", code == "InvalidAccessKeyId":
+		return http.StatusForbidden, CodeForbidden, true
+	case code == "PreconditionFailed":
+		return http.StatusPreconditionFailed, CodePreconditionFailed, true
+	case code == "InvalidRange":
+		return http.StatusRequestedRangeNotSatisfiable, CodeInvalidRange, true
+	}
+	return 0, "", false
+}
+
+func fromAWS(err error) *Error {
+	var awsErr awserr.Error
+	if !errors.As(err, &awsErr) {
+		return nil
+	}
+	e := &Error{
+		Provider:  "aws",
+		Message:   fmt.Sprintf("%s: %s", awsErr.Code(), awsErr.Message()),
+		Retryable: request.IsErrorRetryable(err),
+	}
+
+	var failure awserr.RequestFailure
+	if errors.As(err, &failure) {
+		e.ProviderRequestID = failure.RequestID()
+	}
+	if request.IsErrorThrottle(err) {
+		e.Status, e.Code, e.Retryable = http.StatusTooManyRequests, CodeThrottled, true
+		return e
+	}
+	if status, code, ok := awsCodeStatus(awsErr.Code()); ok {
+		e.Status, e.Code = status, code
+		return e
+	}
+	if failure != nil {
+		var retryable bool
+		e.Status, e.Code, retryable = fromStatus(failure.StatusCode())
+		e.Retryable = e.Retryable || retryable
+		return e
+	}
+	// Without a response the request never reached AWS, e.g. a network error
+	e.Status, e.Code = http.StatusBadGateway, CodeProviderError
+	return e
+}
+
+func fromGoogle(err error) *Error {
+	switch {
+	case errors.Is(err, storage.ErrBucketNotExist), errors.Is(err, storage.ErrObjectNotExist):
+		return &Error{Status: http.StatusNotFound, Code: CodeNotFound, Provider: "gcp", Message: err.Error()}
+	}
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		e := &Error{Provider: "gcp", Message: apiErr.Message}
+		if e.Message == "" {
+			e.Message = apiErr.Error()
+		}
+		e.Status, e.Code, e.Retryable = fromStatus(apiErr.Code)
+		return e
+	}
+
+	// Firestore and the other gRPC based clients return status errors
+	st, ok := status.FromError(err)
+	if !ok || st.Code() == codes.OK || st.Code() == codes.Unknown {
+		return nil
+	}
+	e := &Error{Provider: "gcp", Message: st.Message()}
+	switch st.Code() {
+	case codes.NotFound:
+		e.Status, e.Code = http.StatusNotFound, CodeNotFound
+	case codes.AlreadyExists:
+		e.Status, e.Code = http.StatusConflict, CodeConflict
+	case codes.Aborted:
+		e.Status, e.Code, e.Retryable = http.StatusConflict, CodeConflict, true
+	case codes.PermissionDenied, codes.Unauthenticated:
+		e.Status, e.Code = http.StatusForbidden, CodeForbidden
+	case codes.ResourceExhausted:
+		e.Status, e.Code, e.Retryable = http.StatusTooManyRequests, CodeThrottled, true
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		e.Status, e.Code = http.StatusBadRequest, CodeInvalidRequest
+	case codes.Unavailable:
+		e.Status, e.Code, e.Retryable = http.StatusServiceUnavailable, CodeUnavailable, true
+	case codes.DeadlineExceeded:
+		e.Status, e.Code, e.Retryable = http.StatusGatewayTimeout, CodeTimeout, true
+	default:
+		e.Status, e.Code = http.StatusBadGateway, CodeProviderError
+	}
+	return e
+}
+
+func fromAzure(err error) *Error {
+	var storageErr azblob.StorageError
+	if errors.As(err, &storageErr) {
+		e := &Error{Provider: "azure", Message: fmt.Sprintf("%s: %s", storageErr.ServiceCode(), storageErr.Error())}
+		if resp := storageErr.Response(); resp != nil {
+			e.ProviderRequestID = resp.Header.Get("x-ms-request-id")
+			e.Status, e.Code, e.Retryable = fromStatus(resp.StatusCode)
+		} else {
+			e.Status, e.Code = http.StatusBadGateway, CodeProviderError
+		}
+		return e
+	}
+
+	var requestErr *azure.RequestError
+	if errors.As(err, &requestErr) {
+		e := &Error{Provider: "azure", Message: requestErr.Error(), ProviderRequestID: requestErr.RequestID}
+		if requestErr.ServiceError != nil {
+			e.Message = fmt.Sprintf("%s: %s", requestErr.ServiceError.Code, requestErr.ServiceError.Message)
+		}
+		e.Status, e.Code, e.Retryable = fromStatus(azureStatus(requestErr.DetailedError))
+		return e
+	}
+
+	var detailed autorest.DetailedError
+	if errors.As(err, &detailed) {
+		e := &Error{Provider: "azure", Message: detailed.Error()}
+		if detailed.Response != nil {
+			e.ProviderRequestID = detailed.Response.Header.Get("x-ms-request-id")
+		}
+		e.Status, e.Code, e.Retryable = fromStatus(azureStatus(detailed))
+		return e
+	}
+	return nil
+}
+
+// azureStatus reads the status autorest stores as an interface{}
+func azureStatus(detailed autorest.DetailedError) int {
+	if code, ok := detailed.StatusCode.(int); ok && code != 0 {
+		return code
+	}
+	if detailed.Response != nil {
+		return detailed.Response.StatusCode
+	}
+	return 0
+}
@@ -0,0 +1,216 @@
+// Package awsauth builds the AWS sessions used by the S3, EC2, DynamoDB, VPC, ECS, EKS and Lambda packages.
+//
+// An account authenticates with its AccessKey/SecretKey. When it also stores a RoleARN the
+// server assumes that role through STS with those keys, so they only need sts:AssumeRole.
+// Roles are never assumed with the server's own AWS identity: the RoleARN and ExternalID come
+// from the user, and any role that trusts the server would be open to every user. Temporary
+// role credentials are refreshed shortly before they expire.
+//
+// An account with an Endpoint talks to an S3-compatible service such as MinIO, Ceph or
+// LocalStack instead of AWS. Accounts of the "private" provider are such services and must
+// have one. Only S3 requests go to the Endpoint, STS and every other service stay on AWS. The
+// Endpoint is user input, so the server does not connect to it on loopback, link-local or
+// unspecified addresses, nor on private ones unless ALLOW_PRIVATE_ENDPOINTS is set.
+package awsauth
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	db "btep.project/databaseConnection"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+const (
+	// roleSessionDuration is how long assumed role credentials are requested for
+	roleSessionDuration = time.Hour
+	// expiryWindow refreshes role credentials this long before they expire
+	expiryWindow = 2 * time.Minute
+	// endpointRegion signs requests to custom endpoints when the account has no region,
+	// S3-compatible services mostly ignore it but the SDK cannot sign without one
+	endpointRegion = "us-east-1"
+)
+
+// PrivateProvider is the CloudProvider of self-hosted S3-compatible clouds
+const PrivateProvider = "private"
+
+type sessionKey struct {
+	accountID int
+	region    string
+}
+
+type cachedSession struct {
+	fingerprint string
+	session     *session.Session
+}
+
+var (
+	mu       sync.Mutex
+	sessions = make(map[sessionKey]cachedSession)
+	// allowPrivateEndpoints lets endpoints resolve to private network addresses
+	allowPrivateEndpoints atomic.Bool
+	// endpointClient sends the requests of accounts with an Endpoint, it refuses the
+	// addresses the server must not reach for a user
+	endpointClient = &http.Client{Transport: endpointTransport()}
+)
+
+// Config holds the operator's settings for custom endpoints
+type Config struct {
+	// AllowPrivateEndpoints lets endpoints be on private networks, as self-hosted clouds often are
+	AllowPrivateEndpoints bool
+}
+
+// ConfigFromEnv reads the Config from ALLOW_PRIVATE_ENDPOINTS
+func ConfigFromEnv() (Config, error) {
+	var cfg Config
+	if allow := os.Getenv("ALLOW_PRIVATE_ENDPOINTS"); allow != "" {
+		b, err := strconv.ParseBool(allow)
+		if err != nil {
+			return Config{}, fmt.Errorf("ALLOW_PRIVATE_ENDPOINTS: %v", err)
+		}
+		cfg.AllowPrivateEndpoints = b
+	}
+	return cfg, nil
+}
+
+// Configure applies cfg to the connections made from now on
+func Configure(cfg Config) {
+	allowPrivateEndpoints.Store(cfg.AllowPrivateEndpoints)
+}
+
+// Session returns a session for accountID in region, the account's Region when region is empty.
+// Sessions are cached per account and region and rebuilt when the stored credentials change.
+func Session(accountID int, region string) (*session.Session, error) {
+	account, err := db.GetCloudAccountDetails(accountID)
+	if err != nil {
+		return nil, err
+	}
+	return SessionFor(account, region)
+}
+
+// SessionFor is Session for an account that was already looked up
+func SessionFor(account *db.CloudAccount, region string) (*session.Session, error) {
+	if region == "" {
+		region = account.Region.String
+	}
+	key := sessionKey{accountID: account.AccountID, region: region}
+	fingerprint := fmt.Sprintf("%s\x00%s\x00%s\x00%s\x00%s\x00%t", account.AccessKey.String, account.SecretKey.String,
+		account.RoleARN.String, account.ExternalID.String, account.Endpoint.String, account.UsePathStyle())
+
+	mu.Lock()
+	defer mu.Unlock()
+	if cached, ok := sessions[key]; ok && cached.fingerprint == fingerprint {
+		return cached.session, nil
+	}
+
+	sess, err := newSession(account, region)
+	if err != nil {
+		return nil, err
+	}
+	sessions[key] = cachedSession{fingerprint: fingerprint, session: sess}
+	return sess, nil
+}
+
+func newSession(account *db.CloudAccount, region string) (*session.Session, error) {
+	config := &aws.Config{Region: aws.String(region)}
+	if err := setEndpoint(config, account); err != nil {
+		return nil, err
+	}
+	if account.AccessKey.String == "" {
+		return nil, fmt.Errorf("account %d has no AccessKey, the server does not act for it with its own AWS identity", account.AccountID)
+	}
+	config.Credentials = credentials.NewStaticCredentials(account.AccessKey.String, account.SecretKey.String, "")
+
+	base, err := session.NewSession(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating AWS session: %v", err)
+	}
+	if account.RoleARN.String == "" {
+		return base, nil
+	}
+
+	roleCredentials := stscreds.NewCredentials(base, account.RoleARN.String, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = fmt.Sprintf("multicloud-account-%d", account.AccountID)
+		p.Duration = roleSessionDuration
+		p.ExpiryWindow = expiryWindow
+		if account.ExternalID.String != "" {
+			p.ExternalID = aws.String(account.ExternalID.String)
+		}
+	})
+	return base.Copy(&aws.Config{Credentials: roleCredentials}), nil
+}
+
+// setEndpoint points the S3 requests of config at the account's S3-compatible endpoint, if it
+// has one
+func setEndpoint(config *aws.Config, account *db.CloudAccount) error {
+	endpoint := account.Endpoint.String
+	if endpoint == "" {
+		if strings.EqualFold(account.CloudProvider, PrivateProvider) {
+			return fmt.Errorf("private cloud account %d has no Endpoint", account.AccountID)
+		}
+		return nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("account %d has an invalid Endpoint %q, it needs to be an http or https URL", account.AccountID, endpoint)
+	}
+	if ip := net.ParseIP(u.Hostname()); (ip != nil && !endpointAllowed(ip)) || strings.EqualFold(u.Hostname(), "localhost") {
+		return fmt.Errorf("account %d has an Endpoint %q the server does not connect to", account.AccountID, endpoint)
+	}
+	config.EndpointResolver = endpoints.ResolverFunc(func(service, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+		if service != endpoints.S3ServiceID {
+			return endpoints.DefaultResolver().EndpointFor(service, region, opts...)
+		}
+		return endpoints.ResolvedEndpoint{URL: endpoint, SigningRegion: region, SigningName: service}, nil
+	})
+	config.HTTPClient = endpointClient
+	config.S3ForcePathStyle = aws.Bool(account.UsePathStyle())
+	if aws.StringValue(config.Region) == "" {
+		config.Region = aws.String(endpointRegion)
+	}
+	return nil
+}
+
+// endpointTransport dials only addresses endpointAllowed accepts. The check runs on the address
+// that is connected to, so a host name cannot resolve around it. Proxies are not used, they
+// would connect on the server's behalf.
+func endpointTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !endpointAllowed(ip) {
+				return fmt.Errorf("connecting to %s is not allowed for a custom endpoint", host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return transport
+}
+
+func endpointAllowed(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return false
+	}
+	return !ip.IsPrivate() || allowPrivateEndpoints.Load()
+}
@@ -11,6 +11,7 @@ import (
 	"net/url"
 	"strconv"
 
+	"btep.project/apierror"
 	db "btep.project/databaseConnection"
 	"github.com/gorilla/mux"
 )
@@ -44,7 +45,7 @@ func generateCodeVerifier(accountID int) string {
 	}
 
 	// Concatenate account details to create a unique verifier
-	verifierData := fmt.Sprintf("%s-%s-%s", cloudAccount.ClientID, cloudAccount.ClientSecret)
+	verifierData := fmt.Sprintf("%s-%s", cloudAccount.ClientID.String, cloudAccount.ClientSecret.String)
 
 	// Hash the verifier data
 	h := sha256.Sum256([]byte(verifierData))
@@ -56,13 +57,13 @@ func generateCodeVerifier(accountID int) string {
 func handleAzureLogin(w http.ResponseWriter, r *http.Request) {
 	accountIDStr := r.URL.Query().Get("accountID")
 	if accountIDStr == "" {
-		http.Error(w, "Account ID not provided", http.StatusBadRequest)
+		apierror.BadRequest(w, "Account ID not provided")
 		return
 	}
 
 	accountID, err := strconv.Atoi(accountIDStr)
 	if err != nil {
-		http.Error(w, "Invalid account ID", http.StatusBadRequest)
+		apierror.BadRequest(w, "Invalid account ID")
 		return
 	}
 
@@ -0,0 +1,127 @@
+// Package azureauth builds the autorest.Authorizer every Azure management client uses.
+//
+// Accounts whose AuthMode is service_principal get their tokens for TenantID, ClientID and
+// ClientSecret through the client-credentials grant and work without a browser login. Other
+// accounts use the OAuth login kept in the vault, which needs the same fields.
+package azureauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"btep.project/auth/vault"
+	db "btep.project/databaseConnection"
+	"github.com/Azure/go-autorest/autorest"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"golang.org/x/oauth2/microsoft"
+)
+
+// managementScope requests every permission granted to the service principal on Azure Resource Manager
+const managementScope = "https://management.azure.com/.default"
+
+type cachedSource struct {
+	tenantID     string
+	clientID     string
+	clientSecret string
+	source       oauth2.TokenSource
+}
+
+var (
+	mu      sync.Mutex
+	sources = make(map[int]cachedSource)
+)
+
+// UsesServicePrincipal reports whether the account authenticates as its service principal
+// rather than with the OAuth login of its user
+func UsesServicePrincipal(account *db.CloudAccount) (bool, error) {
+	switch strings.ToLower(account.AuthMode.String) {
+	case "", db.AuthModeOAuth:
+		return false, nil
+	case db.AuthModeServicePrincipal:
+		if account.TenantID.String == "" || account.ClientID.String == "" || account.ClientSecret.String == "" {
+			return false, fmt.Errorf("account %d authenticates as a service principal but has no TenantID, ClientID or ClientSecret", account.AccountID)
+		}
+		return true, nil
+	}
+	return false, fmt.Errorf("account %d has an unknown AuthMode %q", account.AccountID, account.AuthMode.String)
+}
+
+// TokenSource returns the token source for an Azure account: the client-credentials grant of
+// its service principal in that AuthMode, the vault's refreshing OAuth source otherwise
+func TokenSource(ctx context.Context, accountID int) (oauth2.TokenSource, error) {
+	account, err := db.GetCloudAccountDetails(accountID)
+	if err != nil {
+		return nil, err
+	}
+	servicePrincipal, err := UsesServicePrincipal(account)
+	if err != nil {
+		return nil, err
+	}
+	if !servicePrincipal {
+		return vault.TokenSource(ctx, accountID)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	cached, ok := sources[accountID]
+	if ok && cached.tenantID == account.TenantID.String && cached.clientID == account.ClientID.String && cached.clientSecret == account.ClientSecret.String {
+		return cached.source, nil
+	}
+
+	config := &clientcredentials.Config{
+		ClientID:     account.ClientID.String,
+		ClientSecret: account.ClientSecret.String,
+		TokenURL:     microsoft.AzureADEndpoint(account.TenantID.String).TokenURL,
+		Scopes:       []string{managementScope},
+		AuthStyle:    oauth2.AuthStyleInParams,
+	}
+	// The cached source outlives the request, so it fetches tokens on its own context.
+	// clientcredentials wraps it in a ReuseTokenSource, a new token is only requested on expiry.
+	source := config.TokenSource(context.Background())
+	sources[accountID] = cachedSource{
+		tenantID:     account.TenantID.String,
+		clientID:     account.ClientID.String,
+		clientSecret: account.ClientSecret.String,
+		source:       source,
+	}
+	return source, nil
+}
+
+// Authorizer returns an authorizer for the Azure management clients of accountID
+func Authorizer(ctx context.Context, accountID int) (autorest.Authorizer, error) {
+	source, err := TokenSource(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return NewBearerAuthorizer(source), nil
+}
+
+// NewBearerAuthorizer sets a valid access token from source on every request
+func NewBearerAuthorizer(source oauth2.TokenSource) autorest.Authorizer {
+	return bearerAuthorizer{source: source}
+}
+
+type bearerAuthorizer struct {
+	source oauth2.TokenSource
+}
+
+func (a bearerAuthorizer) WithAuthorization() autorest.PrepareDecorator {
+	return func(p autorest.Preparer) autorest.Preparer {
+		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err != nil {
+				return r, err
+			}
+			token, err := a.source.Token()
+			if err != nil {
+				return r, err
+			}
+			token.SetAuthHeader(r)
+			return r, nil
+		})
+	}
+}
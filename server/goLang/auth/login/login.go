@@ -0,0 +1,231 @@
+// Package login runs the OAuth authorization code flow for GCP and Azure accounts.
+//
+// Every login gets its own random state and PKCE verifier, kept server-side and bound to
+// a short-lived session cookie. The callback only accepts a state that was issued to the
+// same browser, redeems the code with the matching verifier and hands the token to the vault.
+//
+// The login is started by the frontend with a credentialed fetch, which carries the user's
+// Bearer token and stores the session cookie. It answers with the authorization URL for the
+// frontend to navigate to, a redirect could not take the window to the provider.
+package login
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"btep.project/apierror"
+	"btep.project/auth/vault"
+	db "btep.project/databaseConnection"
+	"golang.org/x/oauth2"
+)
+
+// sessionCookie binds a pending login to the browser that started it
+const sessionCookie = "oauthSession"
+
+// Flow holds the logins that were started but have not reached their callback yet
+type Flow struct {
+	accounts db.CredentialStore
+	vault    *vault.Vault
+
+	// Config builds the OAuth client of an account, vault.OAuthConfig by default
+	Config func(account *db.CloudAccount) (*oauth2.Config, error)
+	// TTL bounds how long a user has to complete a login
+	TTL time.Duration
+	// DoneURL is where the browser is sent after a successful login
+	DoneURL string
+
+	mu      sync.Mutex
+	pending map[string]pendingLogin
+}
+
+type pendingLogin struct {
+	accountID int
+	provider  string
+	verifier  string
+	session   string
+	expires   time.Time
+}
+
+// NewFlow creates a login flow storing tokens in v
+func NewFlow(accounts db.CredentialStore, v *vault.Vault) *Flow {
+	return &Flow{
+		accounts: accounts,
+		vault:    v,
+		Config:   vault.OAuthConfig,
+		TTL:      10 * time.Minute,
+		DoneURL:  "http://localhost:3000/cloud",
+		pending:  make(map[string]pendingLogin),
+	}
+}
+
+type loginRequest struct {
+	AccountID int `json:"accountID"`
+}
+
+// LoginResponse holds the provider's authorization page the browser has to be sent to
+type LoginResponse struct {
+	URL string `json:"url"`
+}
+
+// LoginHandler starts the login of the account in the request body with provider ("gcp" or "azure")
+func (f *Flow) LoginHandler(provider string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req loginRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.BadRequest(w, "Invalid request body")
+			return
+		}
+		account, err := f.accounts.GetCloudAccount(r.Context(), req.AccountID)
+		if err != nil {
+			apierror.Write(w, err, "Error getting cloud account details")
+			return
+		}
+		if !strings.EqualFold(account.CloudProvider, provider) {
+			apierror.BadRequest(w, fmt.Sprintf("Account %d is not a %s account", req.AccountID, provider))
+			return
+		}
+		config, err := f.Config(account)
+		if err != nil {
+			apierror.BadRequest(w, fmt.Sprintf("Error building OAuth config: %v", err))
+			return
+		}
+
+		state, err := randomString()
+		if err != nil {
+			apierror.Write(w, err, "")
+			return
+		}
+		session, err := f.session(w, r)
+		if err != nil {
+			apierror.Write(w, err, "")
+			return
+		}
+		verifier := oauth2.GenerateVerifier()
+
+		f.mu.Lock()
+		f.prune(time.Now())
+		f.pending[state] = pendingLogin{
+			accountID: req.AccountID,
+			provider:  provider,
+			verifier:  verifier,
+			session:   session,
+			expires:   time.Now().Add(f.TTL),
+		}
+		f.mu.Unlock()
+
+		opts := []oauth2.AuthCodeOption{oauth2.S256ChallengeOption(verifier)}
+		if provider == "gcp" {
+			// Google only issues a refresh token for offline access, and only on consent
+			opts = append(opts, oauth2.AccessTypeOffline, oauth2.ApprovalForce)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(LoginResponse{URL: config.AuthCodeURL(state, opts...)})
+	}
+}
+
+// CallbackHandler completes a login started by LoginHandler for the same provider
+func (f *Flow) CallbackHandler(provider string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		pending, ok := f.take(query.Get("state"))
+		if !ok || pending.provider != provider {
+			apierror.BadRequest(w, "Unknown or expired login, please start again")
+			return
+		}
+		cookie, err := r.Cookie(sessionCookie)
+		if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(pending.session)) != 1 {
+			// The state was issued to a different browser: a forged or replayed callback
+			apierror.Send(w, apierror.New(http.StatusForbidden, apierror.CodeForbidden, "Login state does not match this session"))
+			return
+		}
+		if errCode := query.Get("error"); errCode != "" {
+			apierror.BadRequest(w, fmt.Sprintf("Login failed: %s %s", errCode, query.Get("error_description")))
+			return
+		}
+
+		account, err := f.accounts.GetCloudAccount(r.Context(), pending.accountID)
+		if err != nil {
+			apierror.Write(w, err, "Error getting cloud account details")
+			return
+		}
+		config, err := f.Config(account)
+		if err != nil {
+			apierror.Write(w, err, "Error building OAuth config")
+			return
+		}
+		token, err := config.Exchange(r.Context(), query.Get("code"), oauth2.VerifierOption(pending.verifier))
+		if err != nil {
+			log.Printf("login: exchanging code for account %d: %v", pending.accountID, err)
+			apierror.Send(w, apierror.New(http.StatusBadGateway, apierror.CodeProviderError, "Failed to exchange authorization code"))
+			return
+		}
+		if err := f.vault.Save(r.Context(), pending.accountID, token); err != nil {
+			apierror.Write(w, err, "")
+			return
+		}
+
+		http.Redirect(w, r, f.DoneURL, http.StatusFound)
+	}
+}
+
+// session returns the browser's login session, creating the cookie on first use
+func (f *Flow) session(w http.ResponseWriter, r *http.Request) (string, error) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil && cookie.Value != "" {
+		return cookie.Value, nil
+	}
+	session, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    session,
+		Path:     "/auth",
+		MaxAge:   int(f.TTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		// Lax still sends the cookie on the top-level redirect back from the provider
+		SameSite: http.SameSiteLaxMode,
+	})
+	return session, nil
+}
+
+// take removes and returns a pending login, a state can only be redeemed once
+func (f *Flow) take(state string) (pendingLogin, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pending, ok := f.pending[state]
+	if !ok {
+		return pendingLogin{}, false
+	}
+	delete(f.pending, state)
+	if time.Now().After(pending.expires) {
+		return pendingLogin{}, false
+	}
+	return pending, true
+}
+
+// prune drops abandoned logins, the caller holds f.mu
+func (f *Flow) prune(now time.Time) {
+	for state, pending := range f.pending {
+		if now.After(pending.expires) {
+			delete(f.pending, state)
+		}
+	}
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating random state: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
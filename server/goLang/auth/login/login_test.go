@@ -0,0 +1,333 @@
+package login
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"btep.project/auth/vault"
+	db "btep.project/databaseConnection"
+	"golang.org/x/oauth2"
+)
+
+// fakeStore holds a GCP account 1 and an Azure account 2 and keeps tokens in memory
+type fakeStore struct {
+	mu     sync.Mutex
+	tokens map[int]db.OAuthToken
+}
+
+func (s *fakeStore) GetCloudAccount(ctx context.Context, accountID int) (*db.CloudAccount, error) {
+	switch accountID {
+	case 1:
+		return &db.CloudAccount{AccountID: 1, UserID: 1, CloudProvider: "gcp"}, nil
+	case 2:
+		return &db.CloudAccount{AccountID: 2, UserID: 1, CloudProvider: "azure"}, nil
+	}
+	return nil, db.ErrAccountNotFound
+}
+
+func (s *fakeStore) Close() error { return nil }
+
+func (s *fakeStore) GetToken(ctx context.Context, accountID int) (*db.OAuthToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.tokens[accountID]
+	if !ok {
+		return nil, db.ErrNoToken
+	}
+	return &token, nil
+}
+
+func (s *fakeStore) SaveToken(ctx context.Context, token *db.OAuthToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[token.AccountID] = *token
+	return nil
+}
+
+func (s *fakeStore) DeleteToken(ctx context.Context, accountID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, accountID)
+	return nil
+}
+
+// authServer is a fake authorization server. Its token endpoint redeems the code "good-code"
+// when the PKCE verifier matches the challenge the browser was sent to /authorize with.
+type authServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	challenge string
+	exchanges int
+}
+
+func newAuthServer(t *testing.T) *authServer {
+	s := &authServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/token" {
+			http.NotFound(w, r)
+			return
+		}
+		r.ParseForm()
+		s.mu.Lock()
+		s.exchanges++
+		challenge := s.challenge
+		s.mu.Unlock()
+		if r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("code") != "good-code" ||
+			challenge == "" || s256(r.PostForm.Get("code_verifier")) != challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  "access-1",
+			"refresh_token": "refresh-1",
+			"token_type":    "Bearer",
+			"expires_in":    3600,
+		})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *authServer) exchangeCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.exchanges
+}
+
+func s256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func newTestFlow(t *testing.T, server *authServer) (*Flow, *fakeStore) {
+	t.Helper()
+	store := &fakeStore{tokens: make(map[int]db.OAuthToken)}
+	v, err := vault.New(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flow := NewFlow(store, v)
+	flow.DoneURL = "http://app.test/done"
+	flow.Config = func(account *db.CloudAccount) (*oauth2.Config, error) {
+		return &oauth2.Config{
+			ClientID:    "client",
+			RedirectURL: "http://api.test/auth/callback",
+			Endpoint:    oauth2.Endpoint{AuthURL: server.URL + "/authorize", TokenURL: server.URL + "/token", AuthStyle: oauth2.AuthStyleInParams},
+		}, nil
+	}
+	return flow, store
+}
+
+// start begins a login and returns the state, the session cookie and the authorization URL
+func start(t *testing.T, flow *Flow, server *authServer, provider string, accountID int, cookie *http.Cookie) (string, *http.Cookie, *url.URL) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/auth/"+provider+"/login", strings.NewReader(`{"accountID":`+strconv.Itoa(accountID)+`}`))
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	flow.LoginHandler(provider)(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login status = %d: %s", w.Code, w.Body.String())
+	}
+	var resp LoginResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	location, err := url.Parse(resp.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookie {
+			cookie = c
+		}
+	}
+	server.mu.Lock()
+	server.challenge = location.Query().Get("code_challenge")
+	server.mu.Unlock()
+	return location.Query().Get("state"), cookie, location
+}
+
+func callback(flow *Flow, provider string, query url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/auth/"+provider+"/callback?"+query.Encode(), nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	flow.CallbackHandler(provider)(w, r)
+	return w
+}
+
+func TestLoginStoresToken(t *testing.T) {
+	server := newAuthServer(t)
+	flow, store := newTestFlow(t, server)
+
+	state, cookie, location := start(t, flow, server, "gcp", 1, nil)
+	query := location.Query()
+	if state == "" || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("authorization URL lacks state or PKCE: %s", location)
+	}
+	if query.Get("access_type") != "offline" || query.Get("prompt") != "consent" {
+		t.Fatalf("a GCP login must ask for offline access: %s", location)
+	}
+	if cookie == nil || !cookie.HttpOnly || cookie.Path != "/auth" || cookie.Value == "" {
+		t.Fatalf("session cookie = %+v", cookie)
+	}
+
+	w := callback(flow, "gcp", url.Values{"state": {state}, "code": {"good-code"}}, cookie)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != flow.DoneURL {
+		t.Fatalf("callback = %d to %q: %s", w.Code, w.Header().Get("Location"), w.Body.String())
+	}
+	token, err := store.GetToken(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "access-1" || token.RefreshToken != "refresh-1" || token.Expiry.IsZero() {
+		t.Fatalf("stored token = %+v", token)
+	}
+
+	// A state is only redeemed once
+	if w := callback(flow, "gcp", url.Values{"state": {state}, "code": {"good-code"}}, cookie); w.Code != http.StatusBadRequest {
+		t.Fatalf("replayed callback = %d, want 400", w.Code)
+	}
+}
+
+func TestEveryLoginHasItsOwnStateAndVerifier(t *testing.T) {
+	server := newAuthServer(t)
+	flow, _ := newTestFlow(t, server)
+
+	firstState, cookie, first := start(t, flow, server, "azure", 2, nil)
+	secondState, again, second := start(t, flow, server, "azure", 2, cookie)
+	if firstState == secondState || first.Query().Get("code_challenge") == second.Query().Get("code_challenge") {
+		t.Fatal("two logins got the same state or PKCE challenge")
+	}
+	if again.Value != cookie.Value {
+		t.Fatal("a second login of the same browser did not keep its session")
+	}
+	if first.Query().Get("access_type") != "" {
+		t.Fatalf("an Azure login asked for Google's offline access: %s", first)
+	}
+
+	// The verifier of the first login does not match the challenge of the second
+	w := callback(flow, "azure", url.Values{"state": {firstState}, "code": {"good-code"}}, cookie)
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("callback with the other login's verifier = %d, want 502", w.Code)
+	}
+	w = callback(flow, "azure", url.Values{"state": {secondState}, "code": {"good-code"}}, cookie)
+	if w.Code != http.StatusFound {
+		t.Fatalf("callback = %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCallbackRejections(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  func(state string) url.Values
+		cookie func(session *http.Cookie) *http.Cookie
+		// provider is the callback the browser returns to, gcp when empty
+		provider string
+		status   int
+	}{
+		{"unknown state", func(string) url.Values { return url.Values{"state": {"forged"}, "code": {"good-code"}} }, same, "", http.StatusBadRequest},
+		{"missing state", func(string) url.Values { return url.Values{"code": {"good-code"}} }, same, "", http.StatusBadRequest},
+		{"no session cookie", withCode, func(*http.Cookie) *http.Cookie { return nil }, "", http.StatusForbidden},
+		{"other session", withCode, func(*http.Cookie) *http.Cookie {
+			return &http.Cookie{Name: sessionCookie, Value: "someone-else"}
+		}, "", http.StatusForbidden},
+		{"other provider", withCode, same, "azure", http.StatusBadRequest},
+		{"provider error", func(state string) url.Values {
+			return url.Values{"state": {state}, "error": {"access_denied"}}
+		}, same, "", http.StatusBadRequest},
+		{"bad code", func(state string) url.Values { return url.Values{"state": {state}, "code": {"bad-code"}} }, same, "", http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newAuthServer(t)
+			flow, store := newTestFlow(t, server)
+			state, cookie, _ := start(t, flow, server, "gcp", 1, nil)
+			provider := tt.provider
+			if provider == "" {
+				provider = "gcp"
+			}
+
+			w := callback(flow, provider, tt.query(state), tt.cookie(cookie))
+			if w.Code != tt.status {
+				t.Fatalf("callback = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if _, err := store.GetToken(context.Background(), 1); err != db.ErrNoToken {
+				t.Fatalf("a rejected callback stored a token: %v", err)
+			}
+			if tt.status != http.StatusBadGateway && server.exchangeCount() != 0 {
+				t.Fatal("a rejected callback redeemed the code")
+			}
+		})
+	}
+}
+
+func same(cookie *http.Cookie) *http.Cookie { return cookie }
+
+func withCode(state string) url.Values {
+	return url.Values{"state": {state}, "code": {"good-code"}}
+}
+
+func TestPendingLoginsExpire(t *testing.T) {
+	server := newAuthServer(t)
+	flow, store := newTestFlow(t, server)
+	state, cookie, _ := start(t, flow, server, "gcp", 1, nil)
+
+	flow.mu.Lock()
+	pending := flow.pending[state]
+	pending.expires = time.Now().Add(-time.Second)
+	flow.pending[state] = pending
+	flow.mu.Unlock()
+
+	w := callback(flow, "gcp", url.Values{"state": {state}, "code": {"good-code"}}, cookie)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("callback of an expired login = %d, want 400", w.Code)
+	}
+	if _, err := store.GetToken(context.Background(), 1); err != db.ErrNoToken {
+		t.Fatalf("an expired login stored a token: %v", err)
+	}
+
+	// Abandoned logins are dropped when the next one starts
+	flow.mu.Lock()
+	flow.pending["abandoned"] = pendingLogin{provider: "gcp", expires: time.Now().Add(-time.Second)}
+	flow.mu.Unlock()
+	start(t, flow, server, "gcp", 1, cookie)
+	flow.mu.Lock()
+	_, kept := flow.pending["abandoned"]
+	flow.mu.Unlock()
+	if kept {
+		t.Fatal("an expired login was not pruned")
+	}
+}
+
+func TestLoginRejectsOtherProvidersAccount(t *testing.T) {
+	server := newAuthServer(t)
+	flow, _ := newTestFlow(t, server)
+	for _, body := range []string{`{"accountID":2}`, `{"accountID":`, `{"accountID":99}`} {
+		r := httptest.NewRequest(http.MethodPost, "/auth/google/login", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		flow.LoginHandler("gcp")(w, r)
+		if w.Code == http.StatusOK {
+			t.Fatalf("login with %s was started", body)
+		}
+	}
+	if len(flow.pending) != 0 {
+		t.Fatal("a rejected login was kept")
+	}
+}
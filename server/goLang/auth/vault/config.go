@@ -0,0 +1,72 @@
+package vault
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	db "btep.project/databaseConnection"
+	"golang.org/x/oauth2"
+	googleAuth "golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/microsoft"
+)
+
+// defaultGoogleClientID is the platform's Google OAuth client, used for GCP accounts without their own ClientID
+const defaultGoogleClientID = "570397565376-ks7fmsvgrma2c9gm2k8lfa5tjhqdpala.apps.googleusercontent.com"
+
+var googleScopes = []string{
+	"https://www.googleapis.com/auth/datastore",
+	"https://www.googleapis.com/auth/cloud-platform",
+	"https://www.googleapis.com/auth/userinfo.profile",
+	"https://www.googleapis.com/auth/userinfo.email",
+	"https://www.googleapis.com/auth/devstorage.read_write",
+}
+
+// offline_access is what makes Azure AD return a refresh token
+var azureScopes = []string{
+	"https://management.azure.com/user_impersonation",
+	"offline_access",
+}
+
+// redirectBaseURL is where the OAuth callbacks of this server are reachable, OAUTH_REDIRECT_BASE_URL overrides it
+func redirectBaseURL() string {
+	if base := os.Getenv("OAUTH_REDIRECT_BASE_URL"); base != "" {
+		return base
+	}
+	return "http://localhost:8080"
+}
+
+// OAuthConfig returns the OAuth client of an account. The same config is used to run the
+// login flow and to refresh the tokens it produced, so both always agree on client and tenant.
+func OAuthConfig(account *db.CloudAccount) (*oauth2.Config, error) {
+	switch strings.ToLower(account.CloudProvider) {
+	case "gcp":
+		clientID := account.ClientID.String
+		if clientID == "" {
+			clientID = defaultGoogleClientID
+		}
+		return &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: account.ClientSecret.String,
+			RedirectURL:  redirectBaseURL() + "/auth/google/callback",
+			Scopes:       googleScopes,
+			Endpoint:     googleAuth.Endpoint,
+		}, nil
+	case "azure":
+		if strings.EqualFold(account.AuthMode.String, db.AuthModeServicePrincipal) {
+			return nil, fmt.Errorf("account %d authenticates as its service principal and has no OAuth login", account.AccountID)
+		}
+		if account.ClientID.String == "" || account.TenantID.String == "" {
+			return nil, fmt.Errorf("account %d has no ClientID or TenantID", account.AccountID)
+		}
+		return &oauth2.Config{
+			ClientID:     account.ClientID.String,
+			ClientSecret: account.ClientSecret.String,
+			RedirectURL:  redirectBaseURL() + "/auth/azure/callback",
+			Scopes:       azureScopes,
+			Endpoint:     microsoft.AzureADEndpoint(account.TenantID.String),
+		}, nil
+	default:
+		return nil, fmt.Errorf("account %d uses %q which has no OAuth login", account.AccountID, account.CloudProvider)
+	}
+}
@@ -0,0 +1,221 @@
+// Package vault keeps the OAuth tokens of GCP and Azure accounts on the server.
+//
+// The login callbacks hand the token they receive to Save. Handlers then ask for a
+// TokenSource by AccountID and get an access token that is refreshed with the stored
+// refresh token whenever it expires, instead of trusting a raw token from the request.
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	db "btep.project/databaseConnection"
+	"golang.org/x/oauth2"
+)
+
+// ErrLoginRequired is returned when an account has no usable token and the user has to log in again
+var ErrLoginRequired = errors.New("OAuth login required")
+
+// Vault persists OAuth tokens per AccountID and mints fresh access tokens on demand
+type Vault struct {
+	accounts db.CredentialStore
+	tokens   db.TokenStore
+
+	mu      sync.Mutex
+	sources map[int]oauth2.TokenSource
+}
+
+// New creates a vault storing tokens next to the accounts of store
+func New(store db.CredentialStore) (*Vault, error) {
+	tokens, err := db.Tokens(store)
+	if err != nil {
+		return nil, err
+	}
+	return &Vault{accounts: store, tokens: tokens, sources: make(map[int]oauth2.TokenSource)}, nil
+}
+
+// Save stores the token returned by a completed login for accountID
+func (v *Vault) Save(ctx context.Context, accountID int, token *oauth2.Token) error {
+	if token.RefreshToken == "" {
+		log.Printf("vault: login for account %d returned no refresh token, it will expire at %v", accountID, token.Expiry)
+	}
+	err := v.tokens.SaveToken(ctx, &db.OAuthToken{
+		AccountID:    accountID,
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		TokenType:    token.TokenType,
+		Expiry:       token.Expiry,
+	})
+	if err != nil {
+		return fmt.Errorf("error saving token of account %d: %v", accountID, err)
+	}
+
+	v.mu.Lock()
+	delete(v.sources, accountID)
+	v.mu.Unlock()
+	return nil
+}
+
+// Forget drops the stored token of accountID, e.g. when the user disconnects the account
+func (v *Vault) Forget(ctx context.Context, accountID int) error {
+	v.mu.Lock()
+	delete(v.sources, accountID)
+	v.mu.Unlock()
+	return v.tokens.DeleteToken(ctx, accountID)
+}
+
+// TokenSource returns a source of valid access tokens for accountID.
+// Sources are cached so concurrent requests share one refresh.
+func (v *Vault) TokenSource(ctx context.Context, accountID int) (oauth2.TokenSource, error) {
+	v.mu.Lock()
+	source, ok := v.sources[accountID]
+	v.mu.Unlock()
+	if ok {
+		return source, nil
+	}
+
+	account, err := v.accounts.GetCloudAccount(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	config, err := OAuthConfig(account)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := v.tokens.GetToken(ctx, accountID)
+	if err != nil {
+		if errors.Is(err, db.ErrNoToken) {
+			return nil, fmt.Errorf("%w: account %d has not completed the %s login", ErrLoginRequired, accountID, account.CloudProvider)
+		}
+		return nil, err
+	}
+
+	token := &oauth2.Token{
+		AccessToken:  stored.AccessToken,
+		RefreshToken: stored.RefreshToken,
+		TokenType:    stored.TokenType,
+		Expiry:       stored.Expiry,
+	}
+	source = oauth2.ReuseTokenSource(token, &refreshingSource{
+		vault:     v,
+		accountID: accountID,
+		config:    config,
+		refresh:   stored.RefreshToken,
+	})
+
+	v.mu.Lock()
+	v.sources[accountID] = source
+	v.mu.Unlock()
+	return source, nil
+}
+
+// refreshingSource redeems the refresh token and writes the result back to the store,
+// so rotated refresh tokens survive a restart
+type refreshingSource struct {
+	vault     *Vault
+	accountID int
+	config    *oauth2.Config
+
+	mu      sync.Mutex
+	refresh string
+}
+
+func (s *refreshingSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.refresh == "" {
+		s.vault.drop(s.accountID)
+		return nil, fmt.Errorf("%w: the token of account %d expired and has no refresh token", ErrLoginRequired, s.accountID)
+	}
+
+	// The source outlives the request that created it, so it refreshes on its own context
+	ctx := context.Background()
+	token, err := s.config.TokenSource(ctx, &oauth2.Token{RefreshToken: s.refresh}).Token()
+	if err != nil {
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_grant" {
+			// The grant was revoked or expired, only a new login can fix it
+			s.vault.drop(s.accountID)
+			if deleteErr := s.vault.tokens.DeleteToken(ctx, s.accountID); deleteErr != nil {
+				log.Printf("vault: deleting revoked token of account %d: %v", s.accountID, deleteErr)
+			}
+			return nil, fmt.Errorf("%w: the refresh token of account %d was rejected: %v", ErrLoginRequired, s.accountID, err)
+		}
+		return nil, fmt.Errorf("error refreshing token of account %d: %v", s.accountID, err)
+	}
+	if token.RefreshToken == "" {
+		token.RefreshToken = s.refresh
+	}
+	s.refresh = token.RefreshToken
+
+	err = s.vault.tokens.SaveToken(ctx, &db.OAuthToken{
+		AccountID:    s.accountID,
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		TokenType:    token.TokenType,
+		Expiry:       token.Expiry,
+	})
+	if err != nil {
+		// The fresh token is still usable for this process
+		log.Printf("vault: saving refreshed token of account %d: %v", s.accountID, err)
+	}
+	return token, nil
+}
+
+func (v *Vault) drop(accountID int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.sources, accountID)
+}
+
+var (
+	defaultMu    sync.Mutex
+	defaultVault *Vault
+)
+
+// SetDefault installs the vault used by the package level helpers
+func SetDefault(v *Vault) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultVault = v
+}
+
+// Default returns the process wide vault, built on db.Default on first use
+func Default() (*Vault, error) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultVault != nil {
+		return defaultVault, nil
+	}
+	store, err := db.Default()
+	if err != nil {
+		return nil, err
+	}
+	v, err := New(store)
+	if err != nil {
+		return nil, err
+	}
+	defaultVault = v
+	return defaultVault, nil
+}
+
+// SaveToken stores the token of a completed login in the default vault
+func SaveToken(ctx context.Context, accountID int, token *oauth2.Token) error {
+	v, err := Default()
+	if err != nil {
+		return err
+	}
+	return v.Save(ctx, accountID, token)
+}
+
+// TokenSource returns a refreshing token source for accountID from the default vault
+func TokenSource(ctx context.Context, accountID int) (oauth2.TokenSource, error) {
+	v, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return v.TokenSource(ctx, accountID)
+}
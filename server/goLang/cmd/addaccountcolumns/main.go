@@ -0,0 +1,32 @@
+// Command addaccountcolumns adds the CloudAccount columns the server reads but an older
+// database lacks (RoleARN, ExternalID, Endpoint, PathStyle, AuthMode). The Node backend owns the
+// table, so the server never changes it on startup; run this once when upgrading.
+//
+// It reads the same CREDENTIAL_* environment as the server:
+//
+//	go run ./cmd/addaccountcolumns
+package main
+
+import (
+	"context"
+	"log"
+
+	db "btep.project/databaseConnection"
+)
+
+func main() {
+	cfg, err := db.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid credential store configuration: %v", err)
+	}
+
+	added, err := db.AddOptionalColumns(context.Background(), cfg)
+	if err != nil {
+		log.Fatalf("Error adding columns: %v", err)
+	}
+	if len(added) == 0 {
+		log.Printf("CloudAccount already has every column")
+		return
+	}
+	log.Printf("Added %v to CloudAccount", added)
+}
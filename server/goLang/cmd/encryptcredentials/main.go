@@ -0,0 +1,58 @@
+// Command encryptcredentials encrypts the secret columns of the CloudAccount table
+// (SecretKey, ClientSecret, PrivateKey) and the stored OAuth tokens, and rotates
+// them onto a new master key. Values sealed by an older server as v1 envelopes, which
+// are not bound to their row, are sealed again as v2.
+//
+// It reads the same CREDENTIAL_* environment as the server. To rotate, point
+// CREDENTIAL_MASTER_KEY at the new key and list the old one in CREDENTIAL_PREVIOUS_KEYS:
+//
+//	go run ./cmd/encryptcredentials -generate-key /etc/multicloud/master-2.key
+//	CREDENTIAL_MASTER_KEY=/etc/multicloud/master-2.key \
+//	CREDENTIAL_PREVIOUS_KEYS=/etc/multicloud/master.key \
+//	go run ./cmd/encryptcredentials
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	db "btep.project/databaseConnection"
+)
+
+func main() {
+	generateKey := flag.String("generate-key", "", "write a new random master key to this path and exit")
+	widen := flag.Bool("widen-columns", false, "change the MySQL secret columns to TEXT before encrypting")
+	dryRun := flag.Bool("dry-run", false, "report how many accounts would change without writing")
+	flag.Parse()
+
+	if *generateKey != "" {
+		if err := db.GenerateKeyFile(*generateKey); err != nil {
+			log.Fatalf("Error generating master key: %v", err)
+		}
+		log.Printf("Master key written to %s", *generateKey)
+		return
+	}
+
+	cfg, err := db.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid credential store configuration: %v", err)
+	}
+
+	ctx := context.Background()
+	if *widen && !*dryRun {
+		if err := db.WidenSecretColumns(ctx, cfg); err != nil {
+			log.Fatalf("Error widening secret columns: %v", err)
+		}
+	}
+
+	result, err := db.ReencryptSecrets(ctx, cfg, *dryRun)
+	if err != nil {
+		log.Fatalf("Error encrypting secrets: %v", err)
+	}
+	if *dryRun {
+		log.Printf("%d of %d accounts and %d OAuth tokens would be re-encrypted", result.Updated, result.Accounts, result.TokensUpdated)
+		return
+	}
+	log.Printf("Re-encrypted %d of %d accounts and %d OAuth tokens", result.Updated, result.Accounts, result.TokensUpdated)
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// mysqlUnknownColumn is MySQL's ER_BAD_FIELD_ERROR
+const mysqlUnknownColumn = 1054
+
+// optionalColumns were added to CloudAccount after the schema the Node backend created. The
+// Node backend owns the table, so the server only selects the ones that exist; the
+// addaccountcolumns command adds the missing ones to an older database.
+var optionalColumns = []struct {
+	name    string
+	sqlType string
+}{
+	{"RoleARN", "VARCHAR(255)"},
+	{"ExternalID", "VARCHAR(255)"},
+	{"Endpoint", "VARCHAR(255)"},
+	{"PathStyle", "BOOLEAN"},
+	{"AuthMode", "VARCHAR(32)"},
+}
+
+func optionalFields(account *CloudAccount) map[string]*sql.NullString {
+	return map[string]*sql.NullString{
+		"RoleARN":    &account.RoleARN,
+		"ExternalID": &account.ExternalID,
+		"Endpoint":   &account.Endpoint,
+		"PathStyle":  &account.PathStyle,
+		"AuthMode":   &account.AuthMode,
+	}
+}
+
+// detectColumns records which optional columns can be selected
+func (s *sqlStore) detectColumns(ctx context.Context) error {
+	for _, column := range optionalColumns {
+		ok, err := hasColumn(ctx, s.db, column.name)
+		if err != nil {
+			return err
+		}
+		if ok {
+			s.columns = append(s.columns, column.name)
+			continue
+		}
+		log.Printf("credential store: CloudAccount has no %s column, run addaccountcolumns to add it", column.name)
+	}
+	return nil
+}
+
+// AddOptionalColumns adds the optional columns missing from the CloudAccount table of the
+// mysql or sqlite backend and returns their names
+func AddOptionalColumns(ctx context.Context, cfg Config) ([]string, error) {
+	if cfg.Backend != "mysql" && cfg.Backend != "sqlite" {
+		return nil, nil
+	}
+	conn, err := sql.Open(cfg.Backend, cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var added []string
+	for _, column := range optionalColumns {
+		ok, err := hasColumn(ctx, conn, column.name)
+		if err != nil {
+			return added, err
+		}
+		if ok {
+			continue
+		}
+		// ALTER TABLE ... ADD COLUMN is understood by both MySQL and SQLite
+		_, err = conn.ExecContext(ctx, fmt.Sprintf("ALTER TABLE CloudAccount ADD COLUMN %s %s NULL", column.name, column.sqlType))
+		if err != nil {
+			return added, fmt.Errorf("error adding column %s: %v", column.name, err)
+		}
+		added = append(added, column.name)
+	}
+	return added, nil
+}
+
+// hasColumn reports whether CloudAccount has column. Only the driver's unknown column error
+// means it is missing, a timeout or lost connection must not switch the column off.
+func hasColumn(ctx context.Context, conn *sql.DB, column string) (bool, error) {
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM CloudAccount LIMIT 0", column))
+	if err == nil {
+		rows.Close()
+		return true, nil
+	}
+	if isUnknownColumn(err) {
+		return false, nil
+	}
+	return false, fmt.Errorf("error checking for the %s column of CloudAccount: %w", column, err)
+}
+
+func isUnknownColumn(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlUnknownColumn
+	}
+	// SQLite reports it as a plain SQLITE_ERROR, only the message tells it apart
+	return strings.Contains(err.Error(), "no such column")
+}
+
+// optionalSelect returns the column list and scan targets of the available optional columns
+func (s *sqlStore) optionalSelect(account *CloudAccount) (string, []interface{}) {
+	if len(s.columns) == 0 {
+		return "", nil
+	}
+	fields := optionalFields(account)
+	targets := make([]interface{}, 0, len(s.columns))
+	for _, column := range s.columns {
+		targets = append(targets, fields[column])
+	}
+	return ", " + strings.Join(s.columns, ", "), targets
+}
@@ -1,10 +1,16 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strconv"
+	"sync"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
+	_ "modernc.org/sqlite"
 )
 
 // CloudAccount represents a row in the CloudAccount table
@@ -23,19 +29,82 @@ type CloudAccount struct {
 	ClientEmail    sql.NullString
 	PrivateKey     sql.NullString
 	ProjectID      sql.NullString
+	// RoleARN and ExternalID make the server assume an IAM role with AccessKey instead of using it directly
+	RoleARN    sql.NullString
+	ExternalID sql.NullString
+	// Endpoint points the AWS SDK at an S3-compatible service (MinIO, Ceph, LocalStack) instead of AWS.
+	// PathStyle ("true" or "1") addresses buckets as endpoint/bucket, which most of them need.
+	Endpoint  sql.NullString
+	PathStyle sql.NullString
+	// AuthMode says how an Azure account authenticates, AuthModeOAuth when it is empty
+	AuthMode sql.NullString
 }
 
-// GetCloudAccountDetails retrieves cloud account details from the database
+// Azure accounts authenticate with the OAuth login of their user or as their service principal.
+// Both need TenantID and ClientID, often ClientSecret too, so the mode is stored rather than
+// inferred from the fields that are set.
+const (
+	AuthModeOAuth            = "oauth"
+	AuthModeServicePrincipal = "service_principal"
+)
+
+// UsePathStyle reports whether buckets are addressed in the path instead of the host name
+func (a *CloudAccount) UsePathStyle() bool {
+	pathStyle, _ := strconv.ParseBool(a.PathStyle.String)
+	return pathStyle
+}
+
+// ErrAccountNotFound is returned for an AccountID without a CloudAccount row
+var ErrAccountNotFound = errors.New("cloud account not found")
+
+// GetCloudAccountDetails retrieves cloud account details from the default credential store
 func GetCloudAccountDetails(accountID int) (*CloudAccount, error) {
-	db, err := sql.Open("mysql", "newuser:password@tcp(127.0.0.1:3307)/multicloud")
+	store, err := Default()
 	if err != nil {
 		return nil, err
 	}
-	defer db.Close()
+	return store.GetCloudAccount(context.Background(), accountID)
+}
+
+// sqlStore reads CloudAccount rows through a long-lived connection pool
+type sqlStore struct {
+	db      *sql.DB
+	keyring *Keyring
 
-	var account CloudAccount
-	row := db.QueryRow("SELECT ClientEmail, PrivateKey, ProjectID, Region, AdditionalInformation, CloudProvider, AccessKey, SecretKey, SubscriptionID, TenantID, ClientID, ClientSecret FROM CloudAccount WHERE AccountID = ?", accountID)
-	err = row.Scan(
+	// columns lists the optional CloudAccount columns present in this database
+	columns []string
+
+	tokenTableMu    sync.Mutex
+	tokenTableReady bool
+}
+
+func newSQLStore(driver, dsn string, maxOpenConns int, keyring *Keyring) (*sqlStore, error) {
+	conn, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if maxOpenConns > 0 {
+		conn.SetMaxOpenConns(maxOpenConns)
+		conn.SetMaxIdleConns(maxOpenConns)
+	}
+	conn.SetConnMaxLifetime(5 * time.Minute)
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error connecting to %s credential store: %v", driver, err)
+	}
+	store := &sqlStore{db: conn, keyring: keyring}
+	if err := store.detectColumns(context.Background()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *sqlStore) GetCloudAccount(ctx context.Context, accountID int) (*CloudAccount, error) {
+	account := CloudAccount{AccountID: accountID}
+	optional, optionalTargets := s.optionalSelect(&account)
+	row := s.db.QueryRowContext(ctx, "SELECT ClientEmail, PrivateKey, ProjectID, Region, AdditionalInformation, CloudProvider, AccessKey, SecretKey, SubscriptionID, TenantID, ClientID, ClientSecret, UserID"+optional+" FROM CloudAccount WHERE AccountID = ?", accountID)
+	targets := []interface{}{
 		&account.ClientEmail,
 		&account.PrivateKey,
 		&account.ProjectID,
@@ -48,11 +117,12 @@ func GetCloudAccountDetails(accountID int) (*CloudAccount, error) {
 		&account.TenantID,
 		&account.ClientID,
 		&account.ClientSecret,
-	)
-	fmt.Println(account.PrivateKey)
+		&account.UserID,
+	}
+	err := row.Scan(append(targets, optionalTargets...)...)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("no rows found for account ID %d", accountID)
+			return nil, fmt.Errorf("%w: no rows found for account ID %d", ErrAccountNotFound, accountID)
 		}
 		return nil, err
 	}
@@ -92,7 +162,12 @@ func GetCloudAccountDetails(accountID int) (*CloudAccount, error) {
 		account.ClientSecret.String = ""
 	}
 
-	// Repeat this for other fields...
-
+	if err := decryptSecrets(&account, s.keyring); err != nil {
+		return nil, err
+	}
 	return &account, nil
 }
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
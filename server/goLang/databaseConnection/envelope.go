@@ -0,0 +1,264 @@
+package db
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Secret columns are stored as
+//
+//	enc:v2:<keyID>:<base64 wrapped data key>:<base64 nonce+ciphertext>
+//
+// Every value gets its own AES-256-GCM data key, which is wrapped by a master key
+// from a KeyProvider. The column and the AccountID of the row are bound in as associated
+// data, so a value copied into another row or column does not decrypt. v1 values bound
+// the column alone; they are still read and rewritten as v2 by encryptcredentials. Values
+// without a prefix are legacy plaintext and pass through.
+const (
+	encryptedPrefix = "enc:"
+	envelopeV1      = "v1"
+	envelopeV2      = "v2"
+)
+
+// KeyProvider wraps and unwraps data keys with a master key it never exposes
+type KeyProvider interface {
+	KeyID() string
+	WrapKey(dataKey []byte) ([]byte, error)
+	UnwrapKey(wrapped []byte) ([]byte, error)
+}
+
+// KeyProviderFactory opens a KeyProvider from a key URI such as "file:///etc/multicloud/master.key"
+type KeyProviderFactory func(uri *url.URL) (KeyProvider, error)
+
+var (
+	keyProvidersMu sync.RWMutex
+	keyProviders   = map[string]KeyProviderFactory{
+		"file": openLocalKeyProvider,
+	}
+)
+
+// RegisterKeyProvider adds a key URI scheme, e.g. a KMS plugin under "kms"
+func RegisterKeyProvider(scheme string, factory KeyProviderFactory) {
+	keyProvidersMu.Lock()
+	defer keyProvidersMu.Unlock()
+	keyProviders[scheme] = factory
+}
+
+// OpenKeyProvider resolves a key URI; a bare path is treated as a local keyfile
+func OpenKeyProvider(uri string) (KeyProvider, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key URI %q: %v", uri, err)
+	}
+	if u.Scheme == "" {
+		u = &url.URL{Scheme: "file", Path: uri}
+	}
+
+	keyProvidersMu.RLock()
+	factory, ok := keyProviders[u.Scheme]
+	keyProvidersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no key provider registered for scheme %q", u.Scheme)
+	}
+	return factory(u)
+}
+
+// LocalKeyProvider wraps data keys with a 256-bit master key read from a local keyfile
+type LocalKeyProvider struct {
+	id   string
+	aead cipher.AEAD
+}
+
+func openLocalKeyProvider(u *url.URL) (KeyProvider, error) {
+	return NewLocalKeyProvider(u.Path)
+}
+
+// NewLocalKeyProvider reads a keyfile holding 32 raw bytes or their base64 encoding
+func NewLocalKeyProvider(path string) (*LocalKeyProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading master key: %v", err)
+	}
+
+	key := data
+	if len(key) != 32 {
+		key, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("master key %s must be 32 bytes (raw or base64)", path)
+		}
+	}
+	return newLocalKeyProvider(key)
+}
+
+func newLocalKeyProvider(key []byte) (*LocalKeyProvider, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(key)
+	return &LocalKeyProvider{id: "local-" + hex.EncodeToString(sum[:4]), aead: aead}, nil
+}
+
+// GenerateKeyFile writes a new random master key to path in base64
+func GenerateKeyFile(path string) error {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600)
+}
+
+func (p *LocalKeyProvider) KeyID() string {
+	return p.id
+}
+
+func (p *LocalKeyProvider) WrapKey(dataKey []byte) ([]byte, error) {
+	return seal(p.aead, dataKey, nil)
+}
+
+func (p *LocalKeyProvider) UnwrapKey(wrapped []byte) ([]byte, error) {
+	return open(p.aead, wrapped, nil)
+}
+
+// Keyring encrypts with its primary key and decrypts with any key it knows,
+// which is what allows rotating the master key without downtime.
+type Keyring struct {
+	primary KeyProvider
+	keys    map[string]KeyProvider
+}
+
+// NewKeyring builds a keyring; previous keys are only used for decryption
+func NewKeyring(primary KeyProvider, previous ...KeyProvider) *Keyring {
+	k := &Keyring{primary: primary, keys: make(map[string]KeyProvider)}
+	for _, p := range previous {
+		k.keys[p.KeyID()] = p
+	}
+	k.keys[primary.KeyID()] = primary
+	return k
+}
+
+// IsEncrypted reports whether a stored value is an envelope
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix+envelopeV1+":") || strings.HasPrefix(value, encryptedPrefix+envelopeV2+":")
+}
+
+// KeyIDOf returns the master key ID an envelope was written with
+func KeyIDOf(value string) string {
+	if !IsEncrypted(value) {
+		return ""
+	}
+	return strings.SplitN(strings.TrimPrefix(value, encryptedPrefix), ":", 3)[1]
+}
+
+// additionalData is what an envelope of version is bound to
+func additionalData(version, column string, accountID int) []byte {
+	if version == envelopeV1 {
+		return []byte(column)
+	}
+	return []byte(column + ":" + strconv.Itoa(accountID))
+}
+
+// Encrypt seals plaintext under a fresh data key; column and accountID are bound in as
+// associated data
+func (k *Keyring) Encrypt(column string, accountID int, plaintext string) (string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+	wrapped, err := k.primary.WrapKey(dataKey)
+	if err != nil {
+		return "", fmt.Errorf("error wrapping data key: %v", err)
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(aead, []byte(plaintext), additionalData(envelopeV2, column, accountID))
+	if err != nil {
+		return "", err
+	}
+	return encryptedPrefix + envelopeV2 + ":" + k.primary.KeyID() + ":" +
+		base64.StdEncoding.EncodeToString(wrapped) + ":" +
+		base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt opens an envelope Encrypt wrote for the same column and accountID; plaintext values
+// are returned unchanged
+func (k *Keyring) Decrypt(column string, accountID int, value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(value, encryptedPrefix), ":")
+	if len(parts) != 4 {
+		return "", fmt.Errorf("malformed encrypted %s", column)
+	}
+	version := parts[0]
+
+	provider, ok := k.keys[parts[1]]
+	if !ok {
+		return "", fmt.Errorf("%s is encrypted with unknown master key %s", column, parts[1])
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted %s: %v", column, err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted %s: %v", column, err)
+	}
+
+	dataKey, err := provider.UnwrapKey(wrapped)
+	if err != nil {
+		return "", fmt.Errorf("error unwrapping data key for %s: %v", column, err)
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(aead, ciphertext, additionalData(version, column, accountID))
+	if err != nil {
+		return "", fmt.Errorf("error decrypting %s: %v", column, err)
+	}
+	return string(plaintext), nil
+}
+
+// NeedsRotation reports whether a stored value is plaintext, a v1 envelope or sealed by a
+// non-primary key
+func (k *Keyring) NeedsRotation(value string) bool {
+	return value != "" && (!strings.HasPrefix(value, encryptedPrefix+envelopeV2+":") || KeyIDOf(value) != k.primary.KeyID())
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
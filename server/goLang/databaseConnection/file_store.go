@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// accountRecord is the JSON shape of a CloudAccount row, using the column names of the CloudAccount table
+type accountRecord struct {
+	AccountID             int    `json:"AccountID"`
+	UserID                int    `json:"UserID"`
+	CloudProvider         string `json:"CloudProvider"`
+	AccessKey             string `json:"AccessKey"`
+	SecretKey             string `json:"SecretKey"`
+	SubscriptionID        string `json:"SubscriptionID"`
+	TenantID              string `json:"TenantID"`
+	ClientID              string `json:"ClientID"`
+	ClientSecret          string `json:"ClientSecret"`
+	Region                string `json:"Region"`
+	AdditionalInformation string `json:"AdditionalInformation"`
+	ClientEmail           string `json:"ClientEmail"`
+	PrivateKey            string `json:"PrivateKey"`
+	ProjectID             string `json:"ProjectID"`
+	RoleARN               string `json:"RoleARN"`
+	ExternalID            string `json:"ExternalID"`
+	Endpoint              string `json:"Endpoint"`
+	PathStyle             bool   `json:"PathStyle"`
+	AuthMode              string `json:"AuthMode"`
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func (rec accountRecord) cloudAccount() *CloudAccount {
+	return &CloudAccount{
+		AccountID:      rec.AccountID,
+		UserID:         rec.UserID,
+		CloudProvider:  rec.CloudProvider,
+		AccessKey:      nullString(rec.AccessKey),
+		SecretKey:      nullString(rec.SecretKey),
+		SubscriptionID: nullString(rec.SubscriptionID),
+		TenantID:       nullString(rec.TenantID),
+		ClientID:       nullString(rec.ClientID),
+		ClientSecret:   nullString(rec.ClientSecret),
+		Region:         nullString(rec.Region),
+		AdditionalInfo: nullString(rec.AdditionalInformation),
+		ClientEmail:    nullString(rec.ClientEmail),
+		PrivateKey:     nullString(rec.PrivateKey),
+		ProjectID:      nullString(rec.ProjectID),
+		RoleARN:        nullString(rec.RoleARN),
+		ExternalID:     nullString(rec.ExternalID),
+		Endpoint:       nullString(rec.Endpoint),
+		PathStyle:      sql.NullString{String: strconv.FormatBool(rec.PathStyle), Valid: rec.PathStyle},
+		AuthMode:       nullString(rec.AuthMode),
+	}
+}
+
+// fileStore serves accounts from a JSON array loaded once at startup.
+// It is meant for local development and tests where no MySQL is available.
+type fileStore struct {
+	memoryTokens
+	accounts map[int]accountRecord
+	keyring  *Keyring
+}
+
+// newFileStore loads accounts from path, or from CLOUD_ACCOUNTS_JSON when path is empty
+func newFileStore(path string, keyring *Keyring) (*fileStore, error) {
+	var data []byte
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading credential file: %v", err)
+		}
+		data = b
+	} else if inline := os.Getenv("CLOUD_ACCOUNTS_JSON"); inline != "" {
+		data = []byte(inline)
+	} else {
+		return nil, fmt.Errorf("file credential backend needs CREDENTIAL_FILE or CLOUD_ACCOUNTS_JSON")
+	}
+
+	var records []accountRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("error parsing credential file: %v", err)
+	}
+
+	store := &fileStore{accounts: make(map[int]accountRecord, len(records)), keyring: keyring}
+	for _, rec := range records {
+		store.accounts[rec.AccountID] = rec
+	}
+	return store, nil
+}
+
+func (s *fileStore) GetCloudAccount(ctx context.Context, accountID int) (*CloudAccount, error) {
+	rec, ok := s.accounts[accountID]
+	if !ok {
+		return nil, fmt.Errorf("%w: no rows found for account ID %d", ErrAccountNotFound, accountID)
+	}
+	account := rec.cloudAccount()
+	if err := decryptSecrets(account, s.keyring); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+func (s *fileStore) Close() error {
+	return nil
+}
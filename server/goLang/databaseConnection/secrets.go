@@ -0,0 +1,210 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// secretColumns are the CloudAccount columns stored as envelopes
+var secretColumns = []string{"SecretKey", "ClientSecret", "PrivateKey"}
+
+func secretFields(account *CloudAccount) map[string]*sql.NullString {
+	return map[string]*sql.NullString{
+		"SecretKey":    &account.SecretKey,
+		"ClientSecret": &account.ClientSecret,
+		"PrivateKey":   &account.PrivateKey,
+	}
+}
+
+// decryptSecrets replaces the envelopes in account with their plaintext
+func decryptSecrets(account *CloudAccount, keyring *Keyring) error {
+	for column, field := range secretFields(account) {
+		if !IsEncrypted(field.String) {
+			continue
+		}
+		if keyring == nil {
+			return fmt.Errorf("%s of account %d is encrypted but CREDENTIAL_MASTER_KEY is not set", column, account.AccountID)
+		}
+		plaintext, err := keyring.Decrypt(column, account.AccountID, field.String)
+		if err != nil {
+			return fmt.Errorf("account %d: %v", account.AccountID, err)
+		}
+		field.String = plaintext
+	}
+	return nil
+}
+
+// MigrationResult summarises a ReencryptSecrets run
+type MigrationResult struct {
+	Accounts int
+	Updated  int
+	// TokensUpdated counts OAuthToken rows re-encrypted alongside the accounts
+	TokensUpdated int
+}
+
+// ReencryptSecrets encrypts plaintext secret columns and re-wraps values sealed with a
+// previous master key or as v1 envelopes under the primary one. Rows are updated in a single transaction.
+func ReencryptSecrets(ctx context.Context, cfg Config, dryRun bool) (*MigrationResult, error) {
+	if cfg.Backend != "mysql" && cfg.Backend != "sqlite" {
+		return nil, fmt.Errorf("secrets can only be migrated in the mysql or sqlite backends")
+	}
+	keyring, err := cfg.Keyring()
+	if err != nil {
+		return nil, err
+	}
+	if keyring == nil {
+		return nil, fmt.Errorf("CREDENTIAL_MASTER_KEY is required to encrypt secrets")
+	}
+
+	conn, err := sql.Open(cfg.Backend, cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "SELECT AccountID, SecretKey, ClientSecret, PrivateKey FROM CloudAccount")
+	if err != nil {
+		return nil, err
+	}
+	var accounts []CloudAccount
+	for rows.Next() {
+		var account CloudAccount
+		if err := rows.Scan(&account.AccountID, &account.SecretKey, &account.ClientSecret, &account.PrivateKey); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &MigrationResult{Accounts: len(accounts)}
+	for i := range accounts {
+		account := &accounts[i]
+		changed := false
+		for _, column := range secretColumns {
+			field := secretFields(account)[column]
+			if !keyring.NeedsRotation(field.String) {
+				continue
+			}
+			plaintext, err := keyring.Decrypt(column, account.AccountID, field.String)
+			if err != nil {
+				return nil, fmt.Errorf("account %d: %v", account.AccountID, err)
+			}
+			sealed, err := keyring.Encrypt(column, account.AccountID, plaintext)
+			if err != nil {
+				return nil, err
+			}
+			field.String = sealed
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		result.Updated++
+		if dryRun {
+			continue
+		}
+		_, err := tx.ExecContext(ctx, "UPDATE CloudAccount SET SecretKey = ?, ClientSecret = ?, PrivateKey = ? WHERE AccountID = ?",
+			account.SecretKey, account.ClientSecret, account.PrivateKey, account.AccountID)
+		if err != nil {
+			return nil, fmt.Errorf("error updating account %d: %v", account.AccountID, err)
+		}
+	}
+
+	updated, err := reencryptTokens(ctx, tx, keyring, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	result.TokensUpdated = updated
+
+	if dryRun {
+		return result, nil
+	}
+	return result, tx.Commit()
+}
+
+// reencryptTokens seals the stored OAuth tokens under the primary master key
+func reencryptTokens(ctx context.Context, tx *sql.Tx, keyring *Keyring, dryRun bool) (int, error) {
+	if _, err := tx.ExecContext(ctx, createTokenTable); err != nil {
+		return 0, err
+	}
+	rows, err := tx.QueryContext(ctx, "SELECT AccountID, AccessToken, RefreshToken FROM OAuthToken")
+	if err != nil {
+		return 0, err
+	}
+	var tokens []OAuthToken
+	for rows.Next() {
+		var token OAuthToken
+		var accessToken, refreshToken sql.NullString
+		if err := rows.Scan(&token.AccountID, &accessToken, &refreshToken); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		token.AccessToken = accessToken.String
+		token.RefreshToken = refreshToken.String
+		tokens = append(tokens, token)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for i := range tokens {
+		token := &tokens[i]
+		changed := false
+		for _, column := range tokenColumns {
+			field := tokenFields(token)[column]
+			if !keyring.NeedsRotation(*field) {
+				continue
+			}
+			plaintext, err := keyring.Decrypt(column, token.AccountID, *field)
+			if err != nil {
+				return 0, fmt.Errorf("token of account %d: %v", token.AccountID, err)
+			}
+			sealed, err := keyring.Encrypt(column, token.AccountID, plaintext)
+			if err != nil {
+				return 0, err
+			}
+			*field = sealed
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		updated++
+		if dryRun {
+			continue
+		}
+		_, err := tx.ExecContext(ctx, "UPDATE OAuthToken SET AccessToken = ?, RefreshToken = ? WHERE AccountID = ?",
+			token.AccessToken, token.RefreshToken, token.AccountID)
+		if err != nil {
+			return 0, fmt.Errorf("error updating token of account %d: %v", token.AccountID, err)
+		}
+	}
+	return updated, nil
+}
+
+// WidenSecretColumns turns the MySQL VARCHAR(255) secret columns into TEXT so envelopes fit
+func WidenSecretColumns(ctx context.Context, cfg Config) error {
+	if cfg.Backend != "mysql" {
+		return nil
+	}
+	conn, err := sql.Open(cfg.Backend, cfg.DSN)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.ExecContext(ctx, "ALTER TABLE CloudAccount MODIFY SecretKey TEXT NULL, MODIFY ClientSecret TEXT NULL, MODIFY PrivateKey TEXT NULL")
+	return err
+}
@@ -0,0 +1,226 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CredentialStore resolves cloud account credentials by AccountID
+type CredentialStore interface {
+	GetCloudAccount(ctx context.Context, accountID int) (*CloudAccount, error)
+	Close() error
+}
+
+// Config selects and configures the credential backend
+type Config struct {
+	// Backend is one of "mysql", "sqlite" or "file"
+	Backend string
+	// DSN is the database/sql data source for the mysql and sqlite backends
+	DSN string
+	// File is the JSON accounts file for the file backend
+	File string
+	// CacheTTL is how long a looked up account is served from memory, 0 disables the cache
+	CacheTTL time.Duration
+	// MaxOpenConns caps the pooled connections of the SQL backends
+	MaxOpenConns int
+	// MasterKey is the key URI used to wrap secret column data keys, empty leaves secrets in plaintext
+	MasterKey string
+	// PreviousKeys are older master key URIs still accepted for decryption during rotation
+	PreviousKeys []string
+}
+
+const defaultMySQLDSN = "newuser:password@tcp(127.0.0.1:3307)/multicloud"
+
+// ConfigFromEnv reads the credential store configuration from the environment:
+//
+//	CREDENTIAL_BACKEND    mysql (default), sqlite or file
+//	CREDENTIAL_DSN        database/sql DSN, e.g. a MySQL DSN or a SQLite file path
+//	CREDENTIAL_FILE       JSON accounts file (file backend), CLOUD_ACCOUNTS_JSON may hold the JSON inline
+//	CREDENTIAL_CACHE_TTL  cache lifetime such as "30s" (default), "0" disables caching
+//	CREDENTIAL_MASTER_KEY master key URI for encrypted secrets, e.g. file:///etc/multicloud/master.key
+//	CREDENTIAL_PREVIOUS_KEYS comma separated master key URIs being rotated out
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		Backend:      os.Getenv("CREDENTIAL_BACKEND"),
+		DSN:          os.Getenv("CREDENTIAL_DSN"),
+		File:         os.Getenv("CREDENTIAL_FILE"),
+		CacheTTL:     30 * time.Second,
+		MaxOpenConns: 10,
+		MasterKey:    os.Getenv("CREDENTIAL_MASTER_KEY"),
+	}
+	if previous := os.Getenv("CREDENTIAL_PREVIOUS_KEYS"); previous != "" {
+		cfg.PreviousKeys = strings.Split(previous, ",")
+	}
+	if cfg.Backend == "" {
+		cfg.Backend = "mysql"
+	}
+	if cfg.Backend == "mysql" && cfg.DSN == "" {
+		cfg.DSN = defaultMySQLDSN
+	}
+	if ttl := os.Getenv("CREDENTIAL_CACHE_TTL"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid CREDENTIAL_CACHE_TTL %q: %v", ttl, err)
+		}
+		cfg.CacheTTL = d
+	}
+	return cfg, nil
+}
+
+// Keyring opens the configured master keys, it returns nil when encryption is not configured
+func (cfg Config) Keyring() (*Keyring, error) {
+	if cfg.MasterKey == "" {
+		if len(cfg.PreviousKeys) > 0 {
+			return nil, fmt.Errorf("CREDENTIAL_PREVIOUS_KEYS requires CREDENTIAL_MASTER_KEY")
+		}
+		return nil, nil
+	}
+	primary, err := OpenKeyProvider(cfg.MasterKey)
+	if err != nil {
+		return nil, err
+	}
+	var previous []KeyProvider
+	for _, uri := range cfg.PreviousKeys {
+		p, err := OpenKeyProvider(strings.TrimSpace(uri))
+		if err != nil {
+			return nil, err
+		}
+		previous = append(previous, p)
+	}
+	return NewKeyring(primary, previous...), nil
+}
+
+// NewCredentialStore opens the configured backend, wrapped in a TTL cache when enabled.
+// Encrypted secret columns are decrypted by the backend, callers only ever see plaintext accounts.
+func NewCredentialStore(cfg Config) (CredentialStore, error) {
+	keyring, err := cfg.Keyring()
+	if err != nil {
+		return nil, err
+	}
+
+	var store CredentialStore
+	switch cfg.Backend {
+	case "mysql", "sqlite":
+		store, err = newSQLStore(cfg.Backend, cfg.DSN, cfg.MaxOpenConns, keyring)
+	case "file":
+		store, err = newFileStore(cfg.File, keyring)
+	default:
+		return nil, fmt.Errorf("unknown credential backend %q", cfg.Backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg.CacheTTL > 0 {
+		store = NewCachedStore(store, cfg.CacheTTL)
+	}
+	return store, nil
+}
+
+var (
+	defaultMu    sync.Mutex
+	defaultStore CredentialStore
+)
+
+// SetDefault installs the store used by GetCloudAccountDetails
+func SetDefault(store CredentialStore) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultStore = store
+}
+
+// Default returns the process wide store, opening it from the environment on first use
+func Default() (CredentialStore, error) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultStore != nil {
+		return defaultStore, nil
+	}
+
+	cfg, err := ConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	store, err := NewCredentialStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defaultStore = store
+	return defaultStore, nil
+}
+
+// CachedStore serves recently looked up accounts from memory
+type CachedStore struct {
+	next CredentialStore
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[int]cacheEntry
+	// swept is when expired entries were last dropped, accounts that are not looked up again
+	// would otherwise stay forever
+	swept time.Time
+}
+
+type cacheEntry struct {
+	account *CloudAccount
+	expires time.Time
+}
+
+// NewCachedStore wraps next with a cache keyed by AccountID
+func NewCachedStore(next CredentialStore, ttl time.Duration) *CachedStore {
+	return &CachedStore{next: next, ttl: ttl, entries: make(map[int]cacheEntry)}
+}
+
+func (c *CachedStore) GetCloudAccount(ctx context.Context, accountID int) (*CloudAccount, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[accountID]
+	if ok && !time.Now().Before(entry.expires) {
+		delete(c.entries, accountID)
+		ok = false
+	}
+	c.mu.Unlock()
+	if ok {
+		account := *entry.account
+		return &account, nil
+	}
+
+	account, err := c.next.GetCloudAccount(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	now := time.Now()
+	c.sweep(now)
+	cached := *account
+	c.entries[accountID] = cacheEntry{account: &cached, expires: now.Add(c.ttl)}
+	c.mu.Unlock()
+	return account, nil
+}
+
+// sweep drops the expired entries at most once per ttl, the caller holds c.mu
+func (c *CachedStore) sweep(now time.Time) {
+	if now.Sub(c.swept) < c.ttl {
+		return
+	}
+	c.swept = now
+	for accountID, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, accountID)
+		}
+	}
+}
+
+// Invalidate drops an account from the cache so the next lookup hits the backend
+func (c *CachedStore) Invalidate(accountID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, accountID)
+}
+
+func (c *CachedStore) Close() error {
+	return c.next.Close()
+}
@@ -0,0 +1,197 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrNoToken is returned when no OAuth login has been completed for an account
+var ErrNoToken = errors.New("no OAuth token stored for account")
+
+// OAuthToken represents a row in the OAuthToken table
+type OAuthToken struct {
+	AccountID    int
+	AccessToken  string
+	RefreshToken string
+	TokenType    string
+	Expiry       time.Time
+}
+
+// TokenStore persists the OAuth tokens obtained through the Google and Azure login flows.
+// The SQL and file credential backends implement it next to CredentialStore.
+type TokenStore interface {
+	GetToken(ctx context.Context, accountID int) (*OAuthToken, error)
+	SaveToken(ctx context.Context, token *OAuthToken) error
+	DeleteToken(ctx context.Context, accountID int) error
+}
+
+// tokenColumns are the OAuthToken columns stored as envelopes
+var tokenColumns = []string{"AccessToken", "RefreshToken"}
+
+func tokenFields(token *OAuthToken) map[string]*string {
+	return map[string]*string{
+		"AccessToken":  &token.AccessToken,
+		"RefreshToken": &token.RefreshToken,
+	}
+}
+
+func sealToken(token *OAuthToken, keyring *Keyring) (*OAuthToken, error) {
+	sealed := *token
+	if keyring == nil {
+		return &sealed, nil
+	}
+	for column, field := range tokenFields(&sealed) {
+		if *field == "" {
+			continue
+		}
+		value, err := keyring.Encrypt(column, token.AccountID, *field)
+		if err != nil {
+			return nil, err
+		}
+		*field = value
+	}
+	return &sealed, nil
+}
+
+func openToken(token *OAuthToken, keyring *Keyring) error {
+	for column, field := range tokenFields(token) {
+		if !IsEncrypted(*field) {
+			continue
+		}
+		if keyring == nil {
+			return fmt.Errorf("%s of account %d is encrypted but CREDENTIAL_MASTER_KEY is not set", column, token.AccountID)
+		}
+		plaintext, err := keyring.Decrypt(column, token.AccountID, *field)
+		if err != nil {
+			return fmt.Errorf("account %d: %v", token.AccountID, err)
+		}
+		*field = plaintext
+	}
+	return nil
+}
+
+// createTokenTable works on both MySQL and SQLite
+const createTokenTable = `CREATE TABLE IF NOT EXISTS OAuthToken (
+	AccountID INT NOT NULL PRIMARY KEY,
+	AccessToken TEXT,
+	RefreshToken TEXT,
+	TokenType VARCHAR(32),
+	Expiry BIGINT
+)`
+
+// ensureTokenTable creates the token table on first use. A failure is not remembered, so the
+// next call tries again once the database is back.
+func (s *sqlStore) ensureTokenTable(ctx context.Context) error {
+	s.tokenTableMu.Lock()
+	defer s.tokenTableMu.Unlock()
+	if s.tokenTableReady {
+		return nil
+	}
+	if _, err := s.db.ExecContext(ctx, createTokenTable); err != nil {
+		return err
+	}
+	s.tokenTableReady = true
+	return nil
+}
+
+func (s *sqlStore) GetToken(ctx context.Context, accountID int) (*OAuthToken, error) {
+	if err := s.ensureTokenTable(ctx); err != nil {
+		return nil, err
+	}
+	token := OAuthToken{AccountID: accountID}
+	var accessToken, refreshToken, tokenType sql.NullString
+	var expiry sql.NullInt64
+	row := s.db.QueryRowContext(ctx, "SELECT AccessToken, RefreshToken, TokenType, Expiry FROM OAuthToken WHERE AccountID = ?", accountID)
+	if err := row.Scan(&accessToken, &refreshToken, &tokenType, &expiry); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNoToken
+		}
+		return nil, err
+	}
+	token.AccessToken = accessToken.String
+	token.RefreshToken = refreshToken.String
+	token.TokenType = tokenType.String
+	if expiry.Valid && expiry.Int64 > 0 {
+		token.Expiry = time.Unix(expiry.Int64, 0)
+	}
+
+	if err := openToken(&token, s.keyring); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (s *sqlStore) SaveToken(ctx context.Context, token *OAuthToken) error {
+	if err := s.ensureTokenTable(ctx); err != nil {
+		return err
+	}
+	sealed, err := sealToken(token, s.keyring)
+	if err != nil {
+		return err
+	}
+	var expiry int64
+	if !sealed.Expiry.IsZero() {
+		expiry = sealed.Expiry.Unix()
+	}
+	// REPLACE INTO is understood by both MySQL and SQLite
+	_, err = s.db.ExecContext(ctx, "REPLACE INTO OAuthToken (AccountID, AccessToken, RefreshToken, TokenType, Expiry) VALUES (?, ?, ?, ?, ?)",
+		sealed.AccountID, sealed.AccessToken, sealed.RefreshToken, sealed.TokenType, expiry)
+	return err
+}
+
+func (s *sqlStore) DeleteToken(ctx context.Context, accountID int) error {
+	if err := s.ensureTokenTable(ctx); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, "DELETE FROM OAuthToken WHERE AccountID = ?", accountID)
+	return err
+}
+
+// memoryTokens keeps tokens in process memory for the file backend, logins do not survive a restart
+type memoryTokens struct {
+	mu     sync.Mutex
+	tokens map[int]OAuthToken
+}
+
+func (m *memoryTokens) GetToken(ctx context.Context, accountID int) (*OAuthToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token, ok := m.tokens[accountID]
+	if !ok {
+		return nil, ErrNoToken
+	}
+	return &token, nil
+}
+
+func (m *memoryTokens) SaveToken(ctx context.Context, token *OAuthToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.tokens == nil {
+		m.tokens = make(map[int]OAuthToken)
+	}
+	m.tokens[token.AccountID] = *token
+	return nil
+}
+
+func (m *memoryTokens) DeleteToken(ctx context.Context, accountID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.tokens, accountID)
+	return nil
+}
+
+// Tokens returns the TokenStore backing a credential store
+func Tokens(store CredentialStore) (TokenStore, error) {
+	if cached, ok := store.(*CachedStore); ok {
+		store = cached.next
+	}
+	tokens, ok := store.(TokenStore)
+	if !ok {
+		return nil, fmt.Errorf("credential store %T cannot persist OAuth tokens", store)
+	}
+	return tokens, nil
+}
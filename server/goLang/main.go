@@ -1,22 +1,27 @@
 package main
 
 import (
-	"context"
-	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
-	"net/url"
+	"os"
 	"strings"
 
 	db "btep.project/databaseConnection"
 
+	"btep.project/auth/awsauth"
+	"btep.project/auth/login"
+	"btep.project/auth/vault"
+
 	aws_dynamodb "btep.project/DataBase/aws"
 	azure_cosmosdb "btep.project/DataBase/azure"
 	gcp_firebase "btep.project/DataBase/gcp"
 	aws_s3 "btep.project/Storage/aws"
 	azure_storage "btep.project/Storage/azure"
 	gcp_gcs "btep.project/Storage/gcp"
+	local_storage "btep.project/Storage/local"
+	"btep.project/Storage/objectstore"
+	"btep.project/Storage/transfer"
+	"btep.project/middleware"
 	aws_vpc "btep.project/network/aws"
 	azure_network "btep.project/network/azure"
 	gcp_network "btep.project/network/gcp"
@@ -31,24 +36,65 @@ import (
 	gcp_compute "btep.project/vm/gcp"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
-	"golang.org/x/oauth2"
-	googleAuth "golang.org/x/oauth2/google"
 )
 
 func main() {
-	// Create a new router
-	router := mux.NewRouter()
+	// Open the credential store once so every handler shares its connection pool
+	storeConfig, err := db.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid credential store configuration: %v", err)
+	}
+	credentialStore, err := db.NewCredentialStore(storeConfig)
+	if err != nil {
+		log.Fatalf("Error opening credential store: %v", err)
+	}
+	defer credentialStore.Close()
+	db.SetDefault(credentialStore)
+
+	// OAuth tokens of GCP and Azure accounts live next to the accounts and are refreshed on demand
+	tokenVault, err := vault.New(credentialStore)
+	if err != nil {
+		log.Fatalf("Error opening token vault: %v", err)
+	}
+	vault.SetDefault(tokenVault)
 
-	router.HandleFunc("/auth/google/login", handleGoogleLogin).Methods("POST")
-	router.HandleFunc("/auth/google/callback", handleGoogleCallback).Methods("GET")
+	// Every route requires the JWT issued by the Node backend, except the OAuth
+	// callbacks which are reached through browser redirects
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		log.Fatalf("JWT_SECRET must be set to the secret the Node backend signs sessions with")
+	}
+	authenticator := &middleware.Authenticator{
+		Secret: []byte(jwtSecret),
+		Store:  credentialStore,
+		Exempt: []string{"/auth/google/callback", "/auth/azure/callback"},
+		// Uploads and imports stream their body, it may be far larger than a request
+		Streaming: []string{
+			"/aws/s3/multipartUpload", "/aws/s3/resumeUpload",
+			"/aws/dynamodb/import", "/gcp/firebase/import", "/azure/cosmos/import",
+		},
+	}
 
-	// router.HandleFunc("/gcp/auth", auth_gcp.handleGetAccessToken).Methods("POST")
-	router.HandleFunc("/auth/azure/login", handleAzureLogin).Methods("POST")
-	router.HandleFunc("/auth/azure/callback", handleAzureCallback).Methods("GET")
+	// Create a new router
+	router := mux.NewRouter()
+	router.Use(authenticator.Middleware)
+
+	// OAuth logins for GCP and Azure accounts, the tokens end up in the vault
+	loginFlow := login.NewFlow(credentialStore, tokenVault)
+	router.HandleFunc("/auth/google/login", loginFlow.LoginHandler("gcp")).Methods("POST")
+	router.HandleFunc("/auth/google/callback", loginFlow.CallbackHandler("gcp")).Methods("GET")
+	router.HandleFunc("/auth/azure/login", loginFlow.LoginHandler("azure")).Methods("POST")
+	router.HandleFunc("/auth/azure/callback", loginFlow.CallbackHandler("azure")).Methods("GET")
 	// AWS S3
 	router.HandleFunc("/aws/s3/createBucket", aws_s3.CreateBucketHandler).Methods("POST")
 	router.HandleFunc("/aws/s3/uploadObject", aws_s3.UploadObjectHandler).Methods("POST")
+	router.HandleFunc("/aws/s3/multipartUpload", aws_s3.MultipartUploadHandler).Methods("POST")
+	router.HandleFunc("/aws/s3/resumeUpload", aws_s3.ResumeUploadHandler).Methods("POST")
+	router.HandleFunc("/aws/s3/uploadStatus", aws_s3.UploadStatusHandler).Methods("POST")
+	router.HandleFunc("/aws/s3/abortUpload", aws_s3.AbortUploadHandler).Methods("POST")
+	router.HandleFunc("/aws/s3/listUploads", aws_s3.ListUploadsHandler).Methods("POST")
 	router.HandleFunc("/aws/s3/getObject", aws_s3.GetObjectHandler).Methods("GET")
+	router.HandleFunc("/aws/s3/listObjects", aws_s3.ListObjectsHandler).Methods("POST")
 	router.HandleFunc("/aws/s3/deleteObject", aws_s3.DeleteObjectHandler).Methods("POST")
 	router.HandleFunc("/aws/s3/deleteBucket", aws_s3.DeleteBucketHandler).Methods("POST")
 	router.HandleFunc("/aws/s3/listBuckets", aws_s3.ListBucketsHandler).Methods("POST")
@@ -57,6 +103,7 @@ func main() {
 	router.HandleFunc("/gcp/gcs/createBucket", gcp_gcs.CreateBucketHandler).Methods("POST")
 	router.HandleFunc("/gcp/gcs/uploadObject", gcp_gcs.UploadObjectHandler).Methods("POST")
 	router.HandleFunc("/gcp/gcs/getObject", gcp_gcs.GetObjectHandler).Methods("POST")
+	router.HandleFunc("/gcp/gcs/listObjects", gcp_gcs.ListObjectsHandler).Methods("POST")
 	router.HandleFunc("/gcp/gcs/deleteObject", gcp_gcs.DeleteObjectHandler).Methods("POST")
 	router.HandleFunc("/gcp/gcs/deleteBucket", gcp_gcs.DeleteBucketHandler).Methods("POST")
 	router.HandleFunc("/gcp/gcs/listBuckets", gcp_gcs.ListBucketsHandler).Methods("POST")
@@ -68,6 +115,50 @@ func main() {
 	router.HandleFunc("/azure/storage/listAccounts", azure_storage.ListStorageAccountsHandler).Methods("POST")
 	router.HandleFunc("/azure/storage/getObjects", azure_storage.GetObjectHandler).Methods("POST")
 	router.HandleFunc("/azure/storage/uploadObjects", azure_storage.UploadBlobHandler).Methods("POST")
+	router.HandleFunc("/azure/storage/listObjects", azure_storage.ListObjectsHandler).Methods("POST")
+	router.HandleFunc("/azure/storage/deleteObject", azure_storage.DeleteBlobHandler).Methods("POST")
+	router.HandleFunc("/azure/storage/copyObject", azure_storage.CopyBlobHandler).Methods("POST")
+	router.HandleFunc("/azure/storage/setAccessTier", azure_storage.SetAccessTierHandler).Methods("POST")
+	router.HandleFunc("/azure/storage/createContainer", azure_storage.CreateContainerHandler).Methods("POST")
+	router.HandleFunc("/azure/storage/listContainers", azure_storage.ListContainersHandler).Methods("POST")
+	router.HandleFunc("/azure/storage/deleteContainer", azure_storage.DeleteContainerHandler).Methods("POST")
+
+	// Provider-agnostic object storage
+	objectstore.Register("aws", aws_s3.NewObjectStore)
+	// Self-hosted S3-compatible clouds (MinIO, Ceph) go through the S3 implementation
+	awsConfig, err := awsauth.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid AWS endpoint configuration: %v", err)
+	}
+	awsauth.Configure(awsConfig)
+	objectstore.Register(awsauth.PrivateProvider, aws_s3.NewObjectStore)
+	objectstore.Register("gcp", gcp_gcs.NewObjectStore)
+	objectstore.Register("azure", azure_storage.NewObjectStore)
+	// Local buckets live on this server, so they are only offered on development and CI servers
+	localConfig, err := local_storage.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid local storage configuration: %v", err)
+	}
+	if localConfig.Enabled {
+		local_storage.Configure(localConfig)
+		objectstore.Register(local_storage.Provider, local_storage.NewObjectStore)
+	}
+	router.HandleFunc("/storage/{provider}/createBucket", objectstore.CreateBucketHandler).Methods("POST")
+	router.HandleFunc("/storage/{provider}/putObject", objectstore.PutObjectHandler).Methods("POST")
+	router.HandleFunc("/storage/{provider}/getObject", objectstore.GetObjectHandler).Methods("POST")
+	router.HandleFunc("/storage/{provider}/deleteObject", objectstore.DeleteObjectHandler).Methods("POST")
+	router.HandleFunc("/storage/{provider}/listObjects", objectstore.ListObjectsHandler).Methods("POST")
+	router.HandleFunc("/storage/{provider}/statObject", objectstore.StatObjectHandler).Methods("POST")
+	router.HandleFunc("/storage/{provider}/signedURL", objectstore.SignedURLHandler).Methods("POST")
+	router.HandleFunc("/storage/{provider}/getBucketConfig", objectstore.GetBucketConfigHandler).Methods("POST")
+	router.HandleFunc("/storage/{provider}/setBucketConfig", objectstore.SetBucketConfigHandler).Methods("POST")
+	router.HandleFunc("/storage/{provider}/updateObject", objectstore.UpdateObjectHandler).Methods("POST")
+	router.HandleFunc("/storage/{provider}/setStorageClass", objectstore.SetStorageClassHandler).Methods("POST")
+
+	// Cross-cloud sync jobs
+	router.HandleFunc("/transfer/sync", transfer.SyncHandler).Methods("POST")
+	router.HandleFunc("/transfer/jobs/{jobID}", transfer.JobHandler).Methods("GET")
+	router.HandleFunc("/transfer/jobs/{jobID}/cancel", transfer.CancelJobHandler).Methods("POST")
 
 	// EC2
 	router.HandleFunc("/aws/ec2/createInstance", aws_ec2.CreateInstanceHandler).Methods("POST")
@@ -89,17 +180,24 @@ func main() {
 	router.HandleFunc("/aws/dynamodb/readItem", aws_dynamodb.ReadItemHandler).Methods("POST")
 	router.HandleFunc("/aws/dynamodb/deleteItem", aws_dynamodb.DeleteItemHandler).Methods("POST")
 	router.HandleFunc("/aws/dynamodb/updateItem", aws_dynamodb.UpdateItemHandler).Methods("POST")
+	router.HandleFunc("/aws/dynamodb/batchWrite", aws_dynamodb.BatchWriteItemsHandler).Methods("POST")
+	router.HandleFunc("/aws/dynamodb/transactWrite", aws_dynamodb.TransactWriteItemsHandler).Methods("POST")
 	router.HandleFunc("/aws/dynamodb/createTable", aws_dynamodb.CreateTableHandler).Methods("POST")
 	router.HandleFunc("/aws/dynamodb/deleteTable", aws_dynamodb.DeleteTableHandler).Methods("POST")
 	router.HandleFunc("/aws/dynamodb/updateTable", aws_dynamodb.UpdateTableHandler).Methods("POST")
+	router.HandleFunc("/aws/dynamodb/describeTable", aws_dynamodb.DescribeTableHandler).Methods("POST")
 	router.HandleFunc("/aws/dynamodb/listTables", aws_dynamodb.ListTablesHandler).Methods("POST")
 	router.HandleFunc("/aws/dynamodb/listItems", aws_dynamodb.ListItemsHandler).Methods("POST")
+	router.HandleFunc("/aws/dynamodb/export", aws_dynamodb.ExportItemsHandler).Methods("POST")
+	router.HandleFunc("/aws/dynamodb/import", aws_dynamodb.ImportItemsHandler).Methods("POST")
 
 	// GCP Firebase
 	router.HandleFunc("/gcp/firebase/createTable", gcp_firebase.CreateTableHandler).Methods("POST")
 	router.HandleFunc("/gcp/firebase/deleteTable", gcp_firebase.DeleteTableHandler).Methods("POST")
 	router.HandleFunc("/gcp/firebase/updateTable", gcp_firebase.UpdateTableHandler).Methods("PUT")
 	router.HandleFunc("/gcp/firebase/listTables", gcp_firebase.ListTablesHandler).Methods("GET")
+	router.HandleFunc("/gcp/firebase/export", gcp_firebase.ExportDocumentsHandler).Methods("POST")
+	router.HandleFunc("/gcp/firebase/import", gcp_firebase.ImportDocumentsHandler).Methods("POST")
 
 	// Azure Cosmos DB
 	router.HandleFunc("/azure/cosmos/createAccount", azure_cosmosdb.CreateCosmosDBAccountHandler).Methods("POST")
@@ -109,6 +207,8 @@ func main() {
 	router.HandleFunc("/azure/cosmos/createdatabase", azure_cosmosdb.CreateCosmosDBDatabaseHandler).Methods("POST")
 	router.HandleFunc("/azure/cosmos/deleteDatabase", azure_cosmosdb.DeleteCosmosDBDatabaseHandler).Methods("POST")
 	router.HandleFunc("/azure/cosmos/listAccounts", azure_cosmosdb.ListCosmosDBAccountsHandler).Methods("GET")
+	router.HandleFunc("/azure/cosmos/export", azure_cosmosdb.ExportDocumentsHandler).Methods("POST")
+	router.HandleFunc("/azure/cosmos/import", azure_cosmosdb.ImportDocumentsHandler).Methods("POST")
 
 	// GCP Network
 	router.HandleFunc("/gcp/network/createNetwork", gcp_network.CreateNetworkHandler).Methods("POST")
@@ -193,11 +293,18 @@ func main() {
 	router.HandleFunc("/azure/functions/deleteFunction", azure_functions.DeleteFunctionAppHandler).Methods("POST")
 	router.HandleFunc("/azure/functions/listFunctions", azure_functions.ListFunctionAppsHandler).Methods("GET")
 
-	// Setup CORS
+	// Setup CORS. The OAuth login is started with a credentialed fetch, which a wildcard origin
+	// cannot answer, so the frontend origins are named: CORS_ORIGINS, comma-separated.
+	allowedOrigins := []string{"http://localhost:3000"}
+	if origins := os.Getenv("CORS_ORIGINS"); origins != "" {
+		allowedOrigins = strings.Split(origins, ",")
+	}
 	cors := handlers.CORS(
-		handlers.AllowedOrigins([]string{"*"}), // Adjust this to the appropriate domains in production
+		handlers.AllowedOrigins(allowedOrigins),
+		handlers.AllowCredentials(),
 		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "POST", "OPTIONS"}),
-		handlers.AllowedHeaders([]string{"Content-Type", "X-Requested-With", "Authorization"}),
+		handlers.AllowedHeaders([]string{"Content-Type", "X-Requested-With", "Authorization", "X-Account-ID", "Range", "If-None-Match"}),
+		handlers.ExposedHeaders([]string{"X-Upload-Id", "Content-Range", "Content-Disposition", "Accept-Ranges", "ETag"}),
 	)(router)
 
 	// Apply the CORS middleware to the router
@@ -207,168 +314,3 @@ func main() {
 	log.Println("Server starting on port 8080...")
 	http.ListenAndServe(":8080", cors)
 }
-
-var (
-	googleOauthConfig = &oauth2.Config{
-		RedirectURL:  "http://localhost:8080/auth/google/callback",
-		ClientID:     "570397565376-ks7fmsvgrma2c9gm2k8lfa5tjhqdpala.apps.googleusercontent.com",
-		ClientSecret: "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",
-		Scopes: []string{
-			"https://www.googleapis.com/auth/datastore",
-			"https://www.googleapis.com/auth/cloud-platform",
-			"https://www.googleapis.com/auth/userinfo.profile",
-			"https://www.googleapis.com/auth/userinfo.email",
-			"https://www.googleapis.com/auth/devstorage.read_write",
-		},
-
-		Endpoint: googleAuth.Endpoint,
-	}
-)
-
-type googleToken struct {
-	AccountID int `json:"accountID"`
-}
-
-func handleGoogleLogin(w http.ResponseWriter, r *http.Request) {
-	//googleToken
-	var req googleToken
-	err := json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
-		return
-	}
-	fmt.Println("req done", req)
-	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
-		return
-	}
-	googleOauthConfig.ClientSecret = cloudAccount.ClientSecret.String
-	authURL := googleOauthConfig.AuthCodeURL("state", oauth2.AccessTypeOffline)
-	http.Redirect(w, r, authURL, http.StatusTemporaryRedirect)
-}
-
-func handleGoogleCallback(w http.ResponseWriter, r *http.Request) {
-
-	authCode := r.URL.Query().Get("code")
-	fmt.Println("googleOauthConfig:", googleOauthConfig.ClientSecret)
-	token, err := googleOauthConfig.Exchange(context.Background(), authCode)
-	if err != nil {
-		log.Fatalf("Unable to retrieve token: %v", err)
-		return
-	}
-	fmt.Println("token: ", token.AccessToken)
-
-	http.SetCookie(w, &http.Cookie{Name: "accessTokenGCP", Value: token.AccessToken})
-
-	w.Header().Set("Authorization", "Bearer "+token.AccessToken)
-	http.Redirect(w, r, "http://localhost:3000/cloud", http.StatusTemporaryRedirect)
-
-}
-
-var (
-	clientID     = "YOUR_CLIENT_ID_HERE"
-	clientSecret = "YOUR_CLIENT"
-	redirectURI  = "http://localhost:8080/auth/azure/callback"
-	authURL      = "https://login.microsoftonline.com/8059c3d5-a962-4394-8b62-ef7c9211422a/oauth2/v2.0/authorize"
-	tokenURL     = "https://login.microsoftonline.com/8059c3d5-a962-4394-8b62-ef7c9211422a/oauth2/v2.0/token"
-	scope        = "https://management.azure.com/user_impersonation"
-	cookieName   = "codeVerifier"
-)
-
-func handleAzureLogin(w http.ResponseWriter, r *http.Request) {
-	var req googleToken
-	err := json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
-		return
-	}
-	fmt.Println("req done", req)
-	accountID := req.AccountID
-
-	state := "abcdefghijklmnop"
-	cloudAccount, err := db.GetCloudAccountDetails(accountID)
-
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to convert accountIDStr to int: %v", err), http.StatusInternalServerError)
-		return
-	}
-	clientID = cloudAccount.ClientID.String
-	clientSecret = cloudAccount.ClientSecret.String
-
-	params := url.Values{}
-	params.Set("client_id", cloudAccount.ClientID.String)
-	params.Set("response_type", "code")
-	params.Set("redirect_uri", redirectURI)
-	params.Set("scope", scope)
-	params.Set("code_challenge", "IdbruyXUD2XUVuNsvyuss8gxfag0PMLVjjoOzyZCaIY")
-	params.Set("code_challenge_method", "S256")
-	params.Set("state", state)
-
-	redirectURL := authURL + "?" + params.Encode()
-	http.Redirect(w, r, redirectURL, http.StatusFound)
-}
-
-func handleAzureCallback(w http.ResponseWriter, r *http.Request) {
-	code := r.URL.Query().Get("code")
-	tokenParams := url.Values{}
-	tokenParams.Set("client_id", clientID)
-	tokenParams.Set("client_secret", clientSecret)
-	tokenParams.Set("code", code)
-	tokenParams.Set("redirect_uri", redirectURI)
-	tokenParams.Set("grant_type", "authorization_code")
-	tokenParams.Set("code_verifier", "u7asE4uSEPKu3eRl18_aJylYVXoTeaZWse1U-2mky5FDl881RtPHAagcKEBIIlcx7919ZcR5LHhD4FjP0ocVZ7rk3LJFmO_V42-evDi7A_ZbbaCvWNpv9MLjrTXFJZPv")
-	tokenParams.Set("scope", scope)
-	reqBody := strings.NewReader(tokenParams.Encode())
-
-	req, err := http.NewRequest("POST", tokenURL, reqBody)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create POST request: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to make POST request: %v", err), http.StatusInternalServerError)
-		return
-	}
-	defer resp.Body.Close()
-
-	var respBody map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&respBody); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to decode response body: %v", err), http.StatusInternalServerError)
-		return
-	}
-	fmt.Println("respBody:", respBody)
-	accessToken1, ok := respBody["access_token"].(string)
-	if !ok {
-		http.Error(w, "Access token not found in response", http.StatusInternalServerError)
-		return
-	}
-
-	http.SetCookie(w, &http.Cookie{Name: "accessTokenAZURE", Value: string(accessToken1)})
-	fmt.Println("token:", accessToken1)
-	// w.Write(respBody)
-	http.Redirect(w, r, "http://localhost:3000/cloud", http.StatusTemporaryRedirect)
-}
-
-// func generateCodeChallenge(verifier string) string {
-// 	h := sha256.Sum256([]byte(verifier))
-// 	return base64.RawURLEncoding.EncodeToString(h[:])
-// }
-
-// func generateRandomString(length int) string {
-// 	const chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-// 	b := make([]byte, length)
-// 	for i := range b {
-// 		idx, _ := rand.Int(rand.Reader, big.NewInt(int64(len(chars))))
-// 		b[i] = chars[idx.Int64()]
-// 	}
-// 	return string(b)
-// }
@@ -0,0 +1,328 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"btep.project/apierror"
+	db "btep.project/databaseConnection"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// maxJSONBody caps how much of a JSON body is buffered to look for account IDs
+const maxJSONBody = 10 << 20
+
+// Claims are the JWT claims issued by the Node backend on login
+type Claims struct {
+	UserID   int    `json:"userID"`
+	Username string `json:"username"`
+	jwt.RegisteredClaims
+}
+
+type contextKey int
+
+const (
+	claimsKey contextKey = iota
+	accountKey
+)
+
+// ClaimsFromContext returns the authenticated user of a request that passed Authenticator
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey).(*Claims)
+	return claims, ok
+}
+
+// AccountIDFromContext returns the account a request that passed Authenticator is for, the one
+// named by its query, X-Account-ID header, form or top-level JSON accountID
+func AccountIDFromContext(ctx context.Context) (int, bool) {
+	accountID, ok := ctx.Value(accountKey).(int)
+	return accountID, ok
+}
+
+// FormAccountID returns the accountID of a form once the handler has parsed it. Authenticator
+// leaves a multipart body unread when the account is in the query or header, so a form field
+// naming any other account is refused here.
+func FormAccountID(r *http.Request) (int, error) {
+	r.FormValue("accountID") // parses the form if the handler has not yet
+	values := r.Form["accountID"]
+	verified, ok := AccountIDFromContext(r.Context())
+	if !ok {
+		if len(values) == 0 {
+			return 0, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid account ID: accountID is required")
+		}
+		accountID, err := strconv.Atoi(values[0])
+		if err != nil {
+			return 0, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, fmt.Sprintf("Invalid account ID: %v", err))
+		}
+		return accountID, nil
+	}
+	for _, value := range values {
+		if accountID, err := strconv.Atoi(value); err != nil || accountID != verified {
+			return 0, forbidden(value)
+		}
+	}
+	return verified, nil
+}
+
+// Authenticator validates the session JWT and makes sure every AccountID referenced by a
+// request belongs to the authenticated user.
+type Authenticator struct {
+	// Secret is the HMAC key shared with the Node backend (JWT_SECRET)
+	Secret []byte
+	// Store resolves accounts to their owning UserID
+	Store db.CredentialStore
+	// Exempt lists path prefixes that skip authentication, e.g. OAuth callbacks hit by browser redirects
+	Exempt []string
+	// Streaming lists the paths whose body is an upload rather than a request. It is passed on
+	// unread, so these routes must name their account in the query or X-Account-ID header.
+	Streaming []string
+}
+
+// Middleware implements mux.MiddlewareFunc.
+//
+// Account IDs are taken from the accountID query parameter, the X-Account-ID header, the
+// accountID field of a form and every "accountID" field of a JSON body, however deeply nested.
+// All of them must belong to the user. The query, header, form and top-level JSON IDs name the
+// account of the request and must agree, nested ones may name others, e.g. the source and
+// destination of a transfer. A multipart body is only parsed when neither the query nor the
+// header names the account, so streaming uploads are left untouched; handlers reading such a
+// form use FormAccountID. The body of a Streaming route is never read.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions || a.exempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		claims, err := a.parseToken(r)
+		if err != nil {
+			apierror.Send(w, apierror.New(http.StatusUnauthorized, apierror.CodeUnauthorized, fmt.Sprintf("Unauthorized: %v", err)))
+			return
+		}
+
+		refs, err := accountIDs(r, a.streaming(r.URL.Path))
+		if err != nil {
+			apierror.Write(w, err, "")
+			return
+		}
+		for _, accountID := range refs.all {
+			account, err := a.Store.GetCloudAccount(r.Context(), accountID)
+			if err != nil {
+				log.Printf("auth: looking up account %d: %v", accountID, err)
+				apierror.Send(w, forbidden(strconv.Itoa(accountID)))
+				return
+			}
+			if account.UserID != claims.UserID {
+				apierror.Send(w, forbidden(strconv.Itoa(accountID)))
+				return
+			}
+		}
+
+		ctx := context.WithValue(r.Context(), claimsKey, claims)
+		if refs.primary != nil {
+			ctx = context.WithValue(ctx, accountKey, *refs.primary)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func forbidden(accountID string) *apierror.Error {
+	return apierror.New(http.StatusForbidden, apierror.CodeForbidden, fmt.Sprintf("Forbidden: account %s is not accessible", accountID))
+}
+
+func (a *Authenticator) streaming(path string) bool {
+	for _, streamed := range a.Streaming {
+		if path == streamed {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Authenticator) exempt(path string) bool {
+	for _, prefix := range a.Exempt {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseToken accepts "Authorization: Bearer <jwt>" as well as the bare token the Node backend sends
+func (a *Authenticator) parseToken(r *http.Request) (*Claims, error) {
+	tokenString := strings.TrimSpace(r.Header.Get("Authorization"))
+	if tokenString == "" {
+		return nil, fmt.Errorf("missing Authorization header")
+	}
+	if len(tokenString) > 7 && strings.EqualFold(tokenString[:7], "bearer ") {
+		tokenString = strings.TrimSpace(tokenString[7:])
+	}
+
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		return a.Secret, nil
+	}, jwt.WithValidMethods([]string{"HS256", "HS384", "HS512"}))
+	if err != nil {
+		return nil, err
+	}
+	if claims.UserID == 0 {
+		return nil, fmt.Errorf("token has no userID")
+	}
+	return claims, nil
+}
+
+// accountRefs are the accounts a request refers to
+type accountRefs struct {
+	// primary is the account of the request, nil when it names none
+	primary *int
+	all     []int
+	seen    map[int]bool
+}
+
+// add records an account ID, primary ones must all be the same account
+func (refs *accountRefs) add(value string, primary bool) error {
+	accountID, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, fmt.Sprintf("Invalid request: invalid account ID %q", value))
+	}
+	if primary {
+		if refs.primary != nil && *refs.primary != accountID {
+			return apierror.New(http.StatusForbidden, apierror.CodeForbidden,
+				fmt.Sprintf("Forbidden: the request names both account %d and account %d", *refs.primary, accountID))
+		}
+		refs.primary = &accountID
+	}
+	if !refs.seen[accountID] {
+		refs.seen[accountID] = true
+		refs.all = append(refs.all, accountID)
+	}
+	return nil
+}
+
+// accountIDs collects every account the request refers to without consuming the body. The
+// body of a streaming request is not read at all.
+func accountIDs(r *http.Request, streaming bool) (*accountRefs, error) {
+	refs := &accountRefs{seen: make(map[int]bool)}
+	for key, values := range r.URL.Query() {
+		if !strings.EqualFold(key, "accountID") {
+			continue
+		}
+		for _, v := range values {
+			if err := refs.add(v, true); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, v := range r.Header.Values("X-Account-ID") {
+		if err := refs.add(v, true); err != nil {
+			return nil, err
+		}
+	}
+
+	if streaming {
+		if refs.primary == nil {
+			return nil, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest,
+				"Invalid request: an upload names its account in the accountID query parameter or X-Account-ID header")
+		}
+		return refs, nil
+	}
+	if r.Body == nil || r.Body == http.NoBody {
+		return refs, nil
+	}
+	var form map[string][]string
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "multipart/form-data":
+		if refs.primary != nil {
+			// Left for streaming handlers, FormAccountID checks the form of the others
+			return refs, nil
+		}
+		if err := r.ParseMultipartForm(10 << 20); err != nil {
+			return nil, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, fmt.Sprintf("Invalid request: %v", err))
+		}
+		form = r.MultipartForm.Value
+	case "application/x-www-form-urlencoded":
+		if err := r.ParseForm(); err != nil {
+			return nil, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, fmt.Sprintf("Invalid request: %v", err))
+		}
+		form = r.PostForm
+	default:
+		return refs, jsonAccountIDs(r, refs)
+	}
+	for key, values := range form {
+		if !strings.EqualFold(key, "accountID") {
+			continue
+		}
+		for _, v := range values {
+			if err := refs.add(v, true); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return refs, nil
+}
+
+// jsonAccountIDs buffers a JSON body, restores it for the handler and adds its account IDs
+func jsonAccountIDs(r *http.Request, refs *accountRefs) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxJSONBody+1))
+	r.Body.Close()
+	if err != nil {
+		return apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, fmt.Sprintf("Invalid request: %v", err))
+	}
+	if len(body) > maxJSONBody {
+		return apierror.New(http.StatusRequestEntityTooLarge, apierror.CodeInvalidRequest, "Invalid request: request body too large")
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	// Handlers decode the first JSON value and ignore what follows, so that value is checked
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		// Not JSON, so there is no account to check; the handler reports the bad body
+		return nil
+	}
+	return collectAccountIDs(doc, refs, true)
+}
+
+// collectAccountIDs adds every accountID field of a JSON value, those of the top-level
+// object name the account of the request
+func collectAccountIDs(value interface{}, refs *accountRefs, top bool) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, elem := range v {
+			if strings.EqualFold(key, "accountID") {
+				switch id := elem.(type) {
+				case json.Number:
+					if err := refs.add(id.String(), top); err != nil {
+						return err
+					}
+					continue
+				case string:
+					if err := refs.add(id, top); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			if err := collectAccountIDs(elem, refs, false); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, elem := range v {
+			if err := collectAccountIDs(elem, refs, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,357 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	db "btep.project/databaseConnection"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var testSecret = []byte("test-secret")
+
+// fakeStore owns accounts 1 and 3 by user 1 and account 2 by user 2
+type fakeStore map[int]*db.CloudAccount
+
+func newFakeStore() fakeStore {
+	return fakeStore{
+		1: {AccountID: 1, UserID: 1},
+		2: {AccountID: 2, UserID: 2},
+		3: {AccountID: 3, UserID: 1},
+	}
+}
+
+func (s fakeStore) GetCloudAccount(ctx context.Context, accountID int) (*db.CloudAccount, error) {
+	account, ok := s[accountID]
+	if !ok {
+		return nil, db.ErrAccountNotFound
+	}
+	return account, nil
+}
+
+func (s fakeStore) Close() error { return nil }
+
+func token(t *testing.T, method jwt.SigningMethod, key interface{}, userID int, expires time.Time) string {
+	t.Helper()
+	claims := Claims{UserID: userID, RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(expires)}}
+	signed, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func userToken(t *testing.T, userID int) string {
+	return "Bearer " + token(t, jwt.SigningMethodHS256, testSecret, userID, time.Now().Add(time.Hour))
+}
+
+// seen is what the handler behind the middleware got
+type seen struct {
+	called    bool
+	accountID int
+	hasID     bool
+	body      string
+}
+
+func serve(t *testing.T, r *http.Request) (*httptest.ResponseRecorder, *seen) {
+	t.Helper()
+	got := &seen{}
+	auth := &Authenticator{
+		Secret:    testSecret,
+		Store:     newFakeStore(),
+		Exempt:    []string{"/auth/google/callback"},
+		Streaming: []string{"/import"},
+	}
+	handler := auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.called = true
+		got.accountID, got.hasID = AccountIDFromContext(r.Context())
+		if r.Body != nil {
+			var body bytes.Buffer
+			body.ReadFrom(r.Body)
+			got.body = body.String()
+		}
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w, got
+}
+
+func jsonRequest(target, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+func formRequest(target string, values url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func multipartRequest(t *testing.T, target string, fields map[string]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for name, value := range fields {
+		writer.WriteField(name, value)
+	}
+	part, _ := writer.CreateFormFile("file", "object.txt")
+	part.Write([]byte("contents"))
+	writer.Close()
+	r := httptest.NewRequest(http.MethodPost, target, &body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	return r
+}
+
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var envelope struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+		t.Fatalf("response is not an error envelope: %q", w.Body.String())
+	}
+	return envelope.Code
+}
+
+func TestTokens(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := token(t, jwt.SigningMethodHS256, testSecret, 1, time.Now().Add(time.Hour))
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+	}{
+		{"missing", "", http.StatusUnauthorized},
+		{"garbage", "Bearer not.a.jwt", http.StatusUnauthorized},
+		{"wrong secret", "Bearer " + token(t, jwt.SigningMethodHS256, []byte("other"), 1, time.Now().Add(time.Hour)), http.StatusUnauthorized},
+		{"expired", "Bearer " + token(t, jwt.SigningMethodHS256, testSecret, 1, time.Now().Add(-time.Minute)), http.StatusUnauthorized},
+		{"alg none", "Bearer " + token(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, 1, time.Now().Add(time.Hour)), http.StatusUnauthorized},
+		{"RS256", "Bearer " + token(t, jwt.SigningMethodRS256, rsaKey, 1, time.Now().Add(time.Hour)), http.StatusUnauthorized},
+		{"no userID", "Bearer " + token(t, jwt.SigningMethodHS256, testSecret, 0, time.Now().Add(time.Hour)), http.StatusUnauthorized},
+		{"bearer", "Bearer " + valid, http.StatusOK},
+		{"bare token", valid, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/x?accountID=1", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w, got := serve(t, r)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if tt.status == http.StatusUnauthorized {
+				if got.called {
+					t.Fatal("handler was called")
+				}
+				if code := errorCode(t, w); code != "unauthorized" {
+					t.Fatalf("code = %q, want unauthorized", code)
+				}
+			}
+		})
+	}
+}
+
+func TestExemptAndPreflight(t *testing.T) {
+	for _, r := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/auth/google/callback?accountID=2", nil),
+		httptest.NewRequest(http.MethodOptions, "/x?accountID=2", nil),
+	} {
+		if w, got := serve(t, r); w.Code != http.StatusOK || !got.called {
+			t.Fatalf("%s %s: status = %d, want it to reach the handler", r.Method, r.URL, w.Code)
+		}
+	}
+}
+
+func TestAccountOwnership(t *testing.T) {
+	tests := []struct {
+		name    string
+		request func(t *testing.T) *http.Request
+		status  int
+		// accountID is the account the handler should see in its context, 0 for none
+		accountID int
+	}{
+		{"own query", func(t *testing.T) *http.Request {
+			return httptest.NewRequest(http.MethodGet, "/x?accountID=1", nil)
+		}, http.StatusOK, 1},
+		{"foreign query", func(t *testing.T) *http.Request {
+			return httptest.NewRequest(http.MethodGet, "/x?accountID=2", nil)
+		}, http.StatusForbidden, 0},
+		{"unknown account", func(t *testing.T) *http.Request {
+			return httptest.NewRequest(http.MethodGet, "/x?accountID=99", nil)
+		}, http.StatusForbidden, 0},
+		{"repeated query", func(t *testing.T) *http.Request {
+			return httptest.NewRequest(http.MethodGet, "/x?accountID=1&accountID=2", nil)
+		}, http.StatusForbidden, 0},
+		{"foreign header", func(t *testing.T) *http.Request {
+			r := httptest.NewRequest(http.MethodGet, "/x", nil)
+			r.Header.Set("X-Account-ID", "2")
+			return r
+		}, http.StatusForbidden, 0},
+		{"own header", func(t *testing.T) *http.Request {
+			r := httptest.NewRequest(http.MethodGet, "/x", nil)
+			r.Header.Set("X-Account-ID", "3")
+			return r
+		}, http.StatusOK, 3},
+		{"own JSON", func(t *testing.T) *http.Request {
+			return jsonRequest("/x", `{"accountID":1,"bucketName":"b"}`)
+		}, http.StatusOK, 1},
+		{"foreign JSON", func(t *testing.T) *http.Request {
+			return jsonRequest("/x", `{"accountID":2}`)
+		}, http.StatusForbidden, 0},
+		{"foreign JSON string", func(t *testing.T) *http.Request {
+			return jsonRequest("/x", `{"accountID":"2"}`)
+		}, http.StatusForbidden, 0},
+		{"foreign JSON other case", func(t *testing.T) *http.Request {
+			return jsonRequest("/x", `{"AccountId":2}`)
+		}, http.StatusForbidden, 0},
+		{"foreign nested JSON", func(t *testing.T) *http.Request {
+			return jsonRequest("/x", `{"accountID":1,"source":{"store":{"accountID":2}}}`)
+		}, http.StatusForbidden, 0},
+		{"foreign JSON in array", func(t *testing.T) *http.Request {
+			return jsonRequest("/x", `{"jobs":[{"accountID":1},{"accountID":2}]}`)
+		}, http.StatusForbidden, 0},
+		{"foreign JSON in top-level array", func(t *testing.T) *http.Request {
+			return jsonRequest("/x", `[{"accountID":2}]`)
+		}, http.StatusForbidden, 0},
+		{"foreign JSON before trailing data", func(t *testing.T) *http.Request {
+			return jsonRequest("/x", `{"accountID":2} trailing`)
+		}, http.StatusForbidden, 0},
+		{"own nested JSON of two accounts", func(t *testing.T) *http.Request {
+			return jsonRequest("/x", `{"source":{"accountID":1},"destination":{"accountID":3}}`)
+		}, http.StatusOK, 0},
+		{"JSON without content type", func(t *testing.T) *http.Request {
+			return httptest.NewRequest(http.MethodPost, "/x", strings.NewReader(`{"accountID":2}`))
+		}, http.StatusForbidden, 0},
+		{"invalid JSON is left to the handler", func(t *testing.T) *http.Request {
+			return jsonRequest("/x", `{"accountID":`)
+		}, http.StatusOK, 0},
+		{"own form", func(t *testing.T) *http.Request {
+			return formRequest("/x", url.Values{"accountID": {"1"}})
+		}, http.StatusOK, 1},
+		{"foreign form", func(t *testing.T) *http.Request {
+			return formRequest("/x", url.Values{"accountID": {"2"}})
+		}, http.StatusForbidden, 0},
+		{"foreign multipart form", func(t *testing.T) *http.Request {
+			return multipartRequest(t, "/x", map[string]string{"accountID": "2"})
+		}, http.StatusForbidden, 0},
+		{"own multipart form", func(t *testing.T) *http.Request {
+			return multipartRequest(t, "/x", map[string]string{"accountID": "1"})
+		}, http.StatusOK, 1},
+		{"query and JSON disagree", func(t *testing.T) *http.Request {
+			return jsonRequest("/x?accountID=1", `{"accountID":3}`)
+		}, http.StatusForbidden, 0},
+		{"query and foreign JSON", func(t *testing.T) *http.Request {
+			return jsonRequest("/x?accountID=1", `{"accountID":2}`)
+		}, http.StatusForbidden, 0},
+		{"header and query disagree", func(t *testing.T) *http.Request {
+			r := httptest.NewRequest(http.MethodGet, "/x?accountID=1", nil)
+			r.Header.Set("X-Account-ID", "3")
+			return r
+		}, http.StatusForbidden, 0},
+		{"query and form disagree", func(t *testing.T) *http.Request {
+			return formRequest("/x?accountID=1", url.Values{"accountID": {"3"}})
+		}, http.StatusForbidden, 0},
+		{"query and JSON agree", func(t *testing.T) *http.Request {
+			return jsonRequest("/x?accountID=1", `{"accountID":1}`)
+		}, http.StatusOK, 1},
+		{"invalid account ID", func(t *testing.T) *http.Request {
+			return httptest.NewRequest(http.MethodGet, "/x?accountID=one", nil)
+		}, http.StatusBadRequest, 0},
+		{"streaming body is not read", func(t *testing.T) *http.Request {
+			return jsonRequest("/import?accountID=1", `{"accountID":2}`)
+		}, http.StatusOK, 1},
+		{"streaming body over the JSON limit", func(t *testing.T) *http.Request {
+			return httptest.NewRequest(http.MethodPost, "/import?accountID=1", strings.NewReader(strings.Repeat("a", maxJSONBody+1)))
+		}, http.StatusOK, 1},
+		{"streaming foreign header", func(t *testing.T) *http.Request {
+			r := httptest.NewRequest(http.MethodPost, "/import", strings.NewReader("id,name\n"))
+			r.Header.Set("X-Account-ID", "2")
+			return r
+		}, http.StatusForbidden, 0},
+		{"streaming without query or header account", func(t *testing.T) *http.Request {
+			return jsonRequest("/import", `{"accountID":1}`)
+		}, http.StatusBadRequest, 0},
+		{"only the streaming path itself", func(t *testing.T) *http.Request {
+			return jsonRequest("/import/x?accountID=1", `{"accountID":2}`)
+		}, http.StatusForbidden, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.request(t)
+			r.Header.Set("Authorization", userToken(t, 1))
+			w, got := serve(t, r)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				if got.called {
+					t.Fatal("handler was called")
+				}
+				return
+			}
+			if tt.accountID != 0 && (!got.hasID || got.accountID != tt.accountID) {
+				t.Fatalf("context account = %d (%v), want %d", got.accountID, got.hasID, tt.accountID)
+			}
+			if tt.accountID == 0 && got.hasID {
+				t.Fatalf("context account = %d, want none", got.accountID)
+			}
+		})
+	}
+}
+
+func TestJSONBodyIsRestored(t *testing.T) {
+	body := `{"accountID":1,"tableName":"t"}`
+	r := jsonRequest("/x", body)
+	r.Header.Set("Authorization", userToken(t, 1))
+	w, got := serve(t, r)
+	if w.Code != http.StatusOK || got.body != body {
+		t.Fatalf("status = %d, handler read %q, want %q", w.Code, got.body, body)
+	}
+}
+
+func TestFormAccountID(t *testing.T) {
+	// A streaming upload names its account in the query, the middleware leaves the form unread
+	r := multipartRequest(t, "/x?accountID=1", map[string]string{"accountID": "2"})
+	r.Header.Set("Authorization", userToken(t, 1))
+	var accountID int
+	var formErr error
+	auth := &Authenticator{Secret: testSecret, Store: newFakeStore()}
+	auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accountID, formErr = FormAccountID(r)
+	})).ServeHTTP(httptest.NewRecorder(), r)
+	if formErr == nil || !strings.Contains(formErr.Error(), "Forbidden") {
+		t.Fatalf("FormAccountID = %d, %v, want the foreign form account refused", accountID, formErr)
+	}
+
+	r = multipartRequest(t, "/x?accountID=1", map[string]string{"accountID": "1"})
+	r.Header.Set("Authorization", userToken(t, 1))
+	auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accountID, formErr = FormAccountID(r)
+	})).ServeHTTP(httptest.NewRecorder(), r)
+	if formErr != nil || accountID != 1 {
+		t.Fatalf("FormAccountID = %d, %v, want 1", accountID, formErr)
+	}
+
+	// Without the middleware the form is read as it is
+	r = multipartRequest(t, "/x", map[string]string{"accountID": "2"})
+	if accountID, formErr = FormAccountID(r); formErr != nil || accountID != 2 {
+		t.Fatalf("FormAccountID = %d, %v, want 2", accountID, formErr)
+	}
+}
@@ -5,9 +5,9 @@ import (
 	"fmt"
 	"net/http"
 
+	"btep.project/apierror"
+	"btep.project/auth/awsauth"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 ) // InternetGatewayRequest represents the JSON request structure for creating internet gateways
 type InternetGatewayRequest struct {
@@ -25,27 +25,21 @@ func CreateInternetGatewayHandler(w http.ResponseWriter, r *http.Request) {
 	var req InternetGatewayRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Get cloud account details
 	cloudAccount, err := GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Create AWS session
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(req.Region),
-		Credentials: credentials.NewStaticCredentials(cloudAccount.AccessKey.String, cloudAccount.SecretKey.String, ""),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session")
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 
@@ -55,8 +49,7 @@ func CreateInternetGatewayHandler(w http.ResponseWriter, r *http.Request) {
 	// Create internet gateway
 	resp, err := svc.CreateInternetGateway(&ec2.CreateInternetGatewayInput{})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating internet gateway: %v", err)
+		apierror.Write(w, err, "Error creating internet gateway")
 		return
 	}
 
@@ -66,8 +59,7 @@ func CreateInternetGatewayHandler(w http.ResponseWriter, r *http.Request) {
 		VpcId:             aws.String(req.VPCID),
 	})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error attaching internet gateway to VPC: %v", err)
+		apierror.Write(w, err, "Error attaching internet gateway to VPC")
 		return
 	}
 
@@ -91,27 +83,21 @@ func AttachInternetGatewayHandler(w http.ResponseWriter, r *http.Request) {
 	var req AttachInternetGatewayRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Get cloud account details
 	cloudAccount, err := GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Create AWS session
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(req.Region),
-		Credentials: credentials.NewStaticCredentials(cloudAccount.AccessKey.String, cloudAccount.SecretKey.String, ""),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session")
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 
@@ -124,8 +110,7 @@ func AttachInternetGatewayHandler(w http.ResponseWriter, r *http.Request) {
 		VpcId:             aws.String(req.VPCID),
 	})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error attaching internet gateway to VPC: %v", err)
+		apierror.Write(w, err, "Error attaching internet gateway to VPC")
 		return
 	}
 
@@ -149,27 +134,21 @@ func DetachInternetGatewayHandler(w http.ResponseWriter, r *http.Request) {
 	var req DetachInternetGatewayRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Get cloud account details
 	cloudAccount, err := GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Create AWS session
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(req.Region),
-		Credentials: credentials.NewStaticCredentials(cloudAccount.AccessKey.String, cloudAccount.SecretKey.String, ""),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session")
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 
@@ -182,8 +161,7 @@ func DetachInternetGatewayHandler(w http.ResponseWriter, r *http.Request) {
 		VpcId:             aws.String(req.VPCID),
 	})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error detaching internet gateway from VPC: %v", err)
+		apierror.Write(w, err, "Error detaching internet gateway from VPC")
 		return
 	}
 
@@ -206,27 +184,21 @@ func DeleteInternetGatewayHandler(w http.ResponseWriter, r *http.Request) {
 	var req DeleteInternetGatewayRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Get cloud account details
 	cloudAccount, err := GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Create AWS session
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(req.Region),
-		Credentials: credentials.NewStaticCredentials(cloudAccount.AccessKey.String, cloudAccount.SecretKey.String, ""),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session")
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 
@@ -238,8 +210,7 @@ func DeleteInternetGatewayHandler(w http.ResponseWriter, r *http.Request) {
 		InternetGatewayId: aws.String(req.InternetGatewayID),
 	})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error deleting internet gateway: %v", err)
+		apierror.Write(w, err, "Error deleting internet gateway")
 		return
 	}
 
@@ -261,26 +232,20 @@ func ListAllInternetGatewaysHandler(w http.ResponseWriter, r *http.Request) {
 	var req ListInternetGatewaysRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	cloudAccount, err := GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Create AWS session
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(req.Region),
-		Credentials: credentials.NewStaticCredentials(cloudAccount.AccessKey.String, cloudAccount.SecretKey.String, ""),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session")
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 
@@ -290,8 +255,7 @@ func ListAllInternetGatewaysHandler(w http.ResponseWriter, r *http.Request) {
 	// Describe internet gateways across all regions
 	resp, err := svc.DescribeInternetGateways(nil)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error describing internet gateways: %v", err)
+		apierror.Write(w, err, "Error describing internet gateways")
 		return
 	}
 
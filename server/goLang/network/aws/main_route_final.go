@@ -5,9 +5,9 @@ import (
 	"fmt"
 	"net/http"
 
+	"btep.project/apierror"
+	"btep.project/auth/awsauth"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 )
 
@@ -30,27 +30,21 @@ func CreateRouteTableHandler(w http.ResponseWriter, r *http.Request) {
 	var req RouteTableRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Get cloud account details
 	cloudAccount, err := GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Create AWS session
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(req.Region),
-		Credentials: credentials.NewStaticCredentials(cloudAccount.AccessKey.String, cloudAccount.SecretKey.String, ""),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session")
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 
@@ -62,8 +56,7 @@ func CreateRouteTableHandler(w http.ResponseWriter, r *http.Request) {
 		VpcId: aws.String(req.VPCID),
 	})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating route table: %v", err)
+		apierror.Write(w, err, "Error creating route table")
 		return
 	}
 
@@ -73,8 +66,7 @@ func CreateRouteTableHandler(w http.ResponseWriter, r *http.Request) {
 		SubnetId:     aws.String(req.SubnetID),
 	})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error associating route table with subnet: %v", err)
+		apierror.Write(w, err, "Error associating route table with subnet")
 		return
 	}
 
@@ -85,8 +77,7 @@ func CreateRouteTableHandler(w http.ResponseWriter, r *http.Request) {
 			RouteTableId:         aws.String(*resp.RouteTable.RouteTableId),
 		})
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "Error adding route to route table: %v", err)
+			apierror.Write(w, err, "Error adding route to route table")
 			return
 		}
 	}
@@ -105,27 +96,21 @@ func DeleteRouteTableHandler(w http.ResponseWriter, r *http.Request) {
 	var req DeleteRouteTableRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Get cloud account details
 	cloudAccount, err := GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Create AWS session
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(req.Region),
-		Credentials: credentials.NewStaticCredentials(cloudAccount.AccessKey.String, cloudAccount.SecretKey.String, ""),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session")
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 
@@ -137,8 +122,7 @@ func DeleteRouteTableHandler(w http.ResponseWriter, r *http.Request) {
 		RouteTableId: aws.String(req.RouteTableID),
 	})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error deleting route table: %v", err)
+		apierror.Write(w, err, "Error deleting route table")
 		return
 	}
 
@@ -153,19 +137,14 @@ func ListRouteTableHandler(w http.ResponseWriter, r *http.Request) {
 	// Get cloud account details
 	cloudAccount, err := GetCloudAccountDetails(1)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Create AWS session
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String("us-west-2"),
-		Credentials: credentials.NewStaticCredentials(cloudAccount.AccessKey.String, cloudAccount.SecretKey.String, ""),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, "us-west-2")
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session")
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 
@@ -175,8 +154,7 @@ func ListRouteTableHandler(w http.ResponseWriter, r *http.Request) {
 	// List route tables
 	resp, err := svc.DescribeRouteTables(nil)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error listing route tables: %v", err)
+		apierror.Write(w, err, "Error listing route tables")
 		return
 	}
 
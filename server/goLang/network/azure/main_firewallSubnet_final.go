@@ -6,29 +6,28 @@ import (
 	"fmt"
 	"net/http"
 
+	"btep.project/apierror"
+	"btep.project/auth/azureauth"
 	"github.com/Azure/azure-sdk-for-go/profiles/latest/network/mgmt/network"
-	"github.com/Azure/go-autorest/autorest"
 )
 
-func (ta tokenAuthorizer) WithAuthorization() autorest.PrepareDecorator {
-	return func(p autorest.Preparer) autorest.Preparer {
-		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
-			r.Header.Set("Authorization", "Bearer "+ta.token)
-			return r, nil
-		})
-	}
-}
-func initSubnetClient1(subscriptionID string, token string) (network.SubnetsClient, error) {
+func initSubnetClient1(subscriptionID string, accountID int) (network.SubnetsClient, error) {
 	client := network.NewSubnetsClient(subscriptionID)
-	client.Authorizer = autorest.NullAuthorizer{} // We manually insert the token
-	client.RequestInspector = tokenAuthorizer{token: token}.WithAuthorization()
+	authorizer, err := azureauth.Authorizer(context.Background(), accountID)
+	if err != nil {
+		return client, err
+	}
+	client.Authorizer = authorizer
 	return client, nil
 }
 
-func initFirewallClient(subscriptionID string, token string) (network.AzureFirewallsClient, error) {
+func initFirewallClient(subscriptionID string, accountID int) (network.AzureFirewallsClient, error) {
 	client := network.NewAzureFirewallsClient(subscriptionID)
-	client.Authorizer = autorest.NullAuthorizer{} // We manually insert the token
-	client.RequestInspector = tokenAuthorizer{token: token}.WithAuthorization()
+	authorizer, err := azureauth.Authorizer(context.Background(), accountID)
+	if err != nil {
+		return client, err
+	}
+	client.Authorizer = authorizer
 	return client, nil
 }
 
@@ -37,20 +36,20 @@ type SubnetRequest struct {
 	ResourceGroup  string `json:"resourceGroup"`
 	NetworkName    string `json:"networkName"`
 	Prefix         string `json:"prefix"`
-	Token          string `json:"token"`
+	AccountID      int    `json:"accountID"`
 	SubnetName     string `json:"subnetName"`
 }
 
 func CreateSubnetHandler(w http.ResponseWriter, r *http.Request) {
 	var req SubnetRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
-	client, err := initSubnetClient1(req.SubscriptionID, req.Token)
+	client, err := initSubnetClient1(req.SubscriptionID, req.AccountID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, err, "")
 		return
 	}
 
@@ -63,7 +62,7 @@ func CreateSubnetHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := context.Background()
 	_, err = client.CreateOrUpdate(ctx, req.ResourceGroup, req.NetworkName, req.SubnetName, params)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create subnet: %v", err), http.StatusInternalServerError)
+		apierror.Write(w, err, "Failed to create subnet")
 		return
 	}
 	json.NewEncoder(w).Encode(NetworkResponse{Message: "Subnet created successfully"})
@@ -72,20 +71,20 @@ func CreateSubnetHandler(w http.ResponseWriter, r *http.Request) {
 type FirewallRequest struct {
 	SubscriptionID string `json:"subscriptionID"`
 	ResourceGroup  string `json:"resourceGroup"`
-	Token          string `json:"token"`
+	AccountID      int    `json:"accountID"`
 	FirewallName   string `json:"firewallName"`
 }
 
 func CreateFirewallHandler(w http.ResponseWriter, r *http.Request) {
 	var req FirewallRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
-	client, err := initFirewallClient(req.SubscriptionID, req.Token)
+	client, err := initFirewallClient(req.SubscriptionID, req.AccountID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, err, "")
 		return
 	}
 
@@ -100,7 +99,7 @@ func CreateFirewallHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := context.Background()
 	_, err = client.CreateOrUpdate(ctx, req.ResourceGroup, req.FirewallName, params)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create firewall: %v", err), http.StatusInternalServerError)
+		apierror.Write(w, err, "Failed to create firewall")
 		return
 	}
 	json.NewEncoder(w).Encode(NetworkResponse{Message: "Firewall created successfully"})
@@ -110,32 +109,32 @@ type DeleteSubnetRequest struct {
 	SubscriptionID string `json:"subscriptionID"`
 	ResourceGroup  string `json:"resourceGroup"`
 	NetworkName    string `json:"networkName"`
-	Token          string `json:"token"`
+	AccountID      int    `json:"accountID"`
 	SubnetName     string `json:"subnetName"`
 }
 
 func DeleteSubnetHandler(w http.ResponseWriter, r *http.Request) {
 	var req DeleteSubnetRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
-	client, err := initSubnetClient1(req.SubscriptionID, req.Token)
+	client, err := initSubnetClient1(req.SubscriptionID, req.AccountID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, err, "")
 		return
 	}
 
 	ctx := context.Background()
 	resp, err := client.Delete(ctx, req.ResourceGroup, req.NetworkName, req.SubnetName)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to delete subnet: %v", err), http.StatusInternalServerError)
+		apierror.Write(w, err, "Failed to delete subnet")
 		return
 	}
 
 	if resp.Response().StatusCode != http.StatusAccepted {
-		http.Error(w, fmt.Sprintf("Delete operation not accepted: %v", resp.Status), http.StatusInternalServerError)
+		apierror.Send(w, apierror.New(http.StatusBadGateway, apierror.CodeProviderError, fmt.Sprintf("Delete operation not accepted: %v", resp.Status())))
 		return
 	}
 
@@ -145,32 +144,32 @@ func DeleteSubnetHandler(w http.ResponseWriter, r *http.Request) {
 type DeleteFirewallRequest struct {
 	SubscriptionID string `json:"subscriptionID"`
 	ResourceGroup  string `json:"resourceGroup"`
-	Token          string `json:"token"`
+	AccountID      int    `json:"accountID"`
 	FirewallName   string `json:"firewallName"`
 }
 
 func DeleteFirewallHandler(w http.ResponseWriter, r *http.Request) {
 	var req DeleteFirewallRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
-	client, err := initFirewallClient(req.SubscriptionID, req.Token)
+	client, err := initFirewallClient(req.SubscriptionID, req.AccountID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, err, "")
 		return
 	}
 
 	ctx := context.Background()
 	resp, err := client.Delete(ctx, req.ResourceGroup, req.FirewallName)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to delete firewall: %v", err), http.StatusInternalServerError)
+		apierror.Write(w, err, "Failed to delete firewall")
 		return
 	}
 
 	if resp.Response().StatusCode != http.StatusAccepted {
-		http.Error(w, fmt.Sprintf("Delete operation not accepted: %v", resp.Status), http.StatusInternalServerError)
+		apierror.Send(w, apierror.New(http.StatusBadGateway, apierror.CodeProviderError, fmt.Sprintf("Delete operation not accepted: %v", resp.Status())))
 		return
 	}
 
@@ -185,26 +184,26 @@ type FirewallResponse struct {
 
 type ListFirewallRequest struct {
 	SubscriptionID string `json:"subscriptionID"`
-	Token          string `json:"token"`
+	AccountID      int    `json:"accountID"`
 }
 
 func ListFirewallHandler(w http.ResponseWriter, r *http.Request) {
 	var req ListFirewallRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
-	client, err := initFirewallClient(req.SubscriptionID, req.Token)
+	client, err := initFirewallClient(req.SubscriptionID, req.AccountID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, err, "")
 		return
 	}
 
 	ctx := context.Background()
 	firewallList, err := client.ListAll(ctx)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to list firewalls: %v", err), http.StatusInternalServerError)
+		apierror.Write(w, err, "Failed to list firewalls")
 		return
 	}
 
@@ -229,26 +228,26 @@ type SubnetResponse struct {
 type ListSubnetRequest struct {
 	SubscriptionID string `json:"subscriptionID"`
 	NetworkName    string `json:"networkName"`
-	Token          string `json:"token"`
+	AccountID      int    `json:"accountID"`
 }
 
 func ListSubnetHandler(w http.ResponseWriter, r *http.Request) {
 	var req ListSubnetRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
-	client, err := initSubnetClient1(req.SubscriptionID, req.Token)
+	client, err := initSubnetClient1(req.SubscriptionID, req.AccountID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, err, "")
 		return
 	}
 
 	ctx := context.Background()
 	subnetList, err := client.List(ctx, req.NetworkName, req.SubscriptionID)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to list subnets: %v", err), http.StatusInternalServerError)
+		apierror.Write(w, err, "Failed to list subnets")
 		return
 	}
 
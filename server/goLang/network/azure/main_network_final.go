@@ -3,11 +3,11 @@ package azure_network
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
 
+	"btep.project/apierror"
+	"btep.project/auth/azureauth"
 	"github.com/Azure/azure-sdk-for-go/profiles/latest/network/mgmt/network"
-	"github.com/Azure/go-autorest/autorest"
 )
 
 type NetworkRequest struct {
@@ -16,19 +16,19 @@ type NetworkRequest struct {
 	ResourceGroup  string `json:"resourceGroup"`
 	Location       string `json:"location"`
 	Prefix         string `json:"prefix"`
-	Token          string `json:"token"`
+	AccountID      int    `json:"accountID"`
 }
 type DeleteNetworkRequest struct {
 	SubscriptionID string `json:"subscriptionID"`
 	ResourceGroup  string `json:"resourceGroup"`
 	NetworkName    string `json:"networkName"`
-	Token          string `json:"token"`
+	AccountID      int    `json:"accountID"`
 }
 
 type ListNetworkRequest struct {
 	SubscriptionID string `json:"subscriptionID"`
 	ResourceGroup  string `json:"resourceGroup"`
-	Token          string `json:"token"`
+	AccountID      int    `json:"accountID"`
 }
 
 type ListNetworkResponse struct {
@@ -39,34 +39,26 @@ type NetworkResponse struct {
 	Message  string   `json:"message"`
 	Networks []string `json:"networks"`
 }
-type tokenAuthorizer struct {
-	token string
-}
 
-//	func (ta tokenAuthorizer) WithAuthorization() autorest.PrepareDecorator {
-//		return func(p autorest.Preparer) autorest.Preparer {
-//			return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
-//				r.Header.Set("Authorization", "Bearer "+ta.token)
-//				return r, nil
-//			})
-//		}
-//	}
-func initNetworkClient(subscriptionID string, token string) (network.VirtualNetworksClient, error) {
+func initNetworkClient(subscriptionID string, accountID int) (network.VirtualNetworksClient, error) {
 	client := network.NewVirtualNetworksClient(subscriptionID)
-	client.Authorizer = autorest.NullAuthorizer{} // We manually insert the token
-	client.RequestInspector = tokenAuthorizer{token: token}.WithAuthorization()
+	authorizer, err := azureauth.Authorizer(context.Background(), accountID)
+	if err != nil {
+		return client, err
+	}
+	client.Authorizer = authorizer
 	return client, nil
 }
 func CreateNetworkHandler(w http.ResponseWriter, r *http.Request) {
 	var req NetworkRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
-	client, err := initNetworkClient(req.SubscriptionID, req.Token)
+	client, err := initNetworkClient(req.SubscriptionID, req.AccountID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, err, "")
 		return
 	}
 
@@ -81,7 +73,7 @@ func CreateNetworkHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := context.Background()
 	_, err = client.CreateOrUpdate(ctx, req.ResourceGroup, req.NetworkName, params)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create network: %v", err), http.StatusInternalServerError)
+		apierror.Write(w, err, "Failed to create network")
 		return
 	}
 	json.NewEncoder(w).Encode(NetworkResponse{Message: "Network created successfully"})
@@ -90,20 +82,20 @@ func CreateNetworkHandler(w http.ResponseWriter, r *http.Request) {
 func ListNetworksHandler(w http.ResponseWriter, r *http.Request) {
 	var req ListNetworkRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
-	client, err := initNetworkClient(req.SubscriptionID, req.Token)
+	client, err := initNetworkClient(req.SubscriptionID, req.AccountID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, err, "")
 		return
 	}
 
 	ctx := context.Background()
 	networkList, err := client.List(ctx, req.ResourceGroup)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to list networks: %v", err), http.StatusInternalServerError)
+		apierror.Write(w, err, "Failed to list networks")
 		return
 	}
 
@@ -123,26 +115,26 @@ func ListNetworksHandler(w http.ResponseWriter, r *http.Request) {
 func DeleteNetworkHandler(w http.ResponseWriter, r *http.Request) {
 	var req DeleteNetworkRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
-	client, err := initNetworkClient(req.SubscriptionID, req.Token)
+	client, err := initNetworkClient(req.SubscriptionID, req.AccountID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, err, "")
 		return
 	}
 
 	ctx := context.Background()
 	future, err := client.Delete(ctx, req.ResourceGroup, req.NetworkName)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to delete network: %v", err), http.StatusInternalServerError)
+		apierror.Write(w, err, "Failed to delete network")
 		return
 	}
 
 	err = future.WaitForCompletionRef(ctx, client.Client)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to complete network deletion operation: %v", err), http.StatusInternalServerError)
+		apierror.Write(w, err, "Failed to complete network deletion operation")
 		return
 	}
 
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 
+	"btep.project/apierror"
 	db "btep.project/databaseConnection"
 	"google.golang.org/api/compute/v1"
 )
@@ -22,23 +23,21 @@ type CloudRouterRequest struct {
 	PeerIPAddress   string `json:"peerIpAddress"`
 	PeerASN         int64  `json:"peerAsn"`
 	AdvertisedRoute string `json:"advertisedRoute"`
-	Token           string `json:"token"`
+	AccountID       int    `json:"accountID"`
 }
 
 func CreateCloudRouterHandler(w http.ResponseWriter, r *http.Request) {
 	var req CloudRouterRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.ProjectID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
@@ -56,12 +55,11 @@ func CreateCloudRouterHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	project := cloudAccount.ProjectID.String
-	computeService, err := initComputeService(req.Token)
+	computeService, err := initComputeService(req.AccountID)
 
 	_, err = computeService.Routers.Insert(project, req.Region, router).Do()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating cloud router: %v", err)
+		apierror.Write(w, err, "Error creating cloud router")
 		return
 	}
 
@@ -77,32 +75,28 @@ func ListCloudRoutersHandler(w http.ResponseWriter, r *http.Request) {
 	var req CloudRouterRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.ProjectID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	project := cloudAccount.ProjectID.String
-	computeService, err := initComputeService(req.Token)
+	computeService, err := initComputeService(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing compute service: %v", err)
+		apierror.Write(w, err, "Error initializing compute service")
 		return
 	}
 
 	// List cloud routers
 	routersList, err := computeService.Routers.List(project, req.Region).Do()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error listing cloud routers: %v", err)
+		apierror.Write(w, err, "Error listing cloud routers")
 		return
 	}
 
@@ -125,24 +119,21 @@ func DeleteCloudRouterHandler(w http.ResponseWriter, r *http.Request) {
 	var req CloudRouterRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.ProjectID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	project := cloudAccount.ProjectID.String
-	computeService, err := initComputeService(req.Token)
+	computeService, err := initComputeService(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing compute service: %v", err)
+		apierror.Write(w, err, "Error initializing compute service")
 		return
 	}
 
@@ -153,8 +144,7 @@ func DeleteCloudRouterHandler(w http.ResponseWriter, r *http.Request) {
 	temp, err := computeService.Routers.Delete(project, req.Region, routerID).Do()
 	fmt.Println(temp)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error deleting cloud router: %v", err)
+		apierror.Write(w, err, "Error deleting cloud router")
 		return
 	}
 
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 
+	"btep.project/apierror"
 	db "btep.project/databaseConnection"
 	"google.golang.org/api/compute/v1"
 )
@@ -20,23 +21,21 @@ type FirewallRuleRequest struct {
 	Priority      int64    `json:"priority"`
 	SourceTags    []string `json:"sourceTags"` // Corrected to []string
 	DestinationIP string   `json:"destinationIP"`
-	Token         string   `json:"token"`
+	AccountID     int      `json:"accountID"`
 }
 
 func CreateFirewallRuleHandler(w http.ResponseWriter, r *http.Request) {
 	var req FirewallRuleRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.ProjectID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
@@ -58,12 +57,11 @@ func CreateFirewallRuleHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	project := cloudAccount.ProjectID.String
-	computeService, err := initComputeService(req.Token)
+	computeService, err := initComputeService(req.AccountID)
 
 	_, err = computeService.Firewalls.Insert(project, firewall).Do()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating firewall rule: %v", err)
+		apierror.Write(w, err, "Error creating firewall rule")
 		return
 	}
 
@@ -79,32 +77,28 @@ func ListFirewallRulesHandler(w http.ResponseWriter, r *http.Request) {
 	var req FirewallRuleRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.ProjectID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	project := cloudAccount.ProjectID.String
-	computeService, err := initComputeService(req.Token)
+	computeService, err := initComputeService(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing compute service: %v", err)
+		apierror.Write(w, err, "Error initializing compute service")
 		return
 	}
 
 	// List firewall rules
 	firewallRulesList, err := computeService.Firewalls.List(project).Do()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error listing firewall rules: %v", err)
+		apierror.Write(w, err, "Error listing firewall rules")
 		return
 	}
 
@@ -127,24 +121,21 @@ func DeleteFirewallRuleHandler(w http.ResponseWriter, r *http.Request) {
 	var req FirewallRuleRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.ProjectID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	project := cloudAccount.ProjectID.String
-	computeService, err := initComputeService(req.Token)
+	computeService, err := initComputeService(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing compute service: %v", err)
+		apierror.Write(w, err, "Error initializing compute service")
 		return
 	}
 
@@ -152,8 +143,7 @@ func DeleteFirewallRuleHandler(w http.ResponseWriter, r *http.Request) {
 	temp, err := computeService.Firewalls.Delete(project, req.FirewallName).Do()
 	fmt.Println(temp)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error deleting firewall rule: %v", err)
+		apierror.Write(w, err, "Error deleting firewall rule")
 		return
 	}
 
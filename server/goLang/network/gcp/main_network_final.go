@@ -6,15 +6,21 @@ import (
 	"fmt"
 	"net/http"
 
+	"btep.project/apierror"
+	"btep.project/auth/gcpauth"
 	db "btep.project/databaseConnection"
 	"golang.org/x/oauth2"
 	"google.golang.org/api/compute/v1"
 	"google.golang.org/api/option"
 )
 
-func initComputeService(token string) (*compute.Service, error) {
+func initComputeService(accountID int) (*compute.Service, error) {
 	ctx := context.Background()
-	client := oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))
+	tokenSource, err := gcpauth.TokenSource(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	client := oauth2.NewClient(ctx, tokenSource)
 
 	computeService, err := compute.NewService(ctx, option.WithHTTPClient(client))
 	if err != nil {
@@ -27,24 +33,21 @@ func initComputeService(token string) (*compute.Service, error) {
 type NetworkRequest struct {
 	ProjectID   int    `json:"projectId"`
 	NetworkName string `json:"networkName"`
-	Token       string `json:"token"`
-	AccountID   int    `json:"account"`
+	AccountID   int    `json:"accountID"`
 }
 
 func CreateNetworkHandler(w http.ResponseWriter, r *http.Request) {
 	var req NetworkRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
@@ -55,17 +58,15 @@ func CreateNetworkHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	project := cloudAccount.ProjectID.String
-	computeService, err := initComputeService(req.Token)
+	computeService, err := initComputeService(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing compute service: %v", err)
+		apierror.Write(w, err, "Error initializing compute service")
 		return
 	}
 
 	_, err = computeService.Networks.Insert(project, network).Do()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating network: %v", err)
+		apierror.Write(w, err, "Error creating network")
 		return
 	}
 
@@ -82,32 +83,28 @@ func ListNetworksHandler(w http.ResponseWriter, r *http.Request) {
 	var req NetworkRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.ProjectID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	project := cloudAccount.ProjectID.String
-	computeService, err := initComputeService(req.Token)
+	computeService, err := initComputeService(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing compute service: %v", err)
+		apierror.Write(w, err, "Error initializing compute service")
 		return
 	}
 
 	// List networks
 	networks, err := computeService.Networks.List(project).Do()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error listing networks: %v", err)
+		apierror.Write(w, err, "Error listing networks")
 		return
 	}
 
@@ -131,32 +128,28 @@ func DeleteNetworkHandler(w http.ResponseWriter, r *http.Request) {
 	var req NetworkRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.ProjectID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	project := cloudAccount.ProjectID.String
-	computeService, err := initComputeService(req.Token)
+	computeService, err := initComputeService(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing compute service: %v", err)
+		apierror.Write(w, err, "Error initializing compute service")
 		return
 	}
 
 	// Delete the network
 	_, err = computeService.Networks.Delete(project, req.NetworkName).Do()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error deleting network: %v", err)
+		apierror.Write(w, err, "Error deleting network")
 		return
 	}
 
@@ -2,9 +2,9 @@ package gcp_network
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
 
+	"btep.project/apierror"
 	db "btep.project/databaseConnection"
 	"google.golang.org/api/compute/v1"
 )
@@ -18,23 +18,21 @@ type RouteRequest struct {
 	Priority    int64    `json:"priority"`
 	Description string   `json:"description"`
 	Tags        []string `json:"tags"` // Corrected to []string
-	Token       string   `json:"token"`
+	AccountID   int      `json:"accountID"`
 }
 
 func CreateRouteHandler(w http.ResponseWriter, r *http.Request) {
 	var req RouteRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.ProjectID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
@@ -49,12 +47,11 @@ func CreateRouteHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	project := cloudAccount.ProjectID.String
-	computeService, err := initComputeService(req.Token)
+	computeService, err := initComputeService(req.AccountID)
 
 	_, err = computeService.Routes.Insert(project, route).Do()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating route: %v", err)
+		apierror.Write(w, err, "Error creating route")
 		return
 	}
 
@@ -71,32 +68,28 @@ func DeleteRouteHandler(w http.ResponseWriter, r *http.Request) {
 	var req RouteRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.ProjectID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	project := cloudAccount.ProjectID.String
-	computeService, err := initComputeService(req.Token)
+	computeService, err := initComputeService(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing compute service: %v", err)
+		apierror.Write(w, err, "Error initializing compute service")
 		return
 	}
 
 	// Delete the route
 	_, err = computeService.Routes.Delete(project, req.RouteName).Do()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error deleting route: %v", err)
+		apierror.Write(w, err, "Error deleting route")
 		return
 	}
 
@@ -111,34 +104,31 @@ func DeleteRouteHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 type ListRouteRequest struct {
-	ProjectID int    `json:"projectId"`
-	Token     string `json:"token"`
+	ProjectID int `json:"projectId"`
+	AccountID int `json:"accountID"`
 }
 
 func ListRoutesHandler(w http.ResponseWriter, r *http.Request) {
 	var req ListRouteRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.ProjectID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	project := cloudAccount.ProjectID.String
-	computeService, err := initComputeService(req.Token)
+	computeService, err := initComputeService(req.AccountID)
 
 	routes, err := computeService.Routes.List(project).Do()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error listing routes: %v", err)
+		apierror.Write(w, err, "Error listing routes")
 		return
 	}
 
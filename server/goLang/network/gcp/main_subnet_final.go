@@ -2,9 +2,9 @@ package gcp_network
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
 
+	"btep.project/apierror"
 	db "btep.project/databaseConnection"
 	"google.golang.org/api/compute/v1"
 )
@@ -15,23 +15,21 @@ type SubnetRequest struct {
 	SubnetName  string `json:"subnetName"`
 	Region      string `json:"region"`
 	IPCIDRRange string `json:"ipCidrRange"`
-	Token       string `json:"token"`
+	AccountID   int    `json:"accountID"`
 }
 
 func CreateSubnetHandler(w http.ResponseWriter, r *http.Request) {
 	var req SubnetRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.ProjectID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
@@ -43,12 +41,11 @@ func CreateSubnetHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	project := cloudAccount.ProjectID.String
-	computeService, err := initComputeService(req.Token)
+	computeService, err := initComputeService(req.AccountID)
 
 	_, err = computeService.Subnetworks.Insert(project, req.Region, subnet).Do()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating subnet: %v", err)
+		apierror.Write(w, err, "Error creating subnet")
 		return
 	}
 
@@ -65,32 +62,28 @@ func ListSubnetsHandler(w http.ResponseWriter, r *http.Request) {
 	var req SubnetRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.ProjectID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	project := cloudAccount.ProjectID.String
-	computeService, err := initComputeService(req.Token)
+	computeService, err := initComputeService(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing compute service: %v", err)
+		apierror.Write(w, err, "Error initializing compute service")
 		return
 	}
 
 	// List subnets
 	subnets, err := computeService.Subnetworks.List(project, req.Region).Do()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error listing subnets: %v", err)
+		apierror.Write(w, err, "Error listing subnets")
 		return
 	}
 
@@ -114,32 +107,28 @@ func DeleteSubnetHandler(w http.ResponseWriter, r *http.Request) {
 	var req SubnetRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.ProjectID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	project := cloudAccount.ProjectID.String
-	computeService, err := initComputeService(req.Token)
+	computeService, err := initComputeService(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing compute service: %v", err)
+		apierror.Write(w, err, "Error initializing compute service")
 		return
 	}
 
 	// Delete the subnet
 	_, err = computeService.Subnetworks.Delete(project, req.Region, req.SubnetName).Do()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error deleting subnet: %v", err)
+		apierror.Write(w, err, "Error deleting subnet")
 		return
 	}
 
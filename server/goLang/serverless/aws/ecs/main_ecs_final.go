@@ -2,13 +2,12 @@ package aws_ecs
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
 
+	"btep.project/apierror"
+	"btep.project/auth/awsauth"
 	db "btep.project/databaseConnection"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ecs"
 )
 
@@ -33,31 +32,21 @@ func CreateECSHandler(w http.ResponseWriter, r *http.Request) {
 	var req ClusterRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Create AWS session
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(req.Region),
-		Credentials: credentials.NewStaticCredentials(
-			cloudAccount.AccessKey.String,
-			cloudAccount.SecretKey.String,
-			"",
-		),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session")
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 
@@ -91,8 +80,7 @@ func CreateECSHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 	default:
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid infrastructure choice")
+		apierror.BadRequest(w, "Invalid infrastructure choice")
 		return
 	}
 
@@ -103,8 +91,7 @@ func CreateECSHandler(w http.ResponseWriter, r *http.Request) {
 		DefaultCapacityProviderStrategy: capacityProviders, // Choose default capacity provider strategy
 	})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating ECS cluster: %v", err)
+		apierror.Write(w, err, "Error creating ECS cluster")
 		return
 	}
 
@@ -118,31 +105,21 @@ func DeleteECSHandler(w http.ResponseWriter, r *http.Request) {
 	var req ClusterDeleteRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Create AWS session
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(req.Region),
-		Credentials: credentials.NewStaticCredentials(
-			cloudAccount.AccessKey.String,
-			cloudAccount.SecretKey.String,
-			"",
-		),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session")
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 
@@ -154,8 +131,7 @@ func DeleteECSHandler(w http.ResponseWriter, r *http.Request) {
 		Cluster: aws.String(req.ClusterID),
 	})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error deleting ECS cluster: %v", err)
+		apierror.Write(w, err, "Error deleting ECS cluster")
 		return
 	}
 
@@ -174,31 +150,21 @@ func ListClustersHandler(w http.ResponseWriter, r *http.Request) {
 	var req ListClusterRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Create AWS session
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(req.Region),
-		Credentials: credentials.NewStaticCredentials(
-			cloudAccount.AccessKey.String,
-			cloudAccount.SecretKey.String,
-			"",
-		),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session")
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 
@@ -208,8 +174,7 @@ func ListClustersHandler(w http.ResponseWriter, r *http.Request) {
 	// List all ECS clusters
 	resp, err := svc.ListClusters(&ecs.ListClustersInput{})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error listing ECS clusters: %v", err)
+		apierror.Write(w, err, "Error listing ECS clusters")
 		return
 	}
 
@@ -2,13 +2,12 @@ package aws_eks
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
 
+	"btep.project/apierror"
+	"btep.project/auth/awsauth"
 	db "btep.project/databaseConnection"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/eks"
 )
 
@@ -27,31 +26,21 @@ func CreateEKSHandler(w http.ResponseWriter, r *http.Request) {
 	var req ClusterRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Create AWS session
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(req.Region),
-		Credentials: credentials.NewStaticCredentials(
-			cloudAccount.AccessKey.String,
-			cloudAccount.SecretKey.String,
-			"",
-		),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session")
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 
@@ -80,8 +69,7 @@ func CreateEKSHandler(w http.ResponseWriter, r *http.Request) {
 			ServiceIpv4Cidr: aws.String("")},
 	})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating EKS cluster: %v", err)
+		apierror.Write(w, err, "Error creating EKS cluster")
 		return
 	}
 
@@ -107,31 +95,21 @@ func UpdateEKSHandler(w http.ResponseWriter, r *http.Request) {
 	var req UpdateEKSRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Create AWS session
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(req.Region),
-		Credentials: credentials.NewStaticCredentials(
-			cloudAccount.AccessKey.String,
-			cloudAccount.SecretKey.String,
-			"",
-		),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session")
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 
@@ -148,8 +126,7 @@ func UpdateEKSHandler(w http.ResponseWriter, r *http.Request) {
 	// Update the EKS cluster
 	_, err = svc.UpdateClusterVersion(updateInput)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error updating EKS cluster: %v", err)
+		apierror.Write(w, err, "Error updating EKS cluster")
 		return
 	}
 
@@ -173,31 +150,21 @@ func DeleteEKSHandler(w http.ResponseWriter, r *http.Request) {
 	var req DeleteEKSRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Create AWS session
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(req.Region),
-		Credentials: credentials.NewStaticCredentials(
-			cloudAccount.AccessKey.String,
-			cloudAccount.SecretKey.String,
-			"",
-		),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session")
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 
@@ -212,8 +179,7 @@ func DeleteEKSHandler(w http.ResponseWriter, r *http.Request) {
 	// Delete the EKS cluster
 	_, err = svc.DeleteCluster(deleteInput)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error deleting EKS cluster: %v", err)
+		apierror.Write(w, err, "Error deleting EKS cluster")
 		return
 	}
 
@@ -236,31 +202,21 @@ func ListEKSHandler(w http.ResponseWriter, r *http.Request) {
 	var req ListEKSRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Create AWS session
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(req.Region),
-		Credentials: credentials.NewStaticCredentials(
-			cloudAccount.AccessKey.String,
-			cloudAccount.SecretKey.String,
-			"",
-		),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session")
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 
@@ -270,8 +226,7 @@ func ListEKSHandler(w http.ResponseWriter, r *http.Request) {
 	// List EKS clusters
 	result, err := svc.ListClusters(&eks.ListClustersInput{})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error listing EKS clusters: %v", err)
+		apierror.Write(w, err, "Error listing EKS clusters")
 		return
 	}
 
@@ -5,10 +5,10 @@ import (
 	"fmt"
 	"net/http"
 
+	"btep.project/apierror"
+	"btep.project/auth/awsauth"
 	db "btep.project/databaseConnection"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/lambda"
 )
 
@@ -32,11 +32,8 @@ type LambdaFunctionResponse struct {
 	Message string `json:"message"`
 }
 
-func initLambdaService(accessKey, secretKey, region string) (*lambda.Lambda, error) {
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(region),
-		Credentials: credentials.NewStaticCredentials(accessKey, secretKey, ""),
-	})
+func initLambdaService(cloudAccount *db.CloudAccount, region string) (*lambda.Lambda, error) {
+	sess, err := awsauth.SessionFor(cloudAccount, region)
 	if err != nil {
 		return nil, fmt.Errorf("error creating AWS session: %v", err)
 	}
@@ -50,15 +47,15 @@ func CreateLambdaFunctionHandler(w http.ResponseWriter, r *http.Request) {
 	var req LambdaFunctionRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	// Initialize AWS Lambda service
-	lambdaSvc, err := initLambdaService(cloudAccount.AccessKey.String, cloudAccount.SecretKey.String, req.Region)
+	lambdaSvc, err := initLambdaService(cloudAccount, req.Region)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error initializing AWS Lambda service: %v", err), http.StatusInternalServerError)
+		apierror.Write(w, err, "Error initializing AWS Lambda service")
 		return
 	}
 
@@ -84,7 +81,7 @@ func CreateLambdaFunctionHandler(w http.ResponseWriter, r *http.Request) {
 
 	_, err = lambdaSvc.CreateFunction(params)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error creating Lambda function: %v", err), http.StatusInternalServerError)
+		apierror.Write(w, err, "Error creating Lambda function")
 		return
 	}
 
@@ -108,22 +105,22 @@ func ListLambdaFunctionsHandler(w http.ResponseWriter, r *http.Request) {
 	var req ListLambdaFunctionsRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 
 	// Initialize AWS Lambda service
-	lambdaSvc, err := initLambdaService(cloudAccount.AccessKey.String, cloudAccount.SecretKey.String, cloudAccount.Region.String)
+	lambdaSvc, err := initLambdaService(cloudAccount, cloudAccount.Region.String)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error initializing AWS Lambda service: %v", err), http.StatusInternalServerError)
+		apierror.Write(w, err, "Error initializing AWS Lambda service")
 		return
 	}
 
 	// List Lambda functions
 	result, err := lambdaSvc.ListFunctions(&lambda.ListFunctionsInput{})
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error listing Lambda functions: %v", err), http.StatusInternalServerError)
+		apierror.Write(w, err, "Error listing Lambda functions")
 		return
 	}
 
@@ -147,15 +144,15 @@ func DeleteLambdaFunctionHandler(w http.ResponseWriter, r *http.Request) {
 	var req DeleteLambdaFunctionRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	// Initialize AWS Lambda service
-	lambdaSvc, err := initLambdaService(cloudAccount.AccessKey.String, cloudAccount.SecretKey.String, req.Region)
+	lambdaSvc, err := initLambdaService(cloudAccount, req.Region)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error initializing AWS Lambda service: %v", err), http.StatusInternalServerError)
+		apierror.Write(w, err, "Error initializing AWS Lambda service")
 		return
 	}
 
@@ -164,7 +161,7 @@ func DeleteLambdaFunctionHandler(w http.ResponseWriter, r *http.Request) {
 		FunctionName: aws.String(req.FunctionName),
 	})
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error deleting Lambda function: %v", err), http.StatusInternalServerError)
+		apierror.Write(w, err, "Error deleting Lambda function")
 		return
 	}
 
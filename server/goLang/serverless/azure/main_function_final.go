@@ -3,11 +3,11 @@ package azure_functions
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
 
+	"btep.project/apierror"
+	"btep.project/auth/azureauth"
 	"github.com/Azure/azure-sdk-for-go/profiles/latest/web/mgmt/web"
-	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/to"
 )
 
@@ -19,7 +19,7 @@ type FunctionAppRequest struct {
 	FunctionAppName       string            `json:"functionAppName"`
 	Environment           map[string]string `json:"environment"`
 	ClientAffinityEnabled bool              `json:"clientAffinityEnabled"`
-	Token                 string            `json:"token"`
+	AccountID             int               `json:"accountID"`
 }
 
 type FunctionAppResponse struct {
@@ -27,36 +27,26 @@ type FunctionAppResponse struct {
 	FunctionApps []string `json:"functionApps,omitempty"`
 }
 
-type tokenAuthorizer struct {
-	token string
-}
-
-func (ta tokenAuthorizer) WithAuthorization() autorest.PrepareDecorator {
-	return func(p autorest.Preparer) autorest.Preparer {
-		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
-			r.Header.Set("Authorization", "Bearer "+ta.token)
-			return r, nil
-		})
-	}
-}
-
-func initFunctionAppClient(subscriptionID string, token string) (web.AppsClient, error) {
+func initFunctionAppClient(subscriptionID string, accountID int) (web.AppsClient, error) {
 	client := web.NewAppsClient(subscriptionID)
-	client.Authorizer = autorest.NullAuthorizer{}
-	client.RequestInspector = tokenAuthorizer{token: token}.WithAuthorization()
+	authorizer, err := azureauth.Authorizer(context.Background(), accountID)
+	if err != nil {
+		return client, err
+	}
+	client.Authorizer = authorizer
 	return client, nil
 }
 
 func CreateFunctionAppHandler(w http.ResponseWriter, r *http.Request) {
 	var req FunctionAppRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
-	client, err := initFunctionAppClient(req.SubscriptionID, req.Token)
+	client, err := initFunctionAppClient(req.SubscriptionID, req.AccountID)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to initialize client: %v", err), http.StatusInternalServerError)
+		apierror.Write(w, err, "Failed to initialize client")
 		return
 	}
 
@@ -86,7 +76,7 @@ func CreateFunctionAppHandler(w http.ResponseWriter, r *http.Request) {
 
 	_, err = client.CreateOrUpdate(context.Background(), req.ResourceGroup, req.FunctionAppName, params)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error creating Function App: %v", err), http.StatusInternalServerError)
+		apierror.Write(w, err, "Error creating Function App")
 		return
 	}
 
@@ -97,13 +87,13 @@ func CreateFunctionAppHandler(w http.ResponseWriter, r *http.Request) {
 func ListFunctionAppsHandler(w http.ResponseWriter, r *http.Request) {
 	var req FunctionAppRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
-	client, err := initFunctionAppClient(req.SubscriptionID, req.Token)
+	client, err := initFunctionAppClient(req.SubscriptionID, req.AccountID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, err, "")
 		return
 	}
 
@@ -111,7 +101,7 @@ func ListFunctionAppsHandler(w http.ResponseWriter, r *http.Request) {
 	includeSlots := false
 	result, err := client.ListByResourceGroup(ctx, req.ResourceGroup, &includeSlots)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error listing Function Apps: %v", err), http.StatusInternalServerError)
+		apierror.Write(w, err, "Error listing Function Apps")
 		return
 	}
 
@@ -127,20 +117,20 @@ func ListFunctionAppsHandler(w http.ResponseWriter, r *http.Request) {
 func DeleteFunctionAppHandler(w http.ResponseWriter, r *http.Request) {
 	var req FunctionAppRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
-	client, err := initFunctionAppClient(req.SubscriptionID, req.Token)
+	client, err := initFunctionAppClient(req.SubscriptionID, req.AccountID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, err, "")
 		return
 	}
 	deleteMetrics := true
 	deleteEmptyServerFarm := true
 	_, err = client.Delete(context.Background(), req.ResourceGroup, req.FunctionAppName, &deleteMetrics, &deleteEmptyServerFarm)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error deleting Function App: %v", err), http.StatusInternalServerError)
+		apierror.Write(w, err, "Error deleting Function App")
 		return
 	}
 
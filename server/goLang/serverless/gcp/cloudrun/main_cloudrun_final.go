@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"net/http"
 
+	"btep.project/apierror"
+	"btep.project/auth/gcpauth"
 	db "btep.project/databaseConnection"
 	"golang.org/x/oauth2"
 	"google.golang.org/api/cloudfunctions/v1"
@@ -15,7 +17,6 @@ import (
 
 type CloudRunServiceRequest struct {
 	AccountID int    `json:"accountId"`
-	Token     string `json:"token"`
 	ProjectID string `json:"projectId"`
 	Location  string `json:"location"`
 	Service   string `json:"service"`
@@ -25,9 +26,13 @@ type CloudRunServiceResponse struct {
 	Message string `json:"message"`
 }
 
-func initRunService(token string) (*run.APIService, error) {
+func initRunService(accountID int) (*run.APIService, error) {
 	ctx := context.Background()
-	client := oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))
+	tokenSource, err := gcpauth.TokenSource(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	client := oauth2.NewClient(ctx, tokenSource)
 
 	service, err := run.NewService(ctx, option.WithHTTPClient(client))
 	if err != nil {
@@ -38,9 +43,13 @@ func initRunService(token string) (*run.APIService, error) {
 	return service, nil
 }
 
-func initFunctionsService(token string) (*cloudfunctions.Service, error) {
+func initFunctionsService(accountID int) (*cloudfunctions.Service, error) {
 	ctx := context.Background()
-	client := oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))
+	tokenSource, err := gcpauth.TokenSource(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	client := oauth2.NewClient(ctx, tokenSource)
 
 	Service, err := cloudfunctions.NewService(ctx, option.WithHTTPClient(client))
 	if err != nil {
@@ -54,24 +63,21 @@ func CreateCloudRunServiceHandler(w http.ResponseWriter, r *http.Request) {
 	var req CloudRunServiceRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Initialize Cloud Run service
-	runService, err := initRunService(req.Token)
+	runService, err := initRunService(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing Cloud Run service: %v", err)
+		apierror.Write(w, err, "Error initializing Cloud Run service")
 		return
 	}
 
@@ -110,8 +116,7 @@ func CreateCloudRunServiceHandler(w http.ResponseWriter, r *http.Request) {
 	_, err = createCall.Do()
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating Cloud Run service: %v", err)
+		apierror.Write(w, err, "Error creating Cloud Run service")
 		return
 	}
 
@@ -127,7 +132,6 @@ func ListCloudRunServicesHandler(w http.ResponseWriter, r *http.Request) {
 
 type DeleteCloudRunServiceRequest struct {
 	AccountID int    `json:"accountId"`
-	Token     string `json:"token"`
 	Location  string `json:"location"`
 	Service   string `json:"service"`
 }
@@ -136,33 +140,28 @@ func DeleteCloudRunServiceHandler(w http.ResponseWriter, r *http.Request) {
 	var req DeleteCloudRunServiceRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
-	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
-	fmt.Println(cloudAccount)
+	_, err = db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Initialize Cloud Run service
-	runService, err := initRunService(req.Token)
+	runService, err := initRunService(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing Cloud Run service: %v", err)
+		apierror.Write(w, err, "Error initializing Cloud Run service")
 		return
 	}
 
 	// Delete the Cloud Run service
 	_, err = runService.Projects.Locations.Services.Delete(req.Service).Do()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error deleting Cloud Run service: %v", err)
+		apierror.Write(w, err, "Error deleting Cloud Run service")
 		return
 	}
 
@@ -174,7 +173,6 @@ func DeleteCloudRunServiceHandler(w http.ResponseWriter, r *http.Request) {
 
 type CloutFuntionRequest struct {
 	AccountID int    `json:"accountId"`
-	Token     string `json:"token"`
 	Location  string `json:"location"`
 	Service   string `json:"service"`
 }
@@ -183,24 +181,21 @@ func CreateCloudFunctionHandler(w http.ResponseWriter, r *http.Request) {
 	var req CloutFuntionRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Initialize Cloud Functions service
-	functionsService, err := initFunctionsService(req.Token)
+	functionsService, err := initFunctionsService(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing Cloud Functions service: %v", err)
+		apierror.Write(w, err, "Error initializing Cloud Functions service")
 		return
 	}
 
@@ -218,8 +213,7 @@ func CreateCloudFunctionHandler(w http.ResponseWriter, r *http.Request) {
 	_, err = createCall.Do()
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating Cloud Function: %v", err)
+		apierror.Write(w, err, "Error creating Cloud Function")
 		return
 	}
 
@@ -231,7 +225,6 @@ func CreateCloudFunctionHandler(w http.ResponseWriter, r *http.Request) {
 
 type ListCloudRunFunctionRequest struct {
 	AccountID int    `json:"accountId"`
-	Token     string `json:"token"`
 	Location  string `json:"location"`
 }
 
@@ -239,24 +232,21 @@ func ListCloudFunctionsHandler(w http.ResponseWriter, r *http.Request) {
 	var req ListCloudRunFunctionRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Initialize Cloud Functions service
-	functionsService, err := initFunctionsService(req.Token)
+	functionsService, err := initFunctionsService(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing Cloud Functions service: %v", err)
+		apierror.Write(w, err, "Error initializing Cloud Functions service")
 		return
 	}
 
@@ -264,8 +254,7 @@ func ListCloudFunctionsHandler(w http.ResponseWriter, r *http.Request) {
 	listCall := functionsService.Projects.Locations.Functions.List("projects/" + cloudAccount.ProjectID.String + "/locations/" + req.Location)
 	response, err := listCall.Do()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error listing Cloud Functions: %v", err)
+		apierror.Write(w, err, "Error listing Cloud Functions")
 		return
 	}
 
@@ -287,7 +276,6 @@ func ListCloudFunctionsHandler(w http.ResponseWriter, r *http.Request) {
 
 type GetCloudRunFunctionRequest struct {
 	AccountID int    `json:"accountId"`
-	Token     string `json:"token"`
 	Location  string `json:"location"`
 	Service   string `json:"service"`
 }
@@ -296,24 +284,21 @@ func GetCloudFunctionHandler(w http.ResponseWriter, r *http.Request) {
 	var req GetCloudRunFunctionRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Initialize Cloud Functions service
-	functionsService, err := initFunctionsService(req.Token)
+	functionsService, err := initFunctionsService(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing Cloud Functions service: %v", err)
+		apierror.Write(w, err, "Error initializing Cloud Functions service")
 		return
 	}
 
@@ -321,8 +306,7 @@ func GetCloudFunctionHandler(w http.ResponseWriter, r *http.Request) {
 	getCall := functionsService.Projects.Locations.Functions.Get("projects/" + cloudAccount.ProjectID.String + "/locations/" + req.Location + "/functions/" + req.Service)
 	function, err := getCall.Do()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error fetching Cloud Function: %v", err)
+		apierror.Write(w, err, "Error fetching Cloud Function")
 		return
 	}
 
@@ -333,7 +317,6 @@ func GetCloudFunctionHandler(w http.ResponseWriter, r *http.Request) {
 
 type DeleteCloudFuntion struct {
 	AccountID int    `json:"accountId"`
-	Token     string `json:"token"`
 	Location  string `json:"location"`
 	Service   string `json:"service"`
 }
@@ -342,24 +325,21 @@ func DeleteCloudFunctionHandler(w http.ResponseWriter, r *http.Request) {
 	var req DeleteCloudFuntion
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Initialize Cloud Functions service
-	functionsService, err := initFunctionsService(req.Token)
+	functionsService, err := initFunctionsService(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing Cloud Functions service: %v", err)
+		apierror.Write(w, err, "Error initializing Cloud Functions service")
 		return
 	}
 
@@ -367,8 +347,7 @@ func DeleteCloudFunctionHandler(w http.ResponseWriter, r *http.Request) {
 	deleteCall := functionsService.Projects.Locations.Functions.Delete("projects/" + cloudAccount.ProjectID.String + "/locations/" + req.Location + "/functions/" + req.Service)
 	_, err = deleteCall.Do()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error deleting Cloud Function: %v", err)
+		apierror.Write(w, err, "Error deleting Cloud Function")
 		return
 	}
 
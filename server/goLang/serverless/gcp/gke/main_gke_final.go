@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"net/http"
 
+	"btep.project/apierror"
+	"btep.project/auth/gcpauth"
 	db "btep.project/databaseConnection"
 	"github.com/google/uuid"
 	"golang.org/x/oauth2"
@@ -21,14 +23,12 @@ type ClusterRequest struct {
 	KubernetesVersion string `json:"kubernetesVersion"`
 	NodeCount         int64  `json:"nodeCount"`
 	InstanceType      string `json:"instanceType"`
-	Token             string `json:"token"`
 }
 
 type DeleteClusterRequest struct {
 	AccountID   int    `json:"accountID"`
 	Zone        string `json:"zone"`
 	ClusterName string `json:"clusterName"`
-	Token       string `json:"token"`
 }
 
 // ClusterResponse represents the JSON response structure for GCP cluster operations
@@ -37,9 +37,13 @@ type ClusterResponse struct {
 	ClusterID string `json:"clusterID,omitempty"`
 }
 
-func initContainerService(token string) (*container.Service, error) {
+func initContainerService(accountID int) (*container.Service, error) {
 	ctx := context.Background()
-	client := oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))
+	tokenSource, err := gcpauth.TokenSource(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	client := oauth2.NewClient(ctx, tokenSource)
 
 	containerService, err := container.NewService(ctx, option.WithHTTPClient(client))
 	if err != nil {
@@ -54,19 +58,17 @@ func CreateClusterHandler(w http.ResponseWriter, r *http.Request) {
 	var req ClusterRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
-	containerService, err := initContainerService(req.Token)
+	containerService, err := initContainerService(req.AccountID)
 
 	// Generate a unique ID for the cluster
 	clusterID := uuid.New().String()
@@ -89,8 +91,7 @@ func CreateClusterHandler(w http.ResponseWriter, r *http.Request) {
 		},
 	}).Context(ctx).Do()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating GCP Kubernetes cluster: %v", err)
+		apierror.Write(w, err, "Error creating GCP Kubernetes cluster")
 		return
 	}
 
@@ -104,25 +105,22 @@ func DeleteClusterHandler(w http.ResponseWriter, r *http.Request) {
 	var req DeleteClusterRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
-	containerService, err := initContainerService(req.Token)
+	containerService, err := initContainerService(req.AccountID)
 
 	// Delete the GCP Kubernetes cluster
 	_, err = containerService.Projects.Zones.Clusters.Delete(cloudAccount.ProjectID.String, req.Zone, req.ClusterName).Do()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error deleting GCP Kubernetes cluster: %v", err)
+		apierror.Write(w, err, "Error deleting GCP Kubernetes cluster")
 		return
 	}
 
@@ -134,7 +132,6 @@ func DeleteClusterHandler(w http.ResponseWriter, r *http.Request) {
 type ListClusterRequest struct {
 	AccountID int    `json:"accountID"`
 	Zone      string `json:"zone"`
-	Token     string `json:"token"`
 }
 
 // ListClustersHandler handles POST requests to list GCP Kubernetes clusters
@@ -142,26 +139,23 @@ func ListClustersHandler(w http.ResponseWriter, r *http.Request) {
 	var req ListClusterRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
-	containerService, err := initContainerService(req.Token)
+	containerService, err := initContainerService(req.AccountID)
 
 	// List the GCP Kubernetes clusters
 	ctx := context.Background()
 	clusters, err := containerService.Projects.Zones.Clusters.List(cloudAccount.ProjectID.String, req.Zone).Context(ctx).Do()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error listing GCP Kubernetes clusters: %v", err)
+		apierror.Write(w, err, "Error listing GCP Kubernetes clusters")
 		return
 	}
 
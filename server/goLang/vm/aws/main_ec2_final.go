@@ -5,10 +5,10 @@ import (
 	"fmt"
 	"net/http"
 
+	"btep.project/apierror"
+	"btep.project/auth/awsauth"
 	db "btep.project/databaseConnection"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 )
 
@@ -48,27 +48,21 @@ func CreateInstanceHandler(w http.ResponseWriter, r *http.Request) {
 	var req InstanceRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Initialize AWS session with the provided region
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(req.Region),
-		Credentials: credentials.NewStaticCredentials(cloudAccount.AccessKey.String, cloudAccount.SecretKey.String, ""),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, req.Region)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session: %v", err)
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 	svc := ec2.New(sess)
@@ -95,8 +89,7 @@ func CreateInstanceHandler(w http.ResponseWriter, r *http.Request) {
 		},
 	})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating EC2 instance: %v", err)
+		apierror.Write(w, err, "Error creating EC2 instance")
 		return
 	}
 
@@ -128,30 +121,23 @@ func ListInstancesHandler(w http.ResponseWriter, r *http.Request) {
 	var req InstanceListRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
-	fmt.Println(cloudAccount)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Initialize AWS session with the provided region
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(cloudAccount.Region.String),
-		Credentials: credentials.NewStaticCredentials(cloudAccount.AccessKey.String, cloudAccount.SecretKey.String, ""),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, cloudAccount.Region.String)
 
 	fmt.Println("sess: ", sess)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session: %v", err)
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 	svc := ec2.New(sess)
@@ -161,8 +147,7 @@ func ListInstancesHandler(w http.ResponseWriter, r *http.Request) {
 	// List EC2 instances
 	result, err := svc.DescribeInstances(nil)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error listing EC2 instances: %v", err)
+		apierror.Write(w, err, "Error listing EC2 instances")
 		return
 	}
 
@@ -234,34 +219,26 @@ func TerminateInstanceHandler(w http.ResponseWriter, r *http.Request) {
 	var req TerminalRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	if req.InstanceID == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Instance ID is required")
+		apierror.BadRequest(w, "Instance ID is required")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
-	fmt.Println(cloudAccount)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
 	// Initialize AWS session with the provided region
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(cloudAccount.Region.String),
-		Credentials: credentials.NewStaticCredentials(cloudAccount.AccessKey.String, cloudAccount.SecretKey.String, ""),
-	})
+	sess, err := awsauth.SessionFor(cloudAccount, cloudAccount.Region.String)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing AWS session: %v", err)
+		apierror.Write(w, err, "Error initializing AWS session")
 		return
 	}
 	svc := ec2.New(sess)
@@ -270,8 +247,7 @@ func TerminateInstanceHandler(w http.ResponseWriter, r *http.Request) {
 		InstanceIds: []*string{aws.String(req.InstanceID)},
 	})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error terminating EC2 instance: %v", err)
+		apierror.Write(w, err, "Error terminating EC2 instance")
 		return
 	}
 
@@ -6,10 +6,11 @@ import (
 	"fmt"
 	"net/http"
 
+	"btep.project/apierror"
+	"btep.project/auth/azureauth"
 	db "btep.project/databaseConnection"
 	"github.com/Azure/azure-sdk-for-go/profiles/latest/compute/mgmt/compute"
 	"github.com/Azure/azure-sdk-for-go/profiles/latest/resources/mgmt/subscriptions"
-	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/to"
 )
 
@@ -24,7 +25,6 @@ type VMRequest struct {
 	VMName             string   `json:"vmName"`
 	ResourceGroup      string   `json:"resourceGroup"`
 	SubscriptionID     string   `json:"subscriptionID"`
-	Token              string   `json:"token"`
 	Image              VMImage  `json:"image"`
 	VMSize             string   `json:"vmSize"`
 	KeyPairName        string   `json:"keyPairName"`
@@ -38,8 +38,7 @@ type VMRequest struct {
 
 // ListVMsRequest represents the JSON request structure for listing VMs
 type ListVMsRequest struct {
-	Token     string `json:"token"`
-	AccountID int    `json:"accountID"`
+	AccountID int `json:"accountID"`
 }
 
 // DeleteVMRequest represents the JSON request structure for deleting a VM
@@ -47,7 +46,7 @@ type DeleteVMRequest struct {
 	VMName         string `json:"vmName"`
 	ResourceGroup  string `json:"resourceGroup"`
 	SubscriptionID string `json:"subscriptionID"`
-	Token          string `json:"token"`
+	AccountID      int    `json:"accountID"`
 }
 
 // VMResponse represents the JSON response structure
@@ -55,23 +54,13 @@ type VMResponse struct {
 	Message string `json:"message"`
 }
 
-type tokenAuthorizer struct {
-	token string
-}
-
-func (ta tokenAuthorizer) WithAuthorization() autorest.PrepareDecorator {
-	return func(p autorest.Preparer) autorest.Preparer {
-		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
-			r.Header.Set("Authorization", "Bearer "+ta.token)
-			return r, nil
-		})
-	}
-}
-
-func initComputeClient(subscriptionID string, token string) (compute.VirtualMachinesClient, error) {
+func initComputeClient(subscriptionID string, accountID int) (compute.VirtualMachinesClient, error) {
 	client := compute.NewVirtualMachinesClient(subscriptionID)
-	client.Authorizer = autorest.NullAuthorizer{}
-	client.RequestInspector = tokenAuthorizer{token: token}.WithAuthorization()
+	authorizer, err := azureauth.Authorizer(context.Background(), accountID)
+	if err != nil {
+		return client, err
+	}
+	client.Authorizer = authorizer
 	return client, nil
 }
 
@@ -81,16 +70,14 @@ func CreateVMHandler(w http.ResponseWriter, r *http.Request) {
 	var req VMRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Create Azure VM client
-	client, err := initComputeClient(req.SubscriptionID, req.Token)
+	client, err := initComputeClient(req.SubscriptionID, req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Failed to initialize Azure VM client: %v", err)
+		apierror.Write(w, err, "Failed to initialize Azure VM client")
 		return
 	}
 	networkInterfaceID := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/networkInterfaces/%s", req.SubscriptionID, req.ResourceGroup, req.NetworkInterfaceID)
@@ -135,8 +122,7 @@ func CreateVMHandler(w http.ResponseWriter, r *http.Request) {
 	// Create the VM
 	_, err = client.CreateOrUpdate(context.Background(), req.ResourceGroup, req.VMName, vmParameters)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating Azure VM: %v", err)
+		apierror.Write(w, err, "Error creating Azure VM")
 		return
 	}
 
@@ -152,32 +138,28 @@ func ListVMsHandler(w http.ResponseWriter, r *http.Request) {
 	var req ListVMsRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Get all subscription IDs
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 
-	// subscriptionIDs, err := GetAllSubscriptionIDs(req.Token)
+	// subscriptionIDs, err := GetAllSubscriptionIDs(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Failed to get subscription IDs: %v", err)
+		apierror.Write(w, err, "Failed to get subscription IDs")
 		return
 	}
 
 	// Create Azure VM client
-	client, err := initComputeClient(cloudAccount.SubscriptionID.String, req.Token)
+	client, err := initComputeClient(cloudAccount.SubscriptionID.String, req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Failed to initialize Azure VM client: %v", err)
+		apierror.Write(w, err, "Failed to initialize Azure VM client")
 		return
 	}
 	responce, err := client.ListAll(context.Background(), "", "")
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Failed to initialize Azure VM client: %v", err)
+		apierror.Write(w, err, "Failed to initialize Azure VM client")
 		return
 	}
 	fmt.Println(responce)
@@ -203,24 +185,21 @@ func DeleteVMHandler(w http.ResponseWriter, r *http.Request) {
 	var req DeleteVMRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Create Azure VM client
-	client, err := initComputeClient(req.SubscriptionID, req.Token)
+	client, err := initComputeClient(req.SubscriptionID, req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Failed to initialize Azure VM client: %v", err)
+		apierror.Write(w, err, "Failed to initialize Azure VM client")
 		return
 	}
 
 	// Delete the VM
 	_, err = client.Delete(context.Background(), req.ResourceGroup, req.VMName, nil)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error deleting Azure VM: %v", err)
+		apierror.Write(w, err, "Error deleting Azure VM")
 		return
 	}
 
@@ -230,8 +209,8 @@ func DeleteVMHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
-type TokenRequest struct {
-	Token string `json:"token"`
+type SubscriptionIDsRequest struct {
+	AccountID int `json:"accountID"`
 }
 type SubscriptionIDsResponse struct {
 	SubscriptionIDs []string `json:"subscriptionIDs"`
@@ -240,19 +219,17 @@ type SubscriptionIDsResponse struct {
 // GetSubscriptionIDsHandler handles the request to retrieve subscription IDs
 func GetSubscriptionIDsHandler(w http.ResponseWriter, r *http.Request) {
 	// Parse the JSON request body
-	var req TokenRequest
+	var req SubscriptionIDsRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Retrieve subscription IDs
-	subscriptionIDs, err := GetAllSubscriptionIDs(req.Token)
+	subscriptionIDs, err := GetAllSubscriptionIDs(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error retrieving subscription IDs: %v", err)
+		apierror.Write(w, err, "Error retrieving subscription IDs")
 		return
 	}
 
@@ -266,17 +243,13 @@ func GetSubscriptionIDsHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
-func withBearerToken(token string) autorest.PrepareDecorator {
-	return func(p autorest.Preparer) autorest.Preparer {
-		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
-			r.Header.Set("Authorization", "Bearer "+token)
-			return r, nil
-		})
-	}
-}
-func GetAllSubscriptionIDs(token string) ([]string, error) {
+func GetAllSubscriptionIDs(accountID int) ([]string, error) {
 	client := subscriptions.NewClient()
-	client.RequestInspector = withBearerToken(token)
+	authorizer, err := azureauth.Authorizer(context.Background(), accountID)
+	if err != nil {
+		return nil, err
+	}
+	client.Authorizer = authorizer
 
 	var subscriptionIDs []string
 	subList, err := client.List(context.Background())
@@ -7,6 +7,8 @@ import (
 	"net/http"
 	"strings"
 
+	"btep.project/apierror"
+	"btep.project/auth/gcpauth"
 	db "btep.project/databaseConnection"
 	"github.com/google/uuid"
 	"golang.org/x/oauth2"
@@ -21,7 +23,6 @@ type InstanceRequest struct {
 	Zone        string `json:"zone"`
 	Name        string `json:"name"`
 	AccountID   int    `json:"accountID"`
-	Token       string `json:"token"`
 }
 
 // InstanceResponse represents the JSON response structure for GCP instance operations
@@ -30,9 +31,13 @@ type InstanceResponse struct {
 	InstanceIDs []string `json:"instanceIDs,omitempty"`
 }
 
-func initComputeService(token string) (*compute.Service, error) {
+func initComputeService(accountID int) (*compute.Service, error) {
 	ctx := context.Background()
-	client := oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))
+	tokenSource, err := gcpauth.TokenSource(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	client := oauth2.NewClient(ctx, tokenSource)
 
 	computeService, err := compute.NewService(ctx, option.WithHTTPClient(client))
 	if err != nil {
@@ -47,19 +52,17 @@ func CreateInstanceHandler(w http.ResponseWriter, r *http.Request) {
 	var req InstanceRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
-	computeService, err := initComputeService(req.Token)
+	computeService, err := initComputeService(req.AccountID)
 
 	// Generate a unique name for the instance
 	instanceName := generateValidInstanceName()
@@ -78,8 +81,7 @@ func CreateInstanceHandler(w http.ResponseWriter, r *http.Request) {
 	}).Do()
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error creating GCP instance: %v", err)
+		apierror.Write(w, err, "Error creating GCP instance")
 		return
 	}
 
@@ -96,7 +98,6 @@ func generateValidInstanceName() string {
 // InstanceListRequest represents the JSON request structure for listing GCP instances
 type InstanceListRequest struct {
 	AccountID int    `json:"accountID"`
-	Token     string `json:"token"`
 	Zone      string `json:"zone"`
 }
 
@@ -104,7 +105,6 @@ type InstanceListRequest struct {
 type TerminalRequest struct {
 	AccountID  int    `json:"accountID"`
 	InstanceID string `json:"instanceID"`
-	Token      string `json:"token"`
 	Zone       string `json:"zone"`
 }
 
@@ -122,39 +122,34 @@ type InstanceDetails struct {
 }
 
 type ListRequest struct {
-	AccountID int    `json:"accountID"`
-	Token     string `json:"token"`
+	AccountID int `json:"accountID"`
 }
 
 func ListInstancesHandler(w http.ResponseWriter, r *http.Request) {
 	var req ListRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
 
-	computeService, err := initComputeService(req.Token)
+	computeService, err := initComputeService(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error initializing GCP compute service: %v", err)
+		apierror.Write(w, err, "Error initializing GCP compute service")
 		return
 	}
 
 	// List GCP instances
 	instancesList, err := computeService.Instances.AggregatedList(cloudAccount.ProjectID.String).Do()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error listing GCP instances: %v", err)
+		apierror.Write(w, err, "Error listing GCP instances")
 		return
 	}
 
@@ -179,8 +174,7 @@ func ListInstancesHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(instances)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error encoding JSON response: %v", err)
+		apierror.Write(w, err, "Error encoding JSON response")
 		return
 	}
 }
@@ -201,24 +195,21 @@ func TerminateInstanceHandler(w http.ResponseWriter, r *http.Request) {
 	var req TerminalRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request body")
+		apierror.BadRequest(w, "Invalid request body")
 		return
 	}
 
 	// Fetch cloud account details from the database
 	cloudAccount, err := db.GetCloudAccountDetails(req.AccountID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error getting cloud account details: %v", err)
+		apierror.Write(w, err, "Error getting cloud account details")
 		return
 	}
-	computeService, err := initComputeService(req.Token)
+	computeService, err := initComputeService(req.AccountID)
 	// Delete the GCP instance
 	op, err := computeService.Instances.Delete(cloudAccount.ProjectID.String, req.Zone, req.InstanceID).Do()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error terminating GCP instance: %v", err)
+		apierror.Write(w, err, "Error terminating GCP instance")
 		return
 	}
 	fmt.Println(op)